@@ -34,8 +34,17 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Initialize logger
+	// Subcommands report and exit rather than starting the TUI
 	cfg := configMgr.GetConfig()
+	if flag.NArg() > 0 {
+		if err := runCommand(cfg, flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Initialize logger
 	logConfig := util.LogConfig{
 		LogDir:   cfg.LogDir,
 		LogLevel: cfg.LogLevel,
@@ -62,3 +71,15 @@ func main() {
 
 	util.Logger.Info().Msg("Anvil stopped")
 }
+
+// runCommand runs the subcommand named by args[0]
+func runCommand(cfg *config.Config, args []string) error {
+	switch args[0] {
+	case "usage":
+		return runUsage(cfg, args[1:], os.Stdout)
+	case "trace":
+		return runTrace(cfg, args[1:], os.Stdout)
+	default:
+		return fmt.Errorf("unknown command %q (available: usage, trace)", args[0])
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/config"
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// previewLength is how much of a message or result `anvil trace show`
+// prints without -v
+const previewLength = 100
+
+// runTrace implements `anvil trace`: listing session traces and showing
+// one as a timeline
+func runTrace(cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: anvil trace list | anvil trace show [-v] [session]")
+	}
+
+	switch args[0] {
+	case "list":
+		return listTraces(cfg.Trace.Dir, out)
+	case "show":
+		flags := flag.NewFlagSet("trace show", flag.ContinueOnError)
+		verbose := flags.Bool("v", false, "Show every stream event and full text instead of previews")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		path, err := findTrace(cfg.Trace.Dir, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		events, err := llm.ReadTrace(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Trace %s\n\n", path)
+		writeTimeline(out, events, cfg.ModelCatalog(), *verbose)
+		return nil
+	default:
+		return fmt.Errorf("unknown trace command %q (available: list, show)", args[0])
+	}
+}
+
+// traceFiles returns the traces in dir, newest first
+func traceFiles(dir string) ([]os.FileInfo, string, error) {
+	dir = llm.TraceDir(dir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, dir, nil
+		}
+		return nil, dir, fmt.Errorf("failed to read trace directory: %w", err)
+	}
+
+	var files []os.FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jsonl" {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			files = append(files, info)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().After(files[j].ModTime())
+	})
+	return files, dir, nil
+}
+
+// listTraces prints the recorded sessions, newest first
+func listTraces(dir string, out io.Writer) error {
+	files, dir, err := traceFiles(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		fmt.Fprintf(out, "No traces in %s (set trace.enabled in the config to record them)\n", dir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tLAST WRITTEN\tSIZE\t")
+	for _, file := range files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t\n",
+			strings.TrimSuffix(file.Name(), ".jsonl"),
+			file.ModTime().Local().Format("2006-01-02 15:04:05"),
+			formatBytes(file.Size()),
+		)
+	}
+	return w.Flush()
+}
+
+// findTrace returns the trace of session: an exact session ID, a unique
+// prefix of one, or the latest trace when session is empty or "latest"
+func findTrace(dir, session string) (string, error) {
+	files, dir, err := traceFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("no traces in %s (set trace.enabled in the config to record them)", dir)
+	}
+	if session == "" || session == "latest" {
+		return filepath.Join(dir, files[0].Name()), nil
+	}
+
+	var matches []string
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".jsonl")
+		if name == session {
+			return filepath.Join(dir, file.Name()), nil
+		}
+		if strings.HasPrefix(name, session) {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no trace for session %q in %s", session, dir)
+	case 1:
+		return filepath.Join(dir, matches[0]+".jsonl"), nil
+	default:
+		return "", fmt.Errorf("session %q is ambiguous: %s", session, strings.Join(matches, ", "))
+	}
+}
+
+// streamedCall collects the stream events of a call until its response
+type streamedCall struct {
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []schema.ToolCall
+	events    int
+}
+
+// writeTimeline prints a trace's events with their times since the first
+// event. Stream events are summarized on the call's response unless
+// verbose is set.
+func writeTimeline(out io.Writer, events []llm.TraceEvent, catalog llm.Catalog, verbose bool) {
+	if len(events) == 0 {
+		fmt.Fprintln(out, "The trace is empty.")
+		return
+	}
+
+	start := events[0].Time
+	streams := make(map[int]*streamedCall)
+	var calls, failedCalls, tools, failedTools, unpriced int
+	var usage llm.Usage
+	var cost float64
+
+	line := func(event llm.TraceEvent, format string, args ...any) {
+		fmt.Fprintf(out, "%9s  %s\n", "+"+formatElapsed(event.Time.Sub(start)), fmt.Sprintf(format, args...))
+	}
+	detail := func(label, text string) {
+		if text = previewText(text, verbose); text != "" {
+			text = strings.ReplaceAll(text, "\n", "\n"+strings.Repeat(" ", 16))
+			fmt.Fprintf(out, "%9s     %s %s\n", "", label, text)
+		}
+	}
+
+	for _, event := range events {
+		switch event.Kind {
+		case llm.TraceRequest:
+			calls++
+			summary := fmt.Sprintf("→ call %d  %s/%s", event.Call, event.Provider, event.Model)
+			if req := event.Request; req != nil {
+				summary += fmt.Sprintf("  %d messages, %d tools", len(req.Messages), len(req.Tools))
+				line(event, "%s", summary)
+				if verbose {
+					detail("system:", event.System)
+				}
+				if n := len(req.Messages); n > 0 {
+					last := req.Messages[n-1]
+					detail(string(last.Role)+":", messageText(last))
+				}
+			} else {
+				line(event, "%s", summary)
+			}
+
+		case llm.TraceStream:
+			stream := streams[event.Call]
+			if stream == nil {
+				stream = &streamedCall{}
+				streams[event.Call] = stream
+				line(event, "  call %d first stream event after %s", event.Call, formatMillis(event.DurationMs))
+			}
+			stream.events++
+			stream.content.WriteString(event.Content)
+			stream.reasoning.WriteString(event.Reasoning)
+			stream.toolCalls = append(stream.toolCalls, event.ToolCalls...)
+			if verbose {
+				switch {
+				case event.Error != "":
+					line(event, "  call %d stream error: %s", event.Call, event.Error)
+				case len(event.ToolCalls) > 0:
+					line(event, "  call %d tool call %s", event.Call, toolCallText(event.ToolCalls[0]))
+				case event.Reasoning != "":
+					line(event, "  call %d reasoning %q", event.Call, event.Reasoning)
+				default:
+					line(event, "  call %d delta %q", event.Call, event.Content)
+				}
+			}
+
+		case llm.TraceResponse:
+			content, reasoning, toolCalls := event.Content, event.Reasoning, event.ToolCalls
+			streamed := ""
+			if stream := streams[event.Call]; stream != nil {
+				content = stream.content.String() + content
+				reasoning = stream.reasoning.String() + reasoning
+				toolCalls = append(stream.toolCalls, toolCalls...)
+				streamed = fmt.Sprintf(", %d stream events", stream.events)
+				delete(streams, event.Call)
+			}
+
+			callUsage := event.Usage()
+			usage.PromptTokens += callUsage.PromptTokens
+			usage.CompletionTokens += callUsage.CompletionTokens
+			usage.CacheReadTokens += callUsage.CacheReadTokens
+			usage.CacheWriteTokens += callUsage.CacheWriteTokens
+			if c, ok := catalog.Cost(event.Provider, event.Model, callUsage); ok {
+				cost += c
+			} else {
+				unpriced++
+			}
+
+			line(event, "← call %d  %s  %s%s%s",
+				event.Call, formatMillis(event.DurationMs), tokenText(callUsage), finishText(event.FinishReason), streamed)
+			detail("reasoning:", reasoning)
+			detail("assistant:", content)
+			for _, call := range toolCalls {
+				detail("wants", toolCallText(call))
+			}
+
+		case llm.TraceError:
+			failedCalls++
+			delete(streams, event.Call)
+			line(event, "✗ call %d failed after %s: %s", event.Call, formatMillis(event.DurationMs), event.Error)
+
+		case llm.TraceToolCall:
+			tools++
+			if event.ToolCall != nil {
+				line(event, "⚙ %s", toolCallText(*event.ToolCall))
+			}
+
+		case llm.TraceToolResult:
+			name := "tool"
+			if event.ToolCall != nil {
+				name = event.ToolCall.Name
+			}
+			if event.Error != "" {
+				failedTools++
+				line(event, "✗ %s failed after %s: %s", name, formatMillis(event.DurationMs), previewText(event.Error, verbose))
+				detail("output:", event.Result)
+			} else {
+				line(event, "✓ %s finished in %s", name, formatMillis(event.DurationMs))
+				detail("output:", event.Result)
+			}
+		}
+	}
+
+	elapsed := events[len(events)-1].Time.Sub(start)
+	fmt.Fprintf(out, "\n%d LLM calls (%d failed), %d tool calls (%d failed) over %s\n",
+		calls, failedCalls, tools, failedTools, formatElapsed(elapsed))
+	total := fmt.Sprintf("Tokens: %s", tokenText(usage))
+	if cost > 0 || unpriced == 0 {
+		total += fmt.Sprintf(", $%.4f", cost)
+		if unpriced > 0 {
+			total += fmt.Sprintf(" (%d calls unpriced)", unpriced)
+		}
+	}
+	fmt.Fprintln(out, total)
+}
+
+// messageText returns what a message says, noting its tool results and
+// images
+func messageText(msg llm.Message) string {
+	parts := []string{}
+	if msg.Content != "" {
+		parts = append(parts, msg.Content)
+	}
+	for _, result := range msg.ToolResults {
+		status := "result"
+		if result.IsError {
+			status = "error"
+		}
+		parts = append(parts, fmt.Sprintf("[tool %s %s] %s", status, result.ToolCallID, result.Content))
+	}
+	if len(msg.Images) > 0 {
+		parts = append(parts, fmt.Sprintf("[%d images]", len(msg.Images)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// toolCallText formats a tool call as its name and arguments
+func toolCallText(call schema.ToolCall) string {
+	args, err := json.Marshal(call.Arguments)
+	if err != nil || len(call.Arguments) == 0 {
+		return call.Name
+	}
+	return call.Name + " " + string(args)
+}
+
+// tokenText formats a call's token counts
+func tokenText(usage llm.Usage) string {
+	text := fmt.Sprintf("in %s, out %s",
+		llm.FormatTokenCount(usage.PromptTokens),
+		llm.FormatTokenCount(usage.CompletionTokens))
+	if usage.CacheReadTokens > 0 {
+		text += fmt.Sprintf(" (%s cached)", llm.FormatTokenCount(usage.CacheReadTokens))
+	}
+	return text
+}
+
+// finishText formats why a reply ended, if known
+func finishText(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return ", " + reason
+}
+
+// previewText collapses text to one line and shortens it, unless verbose
+// is set
+func previewText(text string, verbose bool) string {
+	if verbose {
+		return strings.TrimSpace(text)
+	}
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > previewLength {
+		return string(runes[:previewLength]) + "…"
+	}
+	return text
+}
+
+// formatElapsed formats a time since the start of a trace
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.3fs", d.Seconds())
+	}
+	return d.Round(time.Second).String()
+}
+
+// formatMillis formats a duration given in milliseconds
+func formatMillis(ms int64) string {
+	if ms < 1000 {
+		return fmt.Sprintf("%dms", ms)
+	}
+	return fmt.Sprintf("%.1fs", float64(ms)/1000)
+}
+
+// formatBytes formats a file size
+func formatBytes(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%dB", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1fKB", float64(size)/1024)
+	default:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1024*1024))
+	}
+}
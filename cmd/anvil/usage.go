@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/config"
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+)
+
+// runUsage implements `anvil usage`: daily, weekly and per-project token
+// and cost totals from the usage ledger, with budget overruns flagged
+func runUsage(cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	days := flags.Int("days", 7, "Days to show daily totals for")
+	weeks := flags.Int("weeks", 4, "Weeks to show weekly and project totals for")
+	project := flags.String("project", "", "Only count calls made from this project directory")
+	dailyBudget := flags.Float64("daily-budget", cfg.Usage.DailyBudget, "Daily budget in USD (0 = none)")
+	weeklyBudget := flags.Float64("weekly-budget", cfg.Usage.WeeklyBudget, "Weekly budget in USD (0 = none)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if cfg.Usage.Ledger == "" {
+		return fmt.Errorf("the usage ledger is disabled (set usage.ledger in the config)")
+	}
+	ledger := llm.NewLedger(cfg.Usage.Ledger)
+
+	now := time.Now()
+	today := startOfDay(now)
+	thisWeek := startOfWeek(now)
+	firstDay := today.AddDate(0, 0, -(max(*days, 1) - 1))
+	firstWeek := thisWeek.AddDate(0, 0, -7*(max(*weeks, 1)-1))
+
+	start := firstDay
+	if firstWeek.Before(start) {
+		start = firstWeek
+	}
+	entries, err := ledger.Entries(start)
+	if err != nil {
+		return err
+	}
+	if *project != "" {
+		entries = projectEntries(entries, *project)
+	}
+
+	catalog := cfg.ModelCatalog()
+	fmt.Fprintf(out, "Usage from %s\n", ledger.Path())
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "\nNo calls recorded in this period.")
+		return nil
+	}
+
+	daily := llm.SumUsage(since(entries, firstDay), catalog, func(e llm.LedgerEntry) string {
+		return e.Time.Local().Format("2006-01-02")
+	})
+	weekly := llm.SumUsage(since(entries, firstWeek), catalog, func(e llm.LedgerEntry) string {
+		return startOfWeek(e.Time.Local()).Format("2006-01-02")
+	})
+	projects := llm.SumUsage(since(entries, firstWeek), catalog, func(e llm.LedgerEntry) string {
+		if e.Project == "" {
+			return "(unknown)"
+		}
+		return e.Project
+	})
+
+	fmt.Fprintln(out)
+	writeTotals(out, "DAY", daily, *dailyBudget)
+	fmt.Fprintln(out)
+	writeTotals(out, "WEEK OF", weekly, *weeklyBudget)
+	fmt.Fprintln(out)
+	writeTotals(out, "PROJECT", projects, 0)
+
+	unpriced := 0
+	for _, total := range weekly {
+		unpriced += total.Unpriced
+	}
+	if unpriced > 0 {
+		fmt.Fprintf(out, "\n* %d of the calls used models without a price; add them to models in the config.\n", unpriced)
+	}
+
+	// Flag the current period if it is over budget
+	var overruns []string
+	if current := totalFor(daily, today.Format("2006-01-02")); *dailyBudget > 0 && current > *dailyBudget {
+		overruns = append(overruns, fmt.Sprintf("today's spend $%.2f is over the daily budget of $%.2f", current, *dailyBudget))
+	}
+	if current := totalFor(weekly, thisWeek.Format("2006-01-02")); *weeklyBudget > 0 && current > *weeklyBudget {
+		overruns = append(overruns, fmt.Sprintf("this week's spend $%.2f is over the weekly budget of $%.2f", current, *weeklyBudget))
+	}
+	for _, overrun := range overruns {
+		fmt.Fprintf(out, "\n⚠ Budget exceeded: %s\n", overrun)
+	}
+
+	return nil
+}
+
+// writeTotals prints a table of totals, marking those over budget
+func writeTotals(out io.Writer, heading string, totals []llm.UsageTotal, budget float64) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tREQUESTS\tINPUT\tOUTPUT\tCACHED\tCOST\t\n", heading)
+	for _, total := range totals {
+		cost := fmt.Sprintf("$%.2f", total.Cost)
+		if total.Unpriced > 0 {
+			cost += "*"
+		}
+		note := ""
+		if budget > 0 && total.Cost > budget {
+			note = fmt.Sprintf("over budget ($%.2f)", budget)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			total.Key,
+			total.Requests,
+			llm.FormatTokenCount(total.InputTokens),
+			llm.FormatTokenCount(total.OutputTokens),
+			llm.FormatTokenCount(total.CacheReadTokens),
+			cost,
+			note,
+		)
+	}
+	w.Flush()
+}
+
+// projectEntries returns the entries made from dir or a directory in it
+func projectEntries(entries []llm.LedgerEntry, dir string) []llm.LedgerEntry {
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	var matched []llm.LedgerEntry
+	for _, entry := range entries {
+		rel, err := filepath.Rel(dir, entry.Project)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			matched = append(matched, entry)
+		}
+	}
+	return matched
+}
+
+// since returns the entries recorded at or after start
+func since(entries []llm.LedgerEntry, start time.Time) []llm.LedgerEntry {
+	for i, entry := range entries {
+		if !entry.Time.Before(start) {
+			return entries[i:]
+		}
+	}
+	return nil
+}
+
+// totalFor returns the cost of the total with key, 0 if there is none
+func totalFor(totals []llm.UsageTotal, key string) float64 {
+	for _, total := range totals {
+		if total.Key == key {
+			return total.Cost
+		}
+	}
+	return 0
+}
+
+// startOfDay returns midnight at the start of t's day
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// startOfWeek returns midnight at the start of the Monday of t's week
+func startOfWeek(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7 // Days since Monday
+	return startOfDay(t).AddDate(0, 0, -offset)
+}
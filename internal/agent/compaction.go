@@ -0,0 +1,119 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+)
+
+const (
+	// summaryTimeout bounds the LLM call that summarises pruned messages
+	summaryTimeout = 2 * time.Minute
+
+	// maxTranscriptMessageChars caps how much of a single pruned message
+	// is sent for summarisation
+	maxTranscriptMessageChars = 4000
+)
+
+// summaryInstructions tells the model what the summary must preserve
+const summaryInstructions = `You maintain a running summary of a coding session whose older messages are being removed from the context window.
+Merge the previous summary (if any) with the removed messages into one updated summary. Cover:
+- Decisions made and why
+- Files read, created or modified, and what changed in them
+- Open TODOs, unresolved errors and questions still waiting for an answer
+Use short bullet points under those three headings. Keep paths and identifiers exact. Omit pleasantries and anything already resolved and irrelevant.`
+
+// newContext creates a conversation context sized to the model's context
+// window that measures messages with the client's tokenizer and whose
+// pruned messages are summarised by the LLM
+func (a *Agent) newContext() *Context {
+	c := NewContext()
+	if limit := a.contextTokens(); limit > 0 {
+		c.SetMaxTokens(limit)
+	}
+	if a.llmClient != nil {
+		c.SetTokenCounter(a.llmClient)
+	}
+	c.SetPruneCallback(func(pruned []llm.Message, _ string) {
+		a.summarizePruned(c, pruned)
+	})
+	return c
+}
+
+// summarizePruned asks the LLM to fold the pruned messages into the
+// context's running summary. It runs in the prune callback's goroutine;
+// summaries are produced one at a time so none of them is lost. If the
+// call fails the previous summary is kept.
+func (a *Agent) summarizePruned(c *Context, pruned []llm.Message) {
+	a.summaryMu.Lock()
+	defer a.summaryMu.Unlock()
+
+	budget := c.GetConfig().SummaryMaxTokens
+	if budget <= 0 {
+		budget = DefaultSummaryMaxTokens
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+	defer cancel()
+
+	resp, err := a.llmClient.Complete(ctx, llm.Request{
+		Messages: []llm.Message{{
+			Role:    llm.RoleUser,
+			Content: summaryRequest(c.Summary(), pruned),
+		}},
+		SystemPrompt: summaryInstructions,
+		MaxTokens:    budget,
+		Temperature:  0.2,
+		DisableCache: true, // A one-off request; caching it only costs more
+	})
+	if err != nil || resp == nil || strings.TrimSpace(resp.Content) == "" {
+		return
+	}
+
+	c.SetSummary(strings.TrimSpace(resp.Content))
+	a.emit(Event{Type: EventUsage, Usage: &resp.Usage})
+}
+
+// summaryRequest formats the previous summary and the pruned messages
+func summaryRequest(previous string, pruned []llm.Message) string {
+	var b strings.Builder
+
+	if previous != "" {
+		b.WriteString("Previous summary:\n")
+		b.WriteString(previous)
+		b.WriteString("\n\n")
+	}
+
+	b.WriteString("Removed messages:\n")
+	for _, msg := range pruned {
+		fmt.Fprintf(&b, "\n[%s] %s", msg.Role, truncateText(msg.Content, maxTranscriptMessageChars))
+		for _, call := range msg.ToolCalls {
+			fmt.Fprintf(&b, "\n(tool call %s %v)", call.Name, call.Arguments)
+		}
+		for _, result := range msg.ToolResults {
+			status := "result"
+			if result.IsError {
+				status = "error"
+			}
+			fmt.Fprintf(&b, "\n(tool %s) %s", status, truncateText(result.Content, maxTranscriptMessageChars))
+		}
+	}
+
+	return b.String()
+}
+
+// truncateText shortens s to at most limit bytes, marking the cut. The
+// cut falls on a character boundary.
+func truncateText(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit] + " [truncated]"
+}
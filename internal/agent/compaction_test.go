@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+func TestSummaryRequest(t *testing.T) {
+	pruned := []llm.Message{
+		{Role: llm.RoleUser, Content: "Rename the config loader"},
+		{Role: llm.RoleAssistant, ToolCalls: []schema.ToolCall{{Name: "write_file", Arguments: map[string]any{"path": "config.go"}}}},
+		{Role: llm.RoleUser, ToolResults: []llm.ToolResult{{Content: "permission denied", IsError: true}}},
+		{Role: llm.RoleAssistant, Content: strings.Repeat("x", maxTranscriptMessageChars+10)},
+	}
+
+	req := summaryRequest("- Uses viper", pruned)
+
+	for _, want := range []string{
+		"Previous summary:\n- Uses viper",
+		"[user] Rename the config loader",
+		"(tool call write_file map[path:config.go])",
+		"(tool error) permission denied",
+		"[truncated]",
+	} {
+		if !strings.Contains(req, want) {
+			t.Errorf("summary request should contain %q, got:\n%s", want, req)
+		}
+	}
+}
+
+func TestAgentSummarizesPrunedMessages(t *testing.T) {
+	client := &scriptedClient{responses: []*llm.Response{
+		{Content: "- Decided to rename loader.go\n- TODO: update docs", Usage: llm.Usage{TotalTokens: 42}},
+	}}
+	a := newTestAgent(t, client, false)
+
+	var usage *llm.Usage
+	done := make(chan struct{})
+	a.SetEventCallback(func(event Event) {
+		if event.Type == EventUsage {
+			usage = event.Usage
+			close(done)
+		}
+	})
+
+	for _, content := range []string{"Rename loader.go", "Renamed it", "Now update the docs"} {
+		a.context.AddMessage(llm.Message{Role: llm.RoleUser, Content: content})
+	}
+	a.context.SetMaxSize(1)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the summary")
+	}
+
+	if usage == nil || usage.TotalTokens != 42 {
+		t.Errorf("expected summary usage to be reported, got %+v", usage)
+	}
+
+	req := client.requests[0]
+	if req.MaxTokens != DefaultSummaryMaxTokens || !strings.Contains(req.SystemPrompt, "Open TODOs") {
+		t.Errorf("unexpected summary request %+v", req)
+	}
+	if !strings.Contains(req.Messages[0].Content, "Renamed it") || strings.Contains(req.Messages[0].Content, "Now update the docs") {
+		t.Errorf("only pruned messages should be summarised, got %q", req.Messages[0].Content)
+	}
+
+	messages := a.context.GetMessages()
+	if len(messages) != 2 || !strings.Contains(messages[0].Content, "TODO: update docs") || messages[1].Content != "Now update the docs" {
+		t.Errorf("expected the summary at the head of the kept history, got %+v", messages)
+	}
+}
+
+func TestAgentKeepsSummaryWhenSummarizingFails(t *testing.T) {
+	a := newTestAgent(t, &scriptedClient{}, false)
+	c := a.context
+	c.SetSummary("- Earlier summary")
+
+	a.summarizePruned(c, []llm.Message{{Role: llm.RoleUser, Content: "Old"}})
+
+	if c.Summary() != "- Earlier summary" {
+		t.Errorf("expected the previous summary to be kept, got %q", c.Summary())
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	if got := truncateText("héllo", 2); got != "h [truncated]" {
+		t.Errorf("Expected the cut before a multi-byte character, got %q", got)
+	}
+	if got := truncateText("héllo", 3); got != "hé [truncated]" {
+		t.Errorf("Expected the whole character kept, got %q", got)
+	}
+	if got := truncateText("short", 10); got != "short" {
+		t.Errorf("Expected short text unchanged, got %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"sync"
@@ -10,17 +11,26 @@ import (
 
 // ContextConfig holds configuration for context management
 type ContextConfig struct {
-	MaxMessages   int // Maximum number of messages (0 = unlimited)
-	MaxTokens     int // Maximum estimated tokens (0 = unlimited)
-	CharsPerToken int // Characters per token estimate (default: 4)
+	MaxMessages      int // Maximum number of messages (0 = unlimited)
+	MaxTokens        int // Maximum estimated tokens (0 = unlimited)
+	CharsPerToken    int // Characters per token estimate (default: 4)
+	SummaryMaxTokens int // Token budget for the summary of pruned messages (default: 1024)
 }
 
+// DefaultSummaryMaxTokens is the default token budget for the summary
+// that replaces pruned messages
+const DefaultSummaryMaxTokens = 1024
+
+// summaryPrefix introduces the synthetic summary message
+const summaryPrefix = "Summary of the earlier conversation, which was removed to save space:\n\n"
+
 // DefaultContextConfig returns the default context configuration
 func DefaultContextConfig() ContextConfig {
 	return ContextConfig{
-		MaxMessages:   100,
-		MaxTokens:     100000, // ~100k tokens
-		CharsPerToken: 4,
+		MaxMessages:      100,
+		MaxTokens:        100000, // ~100k tokens
+		CharsPerToken:    4,
+		SummaryMaxTokens: DefaultSummaryMaxTokens,
 	}
 }
 
@@ -32,9 +42,26 @@ type Context struct {
 	prunedSummary string       // Summary of pruned content
 	prunedCount   int          // Number of messages pruned
 	onPrune       PruneCallback // Called when messages are pruned
+	summary       string        // Written summary of pruned messages, kept at the head of history
+	counter       TokenCounter  // Counts tokens; nil falls back to CharsPerToken
+	tokens        []int         // Token count of each message, parallel to messages
+	totalTokens   int           // Sum of tokens, kept up to date as messages change
+	summaryTokens int           // Token count of the summary message
+}
+
+// TokenCounter counts tokens for the model the context is sent to.
+// llm.Client implements it.
+type TokenCounter interface {
+	CountTokens(text string) int
 }
 
-// PruneCallback is called when messages are pruned from context
+// messageOverhead approximates the tokens each message costs for its role
+// and framing when a real counter is used
+const messageOverhead = 4
+
+// PruneCallback is called when messages are pruned from context. It runs
+// in its own goroutine, so it may take its time, e.g. to summarise the
+// pruned messages and hand the result back through SetSummary.
 type PruneCallback func(pruned []llm.Message, summary string)
 
 // NewContext creates a new context manager
@@ -56,6 +83,73 @@ func NewContextWithConfig(config ContextConfig) *Context {
 	}
 }
 
+// SetTokenCounter sets the counter used to measure messages and recounts
+// the current history with it
+func (c *Context) SetTokenCounter(counter TokenCounter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counter = counter
+	c.recount()
+	c.checkAndPrune()
+}
+
+// countMessage returns the tokens a message contributes, including tool
+// call arguments, tool result payloads and images
+func (c *Context) countMessage(msg llm.Message) int {
+	images := 0
+	for _, img := range msg.Images {
+		images += img.EstimatedTokens()
+	}
+	if c.counter == nil {
+		return messageLength(msg)/c.config.CharsPerToken + images
+	}
+
+	tokens := messageOverhead + images + c.counter.CountTokens(msg.Content)
+	for _, call := range msg.ToolCalls {
+		args, _ := json.Marshal(call.Arguments)
+		tokens += c.counter.CountTokens(call.Name) + c.counter.CountTokens(string(args))
+	}
+	for _, result := range msg.ToolResults {
+		tokens += c.counter.CountTokens(result.Content)
+	}
+	return tokens
+}
+
+// countText returns the tokens in plain text
+func (c *Context) countText(text string) int {
+	if text == "" {
+		return 0
+	}
+	if c.counter == nil {
+		return len(text) / c.config.CharsPerToken
+	}
+	return messageOverhead + c.counter.CountTokens(text)
+}
+
+// recount measures every message again, e.g. after the counter changed.
+// Must be called with lock held
+func (c *Context) recount() {
+	c.tokens = make([]int, len(c.messages))
+	c.totalTokens = 0
+	for i, msg := range c.messages {
+		c.tokens[i] = c.countMessage(msg)
+		c.totalTokens += c.tokens[i]
+	}
+	c.summaryTokens = c.countText(c.summary)
+}
+
+// setMessages replaces the history along with its token counts.
+// Must be called with lock held
+func (c *Context) setMessages(messages []llm.Message, tokens []int) {
+	c.messages = messages
+	c.tokens = tokens
+	c.totalTokens = 0
+	for _, n := range tokens {
+		c.totalTokens += n
+	}
+}
+
 // SetPruneCallback sets the callback for when messages are pruned
 func (c *Context) SetPruneCallback(cb PruneCallback) {
 	c.mu.Lock()
@@ -68,7 +162,11 @@ func (c *Context) AddMessage(msg llm.Message) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Count each message once; pruning works from the running total
+	tokens := c.countMessage(msg)
 	c.messages = append(c.messages, msg)
+	c.tokens = append(c.tokens, tokens)
+	c.totalTokens += tokens
 
 	// Check if we need to prune
 	c.checkAndPrune()
@@ -94,25 +192,70 @@ func (c *Context) checkAndPrune() {
 	}
 }
 
-// estimateTokensLocked estimates tokens without acquiring lock
+// estimateTokensLocked returns the running token total without acquiring lock
 // Must be called with lock held
 func (c *Context) estimateTokensLocked() int {
-	totalChars := 0
-	for _, msg := range c.messages {
-		totalChars += len(msg.Content)
+	return c.totalTokens + c.summaryTokens
+}
+
+// messageLength returns the number of characters a message contributes,
+// including tool call arguments and tool result payloads
+func messageLength(msg llm.Message) int {
+	length := len(msg.Content)
+	for _, call := range msg.ToolCalls {
+		length += len(call.Name)
+		for key, val := range call.Arguments {
+			length += len(key) + len(fmt.Sprintf("%v", val))
+		}
 	}
-	return totalChars / c.config.CharsPerToken
+	for _, result := range msg.ToolResults {
+		length += len(result.Content)
+	}
+	return length
 }
 
-// GetMessages returns all messages in the context
+// GetMessages returns all messages in the context. If pruned messages
+// have been summarised, the summary comes first after the system messages.
 func (c *Context) GetMessages() []llm.Message {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	// Return a copy to prevent external modification
-	messages := make([]llm.Message, len(c.messages))
-	copy(messages, c.messages)
-	return messages
+	if c.summary == "" {
+		// Return a copy to prevent external modification
+		messages := make([]llm.Message, len(c.messages))
+		copy(messages, c.messages)
+		return messages
+	}
+
+	messages := make([]llm.Message, 0, len(c.messages)+1)
+	head := 0
+	for head < len(c.messages) && c.messages[head].Role == llm.RoleSystem {
+		head++
+	}
+	messages = append(messages, c.messages[:head]...)
+	messages = append(messages, llm.Message{
+		Role:    llm.RoleUser,
+		Content: summaryPrefix + c.summary,
+	})
+	return append(messages, c.messages[head:]...)
+}
+
+// SetSummary sets the written summary of pruned messages, replacing any
+// earlier one
+func (c *Context) SetSummary(summary string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summary = summary
+	c.summaryTokens = c.countText(summary)
+}
+
+// Summary returns the written summary of pruned messages, if any
+func (c *Context) Summary() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.summary
 }
 
 // GetRecentMessages returns the N most recent messages
@@ -137,7 +280,9 @@ func (c *Context) Clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.messages = make([]llm.Message, 0)
+	c.setMessages(make([]llm.Message, 0), nil)
+	c.summary = ""
+	c.summaryTokens = 0
 }
 
 // Size returns the number of messages in the context
@@ -183,82 +328,86 @@ func (c *Context) SetConfig(config ContextConfig) {
 		config.CharsPerToken = 4
 	}
 	c.config = config
+	c.recount()
 	c.checkAndPrune()
 }
 
-// prune removes old messages when the context exceeds limits
+// prune removes the oldest messages when the context exceeds limits,
+// never separating tool calls from their results
 // This is called with the lock already held
 func (c *Context) prune() {
 	// Separate system messages (always keep) from others
-	var systemMessages []llm.Message
-	var otherMessages []llm.Message
+	var systemIdx []int
+	var otherIdx []int
 
-	for _, msg := range c.messages {
+	for i, msg := range c.messages {
 		if msg.Role == llm.RoleSystem {
-			systemMessages = append(systemMessages, msg)
+			systemIdx = append(systemIdx, i)
 		} else {
-			otherMessages = append(otherMessages, msg)
+			otherIdx = append(otherIdx, i)
 		}
 	}
 
-	// Calculate current state
-	systemTokens := 0
-	for _, msg := range systemMessages {
-		systemTokens += len(msg.Content) / c.config.CharsPerToken
+	// Calculate current state; the summary is kept alongside the system messages
+	systemTokens := c.summaryTokens
+	for _, i := range systemIdx {
+		systemTokens += c.tokens[i]
 	}
 
-	var prunedMessages []llm.Message
-	var keptMessages []llm.Message
-
-	// Prune based on message count first
+	// Prune the oldest messages: enough to meet the message count, then
+	// whatever precedes the newest messages that fit the token budget
+	cut := 0
 	if c.config.MaxMessages > 0 {
-		maxOther := c.config.MaxMessages - len(systemMessages)
-		if maxOther < 0 {
-			maxOther = 0
-		}
-		if len(otherMessages) > maxOther {
-			prunedMessages = otherMessages[:len(otherMessages)-maxOther]
-			otherMessages = otherMessages[len(otherMessages)-maxOther:]
-		}
+		maxOther := max(c.config.MaxMessages-len(systemIdx), 0)
+		cut = max(len(otherIdx)-maxOther, 0)
 	}
-
-	// Then prune based on token count
 	if c.config.MaxTokens > 0 {
 		availableTokens := c.config.MaxTokens - systemTokens
 		currentTokens := 0
-
-		// Count tokens from newest to oldest
-		for i := len(otherMessages) - 1; i >= 0; i-- {
-			msgTokens := len(otherMessages[i].Content) / c.config.CharsPerToken
-			if currentTokens+msgTokens <= availableTokens {
-				currentTokens += msgTokens
-				keptMessages = append([]llm.Message{otherMessages[i]}, keptMessages...)
-			} else {
-				prunedMessages = append(prunedMessages, otherMessages[i])
-			}
+		fits := len(otherIdx)
+		for fits > cut && currentTokens+c.tokens[otherIdx[fits-1]] <= availableTokens {
+			currentTokens += c.tokens[otherIdx[fits-1]]
+			fits--
 		}
-		otherMessages = keptMessages
+		cut = fits
+	}
+
+	// Tool results go with the tool calls they answer
+	for cut > 0 && cut < len(otherIdx) && len(c.messages[otherIdx[cut]].ToolResults) > 0 {
+		cut++
 	}
 
+	prunedIdx := otherIdx[:cut]
+	otherIdx = otherIdx[cut:]
+
 	// Update pruned count
-	c.prunedCount += len(prunedMessages)
+	c.prunedCount += len(prunedIdx)
 
 	// Generate summary of pruned content
-	if len(prunedMessages) > 0 {
+	if len(prunedIdx) > 0 {
+		prunedMessages := make([]llm.Message, len(prunedIdx))
+		for j, i := range prunedIdx {
+			prunedMessages[j] = c.messages[i]
+		}
+
 		c.prunedSummary = c.generatePrunedSummary(prunedMessages)
 
-		// Call callback if set
+		// Call callback if set; it gets its own copy of the messages
 		callback := c.onPrune
 		if callback != nil {
-			// Make copies for callback
-			prunedCopy := make([]llm.Message, len(prunedMessages))
-			copy(prunedCopy, prunedMessages)
-			go callback(prunedCopy, c.prunedSummary)
+			go callback(prunedMessages, c.prunedSummary)
 		}
 	}
 
 	// Combine back together
-	c.messages = append(systemMessages, otherMessages...)
+	keep := append(systemIdx, otherIdx...)
+	messages := make([]llm.Message, len(keep))
+	tokens := make([]int, len(keep))
+	for j, i := range keep {
+		messages[j] = c.messages[i]
+		tokens[j] = c.tokens[i]
+	}
+	c.setMessages(messages, tokens)
 }
 
 // generatePrunedSummary creates a brief summary of pruned messages
@@ -317,10 +466,8 @@ func (c *Context) Stats() ContextStats {
 	defer c.mu.RUnlock()
 
 	var userCount, assistantCount, systemCount int
-	var totalChars int
 
 	for _, msg := range c.messages {
-		totalChars += len(msg.Content)
 		switch msg.Role {
 		case llm.RoleUser:
 			userCount++
@@ -336,7 +483,7 @@ func (c *Context) Stats() ContextStats {
 		UserMessages:     userCount,
 		AssistantMessages: assistantCount,
 		SystemMessages:   systemCount,
-		EstimatedTokens:  totalChars / c.config.CharsPerToken,
+		EstimatedTokens:  c.estimateTokensLocked(),
 		PrunedCount:      c.prunedCount,
 		MaxMessages:      c.config.MaxMessages,
 		MaxTokens:        c.config.MaxTokens,
@@ -361,7 +508,8 @@ func (c *Context) RemoveLastMessage() {
 	defer c.mu.Unlock()
 
 	if len(c.messages) > 0 {
-		c.messages = c.messages[:len(c.messages)-1]
+		last := len(c.messages) - 1
+		c.setMessages(c.messages[:last], c.tokens[:last])
 	}
 }
 
@@ -371,9 +519,10 @@ func (c *Context) RemoveLastN(n int) {
 	defer c.mu.Unlock()
 
 	if n >= len(c.messages) {
-		c.messages = make([]llm.Message, 0)
+		c.setMessages(make([]llm.Message, 0), nil)
 		return
 	}
 
-	c.messages = c.messages[:len(c.messages)-n]
+	keep := len(c.messages) - n
+	c.setMessages(c.messages[:keep], c.tokens[:keep])
 }
@@ -1,9 +1,11 @@
 package agent
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
 func TestNewContext(t *testing.T) {
@@ -255,3 +257,136 @@ func TestContextSetMaxTokens(t *testing.T) {
 		t.Errorf("Expected tokens to decrease after SetMaxTokens, before: %d, after: %d", initialTokens, afterTokens)
 	}
 }
+
+func TestContextSummaryAtHeadOfHistory(t *testing.T) {
+	ctx := NewContext()
+	ctx.AddMessage(llm.Message{Role: llm.RoleSystem, Content: "You are helpful"})
+	ctx.AddMessage(llm.Message{Role: llm.RoleUser, Content: "Next task"})
+
+	before := ctx.EstimateTokens()
+	ctx.SetSummary("- Decided to use go-git")
+
+	messages := ctx.GetMessages()
+	if len(messages) != 3 || messages[0].Role != llm.RoleSystem {
+		t.Fatalf("Expected system, summary, user messages, got %+v", messages)
+	}
+	if messages[1].Role != llm.RoleUser || !strings.HasSuffix(messages[1].Content, "- Decided to use go-git") {
+		t.Errorf("Expected the summary after the system messages, got %+v", messages[1])
+	}
+	if ctx.Size() != 2 {
+		t.Errorf("The summary should not count as a stored message, got size %d", ctx.Size())
+	}
+	if ctx.EstimateTokens() <= before {
+		t.Error("The summary should count towards the token estimate")
+	}
+
+	ctx.Clear()
+	if ctx.Summary() != "" || len(ctx.GetMessages()) != 0 {
+		t.Error("Clear should drop the summary")
+	}
+}
+
+// countingCounter counts one token per word and records how often it is used
+type countingCounter struct {
+	calls int
+}
+
+func (c *countingCounter) CountTokens(text string) int {
+	c.calls++
+	return len(strings.Fields(text))
+}
+
+func TestContextRunningTokenTotal(t *testing.T) {
+	counter := &countingCounter{}
+	ctx := NewContext()
+	ctx.SetTokenCounter(counter)
+
+	ctx.AddMessage(llm.Message{Role: llm.RoleUser, Content: "rename the loader"})
+	ctx.AddMessage(llm.Message{Role: llm.RoleAssistant, Content: "done"})
+
+	// Words plus the per-message overhead
+	want := 3 + 1 + 2*messageOverhead
+	if got := ctx.EstimateTokens(); got != want {
+		t.Errorf("Expected %d tokens, got %d", want, got)
+	}
+
+	// Reading the total does not count the history again
+	calls := counter.calls
+	ctx.EstimateTokens()
+	ctx.Stats()
+	if counter.calls != calls {
+		t.Errorf("Expected no recounting, counter called %d more time(s)", counter.calls-calls)
+	}
+
+	ctx.RemoveLastMessage()
+	if got := ctx.EstimateTokens(); got != 3+messageOverhead {
+		t.Errorf("Expected total to drop with the removed message, got %d", got)
+	}
+
+	ctx.SetSummary("one two")
+	if got := ctx.EstimateTokens(); got != 3+2+2*messageOverhead {
+		t.Errorf("Expected the summary to be counted, got %d", got)
+	}
+}
+
+func TestContextPrunesByCountedTokens(t *testing.T) {
+	ctx := NewContextWithConfig(ContextConfig{MaxTokens: 3 * (messageOverhead + 2)})
+	ctx.SetTokenCounter(&countingCounter{})
+
+	for _, content := range []string{"first message", "second message", "third message", "fourth message"} {
+		ctx.AddMessage(llm.Message{Role: llm.RoleUser, Content: content})
+	}
+
+	messages := ctx.GetMessages()
+	if len(messages) != 3 || messages[0].Content != "second message" {
+		t.Errorf("Expected the oldest message to be pruned, got %+v", messages)
+	}
+	if ctx.EstimateTokens() != 3*(messageOverhead+2) {
+		t.Errorf("Unexpected total after pruning: %d", ctx.EstimateTokens())
+	}
+}
+
+func TestContextPrunesOldestMessagesOnly(t *testing.T) {
+	ctx := NewContextWithConfig(ContextConfig{MaxTokens: 2 * (messageOverhead + 2)})
+	ctx.SetTokenCounter(&countingCounter{})
+
+	// A message too large to keep takes everything older with it
+	ctx.AddMessage(llm.Message{Role: llm.RoleUser, Content: "first message"})
+	ctx.AddMessage(llm.Message{Role: llm.RoleAssistant, Content: strings.Repeat("word ", 2*messageOverhead+5)})
+	ctx.AddMessage(llm.Message{Role: llm.RoleUser, Content: "third message"})
+
+	messages := ctx.GetMessages()
+	if len(messages) != 1 || messages[0].Content != "third message" {
+		t.Errorf("Expected only the newest message to be kept, got %+v", messages)
+	}
+	if ctx.GetPrunedCount() != 2 {
+		t.Errorf("Expected 2 pruned messages, got %d", ctx.GetPrunedCount())
+	}
+}
+
+func TestContextPruneKeepsToolResultsWithCalls(t *testing.T) {
+	ctx := NewContextWithConfig(ContextConfig{MaxMessages: 2})
+
+	var pruned []llm.Message
+	done := make(chan struct{}, 4)
+	ctx.SetPruneCallback(func(messages []llm.Message, summary string) {
+		pruned = append(pruned, messages...)
+		done <- struct{}{}
+	})
+
+	ctx.AddMessage(llm.Message{Role: llm.RoleUser, Content: "Read main.go"})
+	ctx.AddMessage(llm.Message{Role: llm.RoleAssistant, ToolCalls: []schema.ToolCall{{ID: "call_1", Name: "read_file"}}})
+	ctx.AddMessage(llm.Message{Role: llm.RoleUser, ToolResults: []llm.ToolResult{{ToolCallID: "call_1", Content: "package main"}}})
+	<-done
+	ctx.AddMessage(llm.Message{Role: llm.RoleAssistant, Content: "It is the main package."})
+	<-done
+
+	// Pruning the call takes its result too, rather than leaving it orphaned
+	messages := ctx.GetMessages()
+	if len(messages) != 1 || messages[0].Content != "It is the main package." {
+		t.Errorf("Expected the tool call and result to be pruned together, got %+v", messages)
+	}
+	if len(pruned) != 3 || len(pruned[1].ToolCalls) != 1 || len(pruned[2].ToolResults) != 1 {
+		t.Errorf("Expected the pruned messages oldest first, got %+v", pruned)
+	}
+}
@@ -2,9 +2,10 @@ package agent
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/siddharth-bhatnagar/anvil/internal/llm"
 	"github.com/siddharth-bhatnagar/anvil/internal/tools"
@@ -19,6 +20,21 @@ type Agent struct {
 	context        *Context
 	lifecycle      *Lifecycle
 	teachingConfig TeachingConfig
+	onEvent        EventCallback
+	verifier       *Verifier
+	acted          bool       // Whether the current request has taken actions worth verifying
+	repairAttempts int        // Repair attempts made for the current request
+	replans        int        // Plan revisions made for the current request
+	replanning     bool       // A step failed and a revised plan is expected
+	reviewFrom     int        // First step of the plan under review; earlier steps already ran
+	summaryMu      sync.Mutex // Serialises summaries of pruned messages
+
+	thinkingBudget  int                 // Tokens the model may spend thinking (0 = off)
+	reasoningEffort llm.ReasoningEffort // Thinking effort for models that take a level
+
+	model       llm.ModelInfo // Limits and features of the model in use
+	maxTokens   int           // Configured reply limit (0 = sized from the model)
+	temperature float64
 }
 
 // Config holds agent configuration
@@ -28,18 +44,101 @@ type Config struct {
 	Temperature    float64
 	MaxTokens      int
 	TeachingMode   TeachingMode
+	Verify         VerifyConfig
+
+	// Extended thinking; see llm.Request
+	ThinkingBudget  int
+	ReasoningEffort llm.ReasoningEffort
+
+	// Catalog describes the model; the default catalog when nil
+	Catalog llm.Catalog
 }
 
 // NewAgent creates a new agent with the given configuration
 func NewAgent(llmClient llm.Client, toolRegistry *tools.Registry, config Config) *Agent {
-	return &Agent{
+	a := &Agent{
 		llmClient:      llmClient,
 		toolRegistry:   toolRegistry,
 		systemPrompt:   config.SystemPrompt,
-		context:        NewContext(),
 		lifecycle:      NewLifecycle(),
 		teachingConfig: TeachingConfigForMode(config.TeachingMode),
+		verifier:       NewVerifier(config.Verify),
+
+		thinkingBudget:  config.ThinkingBudget,
+		reasoningEffort: config.ReasoningEffort,
+
+		maxTokens:   config.MaxTokens,
+		temperature: config.Temperature,
+	}
+
+	catalog := config.Catalog
+	if catalog == nil {
+		catalog = llm.DefaultCatalog()
 	}
+	model := config.Model
+	if model == "" && llmClient != nil {
+		model = llmClient.Model()
+	}
+	a.model = catalog.Info(model)
+	if !a.model.Thinking {
+		a.thinkingBudget = 0
+		a.reasoningEffort = ""
+	}
+
+	a.context = a.newContext()
+	return a
+}
+
+// ModelInfo returns the limits and features of the agent's model
+func (a *Agent) ModelInfo() llm.ModelInfo {
+	return a.model
+}
+
+// SetContextWindow records the context window of a model that reports it
+// at run time, such as a local one, and resizes the context to fit
+func (a *Agent) SetContextWindow(tokens int) {
+	a.model.ContextWindow = tokens
+	if limit := a.contextTokens(); limit > 0 {
+		a.context.SetMaxTokens(limit)
+	}
+}
+
+// SetFeatures records whether a model that reports its features at run
+// time, such as a local one, accepts images and can think. A model that
+// cannot think gets no thinking budget or reasoning effort.
+func (a *Agent) SetFeatures(vision, thinking bool) {
+	a.model.Vision = vision
+	a.model.Thinking = thinking
+	if !thinking {
+		a.thinkingBudget = 0
+		a.reasoningEffort = ""
+	}
+	if limit := a.contextTokens(); limit > 0 {
+		a.context.SetMaxTokens(limit)
+	}
+}
+
+// replyTokens returns the tokens a reply may use: the configured limit,
+// or what the model allows with room left for any thinking budget
+func (a *Agent) replyTokens() int {
+	if a.maxTokens > 0 {
+		return a.maxTokens
+	}
+	tokens := a.model.ReplyTokens()
+	if a.thinkingBudget > 0 && a.model.MaxOutput > a.thinkingBudget {
+		tokens = min(tokens, a.model.MaxOutput-a.thinkingBudget)
+	}
+	return tokens
+}
+
+// contextTokens returns the tokens the conversation may use: the model's
+// context window less the reply and thinking budgets, or 0 if the window
+// is unknown
+func (a *Agent) contextTokens() int {
+	if a.model.ContextWindow == 0 {
+		return 0
+	}
+	return max(a.model.ContextWindow-a.replyTokens()-a.thinkingBudget, 0)
 }
 
 // SetTeachingMode sets the teaching mode
@@ -81,15 +180,27 @@ func (a *Agent) ReviewCode(ctx context.Context, code string, concerns []string)
 }
 
 // ProcessRequest handles a user request through the agent loop
-// Returns the assistant's response and any tool results that require approval
-func (a *Agent) ProcessRequest(ctx context.Context, userMessage string) (*Response, error) {
+// Returns the assistant's response and any tool results that require approval.
+// Images are attached to the user's message.
+func (a *Agent) ProcessRequest(ctx context.Context, userMessage string, images ...llm.Image) (*Response, error) {
+	if len(images) > 0 && !a.model.Vision {
+		return nil, fmt.Errorf("%s does not accept images", a.model.ID)
+	}
+
 	// Start in Understand phase
-	a.lifecycle.SetPhase(PhaseUnderstand)
+	a.setPhase(PhaseUnderstand)
+	a.lifecycle.SetPlan(nil)
+	a.acted = false
+	a.repairAttempts = 0
+	a.replans = 0
+	a.replanning = false
+	a.reviewFrom = 0
 
 	// Add user message to context
 	a.context.AddMessage(llm.Message{
 		Role:    llm.RoleUser,
 		Content: userMessage,
+		Images:  images,
 	})
 
 	// Run through the lifecycle phases
@@ -122,25 +233,20 @@ func (a *Agent) runLifecycle(ctx context.Context) (*Response, error) {
 				return &response, nil
 			}
 
-			// Check if we have a plan in the response
-			if a.detectPlan(resp.Message) {
-				a.lifecycle.NextPhase() // Move to Plan
-			} else if a.detectAction(resp.Message) {
-				a.lifecycle.SetPhase(PhaseAct) // Skip to Act if direct action
+			// A plan submitted through submit_plan moves us to Plan;
+			// otherwise the request was handled directly
+			if a.lifecycle.HasPendingSteps() {
+				a.nextPhase() // Move to Plan
 			} else {
-				// Simple response, no planning needed
 				response.Done = true
-				a.lifecycle.SetPhase(PhaseVerify)
+				a.setPhase(PhaseVerify)
 			}
 
 		case PhasePlan:
-			// Extract and set the plan steps
-			steps := a.extractPlanSteps(response.Message)
-			if len(steps) > 0 {
-				a.lifecycle.SetPlan(steps)
-				response.PlanSteps = a.lifecycle.GetPlan()
-			}
-			a.lifecycle.NextPhase() // Move to Act
+			// Wait for the user to review the plan; ApprovePlan resumes
+			response.PlanSteps = a.lifecycle.GetPlan()
+			response.AwaitingPlanApproval = true
+			return &response, nil
 
 		case PhaseAct:
 			// Execute the plan steps
@@ -160,23 +266,53 @@ func (a *Agent) runLifecycle(ctx context.Context) (*Response, error) {
 				return &response, nil
 			}
 
-			if resp.Done || a.lifecycle.AllStepsCompleted() {
-				a.lifecycle.NextPhase() // Move to Verify
+			if !a.lifecycle.HasPendingSteps() {
+				a.nextPhase() // Move to Verify
 			} else {
-				// Continue acting
+				// Continue with the next step
 				continue
 			}
 
 		case PhaseVerify:
-			// Verify the results
-			resp, err := a.verify(ctx)
+			// Nothing was changed, so there is nothing to verify
+			if !a.acted {
+				response.Done = true
+				return &response, nil
+			}
+
+			// Without configured commands, ask the model to check its work
+			if len(a.verifier.Commands()) == 0 {
+				resp, err := a.verify(ctx)
+				if err != nil {
+					response.Error = err
+					return &response, err
+				}
+				response.Message = resp.Message
+				response.Done = true
+				return &response, nil
+			}
+
+			report, err := a.verifier.Run(ctx)
 			if err != nil {
 				response.Error = err
 				return &response, err
 			}
-			response.Message = resp.Message
-			response.Done = true
-			return &response, nil
+			report.Attempt = a.repairAttempts
+			response.Verification = report
+			a.emit(Event{Type: EventVerification, Verification: report})
+
+			if report.Passed || a.repairAttempts >= a.verifier.MaxRepairAttempts() {
+				response.Done = true
+				return &response, nil
+			}
+
+			// Feed the failures back and let Act repair them
+			a.context.AddMessage(llm.Message{
+				Role:    llm.RoleUser,
+				Content: report.Feedback(a.verifier.MaxRepairAttempts()),
+			})
+			a.repairAttempts++
+			a.setPhase(PhaseAct)
 		}
 	}
 }
@@ -188,32 +324,65 @@ func (a *Agent) understand(ctx context.Context) (*Response, error) {
 
 // act executes the planned actions
 func (a *Agent) act(ctx context.Context) (*Response, error) {
-	// Start the next step if available
-	step := a.lifecycle.StartNextStep()
-	if step != nil {
-		// Add step context to the conversation
-		a.context.AddMessage(llm.Message{
-			Role:    llm.RoleUser,
-			Content: fmt.Sprintf("Execute step %d: %s", step.ID+1, step.Description),
-		})
+	// Resume a step interrupted by approvals, or start the next one
+	step := a.lifecycle.CurrentStep()
+	if step == nil || step.Status != StepInProgress {
+		step = a.lifecycle.StartNextStep()
+		if step == nil && a.lifecycle.HasPendingSteps() {
+			return a.stopStuckPlan(), nil
+		}
+		if step != nil {
+			a.emitPlan()
+
+			// Add step context to the conversation
+			a.context.AddMessage(llm.Message{
+				Role:    llm.RoleUser,
+				Content: stepInstruction(step, len(a.lifecycle.GetPlan())),
+			})
+		}
 	}
 
+	a.acted = true
 	resp, err := a.loop(ctx)
 	if err != nil {
 		if step != nil {
 			a.lifecycle.FailCurrentStep(err)
+			a.emitPlan()
 		}
 		return resp, err
 	}
 
+	// The step may have failed or been replaced by a revised plan
+	a.replanning = false
 	if step != nil {
-		a.lifecycle.CompleteCurrentStep(resp.Message)
+		if current := a.lifecycle.CurrentStep(); current != nil && current.Status == StepInProgress {
+			a.lifecycle.CompleteCurrentStep(resp.Message)
+			a.emitPlan()
+		}
 	}
 
 	return resp, nil
 }
 
-// verify confirms the results of the actions
+// stopStuckPlan blocks the pending steps when none of them can start,
+// which happens when their dependencies wait on each other, so the plan
+// ends instead of asking the model to act with no step to work on
+func (a *Agent) stopStuckPlan() *Response {
+	blocked := a.lifecycle.BlockPendingSteps(fmt.Errorf("its dependencies can never finish"))
+	a.emitPlan()
+
+	numbers := make([]string, len(blocked))
+	for i, id := range blocked {
+		numbers[i] = strconv.Itoa(id + 1)
+	}
+	return &Response{
+		Message: fmt.Sprintf("Stopped the plan: steps %s cannot start because their dependencies can never finish.",
+			strings.Join(numbers, ", ")),
+	}
+}
+
+// verify asks the model to confirm the results of the actions. It is used
+// when no verification commands are configured.
 func (a *Agent) verify(ctx context.Context) (*Response, error) {
 	// Add verification prompt
 	a.context.AddMessage(llm.Message{
@@ -224,89 +393,19 @@ func (a *Agent) verify(ctx context.Context) (*Response, error) {
 	return a.loop(ctx)
 }
 
-// detectPlan checks if the response contains a plan
-func (a *Agent) detectPlan(content string) bool {
-	planIndicators := []string{
-		"here's my plan",
-		"i will:",
-		"steps:",
-		"plan:",
-		"1.",
-		"step 1:",
-		"first,",
-	}
-
-	contentLower := strings.ToLower(content)
-	for _, indicator := range planIndicators {
-		if strings.Contains(contentLower, indicator) {
-			return true
-		}
-	}
-	return false
-}
-
-// detectAction checks if the response indicates direct action
-func (a *Agent) detectAction(content string) bool {
-	actionIndicators := []string{
-		"<tool_use>",
-		"let me",
-		"i'll read",
-		"i'll check",
-		"reading",
-		"checking",
-	}
-
-	contentLower := strings.ToLower(content)
-	for _, indicator := range actionIndicators {
-		if strings.Contains(contentLower, indicator) {
-			return true
-		}
-	}
-	return false
-}
-
-// extractPlanSteps parses plan steps from the message
-func (a *Agent) extractPlanSteps(content string) []string {
-	var steps []string
-	lines := strings.Split(content, "\n")
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// Look for numbered steps (1., 2., etc.)
-		if len(line) > 2 && line[0] >= '1' && line[0] <= '9' && line[1] == '.' {
-			step := strings.TrimSpace(line[2:])
-			if step != "" {
-				steps = append(steps, step)
-			}
-		}
-
-		// Look for bullet points with "Step N:"
-		if strings.HasPrefix(strings.ToLower(line), "step ") {
-			colonIdx := strings.Index(line, ":")
-			if colonIdx > 0 && colonIdx < len(line)-1 {
-				step := strings.TrimSpace(line[colonIdx+1:])
-				if step != "" {
-					steps = append(steps, step)
-				}
-			}
-		}
-	}
-
-	return steps
-}
-
 // Response represents the agent's response to a request
 type Response struct {
-	Message          string                // The assistant's text response
-	ToolCalls        []schema.ToolCall     // Tools that were called
-	ToolResults      []schema.ToolResult   // Results from tool execution
-	RequiresApproval bool                  // Whether any tools require approval
-	PendingApprovals []PendingApproval     // Tools waiting for approval
-	Done             bool                  // Whether processing is complete
-	Error            error                 // Any error that occurred
-	Phase            Phase                 // Current lifecycle phase
-	PlanSteps        []PlanStep            // Plan steps if in planning phase
+	Message              string              // The assistant's text response
+	ToolCalls            []schema.ToolCall   // Tools that were called
+	ToolResults          []schema.ToolResult // Results from tool execution
+	RequiresApproval     bool                // Whether any tools require approval
+	PendingApprovals     []PendingApproval   // Tools waiting for approval
+	Done                 bool                // Whether processing is complete
+	Error                error               // Any error that occurred
+	Phase                Phase               // Current lifecycle phase
+	PlanSteps            []PlanStep          // Plan steps if in planning phase
+	Verification         *VerifyReport       // Outcome of the last verification run, if any
+	AwaitingPlanApproval bool                // Whether the plan is waiting for the user to approve it
 }
 
 // PendingApproval represents a tool call waiting for user approval
@@ -321,27 +420,50 @@ func (a *Agent) loop(ctx context.Context) (*Response, error) {
 	maxIterations := 10 // Prevent infinite loops
 
 	for iteration := 0; iteration < maxIterations; iteration++ {
+		// Stop before starting another iteration if the turn was cancelled
+		if err := ctx.Err(); err != nil {
+			response.Error = err
+			return &response, err
+		}
+
 		// Prepare LLM request with conversation history
 		llmReq := a.prepareLLMRequest()
 
 		// Call LLM
-		llmResp, err := a.llmClient.Complete(ctx, llmReq)
+		llmResp, err := a.complete(ctx, llmReq)
 		if err != nil {
+			if ctx.Err() != nil {
+				// Cancelled mid-response: keep the text that already reached the user
+				if llmResp != nil && llmResp.Content != "" {
+					a.context.AddMessage(llm.Message{
+						Role:    llm.RoleAssistant,
+						Content: llmResp.Content,
+					})
+					response.Message = llmResp.Content
+				}
+				response.Error = ctx.Err()
+				return &response, response.Error
+			}
+
 			response.Error = fmt.Errorf("LLM request failed: %w", err)
-			return &response, err
+			return &response, response.Error
 		}
 
-		// Add assistant message to context
+		// Add assistant message to context, including any tool uses so the
+		// provider can match the results we send back
+		// Signed reasoning must also go back while its tool uses are answered
 		a.context.AddMessage(llm.Message{
-			Role:    llm.RoleAssistant,
-			Content: llmResp.Content,
+			Role:      llm.RoleAssistant,
+			Content:   llmResp.Content,
+			ToolCalls: llmResp.ToolCalls,
+			Reasoning: llmResp.Reasoning,
 		})
 
 		// Update response message
 		response.Message = llmResp.Content
 
 		// Check if there are tool calls in the response
-		toolCalls := a.extractToolCalls(llmResp.Content)
+		toolCalls := llmResp.ToolCalls
 		if len(toolCalls) == 0 {
 			// No tool calls, we're done
 			response.Done = true
@@ -351,14 +473,45 @@ func (a *Agent) loop(ctx context.Context) (*Response, error) {
 		// Execute tools
 		var pendingApprovals []PendingApproval
 		var executedResults []schema.ToolResult
+		var toolResults []llm.ToolResult
+
+		planSubmitted := false
+		stepFailed := false
+
+		for i, toolCall := range toolCalls {
+			if ctx.Err() != nil {
+				// Every tool use needs a result, so report the rest as cancelled
+				for _, skipped := range toolCalls[i:] {
+					toolResults = append(toolResults, cancelledToolResult(skipped))
+				}
+				break
+			}
+
+			// Plans drive the lifecycle rather than the workspace
+			if toolCall.Name == SubmitPlanToolName {
+				result, ok := a.submitPlan(toolCall)
+				toolResults = append(toolResults, result)
+				planSubmitted = planSubmitted || ok
+				continue
+			}
+			if toolCall.Name == FailStepToolName {
+				result, stop := a.failStep(toolCall)
+				toolResults = append(toolResults, result)
+				stepFailed = stepFailed || stop
+				continue
+			}
+
+			a.emit(Event{Type: EventToolCallStart, ToolCall: &toolCall})
 
-		for _, toolCall := range toolCalls {
 			result, err := a.toolRegistry.Execute(ctx, toolCall)
 			if err != nil {
-				// Tool execution failed, add error to context and continue
-				a.context.AddMessage(llm.Message{
-					Role:    llm.RoleUser,
-					Content: fmt.Sprintf("Tool %s failed: %v", toolCall.Name, err),
+				a.emit(Event{Type: EventToolCallFinish, ToolCall: &toolCall, Error: err})
+
+				// Tool execution failed, report the error back to the model
+				toolResults = append(toolResults, llm.ToolResult{
+					ToolCallID: toolCall.ID,
+					Content:    fmt.Sprintf("Tool %s failed: %v", toolCall.Name, err),
+					IsError:    true,
 				})
 				continue
 			}
@@ -371,19 +524,36 @@ func (a *Agent) loop(ctx context.Context) (*Response, error) {
 				})
 			} else {
 				// Tool executed successfully
+				a.emit(Event{Type: EventToolCallFinish, ToolCall: &toolCall, ToolResult: result})
 				executedResults = append(executedResults, *result)
+				toolResults = append(toolResults, toolResultMessage(toolCall, result))
+			}
+		}
 
-				// Add tool result to context
-				a.context.AddMessage(llm.Message{
-					Role:    llm.RoleUser,
-					Content: fmt.Sprintf("Tool %s result:\n%s", toolCall.Name, result.Output),
-				})
+		// Approvals can't be answered once the turn is cancelled
+		if ctx.Err() != nil {
+			for _, pending := range pendingApprovals {
+				toolResults = append(toolResults, cancelledToolResult(pending.ToolCall))
 			}
+			pendingApprovals = nil
+		}
+
+		// Results for a single assistant turn go back in a single user turn
+		if len(toolResults) > 0 {
+			a.context.AddMessage(llm.Message{
+				Role:        llm.RoleUser,
+				ToolResults: toolResults,
+			})
 		}
 
 		response.ToolCalls = toolCalls
 		response.ToolResults = executedResults
 
+		if err := ctx.Err(); err != nil {
+			response.Error = err
+			return &response, err
+		}
+
 		// If there are pending approvals, return and wait for user
 		if len(pendingApprovals) > 0 {
 			response.RequiresApproval = true
@@ -392,14 +562,14 @@ func (a *Agent) loop(ctx context.Context) (*Response, error) {
 			return &response, nil
 		}
 
-		// If all tools executed, continue the loop to let LLM process results
-		if len(executedResults) > 0 {
-			continue
+		// A new plan, or a failed step that can't be re-planned, hands
+		// control back to the lifecycle
+		if planSubmitted || stepFailed {
+			return &response, nil
 		}
 
-		// No tools executed and no pending approvals, we're done
-		response.Done = true
-		return &response, nil
+		// Continue the loop to let the LLM process results (including errors)
+		continue
 	}
 
 	// Hit max iterations
@@ -408,6 +578,52 @@ func (a *Agent) loop(ctx context.Context) (*Response, error) {
 	return &response, response.Error
 }
 
+// complete sends a request to the LLM. When an event callback is set the
+// response is streamed so text deltas reach the UI as they arrive.
+// If the stream fails part way, the text received so far is returned
+// along with the error.
+func (a *Agent) complete(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	if a.onEvent == nil {
+		return a.llmClient.Complete(ctx, req)
+	}
+
+	resp := llm.Response{Role: llm.RoleAssistant, Model: a.llmClient.Model()}
+	var content strings.Builder
+
+	req.Stream = true
+	err := a.llmClient.Stream(ctx, req, func(event llm.StreamEvent) {
+		if event.Delta != "" {
+			content.WriteString(event.Delta)
+			a.emit(Event{Type: EventTextDelta, Delta: event.Delta})
+		}
+		if event.Reasoning != "" {
+			a.emit(Event{Type: EventReasoningDelta, Delta: event.Reasoning})
+		}
+		if event.ReasoningBlock != nil {
+			resp.Reasoning = append(resp.Reasoning, *event.ReasoningBlock)
+		}
+		if event.ToolCall != nil {
+			resp.ToolCalls = append(resp.ToolCalls, *event.ToolCall)
+		}
+		if event.Done {
+			resp.FinishReason = event.FinishReason
+			if event.Usage != nil {
+				resp.Usage = *event.Usage
+			}
+		}
+	})
+	if err != nil {
+		// Tool calls are dropped: without a result they would leave the
+		// history unanswerable
+		return &llm.Response{Role: llm.RoleAssistant, Content: content.String()}, err
+	}
+
+	resp.Content = content.String()
+	a.emit(Event{Type: EventUsage, Usage: &resp.Usage})
+
+	return &resp, nil
+}
+
 // ApproveToolCall executes a tool that was previously pending approval
 func (a *Agent) ApproveToolCall(ctx context.Context, toolCall schema.ToolCall) (*schema.ToolResult, error) {
 	// Get the tool
@@ -416,18 +632,29 @@ func (a *Agent) ApproveToolCall(ctx context.Context, toolCall schema.ToolCall) (
 		return nil, fmt.Errorf("tool not found: %w", err)
 	}
 
-	// Execute the tool directly (bypassing approval check)
+	// Execute the tool directly (bypassing approval check). The call needs
+	// a result in the context even when it fails or is cancelled.
 	result, err := tool.Execute(ctx, toolCall.Arguments)
 	if err != nil {
+		failed := llm.ToolResult{
+			ToolCallID: toolCall.ID,
+			Content:    fmt.Sprintf("Tool %s failed: %v", toolCall.Name, err),
+			IsError:    true,
+		}
+		if ctx.Err() != nil {
+			failed = cancelledToolResult(toolCall)
+		}
+		a.context.AddMessage(llm.Message{Role: llm.RoleUser, ToolResults: []llm.ToolResult{failed}})
 		return nil, fmt.Errorf("tool execution failed: %w", err)
 	}
 
 	result.ToolCallID = toolCall.ID
+	a.acted = true
 
 	// Add tool result to context
 	a.context.AddMessage(llm.Message{
-		Role:    llm.RoleUser,
-		Content: fmt.Sprintf("Tool %s approved and executed:\n%s", toolCall.Name, result.Output),
+		Role:        llm.RoleUser,
+		ToolResults: []llm.ToolResult{toolResultMessage(toolCall, result)},
 	})
 
 	return result, nil
@@ -437,11 +664,38 @@ func (a *Agent) ApproveToolCall(ctx context.Context, toolCall schema.ToolCall) (
 func (a *Agent) RejectToolCall(toolCall schema.ToolCall, reason string) {
 	// Add rejection to context
 	a.context.AddMessage(llm.Message{
-		Role:    llm.RoleUser,
-		Content: fmt.Sprintf("Tool %s rejected: %s", toolCall.Name, reason),
+		Role: llm.RoleUser,
+		ToolResults: []llm.ToolResult{{
+			ToolCallID: toolCall.ID,
+			Content:    fmt.Sprintf("Tool %s rejected by user: %s", toolCall.Name, reason),
+			IsError:    true,
+		}},
 	})
 }
 
+// toolResultMessage converts a tool execution result into the form sent to the LLM
+func toolResultMessage(toolCall schema.ToolCall, result *schema.ToolResult) llm.ToolResult {
+	content := result.Output
+	if !result.Success && result.Error != "" {
+		content = strings.TrimSpace(content + "\n" + result.Error)
+	}
+
+	return llm.ToolResult{
+		ToolCallID: toolCall.ID,
+		Content:    content,
+		IsError:    !result.Success,
+	}
+}
+
+// cancelledToolResult reports a tool call that never ran because the turn was cancelled
+func cancelledToolResult(toolCall schema.ToolCall) llm.ToolResult {
+	return llm.ToolResult{
+		ToolCallID: toolCall.ID,
+		Content:    fmt.Sprintf("Tool %s was not run: cancelled by user", toolCall.Name),
+		IsError:    true,
+	}
+}
+
 // ContinueAfterApproval continues the agent loop after approvals/rejections
 func (a *Agent) ContinueAfterApproval(ctx context.Context) (*Response, error) {
 	// Continue from the current lifecycle phase
@@ -468,73 +722,25 @@ func (a *Agent) prepareLLMRequest() llm.Request {
 		}, messages...)
 	}
 
-	// Add tool definitions
-	toolDefs := a.toolRegistry.ListDefinitions()
-
-	return llm.Request{
-		Messages:    messages,
-		Tools:       convertToolDefinitions(toolDefs),
-		MaxTokens:   4096,
-		Temperature: 0.7,
-	}
-}
-
-// extractToolCalls parses tool calls from the LLM response
-// This is a simplified implementation - real tool calls would use structured output
-func (a *Agent) extractToolCalls(content string) []schema.ToolCall {
-	var toolCalls []schema.ToolCall
-
-	// Look for tool call markers in the format:
-	// <tool_use>
-	// {
-	//   "name": "tool_name",
-	//   "arguments": {...}
-	// }
-	// </tool_use>
-
-	// For now, we'll use a simple approach
-	// In a production system, this would parse the LLM's structured tool call format
-
-	start := 0
-	for {
-		// Find tool_use tag
-		startTag := "<tool_use>"
-		endTag := "</tool_use>"
-
-		startIdx := strings.Index(content[start:], startTag)
-		if startIdx == -1 {
-			break
-		}
-		startIdx += start
-
-		endIdx := strings.Index(content[startIdx:], endTag)
-		if endIdx == -1 {
-			break
+	// Add tool definitions, including the agent's own plan tools, for
+	// models that can call them
+	var tools []llm.Tool
+	if a.model.Tools {
+		toolDefs := a.toolRegistry.ListDefinitions()
+		tools = append(convertToolDefinitions(toolDefs), submitPlanTool())
+		if step := a.lifecycle.CurrentStep(); a.lifecycle.CurrentPhase() == PhaseAct && step != nil && step.Status == StepInProgress {
+			tools = append(tools, failStepTool())
 		}
-		endIdx += startIdx
-
-		// Extract JSON between tags
-		jsonStr := content[startIdx+len(startTag) : endIdx]
-		jsonStr = strings.TrimSpace(jsonStr)
-
-		// Parse the tool call
-		var toolCall struct {
-			Name      string         `json:"name"`
-			Arguments map[string]any `json:"arguments"`
-		}
-
-		if err := json.Unmarshal([]byte(jsonStr), &toolCall); err == nil {
-			toolCalls = append(toolCalls, schema.ToolCall{
-				ID:        fmt.Sprintf("call_%d", len(toolCalls)),
-				Name:      toolCall.Name,
-				Arguments: toolCall.Arguments,
-			})
-		}
-
-		start = endIdx + len(endTag)
 	}
 
-	return toolCalls
+	return llm.Request{
+		Messages:        messages,
+		Tools:           tools,
+		MaxTokens:       a.replyTokens(),
+		Temperature:     a.temperature,
+		ThinkingBudget:  a.thinkingBudget,
+		ReasoningEffort: a.reasoningEffort,
+	}
 }
 
 // convertToolDefinitions converts schema.ToolDefinition to llm.Tool
@@ -564,6 +770,9 @@ func convertParameters(params []schema.ToolParameter) map[string]any {
 		if param.Default != nil {
 			prop["default"] = param.Default
 		}
+		if param.Items != nil {
+			prop["items"] = param.Items
+		}
 
 		properties[param.Name] = prop
 
@@ -586,7 +795,7 @@ func convertParameters(params []schema.ToolParameter) map[string]any {
 
 // Reset clears the agent's context and state
 func (a *Agent) Reset() {
-	a.context = NewContext()
+	a.context = a.newContext()
 	a.lifecycle.Reset()
 }
 
@@ -0,0 +1,555 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/internal/tools"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// scriptedClient is an llm.Client that returns queued responses in order
+type scriptedClient struct {
+	responses []*llm.Response
+	requests  []llm.Request
+}
+
+func (c *scriptedClient) Complete(ctx context.Context, req llm.Request) (*llm.Response, error) {
+	c.requests = append(c.requests, req)
+	if len(c.responses) == 0 {
+		return nil, errors.New("no scripted response left")
+	}
+	resp := c.responses[0]
+	c.responses = c.responses[1:]
+	return resp, nil
+}
+
+// Stream replays the next scripted response as a word-by-word stream
+func (c *scriptedClient) Stream(ctx context.Context, req llm.Request, callback llm.StreamCallback) error {
+	resp, err := c.Complete(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	for i := range resp.Reasoning {
+		callback(llm.StreamEvent{Reasoning: resp.Reasoning[i].Text})
+		callback(llm.StreamEvent{ReasoningBlock: &resp.Reasoning[i]})
+	}
+	for _, word := range strings.SplitAfter(resp.Content, " ") {
+		if word != "" {
+			callback(llm.StreamEvent{Delta: word})
+		}
+	}
+	for i := range resp.ToolCalls {
+		callback(llm.StreamEvent{ToolCall: &resp.ToolCalls[i]})
+	}
+	callback(llm.StreamEvent{Done: true, Usage: &resp.Usage})
+	return nil
+}
+
+func (c *scriptedClient) Provider() llm.ProviderType  { return llm.ProviderAnthropic }
+func (c *scriptedClient) Model() string               { return "test-model" }
+func (c *scriptedClient) CountTokens(text string) int { return len(text) / 4 }
+
+// echoTool returns its "text" argument
+type echoTool struct {
+	tools.BaseTool
+	approval bool
+}
+
+func newEchoTool(approval bool) *echoTool {
+	return &echoTool{
+		BaseTool: tools.NewBaseTool("echo", "Echo text back", []schema.ToolParameter{
+			{Name: "text", Description: "Text to echo", Type: "string", Required: true},
+		}),
+		approval: approval,
+	}
+}
+
+func (t *echoTool) Execute(ctx context.Context, args map[string]any) (*schema.ToolResult, error) {
+	text, _ := args["text"].(string)
+	return &schema.ToolResult{Success: true, Output: text}, nil
+}
+
+func (t *echoTool) RequiresApproval(args map[string]any) bool {
+	return t.approval
+}
+
+func newTestAgent(t *testing.T, client llm.Client, approval bool) *Agent {
+	t.Helper()
+	registry := tools.NewRegistry()
+	if err := registry.Register(newEchoTool(approval)); err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+	return NewAgent(client, registry, Config{})
+}
+
+// cassetteClient replays the LLM traffic recorded in
+// testdata/cassettes/<name>.json. With ANVIL_CASSETTE=record it records the
+// cassette again against Anthropic, using ANVIL_ANTHROPIC_API_KEY.
+func cassetteClient(t *testing.T, name string) *llm.CassetteClient {
+	t.Helper()
+
+	var live llm.Client
+	mode := llm.CassetteModeFromEnv()
+	if mode == llm.CassetteRecord {
+		apiKey := os.Getenv("ANVIL_ANTHROPIC_API_KEY")
+		if apiKey == "" {
+			t.Skip("recording a cassette needs ANVIL_ANTHROPIC_API_KEY")
+		}
+		client, err := llm.NewClient(llm.ClientConfig{
+			Provider: llm.ProviderAnthropic,
+			APIKey:   apiKey,
+			Model:    "claude-sonnet-4-5",
+		})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		live = client
+	}
+
+	cassette, err := llm.NewCassetteClient(live, filepath.Join("testdata", "cassettes", name+".json"), mode)
+	if err != nil {
+		t.Fatalf("failed to open cassette: %v", err)
+	}
+	t.Cleanup(func() {
+		if !t.Failed() {
+			if err := cassette.Save(); err != nil {
+				t.Errorf("failed to save cassette: %v", err)
+			}
+		}
+	})
+	return cassette
+}
+
+// TestAgentCassetteSession runs a whole request through the lifecycle
+// (plan, approval, tool calls, verification) against a cassette. The
+// cassette is a synthetic fixture written by hand, not recorded traffic,
+// until it is re-recorded against the live API.
+func TestAgentCassetteSession(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "greeting.txt")
+	if err := os.WriteFile(path, []byte("hello\n"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	client := cassetteClient(t, "uppercase_file")
+	client.Scrub(dir, "$WORKDIR")
+
+	registry := tools.NewRegistry()
+	registry.Register(tools.NewReadFileTool())
+	registry.Register(tools.NewWriteFileTool())
+	a := NewAgent(client, registry, Config{MaxTokens: 4096, Temperature: 0.7}) // As in the cassette
+	ctx := context.Background()
+
+	resp, err := a.ProcessRequest(ctx, "Make the text in "+path+" uppercase. Submit a one-step plan first.")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.AwaitingPlanApproval {
+		t.Fatalf("expected a plan to approve, got %+v", resp)
+	}
+
+	resp, err = a.ApprovePlan(ctx, resp.PlanSteps)
+	for err == nil && resp.RequiresApproval {
+		for _, pending := range resp.PendingApprovals {
+			if _, err := a.ApproveToolCall(ctx, pending.ToolCall); err != nil {
+				t.Fatalf("failed to run approved tool: %v", err)
+			}
+		}
+		resp, err = a.ContinueAfterApproval(ctx)
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !resp.Done || !a.lifecycle.AllStepsCompleted() {
+		t.Errorf("expected the plan to complete, got %+v", a.lifecycle.GetPlan())
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if strings.TrimSpace(string(content)) != "HELLO" {
+		t.Errorf("expected the file to be uppercased, got %q", content)
+	}
+}
+
+func TestAgentLoopNativeToolCalls(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{{ID: "toolu_abc", Name: "echo", Arguments: map[string]any{"text": "hi"}}}},
+			{Content: "The tool said hi."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+	a.context.AddMessage(llm.Message{Role: llm.RoleUser, Content: "say hi"})
+
+	resp, err := a.loop(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.Message != "The tool said hi." {
+		t.Errorf("unexpected message %q", resp.Message)
+	}
+
+	if len(client.requests) != 2 {
+		t.Fatalf("expected 2 LLM requests, got %d", len(client.requests))
+	}
+
+	offered := client.requests[0].Tools
+	if len(offered) != 2 || offered[0].Name != "echo" || offered[1].Name != SubmitPlanToolName {
+		t.Errorf("expected echo and submit_plan tools to be offered, got %+v", offered)
+	}
+
+	messages := a.context.GetMessages()
+	if len(messages) != 4 {
+		t.Fatalf("expected 4 messages in context, got %d", len(messages))
+	}
+
+	if len(messages[1].ToolCalls) != 1 || messages[1].ToolCalls[0].ID != "toolu_abc" {
+		t.Errorf("expected assistant tool call to be recorded, got %+v", messages[1])
+	}
+
+	results := messages[2].ToolResults
+	if len(results) != 1 || results[0].ToolCallID != "toolu_abc" || results[0].Content != "hi" {
+		t.Errorf("expected tool result for toolu_abc, got %+v", results)
+	}
+}
+
+func TestAgentLoopUnknownToolReportsError(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{{ID: "toolu_x", Name: "missing_tool"}}},
+			{Content: "Sorry."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+	a.context.AddMessage(llm.Message{Role: llm.RoleUser, Content: "go"})
+
+	if _, err := a.loop(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	messages := a.context.GetMessages()
+	results := messages[2].ToolResults
+	if len(results) != 1 || !results[0].IsError || results[0].ToolCallID != "toolu_x" {
+		t.Errorf("expected error result for toolu_x, got %+v", results)
+	}
+}
+
+func TestAgentLoopReturnsWrappedLLMError(t *testing.T) {
+	a := newTestAgent(t, &scriptedClient{}, false)
+
+	resp, err := a.ProcessRequest(context.Background(), "hello")
+	if err == nil || !strings.Contains(err.Error(), "LLM request failed: no scripted response left") {
+		t.Fatalf("expected the wrapped LLM error, got %v", err)
+	}
+	if resp == nil || resp.Error == nil || resp.Error.Error() != err.Error() {
+		t.Errorf("expected the response to carry the returned error, got %+v", resp)
+	}
+}
+
+func TestAgentApproveAndRejectToolCall(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{
+				{ID: "toolu_1", Name: "echo", Arguments: map[string]any{"text": "one"}},
+				{ID: "toolu_2", Name: "echo", Arguments: map[string]any{"text": "two"}},
+			}},
+		},
+	}
+	a := newTestAgent(t, client, true)
+	a.context.AddMessage(llm.Message{Role: llm.RoleUser, Content: "go"})
+
+	resp, err := a.loop(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !resp.RequiresApproval || len(resp.PendingApprovals) != 2 {
+		t.Fatalf("expected 2 pending approvals, got %+v", resp.PendingApprovals)
+	}
+
+	if _, err := a.ApproveToolCall(context.Background(), resp.PendingApprovals[0].ToolCall); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.RejectToolCall(resp.PendingApprovals[1].ToolCall, "not now")
+
+	messages := a.context.GetMessages()
+	approved := messages[len(messages)-2].ToolResults
+	rejected := messages[len(messages)-1].ToolResults
+
+	if len(approved) != 1 || approved[0].ToolCallID != "toolu_1" || approved[0].IsError {
+		t.Errorf("unexpected approved result %+v", approved)
+	}
+
+	if len(rejected) != 1 || rejected[0].ToolCallID != "toolu_2" || !rejected[0].IsError {
+		t.Errorf("unexpected rejected result %+v", rejected)
+	}
+}
+
+func TestAgentStreamsEvents(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{
+				Content:   "Echoing. ",
+				ToolCalls: []schema.ToolCall{{ID: "toolu_1", Name: "echo", Arguments: map[string]any{"text": "hi"}}},
+				Usage:     llm.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15},
+			},
+			{Content: "Echo said hi."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+
+	var events []Event
+	a.SetEventCallback(func(event Event) {
+		events = append(events, event)
+	})
+
+	resp, err := a.ProcessRequest(context.Background(), "echo hi")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !client.requests[0].Stream {
+		t.Error("expected requests to be streamed when a callback is set")
+	}
+
+	var text strings.Builder
+	var types []EventType
+	for _, event := range events {
+		if event.Type == EventTextDelta {
+			text.WriteString(event.Delta)
+			continue
+		}
+		types = append(types, event.Type)
+	}
+
+	if text.String() != "Echoing. Echo said hi." {
+		t.Errorf("unexpected streamed text %q", text.String())
+	}
+
+	if resp.Message != "Echo said hi." {
+		t.Errorf("unexpected message %q", resp.Message)
+	}
+
+	expected := []EventType{
+		EventPhaseChange, EventUsage, EventToolCallStart, EventToolCallFinish,
+		EventUsage, EventPhaseChange,
+	}
+	if len(types) != len(expected) {
+		t.Fatalf("expected %d events, got %v", len(expected), types)
+	}
+	for i, want := range expected {
+		if types[i] != want {
+			t.Errorf("event %d = %s, want %s", i, types[i], want)
+		}
+	}
+
+	for _, event := range events {
+		if event.Type == EventToolCallFinish {
+			if event.ToolResult == nil || event.ToolResult.Output != "hi" {
+				t.Errorf("expected tool result in finish event, got %+v", event)
+			}
+		}
+	}
+
+	messages := a.context.GetMessages()
+	if len(messages[1].ToolCalls) != 1 || messages[1].ToolCalls[0].ID != "toolu_1" {
+		t.Errorf("expected streamed tool call to be recorded, got %+v", messages[1])
+	}
+}
+
+// cancellingClient streams part of an answer and then cancels the turn
+type cancellingClient struct {
+	scriptedClient
+	cancel context.CancelFunc
+}
+
+func (c *cancellingClient) Stream(ctx context.Context, req llm.Request, callback llm.StreamCallback) error {
+	callback(llm.StreamEvent{Delta: "Partial answer"})
+	callback(llm.StreamEvent{ToolCall: &schema.ToolCall{ID: "toolu_1", Name: "echo"}})
+	c.cancel()
+	return ctx.Err()
+}
+
+// cancellingTool cancels the turn while it runs
+type cancellingTool struct {
+	tools.BaseTool
+	cancel context.CancelFunc
+}
+
+func (t *cancellingTool) Execute(ctx context.Context, args map[string]any) (*schema.ToolResult, error) {
+	t.cancel()
+	return &schema.ToolResult{Success: true, Output: "done"}, nil
+}
+
+func (t *cancellingTool) RequiresApproval(args map[string]any) bool {
+	return false
+}
+
+func TestAgentKeepsReasoning(t *testing.T) {
+	thinking := llm.ReasoningBlock{Provider: llm.ProviderAnthropic, Text: "The user wants an echo.", Signature: "sig_1"}
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{
+				Reasoning: []llm.ReasoningBlock{thinking},
+				ToolCalls: []schema.ToolCall{{ID: "toolu_1", Name: "echo", Arguments: map[string]any{"text": "hi"}}},
+			},
+			{Content: "Echo said hi."},
+		},
+	}
+	registry := tools.NewRegistry()
+	registry.Register(newEchoTool(false))
+	a := NewAgent(client, registry, Config{ThinkingBudget: 4000})
+
+	var reasoning strings.Builder
+	a.SetEventCallback(func(event Event) {
+		if event.Type == EventReasoningDelta {
+			reasoning.WriteString(event.Delta)
+		}
+	})
+
+	if _, err := a.ProcessRequest(context.Background(), "echo hi"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if client.requests[0].ThinkingBudget != 4000 {
+		t.Errorf("expected the thinking budget on requests, got %d", client.requests[0].ThinkingBudget)
+	}
+	if reasoning.String() != thinking.Text {
+		t.Errorf("expected reasoning events, got %q", reasoning.String())
+	}
+
+	// The signed reasoning goes back with the tool result it led to
+	var assistant *llm.Message
+	for _, msg := range client.requests[1].Messages {
+		if msg.Role == llm.RoleAssistant {
+			assistant = &msg
+		}
+	}
+	if assistant == nil || len(assistant.Reasoning) != 1 || assistant.Reasoning[0] != thinking {
+		t.Errorf("expected the signed reasoning in the history, got %+v", assistant)
+	}
+}
+
+func TestAgentSizesFromModel(t *testing.T) {
+	registry := tools.NewRegistry()
+
+	// Replies get the model's output limit and the context the rest
+	a := NewAgent(&scriptedClient{}, registry, Config{Model: "claude-3-5-sonnet-20241022"})
+	if got := a.prepareLLMRequest().MaxTokens; got != 8192 {
+		t.Errorf("expected replies sized to the model's output limit, got %d", got)
+	}
+	if got := a.GetContext().GetConfig().MaxTokens; got != 200000-8192 {
+		t.Errorf("expected the context sized to the model's window, got %d", got)
+	}
+
+	// A configured limit wins, and thinking is dropped for models without it
+	a = NewAgent(&scriptedClient{}, registry, Config{Model: "gpt-4", MaxTokens: 1000, ThinkingBudget: 2000})
+	if req := a.prepareLLMRequest(); req.MaxTokens != 1000 || req.ThinkingBudget != 0 {
+		t.Errorf("expected the configured limit without thinking, got %d and %d", req.MaxTokens, req.ThinkingBudget)
+	}
+	if _, err := a.ProcessRequest(context.Background(), "look", llm.Image{Path: "shot.png"}); err == nil {
+		t.Error("expected images to be refused for a model without vision")
+	}
+
+	// Unknown models keep the default context until they report a window
+	a = NewAgent(&scriptedClient{}, registry, Config{})
+	if got := a.GetContext().GetConfig().MaxTokens; got != DefaultContextConfig().MaxTokens {
+		t.Errorf("expected the default context for an unknown model, got %d", got)
+	}
+	a.SetContextWindow(8192)
+	if got := a.GetContext().GetConfig().MaxTokens; got != 8192-2048 {
+		t.Errorf("expected the context fitted to the reported window, got %d", got)
+	}
+	if got := a.prepareLLMRequest().MaxTokens; got != 2048 {
+		t.Errorf("expected replies sized to the reported window, got %d", got)
+	}
+
+	// So do features, such as a local model without vision or thinking
+	a = NewAgent(&scriptedClient{}, registry, Config{Model: "llama3.2", ThinkingBudget: 1024})
+	a.SetFeatures(false, false)
+	if req := a.prepareLLMRequest(); req.ThinkingBudget != 0 {
+		t.Errorf("expected thinking dropped for a model that cannot think, got %d", req.ThinkingBudget)
+	}
+	if _, err := a.ProcessRequest(context.Background(), "look", llm.Image{Path: "shot.png"}); err == nil {
+		t.Error("expected images to be refused for a model that reports no vision")
+	}
+}
+
+func TestAgentCancelDuringStreamKeepsPartialText(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &cancellingClient{cancel: cancel}
+	a := newTestAgent(t, client, false)
+	a.SetEventCallback(func(Event) {})
+	a.context.AddMessage(llm.Message{Role: llm.RoleUser, Content: "go"})
+
+	resp, err := a.loop(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if resp.Message != "Partial answer" {
+		t.Errorf("unexpected message %q", resp.Message)
+	}
+
+	messages := a.context.GetMessages()
+	if len(messages) != 2 {
+		t.Fatalf("expected partial assistant message in context, got %+v", messages)
+	}
+
+	last := messages[1]
+	if last.Role != llm.RoleAssistant || last.Content != "Partial answer" || len(last.ToolCalls) != 0 {
+		t.Errorf("expected text-only partial message, got %+v", last)
+	}
+}
+
+func TestAgentCancelDuringToolsReportsSkippedCalls(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{
+				{ID: "toolu_1", Name: "cancel_turn"},
+				{ID: "toolu_2", Name: "echo", Arguments: map[string]any{"text": "never"}},
+			}},
+		},
+	}
+	a := newTestAgent(t, client, false)
+	tool := &cancellingTool{BaseTool: tools.NewBaseTool("cancel_turn", "Cancel the turn", nil), cancel: cancel}
+	if err := a.toolRegistry.Register(tool); err != nil {
+		t.Fatalf("failed to register tool: %v", err)
+	}
+	a.context.AddMessage(llm.Message{Role: llm.RoleUser, Content: "go"})
+
+	_, err := a.loop(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	if len(client.requests) != 1 {
+		t.Errorf("expected no LLM request after cancellation, got %d", len(client.requests))
+	}
+
+	messages := a.context.GetMessages()
+	results := messages[len(messages)-1].ToolResults
+	if len(results) != 2 {
+		t.Fatalf("expected a result for every tool call, got %+v", results)
+	}
+
+	if results[0].ToolCallID != "toolu_1" || results[0].IsError || results[0].Content != "done" {
+		t.Errorf("expected completed result to be kept, got %+v", results[0])
+	}
+
+	if results[1].ToolCallID != "toolu_2" || !results[1].IsError {
+		t.Errorf("expected skipped call to be reported as cancelled, got %+v", results[1])
+	}
+}
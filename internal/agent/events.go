@@ -0,0 +1,99 @@
+package agent
+
+import (
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// EventType identifies the kind of progress event emitted during a turn
+type EventType int
+
+const (
+	// EventTextDelta carries a chunk of assistant text as it streams in
+	EventTextDelta EventType = iota
+	// EventToolCallStart is emitted before a tool call is executed
+	EventToolCallStart
+	// EventToolCallFinish is emitted once a tool call has a result
+	EventToolCallFinish
+	// EventPhaseChange is emitted when the lifecycle moves to another phase
+	EventPhaseChange
+	// EventPlanUpdate is emitted when the plan or a step's status changes
+	EventPlanUpdate
+	// EventUsage reports token usage for a completed LLM call
+	EventUsage
+	// EventVerification reports the outcome of a verification run
+	EventVerification
+	// EventReasoningDelta carries a chunk of the model's reasoning
+	EventReasoningDelta
+)
+
+// String returns the string representation of an event type
+func (e EventType) String() string {
+	switch e {
+	case EventTextDelta:
+		return "text_delta"
+	case EventToolCallStart:
+		return "tool_call_start"
+	case EventToolCallFinish:
+		return "tool_call_finish"
+	case EventPhaseChange:
+		return "phase_change"
+	case EventPlanUpdate:
+		return "plan_update"
+	case EventUsage:
+		return "usage"
+	case EventVerification:
+		return "verification"
+	case EventReasoningDelta:
+		return "reasoning_delta"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a progress notification emitted while the agent works on a turn
+type Event struct {
+	Type         EventType
+	Delta        string             // Text chunk for EventTextDelta and EventReasoningDelta
+	ToolCall     *schema.ToolCall   // Tool call for EventToolCallStart/Finish
+	ToolResult   *schema.ToolResult // Result for EventToolCallFinish (nil when pending approval or failed)
+	Error        error              // Execution error for EventToolCallFinish
+	Phase        Phase              // Current phase for EventPhaseChange
+	PlanSteps    []PlanStep         // Snapshot of the plan for EventPlanUpdate
+	Usage        *llm.Usage         // Token usage for EventUsage
+	Verification *VerifyReport      // Report for EventVerification
+}
+
+// EventCallback receives agent events. It is called synchronously from the
+// goroutine running the turn and must not block.
+type EventCallback func(event Event)
+
+// SetEventCallback sets the callback for turn events. When a callback is set
+// the agent streams LLM output and reports text deltas as they arrive.
+func (a *Agent) SetEventCallback(cb EventCallback) {
+	a.onEvent = cb
+}
+
+// emit delivers an event to the callback if one is set
+func (a *Agent) emit(event Event) {
+	if a.onEvent != nil {
+		a.onEvent(event)
+	}
+}
+
+// setPhase changes the lifecycle phase and reports it
+func (a *Agent) setPhase(phase Phase) {
+	a.lifecycle.SetPhase(phase)
+	a.emit(Event{Type: EventPhaseChange, Phase: phase})
+}
+
+// nextPhase advances the lifecycle and reports the new phase
+func (a *Agent) nextPhase() {
+	a.lifecycle.NextPhase()
+	a.emit(Event{Type: EventPhaseChange, Phase: a.lifecycle.CurrentPhase()})
+}
+
+// emitPlan reports the current state of the plan
+func (a *Agent) emitPlan() {
+	a.emit(Event{Type: EventPlanUpdate, PlanSteps: a.lifecycle.GetPlan()})
+}
@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -44,11 +45,15 @@ type Lifecycle struct {
 
 // PlanStep represents a single step in the execution plan
 type PlanStep struct {
-	ID          int
-	Description string
-	Status      StepStatus
-	Result      string
-	Error       error
+	ID        int
+	Title     string   // Short description of what the step does
+	Rationale string   // Why the step is needed
+	Files     []string // Files the step expects to touch
+	DependsOn []int    // IDs of steps that must complete first
+	Revision  int      // Re-plan that added the step; 0 for the original plan
+	Status    StepStatus
+	Result    string
+	Error     error
 }
 
 // StepStatus represents the status of a plan step
@@ -59,6 +64,9 @@ const (
 	StepInProgress
 	StepCompleted
 	StepFailed
+	StepSkipped
+	StepBlocked  // A step it depends on failed or was blocked
+	StepReplaced // Superseded by a revised plan
 )
 
 // String returns the string representation of a step status
@@ -72,11 +80,22 @@ func (s StepStatus) String() string {
 		return "Completed"
 	case StepFailed:
 		return "Failed"
+	case StepSkipped:
+		return "Skipped"
+	case StepBlocked:
+		return "Blocked"
+	case StepReplaced:
+		return "Replaced"
 	default:
 		return "Unknown"
 	}
 }
 
+// done reports whether a step with this status needs no further work
+func (s StepStatus) done() bool {
+	return s == StepCompleted || s == StepSkipped || s == StepReplaced
+}
+
 // NewLifecycle creates a new lifecycle manager
 func NewLifecycle() *Lifecycle {
 	return &Lifecycle{
@@ -122,22 +141,46 @@ func (l *Lifecycle) NextPhase() Phase {
 	return l.currentPhase
 }
 
-// SetPlan sets the execution plan
-func (l *Lifecycle) SetPlan(steps []string) {
+// SetPlan sets the execution plan. Steps are numbered by position and
+// start out pending unless marked skipped; DependsOn must refer to those
+// positions.
+func (l *Lifecycle) SetPlan(steps []PlanStep) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
 	l.planSteps = make([]PlanStep, len(steps))
-	for i, desc := range steps {
+	for i, step := range steps {
+		status := StepPending
+		if step.Status == StepSkipped {
+			status = StepSkipped
+		}
+
 		l.planSteps[i] = PlanStep{
-			ID:          i,
-			Description: desc,
-			Status:      StepPending,
+			ID:        i,
+			Title:     step.Title,
+			Rationale: step.Rationale,
+			Files:     append([]string(nil), step.Files...),
+			DependsOn: append([]int(nil), step.DependsOn...),
+			Status:    status,
 		}
 	}
 	l.currentStep = -1
 }
 
+// HasPendingSteps returns true if any step has not started yet
+func (l *Lifecycle) HasPendingSteps() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	for _, step := range l.planSteps {
+		if step.Status == StepPending {
+			return true
+		}
+	}
+
+	return false
+}
+
 // GetPlan returns the current plan steps
 func (l *Lifecycle) GetPlan() []PlanStep {
 	l.mu.RLock()
@@ -148,14 +191,17 @@ func (l *Lifecycle) GetPlan() []PlanStep {
 	return steps
 }
 
-// StartNextStep marks the next pending step as in progress
+// StartNextStep marks the first pending step whose dependencies are all
+// done as in progress. Steps that can no longer run because a dependency
+// failed are blocked first.
 func (l *Lifecycle) StartNextStep() *PlanStep {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	// Find next pending step
+	l.blockDependents()
+
 	for i := range l.planSteps {
-		if l.planSteps[i].Status == StepPending {
+		if l.planSteps[i].Status == StepPending && l.dependenciesDone(i) {
 			l.planSteps[i].Status = StepInProgress
 			l.currentStep = i
 			step := l.planSteps[i]
@@ -166,6 +212,68 @@ func (l *Lifecycle) StartNextStep() *PlanStep {
 	return nil
 }
 
+// dependenciesDone reports whether every dependency of step i has
+// completed or was skipped by the user
+func (l *Lifecycle) dependenciesDone(i int) bool {
+	for _, dep := range l.planSteps[i].DependsOn {
+		if dep < 0 || dep >= len(l.planSteps) {
+			continue
+		}
+		if status := l.planSteps[dep].Status; status != StepCompleted && status != StepSkipped {
+			return false
+		}
+	}
+	return true
+}
+
+// blockDependents blocks pending steps that depend, directly or through
+// other steps, on a step that failed, was blocked or was replaced
+func (l *Lifecycle) blockDependents() {
+	for changed := true; changed; {
+		changed = false
+		for i := range l.planSteps {
+			if l.planSteps[i].Status != StepPending {
+				continue
+			}
+			if dep, ok := l.unreachableDependency(i); ok {
+				l.planSteps[i].Status = StepBlocked
+				l.planSteps[i].Error = fmt.Errorf("blocked by step %d (%s)", dep+1, l.planSteps[dep].Status)
+				changed = true
+			}
+		}
+	}
+}
+
+// unreachableDependency returns a dependency of step i that will never complete
+func (l *Lifecycle) unreachableDependency(i int) (int, bool) {
+	for _, dep := range l.planSteps[i].DependsOn {
+		if dep < 0 || dep >= len(l.planSteps) {
+			continue
+		}
+		switch l.planSteps[dep].Status {
+		case StepFailed, StepBlocked, StepReplaced:
+			return dep, true
+		}
+	}
+	return 0, false
+}
+
+// BlockPendingSteps blocks every pending step with err and returns their IDs
+func (l *Lifecycle) BlockPendingSteps(err error) []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var blocked []int
+	for i := range l.planSteps {
+		if l.planSteps[i].Status == StepPending {
+			l.planSteps[i].Status = StepBlocked
+			l.planSteps[i].Error = err
+			blocked = append(blocked, i)
+		}
+	}
+	return blocked
+}
+
 // CompleteCurrentStep marks the current step as completed
 func (l *Lifecycle) CompleteCurrentStep(result string) {
 	l.mu.Lock()
@@ -177,7 +285,8 @@ func (l *Lifecycle) CompleteCurrentStep(result string) {
 	}
 }
 
-// FailCurrentStep marks the current step as failed
+// FailCurrentStep marks the current step as failed and blocks the steps
+// that depend on it
 func (l *Lifecycle) FailCurrentStep(err error) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -185,7 +294,75 @@ func (l *Lifecycle) FailCurrentStep(err error) {
 	if l.currentStep >= 0 && l.currentStep < len(l.planSteps) {
 		l.planSteps[l.currentStep].Status = StepFailed
 		l.planSteps[l.currentStep].Error = err
+		l.blockDependents()
+	}
+}
+
+// RevisePlan replaces every unfinished step (pending, failed or blocked)
+// with the given revised steps. Finished steps are kept so the revision
+// can depend on them; the new steps are numbered after them and their
+// DependsOn must refer to those numbers.
+func (l *Lifecycle) RevisePlan(steps []PlanStep) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	revision := 1
+	for i := range l.planSteps {
+		switch l.planSteps[i].Status {
+		case StepPending, StepInProgress, StepFailed, StepBlocked:
+			l.planSteps[i].Status = StepReplaced
+		}
+		if l.planSteps[i].Revision >= revision {
+			revision = l.planSteps[i].Revision + 1
+		}
+	}
+
+	for _, step := range steps {
+		l.planSteps = append(l.planSteps, PlanStep{
+			ID:        len(l.planSteps),
+			Title:     step.Title,
+			Rationale: step.Rationale,
+			Files:     append([]string(nil), step.Files...),
+			DependsOn: append([]int(nil), step.DependsOn...),
+			Revision:  revision,
+			Status:    StepPending,
+		})
+	}
+}
+
+// ReviseSteps replaces the steps from start on, a revision the user has
+// reviewed, with steps. Skipped steps stay skipped and the rest are
+// pending; DependsOn must refer to positions in the whole plan.
+func (l *Lifecycle) ReviseSteps(start int, steps []PlanStep) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if start > len(l.planSteps) {
+		start = len(l.planSteps)
+	}
+	revision := 0
+	if start < len(l.planSteps) {
+		revision = l.planSteps[start].Revision
+	}
+
+	l.planSteps = l.planSteps[:start]
+	for _, step := range steps {
+		status := StepPending
+		if step.Status == StepSkipped {
+			status = StepSkipped
+		}
+
+		l.planSteps = append(l.planSteps, PlanStep{
+			ID:        len(l.planSteps),
+			Title:     step.Title,
+			Rationale: step.Rationale,
+			Files:     append([]string(nil), step.Files...),
+			DependsOn: append([]int(nil), step.DependsOn...),
+			Revision:  revision,
+			Status:    status,
+		})
 	}
+	l.currentStep = -1
 }
 
 // CurrentStep returns the current step being executed
@@ -201,13 +378,14 @@ func (l *Lifecycle) CurrentStep() *PlanStep {
 	return nil
 }
 
-// AllStepsCompleted returns true if all steps are completed
+// AllStepsCompleted returns true if all steps are completed, skipped or
+// replaced by a revised plan
 func (l *Lifecycle) AllStepsCompleted() bool {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
 	for _, step := range l.planSteps {
-		if step.Status != StepCompleted {
+		if !step.Status.done() {
 			return false
 		}
 	}
@@ -239,7 +417,8 @@ func (l *Lifecycle) Reset() {
 	l.currentStep = -1
 }
 
-// Progress returns the completion percentage (0-100)
+// Progress returns the completion percentage (0-100); skipped and replaced
+// steps count as done
 func (l *Lifecycle) Progress() int {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -250,7 +429,7 @@ func (l *Lifecycle) Progress() int {
 
 	completed := 0
 	for _, step := range l.planSteps {
-		if step.Status == StepCompleted {
+		if step.Status.done() {
 			completed++
 		}
 	}
@@ -1,6 +1,8 @@
 package agent
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -49,7 +51,7 @@ func TestLifecycleSetPlan(t *testing.T) {
 	lc := NewLifecycle()
 
 	steps := []string{"Step 1", "Step 2", "Step 3"}
-	lc.SetPlan(steps)
+	lc.SetPlan(planOf(steps...))
 
 	plan := lc.GetPlan()
 
@@ -58,8 +60,8 @@ func TestLifecycleSetPlan(t *testing.T) {
 	}
 
 	for i, step := range plan {
-		if step.Description != steps[i] {
-			t.Errorf("Step %d: Expected '%s', got '%s'", i, steps[i], step.Description)
+		if step.Title != steps[i] {
+			t.Errorf("Step %d: Expected '%s', got '%s'", i, steps[i], step.Title)
 		}
 		if step.Status != StepPending {
 			t.Errorf("Step %d: Expected status Pending, got %v", i, step.Status)
@@ -70,15 +72,15 @@ func TestLifecycleSetPlan(t *testing.T) {
 func TestLifecycleStepExecution(t *testing.T) {
 	lc := NewLifecycle()
 
-	lc.SetPlan([]string{"First", "Second", "Third"})
+	lc.SetPlan(planOf("First", "Second", "Third"))
 
 	// Start first step
 	step := lc.StartNextStep()
 	if step == nil {
 		t.Fatal("StartNextStep returned nil")
 	}
-	if step.Description != "First" {
-		t.Errorf("Expected 'First', got '%s'", step.Description)
+	if step.Title != "First" {
+		t.Errorf("Expected 'First', got '%s'", step.Title)
 	}
 	if step.Status != StepInProgress {
 		t.Errorf("Expected StepInProgress, got %v", step.Status)
@@ -97,15 +99,15 @@ func TestLifecycleStepExecution(t *testing.T) {
 
 	// Start second step
 	step = lc.StartNextStep()
-	if step.Description != "Second" {
-		t.Errorf("Expected 'Second', got '%s'", step.Description)
+	if step.Title != "Second" {
+		t.Errorf("Expected 'Second', got '%s'", step.Title)
 	}
 }
 
 func TestLifecycleFailStep(t *testing.T) {
 	lc := NewLifecycle()
 
-	lc.SetPlan([]string{"Will fail"})
+	lc.SetPlan(planOf("Will fail"))
 	lc.StartNextStep()
 	lc.FailCurrentStep(nil)
 
@@ -122,7 +124,7 @@ func TestLifecycleFailStep(t *testing.T) {
 func TestLifecycleAllStepsCompleted(t *testing.T) {
 	lc := NewLifecycle()
 
-	lc.SetPlan([]string{"One", "Two"})
+	lc.SetPlan(planOf("One", "Two"))
 
 	if lc.AllStepsCompleted() {
 		t.Error("AllStepsCompleted should be false initially")
@@ -141,7 +143,7 @@ func TestLifecycleAllStepsCompleted(t *testing.T) {
 func TestLifecycleProgress(t *testing.T) {
 	lc := NewLifecycle()
 
-	lc.SetPlan([]string{"A", "B", "C", "D"})
+	lc.SetPlan(planOf("A", "B", "C", "D"))
 
 	if lc.Progress() != 0 {
 		t.Errorf("Expected 0%% progress, got %d%%", lc.Progress())
@@ -166,7 +168,7 @@ func TestLifecycleReset(t *testing.T) {
 	lc := NewLifecycle()
 
 	lc.SetPhase(PhaseAct)
-	lc.SetPlan([]string{"Step"})
+	lc.SetPlan(planOf("Step"))
 	lc.StartNextStep()
 
 	lc.Reset()
@@ -207,6 +209,9 @@ func TestStepStatusString(t *testing.T) {
 		{StepInProgress, "In Progress"},
 		{StepCompleted, "Completed"},
 		{StepFailed, "Failed"},
+		{StepSkipped, "Skipped"},
+		{StepBlocked, "Blocked"},
+		{StepReplaced, "Replaced"},
 	}
 
 	for _, tt := range tests {
@@ -215,3 +220,152 @@ func TestStepStatusString(t *testing.T) {
 		}
 	}
 }
+
+// planOf builds a plan of independent steps with the given titles
+func planOf(titles ...string) []PlanStep {
+	steps := make([]PlanStep, len(titles))
+	for i, title := range titles {
+		steps[i] = PlanStep{Title: title}
+	}
+	return steps
+}
+
+func TestLifecycleSetPlanKeepsStructure(t *testing.T) {
+	lc := NewLifecycle()
+
+	lc.SetPlan([]PlanStep{
+		{Title: "Add parser", Rationale: "Needed by the CLI", Files: []string{"parser.go"}, Status: StepCompleted},
+		{Title: "Wire up CLI", Files: []string{"main.go"}, DependsOn: []int{0}},
+	})
+
+	plan := lc.GetPlan()
+	if plan[0].Status != StepPending {
+		t.Error("SetPlan should reset step status")
+	}
+	if plan[0].Rationale != "Needed by the CLI" || plan[0].Files[0] != "parser.go" {
+		t.Errorf("Step 0 lost its structure: %+v", plan[0])
+	}
+	if plan[1].ID != 1 || len(plan[1].DependsOn) != 1 || plan[1].DependsOn[0] != 0 {
+		t.Errorf("Step 1 lost its dependencies: %+v", plan[1])
+	}
+	if !lc.HasPendingSteps() {
+		t.Error("New plan should have pending steps")
+	}
+}
+
+func TestLifecycleSkippedSteps(t *testing.T) {
+	lc := NewLifecycle()
+
+	lc.SetPlan([]PlanStep{{Title: "Run"}, {Title: "Skip", Status: StepSkipped}})
+
+	step := lc.StartNextStep()
+	if step == nil || step.Title != "Run" {
+		t.Fatalf("Expected to start 'Run', got %+v", step)
+	}
+	lc.CompleteCurrentStep("done")
+
+	if lc.StartNextStep() != nil {
+		t.Error("Skipped steps should not be started")
+	}
+	if !lc.AllStepsCompleted() || lc.Progress() != 100 {
+		t.Errorf("Skipped steps should count as done, progress %d", lc.Progress())
+	}
+}
+
+func TestLifecycleRunsStepsWhenDependenciesAreDone(t *testing.T) {
+	lc := NewLifecycle()
+
+	// The first step waits for the second
+	lc.SetPlan([]PlanStep{
+		{Title: "Wire up CLI", DependsOn: []int{1}},
+		{Title: "Add parser"},
+	})
+
+	step := lc.StartNextStep()
+	if step == nil || step.Title != "Add parser" {
+		t.Fatalf("Expected the dependency to run first, got %+v", step)
+	}
+	lc.CompleteCurrentStep("done")
+
+	step = lc.StartNextStep()
+	if step == nil || step.Title != "Wire up CLI" {
+		t.Fatalf("Expected the dependent step next, got %+v", step)
+	}
+}
+
+func TestLifecycleFailureBlocksDependents(t *testing.T) {
+	lc := NewLifecycle()
+	lc.SetPlan([]PlanStep{
+		{Title: "Add parser"},
+		{Title: "Wire up CLI", DependsOn: []int{0}},
+		{Title: "Document CLI", DependsOn: []int{1}},
+		{Title: "Update changelog"},
+	})
+
+	lc.StartNextStep()
+	lc.FailCurrentStep(errors.New("parser generator missing"))
+
+	plan := lc.GetPlan()
+	if plan[1].Status != StepBlocked || plan[2].Status != StepBlocked {
+		t.Fatalf("Expected dependents to be blocked, got %+v", plan)
+	}
+	if plan[2].Error == nil || !strings.Contains(plan[2].Error.Error(), "step 2") {
+		t.Errorf("Expected blocked reason, got %v", plan[2].Error)
+	}
+
+	// Independent steps still run
+	step := lc.StartNextStep()
+	if step == nil || step.Title != "Update changelog" {
+		t.Fatalf("Expected the independent step to run, got %+v", step)
+	}
+	lc.CompleteCurrentStep("done")
+
+	if lc.HasPendingSteps() || lc.StartNextStep() != nil {
+		t.Error("Blocked steps should not run")
+	}
+	if lc.AllStepsCompleted() {
+		t.Error("A plan with failed steps is not complete")
+	}
+}
+
+func TestLifecycleRevisePlan(t *testing.T) {
+	lc := NewLifecycle()
+	lc.SetPlan([]PlanStep{
+		{Title: "Add parser"},
+		{Title: "Generate grammar"},
+		{Title: "Wire up CLI", DependsOn: []int{1}},
+	})
+
+	lc.StartNextStep()
+	lc.CompleteCurrentStep("done")
+	lc.StartNextStep()
+	lc.FailCurrentStep(errors.New("generator missing"))
+
+	lc.RevisePlan([]PlanStep{
+		{Title: "Hand-write grammar", DependsOn: []int{0}},
+		{Title: "Wire up CLI", DependsOn: []int{3}},
+	})
+
+	plan := lc.GetPlan()
+	if len(plan) != 5 {
+		t.Fatalf("Expected revised steps to be appended, got %+v", plan)
+	}
+	if plan[0].Status != StepCompleted || plan[1].Status != StepReplaced || plan[2].Status != StepReplaced {
+		t.Errorf("Expected unfinished steps to be replaced, got %+v", plan)
+	}
+	if plan[3].ID != 3 || plan[3].Revision != 1 || plan[3].Status != StepPending {
+		t.Errorf("Unexpected revised step %+v", plan[3])
+	}
+
+	for _, want := range []string{"Hand-write grammar", "Wire up CLI"} {
+		step := lc.StartNextStep()
+		if step == nil || step.Title != want {
+			t.Fatalf("Expected %q, got %+v", want, step)
+		}
+		lc.CompleteCurrentStep("done")
+	}
+
+	if !lc.AllStepsCompleted() || lc.Progress() != 100 {
+		t.Errorf("Replaced steps should count as done, progress %d", lc.Progress())
+	}
+}
@@ -0,0 +1,536 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// SubmitPlanToolName is the tool the model calls to propose a plan.
+// It is handled by the agent itself rather than the tool registry
+// because it drives the lifecycle instead of touching the workspace.
+const SubmitPlanToolName = "submit_plan"
+
+// FailStepToolName is the tool the model calls when it cannot complete
+// the current plan step. Like submit_plan it is handled by the agent.
+const FailStepToolName = "fail_step"
+
+// DefaultMaxReplans is how many times the remaining plan may be revised
+// after step failures within one request
+const DefaultMaxReplans = 2
+
+// submitPlanTool returns the definition of the plan submission tool
+func submitPlanTool() llm.Tool {
+	return llm.Tool{
+		Name: SubmitPlanToolName,
+		Description: "Submit a step-by-step plan before making multi-step changes. " +
+			"Each step is executed in order once the plan is accepted. " +
+			"Skip this for questions and single, trivial edits.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"steps": map[string]any{
+					"type":        "array",
+					"description": "Steps in execution order",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"title": map[string]any{
+								"type":        "string",
+								"description": "Short imperative description of the step",
+							},
+							"rationale": map[string]any{
+								"type":        "string",
+								"description": "Why the step is needed",
+							},
+							"files": map[string]any{
+								"type":        "array",
+								"description": "Files the step will create or modify",
+								"items":       map[string]any{"type": "string"},
+							},
+							"depends_on": map[string]any{
+								"type":        "array",
+								"description": "1-based numbers of earlier steps that must complete first",
+								"items":       map[string]any{"type": "integer"},
+							},
+						},
+						"required": []string{"title"},
+					},
+				},
+			},
+			"required": []string{"steps"},
+		},
+	}
+}
+
+// failStepTool returns the definition of the step failure tool
+func failStepTool() llm.Tool {
+	return llm.Tool{
+		Name: FailStepToolName,
+		Description: "Report that the current plan step cannot be completed. " +
+			"Steps that depend on it are blocked and you may be asked to revise the remaining plan.",
+		InputSchema: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"reason": map[string]any{
+					"type":        "string",
+					"description": "Why the step failed",
+				},
+			},
+			"required": []string{"reason"},
+		},
+	}
+}
+
+// parsePlanSteps validates submit_plan arguments and converts them into
+// plan steps. The submitted steps are numbered after the first offset
+// steps, which they may also depend on; dependencies are converted from
+// 1-based step numbers to IDs.
+func parsePlanSteps(args map[string]any, offset int) ([]PlanStep, error) {
+	rawSteps, ok := args["steps"].([]any)
+	if !ok || len(rawSteps) == 0 {
+		return nil, fmt.Errorf("steps must be a non-empty array")
+	}
+
+	steps := make([]PlanStep, len(rawSteps))
+	for i, raw := range rawSteps {
+		number := offset + i + 1
+
+		fields, ok := raw.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("step %d must be an object", number)
+		}
+
+		title, _ := fields["title"].(string)
+		title = strings.TrimSpace(title)
+		if title == "" {
+			return nil, fmt.Errorf("step %d is missing a title", number)
+		}
+
+		rationale, _ := fields["rationale"].(string)
+
+		files, err := stringList(fields["files"])
+		if err != nil {
+			return nil, fmt.Errorf("step %d: files %w", number, err)
+		}
+
+		deps, err := dependencyList(fields["depends_on"], number)
+		if err != nil {
+			return nil, fmt.Errorf("step %d: %w", number, err)
+		}
+
+		steps[i] = PlanStep{
+			ID:        offset + i,
+			Title:     title,
+			Rationale: strings.TrimSpace(rationale),
+			Files:     files,
+			DependsOn: deps,
+		}
+	}
+
+	return steps, nil
+}
+
+// stringList converts a JSON array of strings
+func stringList(raw any) ([]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	items, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("must be an array of strings")
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		value, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be an array of strings")
+		}
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// dependencyList converts 1-based step numbers into step IDs. Steps may
+// only depend on earlier steps, which keeps the plan free of cycles.
+func dependencyList(raw any, number int) ([]int, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	items, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("depends_on must be an array of step numbers")
+	}
+
+	var deps []int
+	seen := make(map[int]bool)
+	for _, item := range items {
+		value, ok := item.(float64)
+		if !ok || value != float64(int(value)) {
+			return nil, fmt.Errorf("depends_on must be an array of step numbers")
+		}
+
+		dep := int(value)
+		if dep < 1 || dep >= number {
+			return nil, fmt.Errorf("depends on step %d, but steps can only depend on earlier steps", dep)
+		}
+
+		if !seen[dep] {
+			seen[dep] = true
+			deps = append(deps, dep-1)
+		}
+	}
+	return deps, nil
+}
+
+// submitPlan records a plan submitted by the model and returns the tool
+// result to send back
+func (a *Agent) submitPlan(toolCall schema.ToolCall) (llm.ToolResult, bool) {
+	if a.replanning {
+		return a.revisePlan(toolCall)
+	}
+
+	if phase := a.lifecycle.CurrentPhase(); phase != PhaseUnderstand && phase != PhasePlan {
+		return llm.ToolResult{
+			ToolCallID: toolCall.ID,
+			Content:    "A plan is already being executed; continue with the current step.",
+			IsError:    true,
+		}, false
+	}
+
+	steps, err := parsePlanSteps(toolCall.Arguments, 0)
+	if err != nil {
+		return llm.ToolResult{
+			ToolCallID: toolCall.ID,
+			Content:    fmt.Sprintf("Invalid plan: %v", err),
+			IsError:    true,
+		}, false
+	}
+
+	a.lifecycle.SetPlan(steps)
+	a.emitPlan()
+
+	return llm.ToolResult{
+		ToolCallID: toolCall.ID,
+		Content:    fmt.Sprintf("Plan recorded with %d step(s). Wait for instructions to execute each step.", len(steps)),
+	}, true
+}
+
+// revisePlan replaces the unfinished steps with the revision the model
+// submitted after a step failed. Like the first plan, the revision waits
+// for the user to approve it.
+func (a *Agent) revisePlan(toolCall schema.ToolCall) (llm.ToolResult, bool) {
+	plan := a.lifecycle.GetPlan()
+
+	steps, err := parsePlanSteps(toolCall.Arguments, len(plan))
+	if err == nil {
+		err = checkRevisionDependencies(plan, steps)
+	}
+	if err != nil {
+		return llm.ToolResult{
+			ToolCallID: toolCall.ID,
+			Content:    fmt.Sprintf("Invalid plan revision: %v", err),
+			IsError:    true,
+		}, false
+	}
+
+	a.replanning = false
+	a.lifecycle.RevisePlan(steps)
+	a.reviewFrom = len(plan)
+	a.setPhase(PhasePlan)
+	a.emitPlan()
+
+	return llm.ToolResult{
+		ToolCallID: toolCall.ID,
+		Content: fmt.Sprintf("Plan revised: steps %d to %d replace the unfinished steps. Wait for instructions to execute each step.",
+			len(plan)+1, len(plan)+len(steps)),
+	}, true
+}
+
+// checkRevisionDependencies ensures revised steps only depend on existing
+// steps that are finished, since everything else is being replaced
+func checkRevisionDependencies(plan, steps []PlanStep) error {
+	for i, step := range steps {
+		for _, dep := range step.DependsOn {
+			if dep >= len(plan) {
+				continue
+			}
+			if status := plan[dep].Status; status != StepCompleted && status != StepSkipped {
+				return fmt.Errorf("step %d depends on step %d, which is %s", len(plan)+i+1, dep+1, strings.ToLower(status.String()))
+			}
+		}
+	}
+	return nil
+}
+
+// failStep marks the current step as failed, blocking its dependents, and
+// asks the model to revise the rest of the plan if revisions remain. It
+// reports whether control should go back to the lifecycle right away.
+func (a *Agent) failStep(toolCall schema.ToolCall) (llm.ToolResult, bool) {
+	step := a.lifecycle.CurrentStep()
+	if a.lifecycle.CurrentPhase() != PhaseAct || step == nil || step.Status != StepInProgress {
+		return llm.ToolResult{
+			ToolCallID: toolCall.ID,
+			Content:    "No plan step is in progress.",
+			IsError:    true,
+		}, false
+	}
+
+	reason, _ := toolCall.Arguments["reason"].(string)
+	if reason = strings.TrimSpace(reason); reason == "" {
+		reason = "no reason given"
+	}
+
+	a.lifecycle.FailCurrentStep(fmt.Errorf("%s", reason))
+	a.emitPlan()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Step %d marked as failed.", step.ID+1)
+
+	var blocked []string
+	for _, s := range a.lifecycle.GetPlan() {
+		if s.Status == StepBlocked {
+			blocked = append(blocked, strconv.Itoa(s.ID+1))
+		}
+	}
+	if len(blocked) > 0 {
+		fmt.Fprintf(&b, " Blocked steps: %s.", strings.Join(blocked, ", "))
+	}
+
+	if a.replans >= DefaultMaxReplans {
+		b.WriteString(" The plan cannot be revised again; summarise what was done and what is left.")
+		return llm.ToolResult{ToolCallID: toolCall.ID, Content: b.String()}, true
+	}
+
+	a.replans++
+	a.replanning = true
+	fmt.Fprintf(&b, " Call submit_plan with revised steps to replace every unfinished step. "+
+		"They are numbered from %d and may depend on completed steps by number.", len(a.lifecycle.GetPlan())+1)
+
+	return llm.ToolResult{ToolCallID: toolCall.ID, Content: b.String()}, false
+}
+
+// ApprovePlan records the plan as reviewed (and possibly edited) by the user,
+// tells the model about it and starts executing it. When a revised plan is
+// under review, steps is the whole plan but only the revised steps are
+// taken from it; the steps that already ran are kept as they are.
+func (a *Agent) ApprovePlan(ctx context.Context, steps []PlanStep) (*Response, error) {
+	if err := a.CheckPlan(steps); err != nil {
+		return nil, err
+	}
+
+	plan := a.lifecycle.GetPlan()
+	revised := steps[a.reviewFrom:]
+	edited := planEdited(plan[a.reviewFrom:], revised)
+	if a.reviewFrom > 0 {
+		a.lifecycle.ReviseSteps(a.reviewFrom, revised)
+	} else {
+		a.lifecycle.SetPlan(steps)
+	}
+	a.emitPlan()
+
+	// The model only saw its own proposal, so give it the reviewed version
+	a.context.AddMessage(llm.Message{
+		Role:    llm.RoleUser,
+		Content: approvedPlanMessage(a.lifecycle.GetPlan(), a.reviewFrom, edited),
+	})
+
+	if a.lifecycle.HasPendingSteps() {
+		a.setPhase(PhaseAct)
+	} else {
+		a.setPhase(PhaseVerify) // Everything was skipped
+	}
+
+	return a.runLifecycle(ctx)
+}
+
+// CheckPlan reports whether ApprovePlan would accept steps as the reviewed
+// plan, without approving it, so an invalid edit can be fixed while the
+// plan is still under review
+func (a *Agent) CheckPlan(steps []PlanStep) error {
+	if a.lifecycle.CurrentPhase() != PhasePlan {
+		return fmt.Errorf("no plan is awaiting approval")
+	}
+
+	plan := a.lifecycle.GetPlan()
+	if len(steps) < a.reviewFrom {
+		return fmt.Errorf("invalid plan: the %d steps that already ran must be kept", a.reviewFrom)
+	}
+	if err := validatePlan(steps); err != nil {
+		return fmt.Errorf("invalid plan: %w", err)
+	}
+	if err := checkRevisionDependencies(plan[:a.reviewFrom], steps[a.reviewFrom:]); err != nil {
+		return fmt.Errorf("invalid plan: %w", err)
+	}
+	return nil
+}
+
+// RejectPlan drops the plan under review without running any of it and
+// ends the lifecycle, so the next request starts from Understand again
+func (a *Agent) RejectPlan() error {
+	if a.lifecycle.CurrentPhase() != PhasePlan {
+		return fmt.Errorf("no plan is awaiting approval")
+	}
+
+	// Let the model know why its plan was not carried out
+	a.context.AddMessage(llm.Message{
+		Role:    llm.RoleUser,
+		Content: "I rejected the plan, so none of its remaining steps will run. Wait for my next request.",
+	})
+
+	a.lifecycle.Reset()
+	a.reviewFrom = 0
+	a.emitPlan()
+	return nil
+}
+
+// validatePlan checks that dependencies refer to other steps in the plan
+// and do not wait on each other
+func validatePlan(steps []PlanStep) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("plan has no steps")
+	}
+
+	for i, step := range steps {
+		if strings.TrimSpace(step.Title) == "" {
+			return fmt.Errorf("step %d is missing a title", i+1)
+		}
+		for _, dep := range step.DependsOn {
+			if dep < 0 || dep >= len(steps) || dep == i {
+				return fmt.Errorf("step %d depends on unknown step %d", i+1, dep+1)
+			}
+		}
+	}
+
+	if cycle := dependencyCycle(steps); cycle != nil {
+		numbers := make([]string, len(cycle))
+		for i, id := range cycle {
+			numbers[i] = strconv.Itoa(id + 1)
+		}
+		return fmt.Errorf("steps %s depend on each other in a cycle, so none of them can start; remove one of the dependencies",
+			strings.Join(numbers, " → "))
+	}
+
+	return nil
+}
+
+// dependencyCycle returns the steps of a dependency cycle, starting and
+// ending with the same step, or nil if there is none. Dependencies must
+// refer to steps in the plan.
+func dependencyCycle(steps []PlanStep) []int {
+	const (
+		unvisited = iota
+		visiting  // On the current path
+		visited
+	)
+	state := make([]int, len(steps))
+	var path []int
+
+	var visit func(i int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+		for _, dep := range steps[i].DependsOn {
+			switch state[dep] {
+			case visiting:
+				start := slices.Index(path, dep)
+				return append(slices.Clone(path[start:]), dep)
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range steps {
+		if state[i] == unvisited {
+			if cycle := visit(i); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// planEdited reports whether the user changed the proposed plan
+func planEdited(proposed, approved []PlanStep) bool {
+	if len(proposed) != len(approved) {
+		return true
+	}
+
+	for i := range proposed {
+		if proposed[i].Title != approved[i].Title ||
+			approved[i].Status == StepSkipped ||
+			!slices.Equal(proposed[i].DependsOn, approved[i].DependsOn) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// approvedPlanMessage describes the approved plan to the model, from
+// step from on
+func approvedPlanMessage(steps []PlanStep, from int, edited bool) string {
+	var b strings.Builder
+
+	plan := "plan"
+	if from > 0 {
+		plan = "revised plan"
+	}
+	if edited {
+		fmt.Fprintf(&b, "I reviewed and edited your %s. Follow this version instead of your original proposal:\n", plan)
+	} else {
+		fmt.Fprintf(&b, "I reviewed and approved your %s:\n", plan)
+	}
+
+	for i := from; i < len(steps); i++ {
+		step := steps[i]
+		fmt.Fprintf(&b, "\n%d. %s", i+1, step.Title)
+		if step.Status == StepSkipped {
+			b.WriteString(" (skipped - do not do this)")
+		}
+		if len(step.Files) > 0 {
+			fmt.Fprintf(&b, " [files: %s]", strings.Join(step.Files, ", "))
+		}
+		if len(step.DependsOn) > 0 {
+			deps := make([]string, len(step.DependsOn))
+			for j, dep := range step.DependsOn {
+				deps[j] = strconv.Itoa(dep + 1)
+			}
+			fmt.Fprintf(&b, " [after: %s]", strings.Join(deps, ", "))
+		}
+	}
+
+	b.WriteString("\n\nI will ask you to execute each step in turn.")
+	return b.String()
+}
+
+// stepInstruction builds the message asking the model to execute a step
+func stepInstruction(step *PlanStep, total int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Execute step %d of %d: %s", step.ID+1, total, step.Title)
+	if step.Rationale != "" {
+		fmt.Fprintf(&b, "\nRationale: %s", step.Rationale)
+	}
+	if len(step.Files) > 0 {
+		fmt.Fprintf(&b, "\nFiles: %s", strings.Join(step.Files, ", "))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,474 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+func TestParsePlanSteps(t *testing.T) {
+	args := map[string]any{"steps": []any{
+		map[string]any{"title": "Add parser", "rationale": "CLI needs it", "files": []any{"parser.go"}},
+		map[string]any{"title": "Wire up CLI", "files": []any{"main.go"}, "depends_on": []any{float64(1), float64(1)}},
+	}}
+
+	steps, err := parsePlanSteps(args, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(steps) != 2 || steps[0].Title != "Add parser" || steps[0].Rationale != "CLI needs it" {
+		t.Fatalf("unexpected steps %+v", steps)
+	}
+
+	if len(steps[1].DependsOn) != 1 || steps[1].DependsOn[0] != 0 {
+		t.Errorf("expected dependency on step ID 0, got %v", steps[1].DependsOn)
+	}
+}
+
+func TestParsePlanStepsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args map[string]any
+		want string
+	}{
+		{"no steps", map[string]any{}, "non-empty array"},
+		{"empty steps", map[string]any{"steps": []any{}}, "non-empty array"},
+		{"missing title", map[string]any{"steps": []any{map[string]any{"rationale": "x"}}}, "missing a title"},
+		{"forward dependency", map[string]any{"steps": []any{
+			map[string]any{"title": "A", "depends_on": []any{float64(2)}},
+			map[string]any{"title": "B"},
+		}}, "earlier steps"},
+		{"self dependency", map[string]any{"steps": []any{
+			map[string]any{"title": "A", "depends_on": []any{float64(1)}},
+		}}, "earlier steps"},
+		{"bad files", map[string]any{"steps": []any{
+			map[string]any{"title": "A", "files": "main.go"},
+		}}, "array of strings"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parsePlanSteps(tt.args, 0)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAgentExecutesSubmittedPlan(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{
+				Content: "Here is my plan.",
+				ToolCalls: []schema.ToolCall{{
+					ID:   "toolu_plan",
+					Name: SubmitPlanToolName,
+					Arguments: map[string]any{"steps": []any{
+						map[string]any{"title": "Add parser", "rationale": "CLI needs it", "files": []any{"parser.go"}},
+						map[string]any{"title": "Wire up CLI", "depends_on": []any{float64(1)}},
+					}},
+				}},
+			},
+			{Content: "Parser added."},
+			{Content: "CLI wired up."},
+			{Content: "Checked."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+
+	resp := approveSubmittedPlan(t, a, "add a parser")
+
+	if !resp.Done || !a.lifecycle.AllStepsCompleted() {
+		t.Fatalf("expected all steps to complete, got %+v", a.lifecycle.GetPlan())
+	}
+
+	plan := a.lifecycle.GetPlan()
+	if plan[0].Result != "Parser added." || plan[1].Result != "CLI wired up." {
+		t.Errorf("steps ran out of order: %+v", plan)
+	}
+
+	// Step instructions carry the structured fields
+	approval := client.requests[1].Messages[len(client.requests[1].Messages)-2].Content
+	if !strings.Contains(approval, "approved your plan") {
+		t.Errorf("expected the approved plan in context, got %q", approval)
+	}
+
+	instruction := client.requests[1].Messages[len(client.requests[1].Messages)-1].Content
+	if !strings.Contains(instruction, "step 1 of 2: Add parser") || !strings.Contains(instruction, "parser.go") {
+		t.Errorf("unexpected step instruction %q", instruction)
+	}
+}
+
+func TestAgentWaitsForPlanApproval(t *testing.T) {
+	client := &scriptedClient{responses: []*llm.Response{submitPlanResponse("Change it")}}
+	a := newTestAgent(t, client, false)
+
+	resp, err := a.ProcessRequest(context.Background(), "change it")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.Done || !resp.AwaitingPlanApproval || len(resp.PlanSteps) != 1 {
+		t.Fatalf("expected the plan to await approval, got %+v", resp)
+	}
+
+	if a.lifecycle.CurrentPhase() != PhasePlan || len(client.requests) != 1 {
+		t.Errorf("no step should run before approval, phase %s after %d requests",
+			a.lifecycle.CurrentPhase(), len(client.requests))
+	}
+}
+
+func TestAgentRejectPlan(t *testing.T) {
+	client := &scriptedClient{responses: []*llm.Response{submitPlanResponse("Change it")}}
+	a := newTestAgent(t, client, false)
+
+	if _, err := a.ProcessRequest(context.Background(), "change it"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := a.RejectPlan(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.lifecycle.CurrentPhase() != PhaseUnderstand || len(a.lifecycle.GetPlan()) != 0 {
+		t.Errorf("rejecting should end the lifecycle, phase %s with %d steps",
+			a.lifecycle.CurrentPhase(), len(a.lifecycle.GetPlan()))
+	}
+
+	messages := a.context.GetMessages()
+	if last := messages[len(messages)-1]; last.Role != llm.RoleUser || !strings.Contains(last.Content, "rejected the plan") {
+		t.Errorf("the model should be told the plan was rejected, got %+v", last)
+	}
+
+	if err := a.RejectPlan(); err == nil {
+		t.Error("expected an error when no plan is awaiting approval")
+	}
+	if len(client.requests) != 1 {
+		t.Errorf("no step should run after rejecting, got %d requests", len(client.requests))
+	}
+}
+
+func TestAgentApprovePlanWithEdits(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{{
+				ID:   "toolu_plan",
+				Name: SubmitPlanToolName,
+				Arguments: map[string]any{"steps": []any{
+					map[string]any{"title": "Add parser"},
+					map[string]any{"title": "Write docs"},
+					map[string]any{"title": "Wire up CLI", "depends_on": []any{float64(1)}},
+				}},
+			}}},
+			{Content: "CLI wired up first."},
+			{Content: "Parser added."},
+			{Content: "Checked."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+
+	resp, err := a.ProcessRequest(context.Background(), "add a parser")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Reorder, retitle and skip a step
+	proposed := resp.PlanSteps
+	edited := []PlanStep{
+		{Title: "Wire up the CLI"},
+		proposed[0],
+		{Title: proposed[1].Title, Status: StepSkipped},
+	}
+	edited[1].DependsOn = []int{0}
+
+	resp, err = a.ApprovePlan(context.Background(), edited)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !resp.Done {
+		t.Fatalf("expected the edited plan to complete, got %+v", resp)
+	}
+
+	plan := a.lifecycle.GetPlan()
+	if plan[0].Result != "CLI wired up first." || plan[1].Result != "Parser added." || plan[2].Status != StepSkipped {
+		t.Errorf("edited plan did not run as approved: %+v", plan)
+	}
+
+	approval := client.requests[1].Messages[len(client.requests[1].Messages)-2].Content
+	if !strings.Contains(approval, "edited your plan") ||
+		!strings.Contains(approval, "1. Wire up the CLI") ||
+		!strings.Contains(approval, "3. Write docs (skipped") {
+		t.Errorf("edited plan was not injected into context: %q", approval)
+	}
+
+	if _, err := a.ApprovePlan(context.Background(), edited); err == nil {
+		t.Error("expected an error approving a plan that is not awaiting approval")
+	}
+}
+
+func TestValidatePlan(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []PlanStep
+		want  string
+	}{
+		{"empty", nil, "no steps"},
+		{"blank title", []PlanStep{{Title: " "}}, "missing a title"},
+		{"self dependency", []PlanStep{{Title: "A", DependsOn: []int{0}}}, "unknown step 1"},
+		{"missing dependency", []PlanStep{{Title: "A", DependsOn: []int{3}}}, "unknown step 4"},
+		{"cycle", []PlanStep{{Title: "A", DependsOn: []int{1}}, {Title: "B", DependsOn: []int{0}}}, "steps 1 → 2 → 1 depend on each other"},
+		{"longer cycle", []PlanStep{
+			{Title: "A"},
+			{Title: "B", DependsOn: []int{0, 3}},
+			{Title: "C", DependsOn: []int{1}},
+			{Title: "D", DependsOn: []int{2}},
+		}, "steps 2 → 4 → 3 → 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePlan(tt.steps)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestAgentStopsStuckPlan(t *testing.T) {
+	client := &scriptedClient{}
+	a := newTestAgent(t, client, false)
+
+	// Steps that wait on each other, as validatePlan would reject
+	a.lifecycle.SetPlan([]PlanStep{
+		{Title: "A", DependsOn: []int{1}},
+		{Title: "B", DependsOn: []int{0}},
+	})
+	a.setPhase(PhaseAct)
+
+	resp, err := a.runLifecycle(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !resp.Done || !strings.Contains(resp.Message, "steps 1, 2 cannot start") {
+		t.Errorf("expected the plan to stop, got %+v", resp)
+	}
+	if len(client.requests) != 0 {
+		t.Errorf("expected no request without a step to work on, got %d", len(client.requests))
+	}
+	for _, step := range a.lifecycle.GetPlan() {
+		if step.Status != StepBlocked {
+			t.Errorf("expected step %d to be blocked, got %s", step.ID+1, step.Status)
+		}
+	}
+}
+
+func TestAgentReplansAfterStepFailure(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{{
+				ID:   "toolu_plan",
+				Name: SubmitPlanToolName,
+				Arguments: map[string]any{"steps": []any{
+					map[string]any{"title": "Generate grammar"},
+					map[string]any{"title": "Wire up CLI", "depends_on": []any{float64(1)}},
+				}},
+			}}},
+			{ToolCalls: []schema.ToolCall{{
+				ID:        "toolu_fail",
+				Name:      FailStepToolName,
+				Arguments: map[string]any{"reason": "generator is not installed"},
+			}}},
+			{ToolCalls: []schema.ToolCall{{
+				ID:   "toolu_revise",
+				Name: SubmitPlanToolName,
+				Arguments: map[string]any{"steps": []any{
+					map[string]any{"title": "Hand-write grammar"},
+					map[string]any{"title": "Wire up CLI", "depends_on": []any{float64(3)}},
+				}},
+			}}},
+			{Content: "Grammar written."},
+			{Content: "CLI wired up."},
+			{Content: "Checked."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+
+	// The revision waits for approval like the first plan
+	resp := approveSubmittedPlan(t, a, "add a grammar")
+	if resp.Done || !resp.AwaitingPlanApproval || len(resp.PlanSteps) != 4 {
+		t.Fatalf("expected the revised plan to await approval, got %+v", resp)
+	}
+	if a.lifecycle.CurrentPhase() != PhasePlan || len(client.requests) != 3 {
+		t.Fatalf("no revised step should run before approval, phase %s after %d requests",
+			a.lifecycle.CurrentPhase(), len(client.requests))
+	}
+
+	resp, err := a.ApprovePlan(context.Background(), resp.PlanSteps)
+	if err != nil {
+		t.Fatalf("unexpected error approving the revision: %v", err)
+	}
+	if !resp.Done {
+		t.Fatalf("expected the revised plan to complete, got %+v", resp)
+	}
+
+	plan := a.lifecycle.GetPlan()
+	if len(plan) != 4 || plan[0].Status != StepReplaced || plan[1].Status != StepReplaced {
+		t.Fatalf("expected the original steps to be replaced, got %+v", plan)
+	}
+	if plan[2].Result != "Grammar written." || plan[3].Result != "CLI wired up." || plan[3].Revision != 1 {
+		t.Errorf("revised steps did not run: %+v", plan)
+	}
+	if !a.lifecycle.AllStepsCompleted() {
+		t.Error("expected the revised plan to be complete")
+	}
+
+	// The failure result asks for the revision and names the blocked step
+	failResult := client.requests[2].Messages[len(client.requests[2].Messages)-1].ToolResults[0].Content
+	if !strings.Contains(failResult, "Blocked steps: 2") || !strings.Contains(failResult, "numbered from 3") {
+		t.Errorf("unexpected fail_step result %q", failResult)
+	}
+
+	// fail_step is only offered while a step runs
+	if !hasTool(client.requests[1].Tools, FailStepToolName) || hasTool(client.requests[0].Tools, FailStepToolName) {
+		t.Error("fail_step should only be offered during a step")
+	}
+}
+
+func TestAgentApproveRevisedPlanWithEdits(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			{ToolCalls: []schema.ToolCall{{
+				ID:   "toolu_plan",
+				Name: SubmitPlanToolName,
+				Arguments: map[string]any{"steps": []any{
+					map[string]any{"title": "Add parser"},
+					map[string]any{"title": "Add tests", "depends_on": []any{float64(1)}},
+				}},
+			}}},
+			{Content: "Parser added."},
+			{ToolCalls: []schema.ToolCall{{
+				ID:        "toolu_fail",
+				Name:      FailStepToolName,
+				Arguments: map[string]any{"reason": "no test runner"},
+			}}},
+			{ToolCalls: []schema.ToolCall{{
+				ID:   "toolu_revise",
+				Name: SubmitPlanToolName,
+				Arguments: map[string]any{"steps": []any{
+					map[string]any{"title": "Install a test runner"},
+					map[string]any{"title": "Add tests", "depends_on": []any{float64(1), float64(3)}},
+				}},
+			}}},
+			{Content: "Tests added."},
+			{Content: "Checked."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+
+	resp := approveSubmittedPlan(t, a, "add a parser")
+	if !resp.AwaitingPlanApproval {
+		t.Fatalf("expected the revised plan to await approval, got %+v", resp)
+	}
+
+	// The user skips the new step; the revised steps depend on it no more
+	edited := resp.PlanSteps
+	edited[2].Status = StepSkipped
+	resp, err := a.ApprovePlan(context.Background(), edited)
+	if err != nil {
+		t.Fatalf("unexpected error approving the revision: %v", err)
+	}
+	if !resp.Done {
+		t.Fatalf("expected the revised plan to complete, got %+v", resp)
+	}
+
+	plan := a.lifecycle.GetPlan()
+	if plan[0].Result != "Parser added." || plan[1].Status != StepReplaced ||
+		plan[2].Status != StepSkipped || plan[3].Result != "Tests added." || plan[3].Revision != 1 {
+		t.Errorf("revision did not run as approved: %+v", plan)
+	}
+
+	approval := client.requests[4].Messages[len(client.requests[4].Messages)-2].Content
+	if !strings.Contains(approval, "edited your revised plan") ||
+		!strings.Contains(approval, "3. Install a test runner (skipped") ||
+		strings.Contains(approval, "1. Add parser") {
+		t.Errorf("edited revision was not injected into context: %q", approval)
+	}
+
+	// Steps that already ran cannot be dropped from the plan
+	a.setPhase(PhasePlan)
+	a.reviewFrom = 2
+	if _, err := a.ApprovePlan(context.Background(), plan[:1]); err == nil {
+		t.Error("expected an error approving a revision without the steps that ran")
+	}
+}
+
+func TestAgentStopsReplanningAfterLimit(t *testing.T) {
+	fail := func(id string) *llm.Response {
+		return &llm.Response{ToolCalls: []schema.ToolCall{{
+			ID: id, Name: FailStepToolName, Arguments: map[string]any{"reason": "still broken"},
+		}}}
+	}
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			submitPlanResponse("Fix it"),
+			fail("toolu_fail"),
+			{Content: "The step could not be done."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+
+	resp, err := a.ProcessRequest(context.Background(), "fix it")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	a.replans = DefaultMaxReplans
+	resp, err = a.ApprovePlan(context.Background(), resp.PlanSteps)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.Done {
+		t.Fatalf("expected the turn to finish, got %+v", resp)
+	}
+
+	plan := a.lifecycle.GetPlan()
+	if plan[0].Status != StepFailed || plan[0].Error == nil || plan[0].Error.Error() != "still broken" {
+		t.Errorf("expected the step to stay failed, got %+v", plan[0])
+	}
+	failResult := client.requests[2].Messages[len(client.requests[2].Messages)-2].ToolResults[0].Content
+	if !strings.Contains(failResult, "cannot be revised again") {
+		t.Errorf("expected no re-plan once the limit is reached, got %q", failResult)
+	}
+}
+
+func TestAgentRejectsPlanDuringAct(t *testing.T) {
+	a := newTestAgent(t, &scriptedClient{}, false)
+	a.lifecycle.SetPhase(PhaseAct)
+
+	result, ok := a.submitPlan(schema.ToolCall{
+		ID:        "toolu_plan",
+		Name:      SubmitPlanToolName,
+		Arguments: map[string]any{"steps": []any{map[string]any{"title": "A"}}},
+	})
+
+	if ok || !result.IsError {
+		t.Errorf("expected plan to be rejected during Act, got %+v", result)
+	}
+}
+
+// hasTool reports whether a tool with the given name was offered
+func hasTool(tools []llm.Tool, name string) bool {
+	for _, tool := range tools {
+		if tool.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -235,7 +235,7 @@ func getSessionPreview(session *Session) string {
 
 // CreateSession creates a new session with a unique ID
 func CreateSession(name string) *Session {
-	id := generateSessionID()
+	id := NewSessionID()
 
 	return &Session{
 		ID:        id,
@@ -249,8 +249,8 @@ func CreateSession(name string) *Session {
 	}
 }
 
-// generateSessionID generates a unique session ID
-func generateSessionID() string {
+// NewSessionID generates a unique session ID
+func NewSessionID() string {
 	// Format: YYYYMMDD-HHMMSS-RANDOM
 	now := time.Now()
 	return fmt.Sprintf("%s-%06d",
@@ -291,12 +291,15 @@ func (sm *SessionManager) NewSession(name string) (*Session, error) {
 	return session, nil
 }
 
-// LoadSession loads and resumes a session
+// LoadSession loads and resumes a session. Attached images are read from
+// their paths again; any that are gone are sent as a note that they are
+// unavailable.
 func (sm *SessionManager) LoadSession(id string) (*Session, error) {
 	session, err := sm.store.Load(id)
 	if err != nil {
 		return nil, err
 	}
+	llm.ReloadImages(session.Messages)
 
 	sm.currentSession = session
 
@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tools"
+)
+
+const (
+	// DefaultMaxRepairAttempts is how many times failures are sent back to Act
+	DefaultMaxRepairAttempts = 3
+	// DefaultVerifyTimeout bounds a single verification command
+	DefaultVerifyTimeout = 5 * time.Minute
+
+	// maxDiagnostics caps the diagnostics kept per command
+	maxDiagnostics = 50
+	// maxFeedbackLines caps raw output sent back when nothing could be parsed
+	maxFeedbackLines = 40
+)
+
+// VerifyConfig configures the commands run in the Verify phase
+type VerifyConfig struct {
+	Commands          []string      // Shell commands run in order, e.g. "go test ./..."
+	MaxRepairAttempts int           // Repair attempts before giving up (0 uses the default)
+	Timeout           time.Duration // Per-command timeout (0 uses the default)
+}
+
+// Diagnostic is a single problem reported by a verification command
+type Diagnostic struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+// String formats the diagnostic as file:line:col: message
+func (d Diagnostic) String() string {
+	if d.Column > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+	}
+	return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+}
+
+// CommandResult is the outcome of one verification command
+type CommandResult struct {
+	Command     string
+	Passed      bool
+	Skipped     bool // Not run because an earlier command failed
+	Output      string
+	Diagnostics []Diagnostic
+	Duration    time.Duration
+}
+
+// VerifyReport is the outcome of running all verification commands
+type VerifyReport struct {
+	Results []CommandResult
+	Passed  bool
+	Attempt int // Number of repair attempts made before this run
+}
+
+// Summary returns a one-line description of each command's outcome
+func (r *VerifyReport) Summary() string {
+	parts := make([]string, 0, len(r.Results))
+	for _, result := range r.Results {
+		switch {
+		case result.Skipped:
+			parts = append(parts, fmt.Sprintf("%s skipped", result.Command))
+		case result.Passed:
+			parts = append(parts, fmt.Sprintf("%s passed", result.Command))
+		case len(result.Diagnostics) > 0:
+			parts = append(parts, fmt.Sprintf("%s failed (%d problem(s))", result.Command, len(result.Diagnostics)))
+		default:
+			parts = append(parts, fmt.Sprintf("%s failed", result.Command))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Feedback formats the failures as a message asking the model to repair them
+func (r *VerifyReport) Feedback(maxAttempts int) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Verification failed (repair attempt %d of %d). Fix the problems below without changing unrelated code.\n", r.Attempt+1, maxAttempts)
+
+	for _, result := range r.Results {
+		if result.Passed || result.Skipped {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n$ %s\n", result.Command)
+		if len(result.Diagnostics) > 0 {
+			for _, diag := range result.Diagnostics {
+				b.WriteString(diag.String())
+				b.WriteString("\n")
+			}
+			continue
+		}
+
+		// Nothing recognisable; send the tail of the output instead
+		lines := strings.Split(strings.TrimRight(result.Output, "\n"), "\n")
+		if len(lines) > maxFeedbackLines {
+			lines = lines[len(lines)-maxFeedbackLines:]
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// Verifier runs the project's build, lint and test commands
+type Verifier struct {
+	config VerifyConfig
+	shell  tools.Tool
+}
+
+// NewVerifier creates a verifier, filling in defaults for unset limits
+func NewVerifier(config VerifyConfig) *Verifier {
+	if config.MaxRepairAttempts <= 0 {
+		config.MaxRepairAttempts = DefaultMaxRepairAttempts
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultVerifyTimeout
+	}
+
+	return &Verifier{
+		config: config,
+		shell:  tools.NewShellCommandTool(),
+	}
+}
+
+// Commands returns the configured verification commands
+func (v *Verifier) Commands() []string {
+	return v.config.Commands
+}
+
+// MaxRepairAttempts returns how many repair attempts are allowed
+func (v *Verifier) MaxRepairAttempts() int {
+	return v.config.MaxRepairAttempts
+}
+
+// Run executes the commands in order, stopping at the first failure since
+// later commands (vet, test) usually repeat a build failure
+func (v *Verifier) Run(ctx context.Context) (*VerifyReport, error) {
+	report := &VerifyReport{Passed: true}
+
+	for _, command := range v.config.Commands {
+		if !report.Passed {
+			report.Results = append(report.Results, CommandResult{Command: command, Skipped: true})
+			continue
+		}
+
+		start := time.Now()
+		result, _ := v.shell.Execute(ctx, map[string]any{
+			"command":         command,
+			"timeout_seconds": v.config.Timeout.Seconds(),
+		})
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		cmdResult := CommandResult{
+			Command:  command,
+			Passed:   result != nil && result.Success,
+			Duration: time.Since(start),
+		}
+		if result != nil {
+			cmdResult.Output = result.Output
+			if !cmdResult.Passed {
+				cmdResult.Diagnostics = ParseDiagnostics(result.Output)
+				if result.Error != "" {
+					cmdResult.Output = strings.TrimSpace(cmdResult.Output + "\n" + result.Error)
+				}
+			}
+		}
+
+		report.Results = append(report.Results, cmdResult)
+		report.Passed = report.Passed && cmdResult.Passed
+	}
+
+	return report, nil
+}
+
+// diagnosticPattern matches compiler, vet, linter and test output of the
+// form "path/file.ext:line[:col]: message"
+var diagnosticPattern = regexp.MustCompile(`^\s*(?:\./)?([^\s:][^:]*\.[A-Za-z0-9]+):(\d+)(?::(\d+))?:\s*(.+)$`)
+
+// ParseDiagnostics extracts file/line diagnostics from command output
+func ParseDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := make(map[string]bool)
+
+	for _, line := range strings.Split(output, "\n") {
+		match := diagnosticPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		lineNum, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		diag := Diagnostic{
+			File:    filepath.ToSlash(match[1]),
+			Line:    lineNum,
+			Column:  column,
+			Message: strings.TrimSpace(match[4]),
+		}
+
+		key := diag.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		diagnostics = append(diagnostics, diag)
+		if len(diagnostics) >= maxDiagnostics {
+			break
+		}
+	}
+
+	return diagnostics
+}
+
+// DetectVerifyCommands returns sensible verification commands for the
+// project rooted at dir, or nil if the project type is not recognised
+func DetectVerifyCommands(dir string) []string {
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		return []string{"go build ./...", "go vet ./...", "go test ./..."}
+	}
+	return nil
+}
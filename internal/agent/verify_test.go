@@ -0,0 +1,187 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []Diagnostic
+	}{
+		{
+			name:   "go build",
+			output: "# example.com/app\n./main.go:12:3: undefined: foo\n",
+			want:   []Diagnostic{{File: "main.go", Line: 12, Column: 3, Message: "undefined: foo"}},
+		},
+		{
+			name:   "go test",
+			output: "--- FAIL: TestAdd (0.00s)\n    math_test.go:9: Add(1, 2) = 4, want 3\nFAIL\n",
+			want:   []Diagnostic{{File: "math_test.go", Line: 9, Message: "Add(1, 2) = 4, want 3"}},
+		},
+		{
+			name:   "vet with nested path and duplicates",
+			output: "internal/x/y.go:4:2: unreachable code\ninternal/x/y.go:4:2: unreachable code\n",
+			want:   []Diagnostic{{File: "internal/x/y.go", Line: 4, Column: 2, Message: "unreachable code"}},
+		},
+		{
+			name:   "no diagnostics",
+			output: "ok  \texample.com/app\t0.01s\nFAIL\n",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseDiagnostics(tt.output)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestVerifierRunStopsAtFirstFailure(t *testing.T) {
+	v := NewVerifier(VerifyConfig{Commands: []string{
+		"true",
+		"echo 'main.go:3:1: expected declaration'; exit 1",
+		"true",
+	}})
+
+	report, err := v.Run(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if report.Passed {
+		t.Fatal("expected verification to fail")
+	}
+
+	if len(report.Results) != 3 || !report.Results[0].Passed || !report.Results[2].Skipped {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+
+	diags := report.Results[1].Diagnostics
+	if len(diags) != 1 || diags[0].File != "main.go" || diags[0].Line != 3 {
+		t.Errorf("unexpected diagnostics %+v", diags)
+	}
+
+	if !strings.Contains(report.Feedback(3), "main.go:3:1: expected declaration") {
+		t.Errorf("feedback should list the diagnostic, got %q", report.Feedback(3))
+	}
+}
+
+func TestDetectVerifyCommands(t *testing.T) {
+	dir := t.TempDir()
+	if cmds := DetectVerifyCommands(dir); cmds != nil {
+		t.Errorf("expected no commands for an unknown project, got %v", cmds)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/app\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if cmds := DetectVerifyCommands(dir); len(cmds) != 3 || cmds[0] != "go build ./..." {
+		t.Errorf("unexpected commands for a Go project %v", cmds)
+	}
+}
+
+func TestAgentVerifyRepairLoop(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "fixed")
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			submitPlanResponse("Fix the bug"),
+			{Content: "Changed the code."},
+			{Content: "Fixed the build error."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+	// Fails the first time, passes once the "repair" has happened
+	a.verifier = NewVerifier(VerifyConfig{Commands: []string{
+		"test -f " + marker + " || { touch " + marker + "; echo 'main.go:7:2: undefined: x'; exit 1; }",
+	}})
+
+	resp := approveSubmittedPlan(t, a, "fix the bug")
+
+	if !resp.Done || resp.Verification == nil || !resp.Verification.Passed {
+		t.Fatalf("expected passing verification, got %+v", resp.Verification)
+	}
+
+	if resp.Verification.Attempt != 1 {
+		t.Errorf("expected one repair attempt, got %d", resp.Verification.Attempt)
+	}
+
+	var feedback string
+	for _, msg := range a.context.GetMessages() {
+		if strings.HasPrefix(msg.Content, "Verification failed") {
+			feedback = msg.Content
+		}
+	}
+	if !strings.Contains(feedback, "main.go:7:2: undefined: x") {
+		t.Errorf("expected diagnostics to be fed back, got %q", feedback)
+	}
+}
+
+func TestAgentVerifyGivesUpAfterMaxAttempts(t *testing.T) {
+	client := &scriptedClient{
+		responses: []*llm.Response{
+			submitPlanResponse("Change it"),
+			{Content: "Changed."},
+			{Content: "Tried again."},
+		},
+	}
+	a := newTestAgent(t, client, false)
+	a.verifier = NewVerifier(VerifyConfig{Commands: []string{"exit 1"}, MaxRepairAttempts: 1})
+
+	resp := approveSubmittedPlan(t, a, "change it")
+
+	if !resp.Done || resp.Verification == nil || resp.Verification.Passed {
+		t.Fatalf("expected failing verification, got %+v", resp.Verification)
+	}
+
+	if resp.Verification.Attempt != 1 || len(client.requests) != 3 {
+		t.Errorf("expected a single repair attempt, got attempt %d after %d requests",
+			resp.Verification.Attempt, len(client.requests))
+	}
+}
+
+// submitPlanResponse returns a scripted response submitting a one-step plan
+func submitPlanResponse(title string) *llm.Response {
+	return &llm.Response{ToolCalls: []schema.ToolCall{{
+		ID:        "toolu_plan",
+		Name:      SubmitPlanToolName,
+		Arguments: map[string]any{"steps": []any{map[string]any{"title": title}}},
+	}}}
+}
+
+// approveSubmittedPlan processes the request and approves the proposed plan
+// unchanged, returning the response once the plan has run
+func approveSubmittedPlan(t *testing.T, a *Agent, request string) *Response {
+	t.Helper()
+
+	resp, err := a.ProcessRequest(context.Background(), request)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !resp.AwaitingPlanApproval {
+		t.Fatalf("expected the plan to await approval, got %+v", resp)
+	}
+
+	resp, err = a.ApprovePlan(context.Background(), resp.PlanSteps)
+	if err != nil {
+		t.Fatalf("unexpected error approving plan: %v", err)
+	}
+	return resp
+}
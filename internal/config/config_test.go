@@ -191,6 +191,11 @@ func TestConfigDefaults(t *testing.T) {
 		{"MaxTokens", cfg.MaxTokens, DefaultMaxTokens},
 		{"LogLevel", cfg.LogLevel, DefaultLogLevel},
 		{"LogDir", cfg.LogDir, DefaultLogDir},
+		{"Verify.MaxRepairAttempts", cfg.Verify.MaxRepairAttempts, DefaultVerifyMaxRepairAttempts},
+		{"Verify.TimeoutSeconds", cfg.Verify.TimeoutSeconds, DefaultVerifyTimeoutSeconds},
+		{"Usage.Ledger", cfg.Usage.Ledger, DefaultUsageLedger},
+		{"Trace.Enabled", cfg.Trace.Enabled, false},
+		{"Trace.Dir", cfg.Trace.Dir, DefaultTraceDir},
 	}
 
 	for _, tt := range tests {
@@ -224,6 +229,25 @@ func TestManagerAPIKeyCaching(t *testing.T) {
 	}
 }
 
+// TestManagerAPIKeyFromEnvironment tests falling back to ANVIL_<PROVIDER>_API_KEY
+func TestManagerAPIKeyFromEnvironment(t *testing.T) {
+	t.Setenv("ANVIL_ENVTEST_XYZ123_API_KEY", "env-key")
+	mgr := NewManager()
+
+	key, err := mgr.GetAPIKey("envtest_xyz123")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if key != "env-key" {
+		t.Errorf("got key %s, want env-key", key)
+	}
+
+	if !mgr.HasAPIKey("envtest_xyz123") {
+		t.Error("expected HasAPIKey to return true for an environment key")
+	}
+}
+
 // TestManagerHasAPIKey tests checking for API key existence
 func TestManagerHasAPIKey(t *testing.T) {
 	mgr := NewManager()
@@ -241,3 +265,23 @@ func TestManagerHasAPIKey(t *testing.T) {
 		t.Error("expected HasAPIKey to return false for non-existent key")
 	}
 }
+
+// TestModelCatalog tests applying configured models to the catalog
+func TestModelCatalog(t *testing.T) {
+	vision := false
+	cfg := NewDefaultConfig()
+	cfg.Models = []ModelConfig{
+		{Model: "llama3.2", ContextWindow: 128000, Vision: &vision},
+		{Model: "claude-sonnet-4-5", Input: 2, Output: 10},
+	}
+
+	catalog := cfg.ModelCatalog()
+
+	if info := catalog.Info("llama3.2"); info.ContextWindow != 128000 || info.Vision || !info.Tools {
+		t.Errorf("unexpected entry for an added model: %+v", info)
+	}
+	info := catalog.Info("claude-sonnet-4-5")
+	if info.Price.Input != 2 || info.Price.CacheRead != 2 || info.ContextWindow != 200000 {
+		t.Errorf("expected only the price overridden, got %+v", info)
+	}
+}
@@ -1,9 +1,11 @@
 package config
 
+import "github.com/siddharth-bhatnagar/anvil/internal/llm"
+
 // Default configuration values
 const (
 	// DefaultModel is the default LLM model to use
-	DefaultModel = "claude-sonnet-4-5"
+	DefaultModel = llm.DefaultModel
 
 	// DefaultProvider is the default LLM provider
 	DefaultProvider = "anthropic"
@@ -11,8 +13,9 @@ const (
 	// DefaultTemperature is the default temperature for LLM requests
 	DefaultTemperature = 0.7
 
-	// DefaultMaxTokens is the default maximum tokens for LLM responses
-	DefaultMaxTokens = 4096
+	// DefaultMaxTokens is the default maximum tokens for LLM responses;
+	// 0 sizes responses from the model catalog
+	DefaultMaxTokens = 0
 
 	// DefaultConfigDir is the default directory for Anvil configuration
 	DefaultConfigDir = "~/.anvil"
@@ -25,6 +28,21 @@ const (
 
 	// DefaultLogLevel is the default logging level
 	DefaultLogLevel = "info"
+
+	// DefaultVerifyMaxRepairAttempts is how often failed verification is sent back to the agent
+	DefaultVerifyMaxRepairAttempts = 3
+
+	// DefaultVerifyTimeoutSeconds is the timeout for each verification command
+	DefaultVerifyTimeoutSeconds = 300
+
+	// DefaultFallbackCooldownSeconds is how long a failed provider is tried last
+	DefaultFallbackCooldownSeconds = 60
+
+	// DefaultUsageLedger is the file every LLM call's token usage is appended to
+	DefaultUsageLedger = "~/.anvil/usage.jsonl"
+
+	// DefaultTraceDir is the directory session traces are written to
+	DefaultTraceDir = "~/.anvil/traces"
 )
 
 // Config represents the application configuration
@@ -34,16 +52,121 @@ type Config struct {
 	Provider    string  `mapstructure:"provider"`
 	Temperature float64 `mapstructure:"temperature"`
 	MaxTokens   int     `mapstructure:"max_tokens"`
+	BaseURL     string  `mapstructure:"base_url"` // API address, e.g. a remote Ollama server
+	Script      string  `mapstructure:"script"`   // Reply script for the scripted provider
+
+	// Extended thinking: a token budget, or an effort level (low, medium
+	// or high) for models that take one; both off when unset
+	ThinkingBudget  int    `mapstructure:"thinking_budget"`
+	ReasoningEffort string `mapstructure:"reasoning_effort"`
 
 	// Logging configuration
 	LogLevel string `mapstructure:"log_level"`
 	LogDir   string `mapstructure:"log_dir"`
 
+	// Verification configuration
+	Verify VerifyConfig `mapstructure:"verify"`
+
+	// Providers to fall back to when the configured one fails
+	Fallback FallbackConfig `mapstructure:"fallback"`
+
+	// Usage ledger and spending budgets
+	Usage UsageConfig `mapstructure:"usage"`
+
+	// Request and tool call tracing, for debugging sessions
+	Trace TraceConfig `mapstructure:"trace"`
+
+	// Model limits, features and prices, overriding and extending the
+	// built-in model catalog
+	Models []ModelConfig `mapstructure:"models"`
+
 	// API Keys (stored in OS keychain, not in file)
 	// These are not part of the config file
 	APIKeys map[string]string `mapstructure:"-"`
 }
 
+// VerifyConfig configures the build, lint and test commands run after changes
+type VerifyConfig struct {
+	// Commands run in order; when empty they are detected from the project
+	Commands          []string `mapstructure:"commands"`
+	MaxRepairAttempts int      `mapstructure:"max_repair_attempts"`
+	TimeoutSeconds    int      `mapstructure:"timeout_seconds"`
+}
+
+// FallbackConfig lists the providers tried, in order, when a request to
+// the configured provider fails
+type FallbackConfig struct {
+	Providers []ProviderConfig `mapstructure:"providers"`
+	// Error types that move a request to the next provider; when empty
+	// they are rate_limit, server, timeout and network
+	Errors          []string `mapstructure:"errors"`
+	CooldownSeconds int      `mapstructure:"cooldown_seconds"`
+}
+
+// ProviderConfig selects a provider and model
+type ProviderConfig struct {
+	Provider string `mapstructure:"provider"`
+	Model    string `mapstructure:"model"`
+	BaseURL  string `mapstructure:"base_url"`
+	Script   string `mapstructure:"script"`
+}
+
+// UsageConfig configures the usage ledger and the budgets `anvil usage`
+// reports overruns of
+type UsageConfig struct {
+	Ledger       string  `mapstructure:"ledger"`        // Ledger file; empty disables recording
+	DailyBudget  float64 `mapstructure:"daily_budget"`  // USD per day, 0 for none
+	WeeklyBudget float64 `mapstructure:"weekly_budget"` // USD per week, 0 for none
+}
+
+// TraceConfig configures session traces: every LLM request, response
+// and stream event, and every tool call and result, with secrets redacted
+type TraceConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"` // One <session>.jsonl file per session
+}
+
+// ModelConfig describes a model to the model catalog. Unset fields keep
+// the built-in values. Prices are in USD per million tokens; cached
+// tokens cost the input price unless cache prices are given.
+type ModelConfig struct {
+	Model         string  `mapstructure:"model"`
+	ContextWindow int     `mapstructure:"context_window"`
+	MaxOutput     int     `mapstructure:"max_output"`
+	Tools         *bool   `mapstructure:"tools"`
+	Vision        *bool   `mapstructure:"vision"`
+	Thinking      *bool   `mapstructure:"thinking"`
+	Caching       *bool   `mapstructure:"caching"`
+	Input         float64 `mapstructure:"input"`
+	Output        float64 `mapstructure:"output"`
+	CacheRead     float64 `mapstructure:"cache_read"`
+	CacheWrite    float64 `mapstructure:"cache_write"`
+}
+
+// ModelCatalog returns the built-in model catalog with the configured
+// models applied
+func (c *Config) ModelCatalog() llm.Catalog {
+	overrides := make([]llm.ModelOverride, len(c.Models))
+	for i, m := range c.Models {
+		overrides[i] = llm.ModelOverride{
+			Model:         m.Model,
+			ContextWindow: m.ContextWindow,
+			MaxOutput:     m.MaxOutput,
+			Tools:         m.Tools,
+			Vision:        m.Vision,
+			Thinking:      m.Thinking,
+			Caching:       m.Caching,
+			Price: llm.ModelPrice{
+				Input:      m.Input,
+				Output:     m.Output,
+				CacheRead:  m.CacheRead,
+				CacheWrite: m.CacheWrite,
+			},
+		}
+	}
+	return llm.DefaultCatalog().With(overrides...)
+}
+
 // NewDefaultConfig returns a new Config with default values
 func NewDefaultConfig() *Config {
 	return &Config{
@@ -53,6 +176,19 @@ func NewDefaultConfig() *Config {
 		MaxTokens:   DefaultMaxTokens,
 		LogLevel:    DefaultLogLevel,
 		LogDir:      DefaultLogDir,
-		APIKeys:     make(map[string]string),
+		Verify: VerifyConfig{
+			MaxRepairAttempts: DefaultVerifyMaxRepairAttempts,
+			TimeoutSeconds:    DefaultVerifyTimeoutSeconds,
+		},
+		Fallback: FallbackConfig{
+			CooldownSeconds: DefaultFallbackCooldownSeconds,
+		},
+		Usage: UsageConfig{
+			Ledger: DefaultUsageLedger,
+		},
+		Trace: TraceConfig{
+			Dir: DefaultTraceDir,
+		},
+		APIKeys: make(map[string]string),
 	}
 }
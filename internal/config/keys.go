@@ -90,7 +90,7 @@ func (km *KeyManager) HasKey(provider string) bool {
 // Note: This is a best-effort implementation as the keyring API doesn't
 // provide a native way to list all keys. We check common providers.
 func (km *KeyManager) ListProviders() []string {
-	commonProviders := []string{"anthropic", "openai", "google", "ollama"}
+	commonProviders := []string{"anthropic", "openai", "gemini", "google", "ollama"}
 	var providers []string
 
 	for _, provider := range commonProviders {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/viper"
 )
@@ -56,8 +57,22 @@ func (m *Manager) Load() error {
 	viper.SetDefault("provider", DefaultProvider)
 	viper.SetDefault("temperature", DefaultTemperature)
 	viper.SetDefault("max_tokens", DefaultMaxTokens)
+	viper.SetDefault("base_url", "")
+	viper.SetDefault("script", "")
+	viper.SetDefault("thinking_budget", 0)
+	viper.SetDefault("reasoning_effort", "")
 	viper.SetDefault("log_level", DefaultLogLevel)
 	viper.SetDefault("log_dir", DefaultLogDir)
+	viper.SetDefault("verify.commands", []string{})
+	viper.SetDefault("verify.max_repair_attempts", DefaultVerifyMaxRepairAttempts)
+	viper.SetDefault("verify.timeout_seconds", DefaultVerifyTimeoutSeconds)
+	viper.SetDefault("fallback.errors", []string{})
+	viper.SetDefault("fallback.cooldown_seconds", DefaultFallbackCooldownSeconds)
+	viper.SetDefault("usage.ledger", DefaultUsageLedger)
+	viper.SetDefault("usage.daily_budget", 0)
+	viper.SetDefault("usage.weekly_budget", 0)
+	viper.SetDefault("trace.enabled", false)
+	viper.SetDefault("trace.dir", DefaultTraceDir)
 
 	// Read config file if it exists
 	if err := viper.ReadInConfig(); err != nil {
@@ -95,8 +110,24 @@ func (m *Manager) Save() error {
 	viper.Set("provider", m.config.Provider)
 	viper.Set("temperature", m.config.Temperature)
 	viper.Set("max_tokens", m.config.MaxTokens)
+	viper.Set("base_url", m.config.BaseURL)
+	viper.Set("script", m.config.Script)
+	viper.Set("thinking_budget", m.config.ThinkingBudget)
+	viper.Set("reasoning_effort", m.config.ReasoningEffort)
 	viper.Set("log_level", m.config.LogLevel)
 	viper.Set("log_dir", m.config.LogDir)
+	viper.Set("verify.commands", m.config.Verify.Commands)
+	viper.Set("verify.max_repair_attempts", m.config.Verify.MaxRepairAttempts)
+	viper.Set("verify.timeout_seconds", m.config.Verify.TimeoutSeconds)
+	viper.Set("fallback.providers", providerMaps(m.config.Fallback.Providers))
+	viper.Set("fallback.errors", m.config.Fallback.Errors)
+	viper.Set("fallback.cooldown_seconds", m.config.Fallback.CooldownSeconds)
+	viper.Set("usage.ledger", m.config.Usage.Ledger)
+	viper.Set("usage.daily_budget", m.config.Usage.DailyBudget)
+	viper.Set("usage.weekly_budget", m.config.Usage.WeeklyBudget)
+	viper.Set("trace.enabled", m.config.Trace.Enabled)
+	viper.Set("trace.dir", m.config.Trace.Dir)
+	viper.Set("models", modelMaps(m.config.Models))
 
 	// Write config file
 	if err := viper.WriteConfig(); err != nil {
@@ -113,6 +144,62 @@ func (m *Manager) Save() error {
 	return nil
 }
 
+// providerMaps converts provider settings to the keys used in the file
+func providerMaps(providers []ProviderConfig) []map[string]string {
+	maps := make([]map[string]string, len(providers))
+	for i, p := range providers {
+		maps[i] = map[string]string{
+			"provider": p.Provider,
+			"model":    p.Model,
+		}
+		if p.BaseURL != "" {
+			maps[i]["base_url"] = p.BaseURL
+		}
+		if p.Script != "" {
+			maps[i]["script"] = p.Script
+		}
+	}
+	return maps
+}
+
+// modelMaps converts model settings to the keys used in the file,
+// leaving out those that are unset
+func modelMaps(models []ModelConfig) []map[string]any {
+	maps := make([]map[string]any, len(models))
+	for i, m := range models {
+		maps[i] = map[string]any{"model": m.Model}
+		for key, value := range map[string]int{
+			"context_window": m.ContextWindow,
+			"max_output":     m.MaxOutput,
+		} {
+			if value != 0 {
+				maps[i][key] = value
+			}
+		}
+		for key, value := range map[string]*bool{
+			"tools":    m.Tools,
+			"vision":   m.Vision,
+			"thinking": m.Thinking,
+			"caching":  m.Caching,
+		} {
+			if value != nil {
+				maps[i][key] = *value
+			}
+		}
+		for key, value := range map[string]float64{
+			"input":       m.Input,
+			"output":      m.Output,
+			"cache_read":  m.CacheRead,
+			"cache_write": m.CacheWrite,
+		} {
+			if value != 0 {
+				maps[i][key] = value
+			}
+		}
+	}
+	return maps
+}
+
 // GetConfig returns the current configuration
 func (m *Manager) GetConfig() *Config {
 	return m.config
@@ -135,10 +222,12 @@ func (m *Manager) GetAPIKey(provider string) (string, error) {
 		return apiKey, nil
 	}
 
-	// Try to load from keychain
+	// Try to load from keychain, then from the environment
 	apiKey, err := m.keyManager.GetKey(provider)
 	if err != nil {
-		return "", err
+		if apiKey = envAPIKey(provider); apiKey == "" {
+			return "", err
+		}
 	}
 
 	// Cache in memory
@@ -177,6 +266,17 @@ func (m *Manager) HasAPIKey(provider string) bool {
 		return true
 	}
 
-	// Check keychain
-	return m.keyManager.HasKey(provider)
+	// Check keychain and environment
+	return m.keyManager.HasKey(provider) || envAPIKey(provider) != ""
+}
+
+// envAPIKey returns the API key set in ANVIL_<PROVIDER>_API_KEY, if any
+func envAPIKey(provider string) string {
+	return os.Getenv(APIKeyEnvVar(provider))
+}
+
+// APIKeyEnvVar returns the environment variable that can hold the API key
+// for provider, e.g. ANVIL_OPENAI_API_KEY
+func APIKeyEnvVar(provider string) string {
+	return "ANVIL_" + strings.ToUpper(provider) + "_API_KEY"
 }
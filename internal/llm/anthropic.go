@@ -10,11 +10,17 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tokenizer"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
 const (
 	anthropicAPIURL = "https://api.anthropic.com/v1/messages"
 	anthropicVersion = "2023-06-01"
+
+	// anthropicMinThinkingBudget is the smallest thinking budget accepted
+	anthropicMinThinkingBudget = 1024
 )
 
 // AnthropicClient implements the Client interface for Anthropic's Claude API
@@ -54,18 +60,45 @@ func NewAnthropicClient(config ClientConfig) (*AnthropicClient, error) {
 
 // anthropicRequest represents the Anthropic API request format
 type anthropicRequest struct {
-	Model       string              `json:"model"`
-	Messages    []anthropicMessage  `json:"messages"`
-	MaxTokens   int                 `json:"max_tokens"`
-	Temperature float64             `json:"temperature,omitempty"`
-	TopP        float64             `json:"top_p,omitempty"`
-	Stream      bool                `json:"stream"`
-	System      string              `json:"system,omitempty"`
+	Model       string               `json:"model"`
+	Messages    []anthropicMessage   `json:"messages"`
+	MaxTokens   int                  `json:"max_tokens"`
+	Temperature float64              `json:"temperature,omitempty"`
+	TopP        float64              `json:"top_p,omitempty"`
+	Stream      bool                 `json:"stream"`
+	System      []anthropicContent   `json:"system,omitempty"`
+	Tools       []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Thinking    *anthropicThinking   `json:"thinking,omitempty"`
+}
+
+// anthropicToolChoice makes the model call a particular tool
+type anthropicToolChoice struct {
+	Type string `json:"type"` // "tool"
+	Name string `json:"name"`
+}
+
+// anthropicThinking enables extended thinking
+type anthropicThinking struct {
+	Type         string `json:"type"` // Always "enabled"
+	BudgetTokens int    `json:"budget_tokens"`
 }
 
 type anthropicMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string             `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicTool struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	InputSchema  map[string]any         `json:"input_schema"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+// anthropicCacheControl marks the end of a prompt prefix to cache
+type anthropicCacheControl struct {
+	Type string `json:"type"` // Always "ephemeral"
 }
 
 // anthropicResponse represents the Anthropic API response format
@@ -80,14 +113,52 @@ type anthropicResponse struct {
 	Error        *anthropicError         `json:"error,omitempty"`
 }
 
+// anthropicContent is a content block; which fields are set depends on Type
+// ("text", "tool_use", "tool_result", "thinking" or "redacted_thinking")
 type anthropicContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	Signature string          `json:"signature,omitempty"`
+	Data      string          `json:"data,omitempty"` // Encrypted redacted thinking
+
+	Source *anthropicImageSource `json:"source,omitempty"`
+
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+// anthropicImageSource is the data of an image block
+type anthropicImageSource struct {
+	Type      string `json:"type"` // Always "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
 }
 
+// anthropicUsage reports input tokens excluding those read from or
+// written to the prompt cache, which are counted separately
 type anthropicUsage struct {
-	InputTokens  int `json:"input_tokens"`
-	OutputTokens int `json:"output_tokens"`
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// toUsage converts to Usage, whose prompt tokens include cached ones
+func (u anthropicUsage) toUsage() Usage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+		CacheReadTokens:  u.CacheReadInputTokens,
+		CacheWriteTokens: u.CacheCreationInputTokens,
+	}
 }
 
 type anthropicError struct {
@@ -107,13 +178,21 @@ type anthropicStreamEvent struct {
 }
 
 type anthropicDelta struct {
-	Type       string `json:"type"`
-	Text       string `json:"text"`
-	StopReason string `json:"stop_reason,omitempty"`
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	PartialJSON string `json:"partial_json,omitempty"`
+	Thinking    string `json:"thinking,omitempty"`
+	Signature   string `json:"signature,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"`
 }
 
 // Complete sends a non-streaming request
 func (c *AnthropicClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	return completeStructured(ctx, req, c.complete)
+}
+
+// complete sends one non-streaming request
+func (c *AnthropicClient) complete(ctx context.Context, req Request) (*Response, error) {
 	apiReq := c.buildRequest(req)
 	apiReq.Stream = false
 
@@ -161,6 +240,10 @@ func (c *AnthropicClient) Complete(ctx context.Context, req Request) (*Response,
 
 // Stream sends a streaming request
 func (c *AnthropicClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	if req.ResponseSchema != nil {
+		return streamStructured(ctx, req, c.Complete, callback)
+	}
+
 	apiReq := c.buildRequest(req)
 	apiReq.Stream = true
 
@@ -201,7 +284,15 @@ func (c *AnthropicClient) Stream(ctx context.Context, req Request, callback Stre
 // processStream processes the SSE stream
 func (c *AnthropicClient) processStream(body io.Reader, callback StreamCallback) error {
 	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	var usage *Usage
+	var inputUsage anthropicUsage // From message_start
+
+	// Tool use blocks stream their input as JSON fragments, keyed by block index
+	toolBlocks := make(map[int]*anthropicToolBlock)
+
+	// Thinking blocks stream their text, then their signature
+	thinkingBlocks := make(map[int]*ReasoningBlock)
 
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -228,8 +319,56 @@ func (c *AnthropicClient) processStream(body io.Reader, callback StreamCallback)
 
 		// Handle different event types
 		switch event.Type {
+		case "message_start":
+			// Input and cache usage is reported here
+			if event.Message != nil {
+				inputUsage = event.Message.Usage
+				startUsage := inputUsage.toUsage()
+				usage = &startUsage
+			}
+
+		case "content_block_start":
+			if event.ContentBlock == nil {
+				continue
+			}
+			switch event.ContentBlock.Type {
+			case "tool_use":
+				toolBlocks[event.Index] = &anthropicToolBlock{
+					id:   event.ContentBlock.ID,
+					name: event.ContentBlock.Name,
+				}
+			case "thinking", "redacted_thinking":
+				thinkingBlocks[event.Index] = anthropicReasoningBlock(*event.ContentBlock)
+			}
+
 		case "content_block_delta":
-			if event.Delta != nil && event.Delta.Text != "" {
+			if event.Delta == nil {
+				continue
+			}
+			switch event.Delta.Type {
+			case "input_json_delta":
+				if block, ok := toolBlocks[event.Index]; ok {
+					block.input.WriteString(event.Delta.PartialJSON)
+				}
+				continue
+			case "thinking_delta":
+				if block, ok := thinkingBlocks[event.Index]; ok {
+					block.Text += event.Delta.Thinking
+				}
+				if event.Delta.Thinking != "" {
+					callback(StreamEvent{
+						Reasoning: event.Delta.Thinking,
+						Timestamp: time.Now(),
+					})
+				}
+				continue
+			case "signature_delta":
+				if block, ok := thinkingBlocks[event.Index]; ok {
+					block.Signature += event.Delta.Signature
+				}
+				continue
+			}
+			if event.Delta.Text != "" {
 				callback(StreamEvent{
 					Delta:     event.Delta.Text,
 					Done:      false,
@@ -237,6 +376,27 @@ func (c *AnthropicClient) processStream(body io.Reader, callback StreamCallback)
 				})
 			}
 
+		case "content_block_stop":
+			if block, ok := thinkingBlocks[event.Index]; ok {
+				delete(thinkingBlocks, event.Index)
+				callback(StreamEvent{
+					ReasoningBlock: block,
+					Timestamp:      time.Now(),
+				})
+			}
+			if block, ok := toolBlocks[event.Index]; ok {
+				delete(toolBlocks, event.Index)
+				toolCall := schema.ToolCall{
+					ID:        block.id,
+					Name:      block.name,
+					Arguments: decodeToolArguments(block.input.String()),
+				}
+				callback(StreamEvent{
+					ToolCall:  &toolCall,
+					Timestamp: time.Now(),
+				})
+			}
+
 		case "message_delta":
 			if event.Delta != nil && event.Delta.StopReason != "" {
 				callback(StreamEvent{
@@ -246,11 +406,15 @@ func (c *AnthropicClient) processStream(body io.Reader, callback StreamCallback)
 				})
 			}
 			if event.Usage != nil {
-				usage = &Usage{
-					PromptTokens:     event.Usage.InputTokens,
-					CompletionTokens: event.Usage.OutputTokens,
-					TotalTokens:      event.Usage.InputTokens + event.Usage.OutputTokens,
+				// Counts are cumulative; input counts may be left out
+				final := *event.Usage
+				if final.InputTokens == 0 && final.CacheCreationInputTokens == 0 && final.CacheReadInputTokens == 0 {
+					final.InputTokens = inputUsage.InputTokens
+					final.CacheCreationInputTokens = inputUsage.CacheCreationInputTokens
+					final.CacheReadInputTokens = inputUsage.CacheReadInputTokens
 				}
+				finalUsage := final.toUsage()
+				usage = &finalUsage
 			}
 
 		case "message_stop":
@@ -282,6 +446,13 @@ func (c *AnthropicClient) processStream(body io.Reader, callback StreamCallback)
 	return nil
 }
 
+// anthropicToolBlock accumulates a streamed tool_use block
+type anthropicToolBlock struct {
+	id    string
+	name  string
+	input strings.Builder
+}
+
 // buildRequest converts a generic Request to Anthropic format
 func (c *AnthropicClient) buildRequest(req Request) anthropicRequest {
 	apiReq := anthropicRequest{
@@ -290,8 +461,7 @@ func (c *AnthropicClient) buildRequest(req Request) anthropicRequest {
 		Temperature: req.Temperature,
 		TopP:        req.TopP,
 		Stream:      req.Stream,
-		System:      req.SystemPrompt,
-		Messages:    make([]anthropicMessage, len(req.Messages)),
+		Messages:    make([]anthropicMessage, 0, len(req.Messages)),
 	}
 
 	// Use config defaults if not specified
@@ -305,34 +475,200 @@ func (c *AnthropicClient) buildRequest(req Request) anthropicRequest {
 		apiReq.Temperature = c.config.Temperature
 	}
 
+	// Thinking counts towards max_tokens, so the budget is added to leave
+	// the answer its full length. Thinking cannot be combined with a
+	// temperature or top_p, nor with the forced tool use that returns a
+	// structured answer.
+	if budget := req.thinkingBudget(); budget > 0 && req.ResponseSchema == nil {
+		budget = max(budget, anthropicMinThinkingBudget)
+		apiReq.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+		apiReq.MaxTokens += budget
+		apiReq.Temperature = 0
+		apiReq.TopP = 0
+	}
+
+	// Convert tool definitions
+	for _, tool := range req.Tools {
+		apiReq.Tools = append(apiReq.Tools, anthropicTool{
+			Name:        tool.Name,
+			Description: tool.Description,
+			InputSchema: tool.InputSchema,
+		})
+	}
+
+	// A structured answer is the input of a tool the model must call
+	if schema := req.ResponseSchema; schema != nil {
+		apiReq.Tools = append(apiReq.Tools, anthropicTool{
+			Name:        schema.name(),
+			Description: schema.Description,
+			InputSchema: schema.objectSchema(),
+		})
+		apiReq.ToolChoice = &anthropicToolChoice{Type: "tool", Name: schema.name()}
+	}
+
+	// Anthropic has no system role and requires alternating turns
+	system, messages := normalizeMessages(ProviderAnthropic, req.SystemPrompt, req.Messages)
+
 	// Convert messages
-	for i, msg := range req.Messages {
-		apiReq.Messages[i] = anthropicMessage{
+	for _, msg := range messages {
+		apiReq.Messages = append(apiReq.Messages, anthropicMessage{
 			Role:    string(msg.Role),
-			Content: msg.Content,
-		}
+			Content: anthropicContentBlocks(msg),
+		})
+	}
+
+	if system != "" {
+		apiReq.System = []anthropicContent{{Type: "text", Text: system}}
+	}
+
+	if !req.DisableCache {
+		addCacheBreakpoints(&apiReq)
 	}
 
 	return apiReq
 }
 
+// addCacheBreakpoints marks the prompt prefixes Anthropic should cache.
+// The tools and the system prompt are the same on every request of a
+// session; the history grows by a few messages per request, so a rolling
+// breakpoint on the last message writes the prefix the next request
+// reads, and one on the previous user message reads the prefix the
+// previous request wrote. That is four breakpoints, the most allowed.
+func addCacheBreakpoints(apiReq *anthropicRequest) {
+	ephemeral := &anthropicCacheControl{Type: "ephemeral"}
+
+	if n := len(apiReq.Tools); n > 0 {
+		apiReq.Tools[n-1].CacheControl = ephemeral
+	}
+	if n := len(apiReq.System); n > 0 {
+		apiReq.System[n-1].CacheControl = ephemeral
+	}
+
+	last := len(apiReq.Messages) - 1
+	markLastBlock := func(i int) {
+		blocks := apiReq.Messages[i].Content
+		if n := len(blocks); n > 0 && !strings.HasSuffix(blocks[n-1].Type, "thinking") {
+			blocks[n-1].CacheControl = ephemeral
+		}
+	}
+	if last < 0 {
+		return
+	}
+	markLastBlock(last)
+	for i := last - 1; i >= 0; i-- {
+		if apiReq.Messages[i].Role == string(RoleUser) {
+			markLastBlock(i)
+			break
+		}
+	}
+}
+
+// anthropicContentBlocks converts a message into Anthropic content blocks.
+// Tool results must lead a user turn, so they are emitted before any
+// images and text; an assistant turn starts with its signed thinking,
+// which must be sent back unchanged while the tool use it led to is
+// answered.
+func anthropicContentBlocks(msg Message) []anthropicContent {
+	var blocks []anthropicContent
+
+	for _, block := range providerReasoning(msg, ProviderAnthropic) {
+		switch {
+		case block.Redacted != "":
+			blocks = append(blocks, anthropicContent{
+				Type: "redacted_thinking",
+				Data: block.Redacted,
+			})
+		case block.Signature != "":
+			blocks = append(blocks, anthropicContent{
+				Type:      "thinking",
+				Thinking:  block.Text,
+				Signature: block.Signature,
+			})
+		}
+	}
+
+	for _, result := range msg.ToolResults {
+		blocks = append(blocks, anthropicContent{
+			Type:      "tool_result",
+			ToolUseID: result.ToolCallID,
+			Content:   result.Content,
+			IsError:   result.IsError,
+		})
+	}
+
+	for _, img := range msg.Images {
+		if len(img.Data) == 0 {
+			blocks = append(blocks, anthropicContent{Type: "text", Text: img.unavailableText()})
+			continue
+		}
+		blocks = append(blocks, anthropicContent{
+			Type: "image",
+			Source: &anthropicImageSource{
+				Type:      "base64",
+				MediaType: img.MediaType,
+				Data:      img.Base64(),
+			},
+		})
+	}
+
+	if msg.Content != "" {
+		blocks = append(blocks, anthropicContent{
+			Type: "text",
+			Text: msg.Content,
+		})
+	}
+
+	for _, call := range msg.ToolCalls {
+		blocks = append(blocks, anthropicContent{
+			Type:  "tool_use",
+			ID:    call.ID,
+			Name:  call.Name,
+			Input: encodeToolArguments(call.Arguments),
+		})
+	}
+
+	return blocks
+}
+
 // convertResponse converts Anthropic response to generic Response
 func (c *AnthropicClient) convertResponse(resp *anthropicResponse) *Response {
-	var content string
-	if len(resp.Content) > 0 {
-		content = resp.Content[0].Text
+	var content strings.Builder
+	var toolCalls []schema.ToolCall
+	var reasoning []ReasoningBlock
+
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "thinking", "redacted_thinking":
+			reasoning = append(reasoning, *anthropicReasoningBlock(block))
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, schema.ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: decodeToolArguments(string(block.Input)),
+			})
+		}
 	}
 
 	return &Response{
-		Content:      content,
+		Content:      content.String(),
+		ToolCalls:    toolCalls,
+		Reasoning:    reasoning,
 		Role:         Role(resp.Role),
 		FinishReason: resp.StopReason,
 		Model:        resp.Model,
-		Usage: Usage{
-			PromptTokens:     resp.Usage.InputTokens,
-			CompletionTokens: resp.Usage.OutputTokens,
-			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
-		},
+		Usage:        resp.Usage.toUsage(),
+	}
+}
+
+// anthropicReasoningBlock converts a thinking or redacted_thinking block
+func anthropicReasoningBlock(block anthropicContent) *ReasoningBlock {
+	return &ReasoningBlock{
+		Provider:  ProviderAnthropic,
+		Text:      block.Thinking,
+		Signature: block.Signature,
+		Redacted:  block.Data,
 	}
 }
 
@@ -349,19 +685,19 @@ func (c *AnthropicClient) handleError(resp *http.Response) error {
 
 	var apiErr anthropicResponse
 	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error != nil {
-		return &LLMError{
+		return withResponseHeaders(&LLMError{
 			Type:    c.mapErrorType(resp.StatusCode),
 			Message: apiErr.Error.Message,
 			Code:    resp.StatusCode,
 			Details: apiErr.Error.Type,
-		}
+		}, resp.Header)
 	}
 
-	return &LLMError{
+	return withResponseHeaders(&LLMError{
 		Type:    c.mapErrorType(resp.StatusCode),
 		Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
 		Code:    resp.StatusCode,
-	}
+	}, resp.Header)
 }
 
 // mapErrorType maps HTTP status codes to error types
@@ -373,7 +709,7 @@ func (c *AnthropicClient) mapErrorType(statusCode int) ErrorType {
 		return ErrorTypeRateLimit
 	case 400, 422:
 		return ErrorTypeInvalidRequest
-	case 500, 502, 503, 504:
+	case 500, 502, 503, 504, 529: // 529: overloaded
 		return ErrorTypeServer
 	default:
 		return ErrorTypeUnknown
@@ -390,8 +726,8 @@ func (c *AnthropicClient) Model() string {
 	return c.config.Model
 }
 
-// CountTokens estimates token count (rough approximation)
+// CountTokens counts tokens offline with the model's tokenizer, or a
+// calibrated estimate when no tokenizer is available for the model
 func (c *AnthropicClient) CountTokens(text string) int {
-	// Rough approximation: ~4 characters per token
-	return len(text) / 4
+	return tokenizer.ForModel(c.config.Model).Count(text)
 }
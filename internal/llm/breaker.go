@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// circuitBreaker fails requests fast after repeated server errors. Once
+// threshold consecutive server errors are seen the circuit opens and every
+// request fails immediately until cooldown has passed; then requests are
+// let through again, and the first result decides whether the circuit
+// closes (success) or opens for another cooldown (server error).
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int       // Consecutive server errors
+	openedAt time.Time // When the circuit last opened; zero while closed
+	now      func() time.Time
+}
+
+// newCircuitBreaker creates a breaker, or nil if threshold is not positive.
+// A nil breaker lets every request through.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// allow returns an error if the circuit is open
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openedAt.IsZero() {
+		return nil
+	}
+	remaining := b.cooldown - b.now().Sub(b.openedAt)
+	if remaining <= 0 {
+		return nil // Half open: let a request probe the provider
+	}
+
+	return &LLMError{
+		Type:       ErrorTypeServer,
+		Message:    fmt.Sprintf("provider is failing repeatedly; not sending requests for %s", remaining.Round(time.Second)),
+		Details:    "circuit_open",
+		RetryAfter: remaining,
+	}
+}
+
+// record updates the breaker with the result of a request
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var llmErr *LLMError
+	switch {
+	case err == nil:
+		b.failures = 0
+		b.openedAt = time.Time{}
+	case errors.As(err, &llmErr) && llmErr.Type == ErrorTypeServer:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.openedAt = b.now()
+		}
+	}
+}
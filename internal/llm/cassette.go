@@ -0,0 +1,603 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tokenizer"
+	"github.com/siddharth-bhatnagar/anvil/internal/util"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// CassetteMode selects whether a CassetteClient records or replays
+type CassetteMode int
+
+const (
+	// CassetteReplay answers requests from the cassette file, offline
+	CassetteReplay CassetteMode = iota
+
+	// CassetteRecord sends requests to the wrapped client and saves them
+	CassetteRecord
+)
+
+// CassetteModeFromEnv returns CassetteRecord when ANVIL_CASSETTE is
+// "record", so tests can re-record their cassettes against a live API
+func CassetteModeFromEnv() CassetteMode {
+	if strings.EqualFold(os.Getenv("ANVIL_CASSETTE"), "record") {
+		return CassetteRecord
+	}
+	return CassetteReplay
+}
+
+// CassetteClient records the traffic of a Client to a JSON file and
+// replays it offline. Requests are matched by a hash of their content
+// that ignores volatile fields: the model name, the stream flag, tool call
+// IDs (replaced by their order of appearance) and any strings registered
+// with Scrub.
+type CassetteClient struct {
+	client Client
+	path   string
+	mode   CassetteMode
+
+	mu       sync.Mutex
+	cassette cassetteFile
+	byHash   map[string][]int // Interaction indices per request hash
+	replayed map[string]int   // How many interactions of each hash were replayed
+	scrubs   []cassetteScrub
+}
+
+// cassetteFile is the on-disk format of a cassette
+type cassetteFile struct {
+	Provider     ProviderType          `json:"provider"`
+	Model        string                `json:"model"`
+	Note         string                `json:"note,omitempty"` // e.g. that the cassette was written by hand rather than recorded
+	Interactions []cassetteInteraction `json:"interactions"`
+}
+
+// cassetteInteraction is one recorded request and its outcome
+type cassetteInteraction struct {
+	Hash     string            `json:"hash"`
+	Request  cassetteRequest   `json:"request"`
+	Response *cassetteResponse `json:"response,omitempty"`
+	Events   []cassetteEvent   `json:"events,omitempty"`
+	Error    *cassetteError    `json:"error,omitempty"`
+}
+
+// cassetteRequest is the normalized form of a request that is hashed
+type cassetteRequest struct {
+	Kind         string    `json:"kind"` // "complete" or "stream"
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	Messages     []Message `json:"messages"`
+	Tools        []Tool    `json:"tools,omitempty"`
+	MaxTokens    int       `json:"max_tokens,omitempty"`
+	Temperature  float64   `json:"temperature,omitempty"`
+	TopP         float64   `json:"top_p,omitempty"`
+
+	ThinkingBudget  int             `json:"thinking_budget,omitempty"`
+	ReasoningEffort ReasoningEffort `json:"reasoning_effort,omitempty"`
+
+	ResponseSchema *ResponseSchema `json:"response_schema,omitempty"`
+}
+
+// cassetteResponse is a recorded response. The parsed answer to a request
+// with a response schema is not kept; replay parses the content again.
+type cassetteResponse struct {
+	Content      string            `json:"content,omitempty"`
+	ToolCalls    []schema.ToolCall `json:"tool_calls,omitempty"`
+	Reasoning    []ReasoningBlock  `json:"reasoning,omitempty"`
+	Role         Role              `json:"role,omitempty"`
+	FinishReason string            `json:"finish_reason,omitempty"`
+	Usage        Usage             `json:"usage"`
+	Model        string            `json:"model,omitempty"`
+}
+
+type cassetteEvent struct {
+	Delta          string           `json:"delta,omitempty"`
+	Reasoning      string           `json:"reasoning,omitempty"`
+	ReasoningBlock *ReasoningBlock  `json:"reasoning_block,omitempty"`
+	ToolCall       *schema.ToolCall `json:"tool_call,omitempty"`
+	Done           bool             `json:"done,omitempty"`
+	Error          string           `json:"error,omitempty"`
+	Usage          *Usage           `json:"usage,omitempty"`
+	FinishReason   string           `json:"finish_reason,omitempty"`
+}
+
+// cassetteError is a recorded failure, replayed as an LLMError
+type cassetteError struct {
+	Type    ErrorType `json:"type"`
+	Message string    `json:"message"`
+	Code    int       `json:"code,omitempty"`
+	Details string    `json:"details,omitempty"`
+}
+
+// cassetteScrub replaces a run-specific string, such as a temporary
+// directory, with a stable placeholder
+type cassetteScrub struct {
+	value       string
+	placeholder string
+}
+
+// NewCassetteClient creates a recording or replaying client for the
+// cassette at path. Recording needs the client to forward requests to;
+// replaying loads the cassette and does not use client.
+func NewCassetteClient(client Client, path string, mode CassetteMode) (*CassetteClient, error) {
+	c := &CassetteClient{
+		client:   client,
+		path:     path,
+		mode:     mode,
+		byHash:   make(map[string][]int),
+		replayed: make(map[string]int),
+	}
+
+	if mode == CassetteRecord {
+		if client == nil {
+			return nil, &LLMError{
+				Type:    ErrorTypeInvalidRequest,
+				Message: "recording a cassette needs a client",
+			}
+		}
+		c.cassette.Provider = client.Provider()
+		c.cassette.Model = client.Model()
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	for i, interaction := range c.cassette.Interactions {
+		c.byHash[interaction.Hash] = append(c.byHash[interaction.Hash], i)
+	}
+
+	return c, nil
+}
+
+// Scrub replaces value with placeholder in recorded requests and
+// responses, and placeholder with value in replayed responses. Use it for
+// strings that differ between runs, such as temporary directories.
+func (c *CassetteClient) Scrub(value, placeholder string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scrubs = append(c.scrubs, cassetteScrub{value: value, placeholder: placeholder})
+}
+
+// Save writes the recorded interactions to the cassette file. It does
+// nothing when replaying.
+func (c *CassetteClient) Save() error {
+	if c.mode != CassetteRecord {
+		return nil
+	}
+
+	c.mu.Lock()
+	data, err := json.MarshalIndent(c.cassette, "", "  ")
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(c.path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// Complete records or replays a non-streaming request
+func (c *CassetteClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	normalized := c.normalize(req, "complete")
+
+	if c.mode == CassetteReplay {
+		interaction, err := c.lookup(normalized)
+		if err != nil {
+			return nil, err
+		}
+		if interaction.Error != nil {
+			return nil, interaction.Error.llmError()
+		}
+		if interaction.Response == nil {
+			return nil, c.missError(normalized, "recording has no response")
+		}
+		resp := c.restoreResponse(*interaction.Response)
+		if schema := req.ResponseSchema; schema != nil {
+			parsed, err := schema.parse(resp.Content)
+			if err != nil {
+				return nil, &LLMError{
+					Type:    ErrorTypeInvalidResponse,
+					Message: fmt.Sprintf("cassette %s: the recorded answer does not match the %s schema: %v", c.path, schema.name(), err),
+					Details: resp.Content,
+				}
+			}
+			resp.Parsed = parsed
+		}
+		return resp, nil
+	}
+
+	resp, err := c.client.Complete(ctx, req)
+	interaction := cassetteInteraction{Request: normalized}
+	if err != nil {
+		interaction.Error = newCassetteError(err)
+	} else {
+		recorded := c.scrubResponse(resp)
+		interaction.Response = &recorded
+	}
+	c.record(interaction)
+
+	return resp, err
+}
+
+// Stream records or replays a streaming request. A replayed stream
+// delivers the recorded events in order.
+func (c *CassetteClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	normalized := c.normalize(req, "stream")
+
+	if c.mode == CassetteReplay {
+		interaction, err := c.lookup(normalized)
+		if err != nil {
+			return err
+		}
+		for _, event := range interaction.Events {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			callback(c.restoreEvent(event))
+		}
+		if interaction.Error != nil {
+			return interaction.Error.llmError()
+		}
+		return nil
+	}
+
+	var events []cassetteEvent
+	err := c.client.Stream(ctx, req, func(event StreamEvent) {
+		events = append(events, c.scrubEvent(event))
+		callback(event)
+	})
+
+	interaction := cassetteInteraction{Request: normalized, Events: events}
+	if err != nil {
+		interaction.Error = newCassetteError(err)
+	}
+	c.record(interaction)
+
+	return err
+}
+
+// record appends an interaction to the cassette
+func (c *CassetteClient) record(interaction cassetteInteraction) {
+	interaction.Hash = hashCassetteRequest(interaction.Request)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.byHash[interaction.Hash] = append(c.byHash[interaction.Hash], len(c.cassette.Interactions))
+	c.cassette.Interactions = append(c.cassette.Interactions, interaction)
+}
+
+// lookup finds the recording for a request. Identical requests replay
+// their recordings in order, repeating the last one once all were used.
+func (c *CassetteClient) lookup(req cassetteRequest) (cassetteInteraction, error) {
+	hash := hashCassetteRequest(req)
+
+	c.mu.Lock()
+	indices := c.byHash[hash]
+	n := c.replayed[hash]
+	if len(indices) > 0 {
+		c.replayed[hash] = n + 1
+	}
+	c.mu.Unlock()
+
+	if len(indices) == 0 {
+		return cassetteInteraction{}, c.missError(req, "no recording matches this request")
+	}
+	return c.cassette.Interactions[indices[min(n, len(indices)-1)]], nil
+}
+
+// missError explains a replay miss with a diff against the most similar
+// recorded request
+func (c *CassetteClient) missError(req cassetteRequest, reason string) error {
+	want := cassetteJSON(req)
+
+	var closest string
+	closestChanges := -1
+	for _, interaction := range c.cassette.Interactions {
+		diff := util.UnifiedDiff(cassetteJSON(interaction.Request), want, "recorded", "requested")
+		added, removed := util.CountChanges(diff)
+		if closestChanges < 0 || added+removed < closestChanges {
+			closest, closestChanges = diff, added+removed
+		}
+	}
+
+	message := fmt.Sprintf("cassette %s: %s (%s request, hash %s)", c.path, reason, req.Kind, hashCassetteRequest(req)[:12])
+	if closest != "" {
+		message += "\nclosest recorded request differs:\n" + closest
+	} else {
+		message += "\ncassette has no recordings; re-record it with ANVIL_CASSETTE=record"
+	}
+
+	return &LLMError{
+		Type:    ErrorTypeInvalidRequest,
+		Message: message,
+		Details: "cassette_miss",
+	}
+}
+
+// normalize builds the hashed form of a request, dropping volatile fields
+func (c *CassetteClient) normalize(req Request, kind string) cassetteRequest {
+	c.mu.Lock()
+	scrub := c.scrubber()
+	c.mu.Unlock()
+
+	ids := make(map[string]string)
+	stableID := func(id string) string {
+		if stable, ok := ids[id]; ok {
+			return stable
+		}
+		ids[id] = fmt.Sprintf("call_%d", len(ids)+1)
+		return ids[id]
+	}
+
+	messages := make([]Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		msg.Content = scrub.Replace(msg.Content)
+		msg.Reasoning = replaceInReasoning(msg.Reasoning, scrub)
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]schema.ToolCall, len(msg.ToolCalls))
+			for j, call := range msg.ToolCalls {
+				call.ID = stableID(call.ID)
+				call.Arguments = replaceInArguments(call.Arguments, scrub)
+				calls[j] = call
+			}
+			msg.ToolCalls = calls
+		}
+		for j := range msg.Reasoning {
+			if msg.Reasoning[j].ToolCallID != "" {
+				msg.Reasoning[j].ToolCallID = stableID(msg.Reasoning[j].ToolCallID)
+			}
+		}
+		if len(msg.ToolResults) > 0 {
+			results := make([]ToolResult, len(msg.ToolResults))
+			for j, result := range msg.ToolResults {
+				result.ToolCallID = stableID(result.ToolCallID)
+				result.Content = scrub.Replace(result.Content)
+				results[j] = result
+			}
+			msg.ToolResults = results
+		}
+		messages[i] = msg
+	}
+
+	return cassetteRequest{
+		Kind:         kind,
+		SystemPrompt: scrub.Replace(req.SystemPrompt),
+		Messages:     messages,
+		Tools:        req.Tools,
+		MaxTokens:    req.MaxTokens,
+		Temperature:  req.Temperature,
+		TopP:         req.TopP,
+
+		ThinkingBudget:  req.ThinkingBudget,
+		ReasoningEffort: req.ReasoningEffort,
+
+		ResponseSchema: req.ResponseSchema,
+	}
+}
+
+// scrubber replaces scrubbed values with their placeholders
+func (c *CassetteClient) scrubber() *strings.Replacer {
+	var pairs []string
+	for _, s := range c.scrubs {
+		pairs = append(pairs, s.value, s.placeholder)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+// restorer replaces placeholders with their values
+func (c *CassetteClient) restorer() *strings.Replacer {
+	var pairs []string
+	for _, s := range c.scrubs {
+		pairs = append(pairs, s.placeholder, s.value)
+	}
+	return strings.NewReplacer(pairs...)
+}
+
+func (c *CassetteClient) scrubResponse(resp *Response) cassetteResponse {
+	c.mu.Lock()
+	scrub := c.scrubber()
+	c.mu.Unlock()
+
+	return cassetteResponse{
+		Content:      scrub.Replace(resp.Content),
+		ToolCalls:    replaceInToolCalls(resp.ToolCalls, scrub),
+		Reasoning:    replaceInReasoning(resp.Reasoning, scrub),
+		Role:         resp.Role,
+		FinishReason: resp.FinishReason,
+		Usage:        resp.Usage,
+		Model:        resp.Model,
+	}
+}
+
+func (c *CassetteClient) restoreResponse(recorded cassetteResponse) *Response {
+	c.mu.Lock()
+	restore := c.restorer()
+	c.mu.Unlock()
+
+	return &Response{
+		Content:      restore.Replace(recorded.Content),
+		ToolCalls:    replaceInToolCalls(recorded.ToolCalls, restore),
+		Reasoning:    replaceInReasoning(recorded.Reasoning, restore),
+		Role:         recorded.Role,
+		FinishReason: recorded.FinishReason,
+		Usage:        recorded.Usage,
+		Model:        recorded.Model,
+	}
+}
+
+func (c *CassetteClient) scrubEvent(event StreamEvent) cassetteEvent {
+	c.mu.Lock()
+	scrub := c.scrubber()
+	c.mu.Unlock()
+
+	recorded := cassetteEvent{
+		Delta:        scrub.Replace(event.Delta),
+		Reasoning:    scrub.Replace(event.Reasoning),
+		Done:         event.Done,
+		Usage:        event.Usage,
+		FinishReason: event.FinishReason,
+	}
+	if event.ReasoningBlock != nil {
+		recorded.ReasoningBlock = &replaceInReasoning([]ReasoningBlock{*event.ReasoningBlock}, scrub)[0]
+	}
+	if event.ToolCall != nil {
+		recorded.ToolCall = &replaceInToolCalls([]schema.ToolCall{*event.ToolCall}, scrub)[0]
+	}
+	if event.Error != nil {
+		recorded.Error = event.Error.Error()
+	}
+	return recorded
+}
+
+func (c *CassetteClient) restoreEvent(recorded cassetteEvent) StreamEvent {
+	c.mu.Lock()
+	restore := c.restorer()
+	c.mu.Unlock()
+
+	event := StreamEvent{
+		Delta:        restore.Replace(recorded.Delta),
+		Reasoning:    restore.Replace(recorded.Reasoning),
+		Done:         recorded.Done,
+		Usage:        recorded.Usage,
+		FinishReason: recorded.FinishReason,
+		Timestamp:    time.Now(),
+	}
+	if recorded.ReasoningBlock != nil {
+		event.ReasoningBlock = &replaceInReasoning([]ReasoningBlock{*recorded.ReasoningBlock}, restore)[0]
+	}
+	if recorded.ToolCall != nil {
+		event.ToolCall = &replaceInToolCalls([]schema.ToolCall{*recorded.ToolCall}, restore)[0]
+	}
+	if recorded.Error != "" {
+		event.Error = &LLMError{Type: ErrorTypeUnknown, Message: recorded.Error}
+	}
+	return event
+}
+
+// Provider returns the recorded provider, or the wrapped client's
+func (c *CassetteClient) Provider() ProviderType {
+	return c.cassette.Provider
+}
+
+// Model returns the recorded model, or the wrapped client's
+func (c *CassetteClient) Model() string {
+	return c.cassette.Model
+}
+
+// CountTokens counts tokens with the wrapped client, or with the
+// recorded model's tokenizer when replaying
+func (c *CassetteClient) CountTokens(text string) int {
+	if c.client != nil {
+		return c.client.CountTokens(text)
+	}
+	return tokenizer.ForModel(c.cassette.Model).Count(text)
+}
+
+// newCassetteError records err, keeping its LLMError fields if it has them
+func newCassetteError(err error) *cassetteError {
+	if llmErr, ok := err.(*LLMError); ok {
+		return &cassetteError{
+			Type:    llmErr.Type,
+			Message: llmErr.Message,
+			Code:    llmErr.Code,
+			Details: llmErr.Details,
+		}
+	}
+	return &cassetteError{Type: ErrorTypeUnknown, Message: err.Error()}
+}
+
+func (e *cassetteError) llmError() *LLMError {
+	return &LLMError{
+		Type:    e.Type,
+		Message: e.Message,
+		Code:    e.Code,
+		Details: e.Details,
+	}
+}
+
+// replaceInToolCalls copies calls, applying r to their string arguments
+func replaceInToolCalls(calls []schema.ToolCall, r *strings.Replacer) []schema.ToolCall {
+	if len(calls) == 0 {
+		return nil
+	}
+	replaced := make([]schema.ToolCall, len(calls))
+	for i, call := range calls {
+		call.Arguments = replaceInArguments(call.Arguments, r)
+		replaced[i] = call
+	}
+	return replaced
+}
+
+// replaceInReasoning copies blocks, applying r to their text
+func replaceInReasoning(blocks []ReasoningBlock, r *strings.Replacer) []ReasoningBlock {
+	if len(blocks) == 0 {
+		return nil
+	}
+	replaced := make([]ReasoningBlock, len(blocks))
+	for i, block := range blocks {
+		block.Text = r.Replace(block.Text)
+		replaced[i] = block
+	}
+	return replaced
+}
+
+// replaceInArguments copies args, applying r to every string value
+func replaceInArguments(args map[string]any, r *strings.Replacer) map[string]any {
+	if args == nil {
+		return nil
+	}
+	replaced := make(map[string]any, len(args))
+	for key, value := range args {
+		replaced[key] = replaceInValue(value, r)
+	}
+	return replaced
+}
+
+func replaceInValue(value any, r *strings.Replacer) any {
+	switch v := value.(type) {
+	case string:
+		return r.Replace(v)
+	case map[string]any:
+		return replaceInArguments(v, r)
+	case []any:
+		replaced := make([]any, len(v))
+		for i, item := range v {
+			replaced[i] = replaceInValue(item, r)
+		}
+		return replaced
+	default:
+		return value
+	}
+}
+
+// hashCassetteRequest hashes the normalized request
+func hashCassetteRequest(req cassetteRequest) string {
+	data, _ := json.Marshal(req)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// cassetteJSON renders a normalized request for diffs
+func cassetteJSON(req cassetteRequest) string {
+	data, _ := json.MarshalIndent(req, "", "  ")
+	return string(data)
+}
@@ -0,0 +1,240 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultModel is the model used when none is configured
+const DefaultModel = "claude-sonnet-4-5"
+
+// DefaultReplyTokens is the reply budget for models whose limits are
+// unknown
+const DefaultReplyTokens = 4096
+
+// ModelInfo describes a model's limits, features and price
+type ModelInfo struct {
+	ID            string // Catalog entry, e.g. "claude-sonnet-4-5" for a dated ID
+	Provider      ProviderType
+	ContextWindow int // Prompt and reply tokens the model attends to; 0 if unknown
+	MaxOutput     int // Most tokens one reply can have; 0 if unknown
+
+	// Supported features
+	Tools    bool
+	Vision   bool // Images in messages
+	Thinking bool // Extended thinking or reasoning effort
+	Caching  bool // Prompt caching
+
+	Price ModelPrice // Zero for models without a known price
+}
+
+// ReplyTokens returns the tokens to let a reply use: the model's maximum
+// output, but no more than a quarter of its context window so most of it
+// is left for the conversation
+func (m ModelInfo) ReplyTokens() int {
+	tokens := m.MaxOutput
+	if m.ContextWindow > 0 && (tokens == 0 || tokens > m.ContextWindow/4) {
+		tokens = m.ContextWindow / 4
+	}
+	if tokens <= 0 {
+		return DefaultReplyTokens
+	}
+	return tokens
+}
+
+// Catalog maps model IDs to what Anvil knows about them
+type Catalog map[string]ModelInfo
+
+func anthropicModel(window, output int, thinking bool, price ModelPrice) ModelInfo {
+	return ModelInfo{
+		Provider:      ProviderAnthropic,
+		ContextWindow: window,
+		MaxOutput:     output,
+		Tools:         true,
+		Vision:        true,
+		Thinking:      thinking,
+		Caching:       true,
+		Price:         price,
+	}
+}
+
+func openaiModel(window, output int, vision, reasoning, caching bool, price ModelPrice) ModelInfo {
+	return ModelInfo{
+		Provider:      ProviderOpenAI,
+		ContextWindow: window,
+		MaxOutput:     output,
+		Tools:         true,
+		Vision:        vision,
+		Thinking:      reasoning,
+		Caching:       caching,
+		Price:         price,
+	}
+}
+
+func geminiModel(window, output int, thinking bool, price ModelPrice) ModelInfo {
+	return ModelInfo{
+		Provider:      ProviderGemini,
+		ContextWindow: window,
+		MaxOutput:     output,
+		Tools:         true,
+		Vision:        true,
+		Thinking:      thinking,
+		Caching:       true,
+		Price:         price,
+	}
+}
+
+// DefaultCatalog returns the built-in models with their published limits
+// and approximate list prices (as of 2026). The models table in the
+// config overrides and extends it.
+func DefaultCatalog() Catalog {
+	catalog := Catalog{
+		"claude-sonnet-4-5": anthropicModel(200_000, 64_000, true, anthropicPrice(3.00, 15.00)),
+		"claude-sonnet-4":   anthropicModel(200_000, 64_000, true, anthropicPrice(3.00, 15.00)),
+		"claude-3-7-sonnet": anthropicModel(200_000, 64_000, true, anthropicPrice(3.00, 15.00)),
+		"claude-3-5-sonnet": anthropicModel(200_000, 8_192, false, anthropicPrice(3.00, 15.00)),
+		"claude-opus-4-5":   anthropicModel(200_000, 64_000, true, anthropicPrice(5.00, 25.00)),
+		"claude-opus-4-1":   anthropicModel(200_000, 32_000, true, anthropicPrice(15.00, 75.00)),
+		"claude-opus-4":     anthropicModel(200_000, 32_000, true, anthropicPrice(15.00, 75.00)),
+		"claude-haiku-4-5":  anthropicModel(200_000, 64_000, true, anthropicPrice(1.00, 5.00)),
+		"claude-3-5-haiku":  anthropicModel(200_000, 8_192, false, anthropicPrice(0.80, 4.00)),
+
+		"gpt-5":         openaiModel(400_000, 128_000, true, true, true, openaiPrice(1.25, 10.00)),
+		"gpt-5-mini":    openaiModel(400_000, 128_000, true, true, true, openaiPrice(0.25, 2.00)),
+		"gpt-4.1":       openaiModel(1_047_576, 32_768, true, false, true, openaiPrice(2.00, 8.00)),
+		"gpt-4.1-mini":  openaiModel(1_047_576, 32_768, true, false, true, openaiPrice(0.40, 1.60)),
+		"gpt-4o":        openaiModel(128_000, 16_384, true, false, true, openaiPrice(2.50, 10.00)),
+		"gpt-4o-mini":   openaiModel(128_000, 16_384, true, false, true, openaiPrice(0.15, 0.60)),
+		"gpt-4-turbo":   openaiModel(128_000, 4_096, true, false, false, openaiPrice(10.00, 30.00)),
+		"gpt-4":         openaiModel(8_192, 8_192, false, false, false, openaiPrice(10.00, 30.00)),
+		"gpt-3.5-turbo": openaiModel(16_385, 4_096, false, false, false, openaiPrice(0.50, 1.50)),
+		"o3":            openaiModel(200_000, 100_000, true, true, true, openaiPrice(2.00, 8.00)),
+		"o3-mini":       openaiModel(200_000, 100_000, false, true, true, openaiPrice(1.10, 4.40)),
+		"o4-mini":       openaiModel(200_000, 100_000, true, true, true, openaiPrice(1.10, 4.40)),
+
+		"gemini-2.5-pro":   geminiModel(1_048_576, 65_536, true, geminiPrice(1.25, 10.00)),
+		"gemini-2.5-flash": geminiModel(1_048_576, 65_536, true, geminiPrice(0.30, 2.50)),
+		"gemini-2.0-flash": geminiModel(1_048_576, 8_192, false, geminiPrice(0.10, 0.40)),
+	}
+	for id, info := range catalog {
+		info.ID = id
+		catalog[id] = info
+	}
+	return catalog
+}
+
+// modelVersionSuffix matches the date, snapshot or alias that versions a
+// model ID, e.g. "-20250929", "-2024-08-06", "-0613", "-001" or "-latest"
+var modelVersionSuffix = regexp.MustCompile(`-(\d{8}|\d{4}-\d{2}-\d{2}|\d{3,4}|latest)$`)
+
+// Lookup returns the entry for model. Dated and aliased IDs such as
+// "claude-sonnet-4-5-20250929" resolve to the model they version; any
+// other ID the catalog does not list, such as "o3-pro" or "gpt-4-32k", is
+// a different model and is not found, so it is never priced or sized as
+// a model it merely extends.
+func (c Catalog) Lookup(model string) (ModelInfo, bool) {
+	model = normalizeModelID(model)
+
+	if info, ok := c[model]; ok {
+		return info, true
+	}
+	info, ok := c[modelVersionSuffix.ReplaceAllString(model, "")]
+	return info, ok
+}
+
+// Info returns the entry for model. Models the catalog does not know have
+// unknown limits and are assumed to support every feature, leaving it to
+// the provider to reject what they do not.
+func (c Catalog) Info(model string) ModelInfo {
+	if info, ok := c.Lookup(model); ok {
+		return info
+	}
+	return ModelInfo{
+		ID:       normalizeModelID(model),
+		Tools:    true,
+		Vision:   true,
+		Thinking: true,
+		Caching:  true,
+	}
+}
+
+// normalizeModelID lowercases model and removes any provider prefix, as
+// in "anthropic/claude-sonnet-4-5"
+func normalizeModelID(model string) string {
+	model = strings.ToLower(strings.TrimSpace(model))
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+	return model
+}
+
+// ModelOverride corrects or adds a catalog entry. Zero limits, nil
+// features and a zero price keep what the catalog knows about the model.
+type ModelOverride struct {
+	Model         string
+	ContextWindow int
+	MaxOutput     int
+	Tools         *bool
+	Vision        *bool
+	Thinking      *bool
+	Caching       *bool
+	Price         ModelPrice // Cached tokens cost the input price unless cache prices are set
+}
+
+// With returns a copy of the catalog with overrides applied. An override
+// for a model the catalog resolves, such as a dated ID, starts from that
+// model's entry.
+func (c Catalog) With(overrides ...ModelOverride) Catalog {
+	merged := make(Catalog, len(c)+len(overrides))
+	for id, info := range c {
+		merged[id] = info
+	}
+
+	for _, o := range overrides {
+		info := merged.Info(o.Model)
+		info.ID = normalizeModelID(o.Model)
+		if o.ContextWindow > 0 {
+			info.ContextWindow = o.ContextWindow
+		}
+		if o.MaxOutput > 0 {
+			info.MaxOutput = o.MaxOutput
+		}
+		for _, feature := range []struct {
+			set   *bool
+			value *bool
+		}{
+			{o.Tools, &info.Tools},
+			{o.Vision, &info.Vision},
+			{o.Thinking, &info.Thinking},
+			{o.Caching, &info.Caching},
+		} {
+			if feature.set != nil {
+				*feature.value = *feature.set
+			}
+		}
+		if price := o.Price; price != (ModelPrice{}) {
+			if price.CacheRead == 0 {
+				price.CacheRead = price.Input
+			}
+			if price.CacheWrite == 0 {
+				price.CacheWrite = price.Input
+			}
+			info.Price = price
+		}
+		merged[info.ID] = info
+	}
+	return merged
+}
+
+// Cost returns the price of usage by model, and whether the model is
+// priced. Models served locally are free.
+func (c Catalog) Cost(provider ProviderType, model string, usage Usage) (float64, bool) {
+	if !provider.RequiresAPIKey() && provider != "" {
+		return 0, true
+	}
+	info, ok := c.Lookup(model)
+	if !ok || info.Price == (ModelPrice{}) {
+		return 0, false
+	}
+	return info.Price.Cost(usage), true
+}
@@ -20,13 +20,24 @@ type Client interface {
 	CountTokens(text string) int
 }
 
-// NewClient creates a new LLM client based on the provider type
+// NewClient creates a new LLM client based on the provider type. Without
+// a MaxTokens the client sizes replies from the model's catalog entry.
 func NewClient(config ClientConfig) (Client, error) {
+	if config.MaxTokens == 0 {
+		config.MaxTokens = DefaultCatalog().Info(config.Model).ReplyTokens()
+	}
+
 	switch config.Provider {
 	case ProviderAnthropic:
 		return NewAnthropicClient(config)
 	case ProviderOpenAI, ProviderLocal:
 		return NewOpenAIClient(config)
+	case ProviderGemini:
+		return NewGeminiClient(config)
+	case ProviderOllama:
+		return NewOllamaClient(config)
+	case ProviderScripted:
+		return NewScriptedClient(config)
 	default:
 		return nil, &LLMError{
 			Type:    ErrorTypeInvalidRequest,
@@ -0,0 +1,319 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// maxToolCallIDLength is the longest tool call ID every provider accepts
+const maxToolCallIDLength = 40
+
+// FallbackConfig configures when a FallbackClient moves to the next client
+type FallbackConfig struct {
+	// FallbackOn lists the error types that move a request to the next
+	// client; other errors are returned immediately
+	FallbackOn []ErrorType
+
+	// Cooldown is how long a client that failed is skipped before it is
+	// tried again (0 = always try it)
+	Cooldown time.Duration
+
+	// Catalog holds the limits each client's model is held to when a
+	// request sized for another model is sent to it (nil = DefaultCatalog)
+	Catalog Catalog
+}
+
+// DefaultFallbackConfig falls over on the errors that another provider
+// can be expected not to share
+func DefaultFallbackConfig() FallbackConfig {
+	return FallbackConfig{
+		FallbackOn: []ErrorType{ErrorTypeRateLimit, ErrorTypeServer, ErrorTypeTimeout, ErrorTypeNetwork},
+		Cooldown:   time.Minute,
+		Catalog:    DefaultCatalog(),
+	}
+}
+
+// ParseErrorType converts an error type name such as "rate_limit"
+func ParseErrorType(name string) (ErrorType, error) {
+	switch errType := ErrorType(strings.ToLower(strings.TrimSpace(name))); errType {
+	case ErrorTypeAuth, ErrorTypeRateLimit, ErrorTypeInvalidRequest, ErrorTypeTimeout,
+		ErrorTypeNetwork, ErrorTypeServer, ErrorTypeUnknown:
+		return errType, nil
+	default:
+		return "", fmt.Errorf("unknown error type %q", name)
+	}
+}
+
+// ProviderSwitchCallback is called when requests move to another client.
+// err is the error that caused the switch, or nil when an earlier client
+// serves requests again.
+type ProviderSwitchCallback func(from, to Client, err error)
+
+// FallbackClient tries an ordered list of clients, moving to the next one
+// when a request fails with one of the configured error types. Each
+// client may be a different provider; the conversation history is
+// translated for whichever client serves the request.
+type FallbackClient struct {
+	clients  []Client
+	config   FallbackConfig
+	onSwitch ProviderSwitchCallback
+
+	mu        sync.Mutex
+	active    int         // Index of the client that served the last request
+	failedAt  []time.Time // When each client last failed, for the cooldown
+	fallOnSet map[ErrorType]bool
+}
+
+// NewFallbackClient creates a client that tries clients in order
+func NewFallbackClient(clients []Client, config FallbackConfig) (*FallbackClient, error) {
+	if len(clients) == 0 {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: "fallback client needs at least one client",
+		}
+	}
+
+	if config.Catalog == nil {
+		config.Catalog = DefaultCatalog()
+	}
+
+	fallOnSet := make(map[ErrorType]bool, len(config.FallbackOn))
+	for _, errType := range config.FallbackOn {
+		fallOnSet[errType] = true
+	}
+
+	return &FallbackClient{
+		clients:   clients,
+		config:    config,
+		failedAt:  make([]time.Time, len(clients)),
+		fallOnSet: fallOnSet,
+	}, nil
+}
+
+// SetSwitchCallback sets the function called when the active client changes
+func (f *FallbackClient) SetSwitchCallback(cb ProviderSwitchCallback) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onSwitch = cb
+}
+
+// Active returns the client that served the last request
+func (f *FallbackClient) Active() Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.clients[f.active]
+}
+
+// Complete sends the request to each client in turn until one succeeds
+func (f *FallbackClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	var lastErr error
+
+	for _, i := range f.candidates() {
+		client := f.clients[i]
+		resp, err := client.Complete(ctx, translateRequest(req, client, f.config.Catalog))
+		if err == nil {
+			f.succeeded(i, lastErr)
+			return resp, nil
+		}
+
+		lastErr = err
+		if !f.shouldFallOver(ctx, err) {
+			return nil, err
+		}
+		f.failed(i)
+	}
+
+	return nil, lastErr
+}
+
+// Stream streams from each client in turn until one succeeds. Once a
+// client has delivered any event the request is committed to it: falling
+// over then would repeat output the caller has already seen.
+func (f *FallbackClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	var lastErr error
+
+	for _, i := range f.candidates() {
+		client := f.clients[i]
+		started := false
+		err := client.Stream(ctx, translateRequest(req, client, f.config.Catalog), func(event StreamEvent) {
+			if !started {
+				started = true
+				f.succeeded(i, lastErr)
+			}
+			callback(event)
+		})
+		if err == nil {
+			if !started {
+				f.succeeded(i, lastErr)
+			}
+			return nil
+		}
+
+		lastErr = err
+		if started || !f.shouldFallOver(ctx, err) {
+			return err
+		}
+		f.failed(i)
+	}
+
+	return lastErr
+}
+
+// candidates returns the indices of the clients to try, in order. Clients
+// still cooling down after a failure go last rather than being dropped,
+// so a request is never refused while a client might answer it.
+func (f *FallbackClient) candidates() []int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var ready, cooling []int
+	for i := range f.clients {
+		if !f.failedAt[i].IsZero() && f.config.Cooldown > 0 && time.Since(f.failedAt[i]) < f.config.Cooldown {
+			cooling = append(cooling, i)
+		} else {
+			ready = append(ready, i)
+		}
+	}
+	return append(ready, cooling...)
+}
+
+// shouldFallOver reports whether err should move the request on
+func (f *FallbackClient) shouldFallOver(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) {
+		return false
+	}
+	return f.fallOnSet[llmErr.Type]
+}
+
+// failed records that client i failed
+func (f *FallbackClient) failed(i int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failedAt[i] = time.Now()
+}
+
+// succeeded makes client i the active client, reporting the switch if
+// it is a different one
+func (f *FallbackClient) succeeded(i int, cause error) {
+	f.mu.Lock()
+	f.failedAt[i] = time.Time{}
+	from := f.active
+	f.active = i
+	onSwitch := f.onSwitch
+	f.mu.Unlock()
+
+	if from != i && onSwitch != nil {
+		if i < from {
+			cause = nil // Back to a preferred client
+		}
+		onSwitch(f.clients[from], f.clients[i], cause)
+	}
+}
+
+// Provider returns the provider of the active client
+func (f *FallbackClient) Provider() ProviderType {
+	return f.Active().Provider()
+}
+
+// Model returns the model of the active client
+func (f *FallbackClient) Model() string {
+	return f.Active().Model()
+}
+
+// CountTokens counts tokens with the active client's tokenizer
+func (f *FallbackClient) CountTokens(text string) int {
+	return f.Active().CountTokens(text)
+}
+
+// translateRequest prepares a request built for one provider to be sent
+// to client. The model is the client's own, its reply and thinking
+// budgets are held to that model's limits in catalog, and tool call IDs
+// issued by another provider are rewritten into a form every provider
+// accepts, keeping each call matched to its result.
+func translateRequest(req Request, client Client, catalog Catalog) Request {
+	req.Model = client.Model()
+	req = fitRequest(req, catalog.Info(req.Model))
+
+	messages := make([]Message, len(req.Messages))
+	for i, msg := range req.Messages {
+		if len(msg.ToolCalls) > 0 {
+			calls := make([]schema.ToolCall, len(msg.ToolCalls))
+			copy(calls, msg.ToolCalls)
+			for j := range calls {
+				calls[j].ID = portableToolCallID(calls[j].ID)
+			}
+			msg.ToolCalls = calls
+		}
+		if len(msg.ToolResults) > 0 {
+			results := make([]ToolResult, len(msg.ToolResults))
+			copy(results, msg.ToolResults)
+			for j := range results {
+				results[j].ToolCallID = portableToolCallID(results[j].ToolCallID)
+			}
+			msg.ToolResults = results
+		}
+		messages[i] = msg
+	}
+	req.Messages = messages
+
+	return req
+}
+
+// fitRequest holds a request's budgets to model's limits. Models without
+// thinking get none; otherwise thinking may use at most half the model's
+// output, and the reply what is left, since providers count the thinking
+// budget towards the output limit.
+func fitRequest(req Request, model ModelInfo) Request {
+	if !model.Thinking {
+		req.ThinkingBudget = 0
+		req.ReasoningEffort = ""
+	}
+	if model.MaxOutput == 0 {
+		return req
+	}
+
+	if budget := req.thinkingBudget(); budget > model.MaxOutput/2 {
+		req.ThinkingBudget = model.MaxOutput / 2
+	}
+	if req.MaxTokens > 0 {
+		req.MaxTokens = min(req.MaxTokens, model.MaxOutput-req.thinkingBudget())
+	}
+	return req
+}
+
+// portableToolCallID returns id if every provider accepts it: letters,
+// digits, '_' and '-', at most 40 characters. Other IDs are replaced by a
+// stable ID derived from them.
+func portableToolCallID(id string) string {
+	valid := id != "" && len(id) <= maxToolCallIDLength
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			valid = false
+			break
+		}
+	}
+	if valid {
+		return id
+	}
+
+	sum := sha256.Sum256([]byte(id))
+	return "call_" + hex.EncodeToString(sum[:])[:maxToolCallIDLength-len("call_")]
+}
+
+// DescribeClient names a client's provider and model, e.g. "openai/gpt-4o"
+func DescribeClient(client Client) string {
+	return strings.TrimSuffix(fmt.Sprintf("%s/%s", client.Provider(), client.Model()), "/")
+}
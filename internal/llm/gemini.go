@@ -0,0 +1,704 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tokenizer"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+const (
+	// geminiAPIURL is the base URL of the Gemini API; the model and method
+	// are appended, e.g. /models/gemini-2.5-pro:generateContent
+	geminiAPIURL = "https://generativelanguage.googleapis.com/v1beta"
+)
+
+// GeminiClient implements the Client interface for Google's Gemini API
+type GeminiClient struct {
+	config     ClientConfig
+	httpClient *http.Client
+}
+
+// NewGeminiClient creates a new Gemini client
+func NewGeminiClient(config ClientConfig) (*GeminiClient, error) {
+	if config.APIKey == "" {
+		return nil, &LLMError{
+			Type:    ErrorTypeAuth,
+			Message: "API key is required for Gemini",
+		}
+	}
+
+	if config.BaseURL == "" {
+		config.BaseURL = geminiAPIURL
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+
+	if config.Timeout == 0 {
+		config.Timeout = 60 * time.Second
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+
+	return &GeminiClient{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}, nil
+}
+
+// geminiRequest represents the generateContent request format
+type geminiRequest struct {
+	Contents          []geminiContent         `json:"contents"`
+	SystemInstruction *geminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []geminiTool            `json:"tools,omitempty"`
+	GenerationConfig  *geminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+// geminiPart is a content part; exactly one of Text, FunctionCall and
+// FunctionResponse is set
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+	InlineData       *geminiBlob             `json:"inlineData,omitempty"`
+
+	// Thought marks text as the model's reasoning. A signature over the
+	// reasoning behind a part must be sent back on that part.
+	Thought          bool   `json:"thought,omitempty"`
+	ThoughtSignature string `json:"thoughtSignature,omitempty"`
+}
+
+// geminiBlob is inline binary data such as an image
+type geminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // Base64
+}
+
+type geminiFunctionCall struct {
+	ID   string         `json:"id,omitempty"`
+	Name string         `json:"name"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+type geminiTool struct {
+	FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters,omitempty"`
+}
+
+type geminiGenerationConfig struct {
+	Temperature     float64               `json:"temperature,omitempty"`
+	TopP            float64               `json:"topP,omitempty"`
+	MaxOutputTokens int                   `json:"maxOutputTokens,omitempty"`
+	ThinkingConfig  *geminiThinkingConfig `json:"thinkingConfig,omitempty"`
+
+	// JSON answers matching a schema. The schema is an any so the struct
+	// stays comparable; it is only set once the config is built.
+	ResponseMimeType   string `json:"responseMimeType,omitempty"`
+	ResponseJSONSchema any    `json:"responseJsonSchema,omitempty"`
+}
+
+type geminiThinkingConfig struct {
+	ThinkingBudget  int  `json:"thinkingBudget,omitempty"`
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+}
+
+// geminiResponse represents a generateContent response, and each chunk of
+// a streamGenerateContent response
+type geminiResponse struct {
+	Candidates     []geminiCandidate     `json:"candidates"`
+	PromptFeedback *geminiPromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  *geminiUsage          `json:"usageMetadata,omitempty"`
+	ModelVersion   string                `json:"modelVersion,omitempty"`
+	Error          *geminiError          `json:"error,omitempty"`
+}
+
+type geminiCandidate struct {
+	Content      geminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+}
+
+type geminiPromptFeedback struct {
+	BlockReason string `json:"blockReason,omitempty"`
+}
+
+type geminiUsage struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"` // Included in PromptTokenCount
+}
+
+// geminiError is a Google API error status
+type geminiError struct {
+	Code    int                  `json:"code"`
+	Message string               `json:"message"`
+	Status  string               `json:"status"`
+	Details []geminiErrorDetails `json:"details,omitempty"`
+}
+
+type geminiErrorDetails struct {
+	Reason     string `json:"reason,omitempty"`
+	RetryDelay string `json:"retryDelay,omitempty"` // From google.rpc.RetryInfo, e.g. "23s"
+}
+
+// Complete sends a non-streaming request
+func (c *GeminiClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	return completeStructured(ctx, req, c.complete)
+}
+
+// complete sends one non-streaming request
+func (c *GeminiClient) complete(ctx context.Context, req Request) (*Response, error) {
+	resp, err := c.send(ctx, req, "generateContent")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp geminiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeServer,
+			Message: "failed to decode response: " + err.Error(),
+		}
+	}
+
+	if apiResp.Error != nil {
+		return nil, c.convertError(apiResp.Error, 0)
+	}
+	if err := blockedPromptError(&apiResp); err != nil {
+		return nil, err
+	}
+
+	return c.convertResponse(&apiResp, c.requestModel(req)), nil
+}
+
+// Stream sends a streaming request
+func (c *GeminiClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	if req.ResponseSchema != nil {
+		return streamStructured(ctx, req, c.Complete, callback)
+	}
+
+	resp, err := c.send(ctx, req, "streamGenerateContent?alt=sse")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return c.processStream(resp.Body, callback)
+}
+
+// send posts a request to the given model method and returns the
+// response if it succeeded
+func (c *GeminiClient) send(ctx context.Context, req Request, method string) (*http.Response, error) {
+	body, err := json.Marshal(c.buildRequest(req))
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: "failed to marshal request: " + err.Error(),
+		}
+	}
+
+	url := fmt.Sprintf("%s/models/%s:%s", c.config.BaseURL, c.requestModel(req), method)
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: "failed to create request: " + err.Error(),
+		}
+	}
+
+	c.setHeaders(httpReq)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: "request failed: " + err.Error(),
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, c.handleError(resp)
+	}
+
+	return resp, nil
+}
+
+// processStream processes the SSE stream. Each event carries a complete
+// response chunk: text parts are deltas, function calls arrive whole and
+// usage metadata is cumulative.
+func (c *GeminiClient) processStream(body io.Reader, callback StreamCallback) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var usage *Usage
+	var thoughts strings.Builder
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+
+		var chunk geminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk); err != nil {
+			continue // Skip malformed events
+		}
+
+		if chunk.Error != nil {
+			return c.convertError(chunk.Error, 0)
+		}
+		if err := blockedPromptError(&chunk); err != nil {
+			return err
+		}
+
+		if chunk.UsageMetadata != nil {
+			usage = convertGeminiUsage(chunk.UsageMetadata)
+		}
+
+		if len(chunk.Candidates) == 0 {
+			continue
+		}
+		candidate := chunk.Candidates[0]
+
+		for _, part := range candidate.Content.Parts {
+			if part.Thought {
+				thoughts.WriteString(part.Text)
+				callback(StreamEvent{
+					Reasoning: part.Text,
+					Timestamp: time.Now(),
+				})
+				continue
+			}
+			if part.Text != "" {
+				callback(StreamEvent{
+					Delta:     part.Text,
+					Done:      false,
+					Timestamp: time.Now(),
+				})
+			}
+			var toolCallID string
+			if part.FunctionCall != nil {
+				toolCall := geminiToolCall(part.FunctionCall)
+				toolCallID = toolCall.ID
+				callback(StreamEvent{
+					ToolCall:  &toolCall,
+					Timestamp: time.Now(),
+				})
+			}
+			if part.ThoughtSignature != "" {
+				callback(StreamEvent{
+					ReasoningBlock: geminiSignature(part.ThoughtSignature, toolCallID),
+					Timestamp:      time.Now(),
+				})
+			}
+		}
+
+		if candidate.FinishReason != "" {
+			callback(StreamEvent{
+				Done:         false,
+				FinishReason: candidate.FinishReason,
+				Timestamp:    time.Now(),
+			})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: "stream reading error: " + err.Error(),
+		}
+	}
+
+	if thoughts.Len() > 0 {
+		callback(StreamEvent{
+			ReasoningBlock: &ReasoningBlock{Provider: ProviderGemini, Text: thoughts.String()},
+			Timestamp:      time.Now(),
+		})
+	}
+
+	// The stream has no terminator; it simply ends after the last chunk
+	callback(StreamEvent{
+		Done:      true,
+		Usage:     usage,
+		Timestamp: time.Now(),
+	})
+
+	return nil
+}
+
+// requestModel returns the model a request is sent to
+func (c *GeminiClient) requestModel(req Request) string {
+	model := req.Model
+	if model == "" {
+		model = c.config.Model
+	}
+	return strings.TrimPrefix(model, "models/")
+}
+
+// buildRequest converts a generic Request to Gemini format
+func (c *GeminiClient) buildRequest(req Request) geminiRequest {
+	apiReq := geminiRequest{
+		Contents: make([]geminiContent, 0, len(req.Messages)),
+	}
+
+	genConfig := geminiGenerationConfig{
+		Temperature:     req.Temperature,
+		TopP:            req.TopP,
+		MaxOutputTokens: req.MaxTokens,
+	}
+
+	// Use config defaults if not specified
+	if genConfig.MaxOutputTokens == 0 {
+		genConfig.MaxOutputTokens = c.config.MaxTokens
+	}
+	if genConfig.Temperature == 0 {
+		genConfig.Temperature = c.config.Temperature
+	}
+	if budget := req.thinkingBudget(); budget > 0 {
+		genConfig.ThinkingConfig = &geminiThinkingConfig{
+			ThinkingBudget:  budget,
+			IncludeThoughts: true,
+		}
+	}
+	if genConfig != (geminiGenerationConfig{}) {
+		apiReq.GenerationConfig = &genConfig
+	}
+	if schema := req.ResponseSchema; schema != nil {
+		apiReq.GenerationConfig = &genConfig
+		genConfig.ResponseMimeType = "application/json"
+		genConfig.ResponseJSONSchema = schema.Schema
+	}
+
+	// Gemini has no system role and requires alternating turns; system
+	// messages join the system instruction
+	system, messages := normalizeMessages(ProviderGemini, req.SystemPrompt, req.Messages)
+	if system != "" {
+		apiReq.SystemInstruction = &geminiContent{
+			Parts: []geminiPart{{Text: system}},
+		}
+	}
+
+	// Convert tool definitions
+	if len(req.Tools) > 0 {
+		declarations := make([]geminiFunctionDeclaration, 0, len(req.Tools))
+		for _, tool := range req.Tools {
+			declarations = append(declarations, geminiFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			})
+		}
+		apiReq.Tools = []geminiTool{{FunctionDeclarations: declarations}}
+	}
+
+	// Function responses are matched to calls by name, not by ID
+	callNames := make(map[string]string)
+	for _, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			callNames[call.ID] = call.Name
+		}
+	}
+
+	// Convert messages
+	for _, msg := range messages {
+		parts := geminiParts(msg, callNames)
+		if len(parts) == 0 {
+			continue // Gemini rejects turns without parts
+		}
+
+		role := "user"
+		if msg.Role == RoleAssistant {
+			role = "model"
+		}
+		apiReq.Contents = append(apiReq.Contents, geminiContent{
+			Role:  role,
+			Parts: parts,
+		})
+	}
+
+	return apiReq
+}
+
+// geminiParts converts a message into Gemini parts. Function responses
+// lead the turn, mirroring the order of the calls they answer. Thought
+// signatures go back on the parts they came with.
+func geminiParts(msg Message, callNames map[string]string) []geminiPart {
+	var parts []geminiPart
+
+	signatures := make(map[string]string) // By tool call ID; "" for the text
+	for _, block := range providerReasoning(msg, ProviderGemini) {
+		if block.Signature != "" {
+			signatures[block.ToolCallID] = block.Signature
+		}
+	}
+
+	for _, result := range msg.ToolResults {
+		key := "output"
+		if result.IsError {
+			key = "error"
+		}
+		parts = append(parts, geminiPart{
+			FunctionResponse: &geminiFunctionResponse{
+				Name:     callNames[result.ToolCallID],
+				Response: map[string]any{key: result.Content},
+			},
+		})
+	}
+
+	for _, img := range msg.Images {
+		if len(img.Data) == 0 {
+			parts = append(parts, geminiPart{Text: img.unavailableText()})
+			continue
+		}
+		parts = append(parts, geminiPart{
+			InlineData: &geminiBlob{MimeType: img.MediaType, Data: img.Base64()},
+		})
+	}
+
+	if msg.Content != "" {
+		parts = append(parts, geminiPart{Text: msg.Content, ThoughtSignature: signatures[""]})
+	}
+
+	for _, call := range msg.ToolCalls {
+		args := call.Arguments
+		if args == nil {
+			args = map[string]any{}
+		}
+		parts = append(parts, geminiPart{
+			FunctionCall: &geminiFunctionCall{
+				Name: call.Name,
+				Args: args,
+			},
+			ThoughtSignature: signatures[call.ID],
+		})
+	}
+
+	return parts
+}
+
+// geminiToolCall converts a function call, assigning an ID when Gemini
+// did not provide one so its result can be matched to it
+func geminiToolCall(call *geminiFunctionCall) schema.ToolCall {
+	id := call.ID
+	if id == "" {
+		id = fmt.Sprintf("call_%d", toolCallCounter.Add(1))
+	}
+
+	args := call.Args
+	if args == nil {
+		args = make(map[string]any)
+	}
+
+	return schema.ToolCall{
+		ID:        id,
+		Name:      call.Name,
+		Arguments: args,
+	}
+}
+
+// geminiSignature records the thought signature on a part: a function
+// call, or the text when toolCallID is empty
+func geminiSignature(signature, toolCallID string) *ReasoningBlock {
+	return &ReasoningBlock{
+		Provider:   ProviderGemini,
+		Signature:  signature,
+		ToolCallID: toolCallID,
+	}
+}
+
+// convertResponse converts a Gemini response to generic Response
+func (c *GeminiClient) convertResponse(resp *geminiResponse, model string) *Response {
+	result := &Response{
+		Role:  RoleAssistant,
+		Model: model,
+	}
+	if resp.ModelVersion != "" {
+		result.Model = resp.ModelVersion
+	}
+	if resp.UsageMetadata != nil {
+		result.Usage = *convertGeminiUsage(resp.UsageMetadata)
+	}
+
+	if len(resp.Candidates) == 0 {
+		return result
+	}
+	candidate := resp.Candidates[0]
+
+	var content, thoughts strings.Builder
+	var signatures []ReasoningBlock
+	for _, part := range candidate.Content.Parts {
+		if part.Thought {
+			thoughts.WriteString(part.Text)
+			continue
+		}
+		content.WriteString(part.Text)
+		var toolCallID string
+		if part.FunctionCall != nil {
+			toolCall := geminiToolCall(part.FunctionCall)
+			toolCallID = toolCall.ID
+			result.ToolCalls = append(result.ToolCalls, toolCall)
+		}
+		if part.ThoughtSignature != "" {
+			signatures = append(signatures, *geminiSignature(part.ThoughtSignature, toolCallID))
+		}
+	}
+
+	if thoughts.Len() > 0 {
+		result.Reasoning = append(result.Reasoning, ReasoningBlock{Provider: ProviderGemini, Text: thoughts.String()})
+	}
+	result.Reasoning = append(result.Reasoning, signatures...)
+	result.Content = content.String()
+	result.FinishReason = candidate.FinishReason
+	return result
+}
+
+// convertGeminiUsage converts usage metadata
+func convertGeminiUsage(usage *geminiUsage) *Usage {
+	total := usage.TotalTokenCount
+	if total == 0 {
+		total = usage.PromptTokenCount + usage.CandidatesTokenCount
+	}
+	return &Usage{
+		PromptTokens:     usage.PromptTokenCount,
+		CompletionTokens: usage.CandidatesTokenCount,
+		TotalTokens:      total,
+		CacheReadTokens:  usage.CachedContentTokenCount,
+	}
+}
+
+// blockedPromptError reports a prompt that Gemini refused to answer
+func blockedPromptError(resp *geminiResponse) error {
+	if resp.PromptFeedback == nil || resp.PromptFeedback.BlockReason == "" || len(resp.Candidates) > 0 {
+		return nil
+	}
+	return &LLMError{
+		Type:    ErrorTypeInvalidRequest,
+		Message: "prompt blocked by Gemini: " + resp.PromptFeedback.BlockReason,
+		Details: resp.PromptFeedback.BlockReason,
+	}
+}
+
+// setHeaders sets the required headers for Gemini API
+func (c *GeminiClient) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", c.config.APIKey)
+}
+
+// handleError processes error responses
+func (c *GeminiClient) handleError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var apiResp geminiResponse
+	if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
+		return withResponseHeaders(c.convertError(apiResp.Error, resp.StatusCode), resp.Header)
+	}
+
+	return withResponseHeaders(&LLMError{
+		Type:    c.mapErrorType("", resp.StatusCode),
+		Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
+		Code:    resp.StatusCode,
+	}, resp.Header)
+}
+
+// convertError converts a Google API error status. An invalid API key is
+// reported as INVALID_ARGUMENT, so the error reason is checked as well.
+func (c *GeminiClient) convertError(apiErr *geminiError, statusCode int) *LLMError {
+	if statusCode == 0 {
+		statusCode = apiErr.Code
+	}
+
+	errType := c.mapErrorType(apiErr.Status, statusCode)
+	var retryAfter time.Duration
+	for _, detail := range apiErr.Details {
+		if detail.Reason == "API_KEY_INVALID" {
+			errType = ErrorTypeAuth
+		}
+		if delay, err := time.ParseDuration(detail.RetryDelay); err == nil && delay > 0 {
+			retryAfter = delay
+		}
+	}
+
+	return &LLMError{
+		Type:       errType,
+		Message:    apiErr.Message,
+		Code:       statusCode,
+		Details:    apiErr.Status,
+		RetryAfter: retryAfter,
+	}
+}
+
+// mapErrorType maps Google API status codes, falling back to HTTP status
+// codes, to error types
+func (c *GeminiClient) mapErrorType(status string, statusCode int) ErrorType {
+	switch status {
+	case "UNAUTHENTICATED", "PERMISSION_DENIED":
+		return ErrorTypeAuth
+	case "RESOURCE_EXHAUSTED":
+		return ErrorTypeRateLimit
+	case "INVALID_ARGUMENT", "FAILED_PRECONDITION", "NOT_FOUND", "OUT_OF_RANGE":
+		return ErrorTypeInvalidRequest
+	case "DEADLINE_EXCEEDED":
+		return ErrorTypeTimeout
+	case "INTERNAL", "UNAVAILABLE", "UNKNOWN":
+		return ErrorTypeServer
+	}
+
+	switch statusCode {
+	case 401, 403:
+		return ErrorTypeAuth
+	case 429:
+		return ErrorTypeRateLimit
+	case 400, 404, 422:
+		return ErrorTypeInvalidRequest
+	case 504:
+		return ErrorTypeTimeout
+	case 500, 502, 503:
+		return ErrorTypeServer
+	default:
+		return ErrorTypeUnknown
+	}
+}
+
+// Provider returns the provider type
+func (c *GeminiClient) Provider() ProviderType {
+	return ProviderGemini
+}
+
+// Model returns the configured model
+func (c *GeminiClient) Model() string {
+	return c.config.Model
+}
+
+// CountTokens counts tokens offline with the model's tokenizer, or a
+// calibrated estimate when no tokenizer is available for the model
+func (c *GeminiClient) CountTokens(text string) int {
+	return tokenizer.ForModel(c.config.Model).Count(text)
+}
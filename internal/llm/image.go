@@ -0,0 +1,273 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MaxImageFileSize is the largest image file that is read at all
+	MaxImageFileSize = 20 << 20
+
+	// maxImageBytes is the largest encoded image sent to a provider. Its
+	// base64 form stays under Anthropic's 5 MB limit per image.
+	maxImageBytes = (5 << 20) * 3 / 4
+
+	// maxImageDimension is the longest side an image is sent with. Larger
+	// images are downscaled by the providers anyway and cost more tokens.
+	maxImageDimension = 1568
+
+	// maxImagePixels is the most pixels an image may have. A small file can
+	// declare huge dimensions, and decoding it would take gigabytes.
+	maxImagePixels = 40_000_000
+
+	// imageJPEGQuality is used when an image is re-encoded as JPEG
+	imageJPEGQuality = 85
+)
+
+// imageExtensions are the file extensions of the image formats that can
+// be attached
+var imageExtensions = map[string]bool{
+	".png":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".gif":  true,
+}
+
+// Image is an image attached to a message. Sessions store it by
+// reference: only the path and metadata are saved, and the data is read
+// from the path again when the session is resumed.
+type Image struct {
+	Path      string `json:"path"`
+	MediaType string `json:"media_type"` // e.g. "image/png"
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Data      []byte `json:"-"` // Encoded image, after any downscaling
+}
+
+// IsImagePath reports whether path has the extension of an image format
+// that can be attached
+func IsImagePath(path string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// LoadImage reads the image at path for attaching to a message. Images
+// larger than the providers accept are downscaled and re-encoded. The
+// image records the absolute path, so a session can be resumed from
+// another directory.
+func LoadImage(path string) (Image, error) {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to read image: %w", err)
+	}
+	if info.IsDir() {
+		return Image{}, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > MaxImageFileSize {
+		return Image{}, fmt.Errorf("image %s is too large (%d MB, limit %d MB)",
+			filepath.Base(path), info.Size()>>20, MaxImageFileSize>>20)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	img, err := encodeImage(data)
+	if err != nil {
+		return Image{}, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	img.Path = path
+	return img, nil
+}
+
+// Reload reads the image's data from its path again, e.g. after a session
+// was loaded
+func (img *Image) Reload() error {
+	loaded, err := LoadImage(img.Path)
+	if err != nil {
+		return err
+	}
+	*img = loaded
+	return nil
+}
+
+// Name returns the image's file name
+func (img Image) Name() string {
+	return filepath.Base(img.Path)
+}
+
+// Base64 returns the image data encoded as base64
+func (img Image) Base64() string {
+	return base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// DataURI returns the image as a data URI
+func (img Image) DataURI() string {
+	return "data:" + img.MediaType + ";base64," + img.Base64()
+}
+
+// EstimatedTokens approximates the tokens the image costs in a prompt,
+// using Anthropic's rule of one token per 750 pixels
+func (img Image) EstimatedTokens() int {
+	if img.Width == 0 || img.Height == 0 {
+		return maxImageDimension * maxImageDimension / 750
+	}
+	return max(img.Width*img.Height/750, 1)
+}
+
+// unavailableText stands in for an image whose data could not be loaded,
+// so the model still knows one was attached
+func (img Image) unavailableText() string {
+	return fmt.Sprintf("[image unavailable: %s]", img.Path)
+}
+
+// encodeImage checks that data is a supported image and downscales it if
+// it is larger than the providers accept
+func encodeImage(data []byte) (Image, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("unsupported image format (use PNG, JPEG or GIF)")
+	}
+	if int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return Image{}, fmt.Errorf("image is too large (%dx%d pixels, limit %d megapixels)",
+			config.Width, config.Height, maxImagePixels/1_000_000)
+	}
+
+	img := Image{
+		MediaType: "image/" + format,
+		Width:     config.Width,
+		Height:    config.Height,
+		Data:      data,
+	}
+	if len(data) <= maxImageBytes && max(config.Width, config.Height) <= maxImageDimension {
+		return img, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	rgba := toRGBA(decoded)
+
+	// Shrink until the encoded image fits, halving the size each time the
+	// longest side alone is not enough
+	limit := maxImageDimension
+	for {
+		scaled := scaleImage(rgba, limit)
+		bounds := scaled.Bounds()
+		var buf bytes.Buffer
+		mediaType := "image/jpeg"
+		if format == "png" || format == "gif" {
+			// Keep lossless formats lossless if they fit
+			if err := png.Encode(&buf, scaled); err == nil && buf.Len() <= maxImageBytes {
+				mediaType = "image/png"
+			} else {
+				buf.Reset()
+			}
+		}
+		if buf.Len() == 0 {
+			if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: imageJPEGQuality}); err != nil {
+				return Image{}, fmt.Errorf("failed to encode image: %w", err)
+			}
+		}
+
+		if buf.Len() <= maxImageBytes || limit <= 1 {
+			img.MediaType = mediaType
+			img.Width = bounds.Dx()
+			img.Height = bounds.Dy()
+			img.Data = buf.Bytes()
+			return img, nil
+		}
+		limit = max(bounds.Dx(), bounds.Dy()) / 2
+	}
+}
+
+// toRGBA returns img as RGBA pixels, converting it only if the decoder
+// produced another layout
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// scaleImage returns src scaled down so its longest side is at most limit,
+// averaging the source pixels behind each destination pixel
+func scaleImage(src *image.RGBA, limit int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if max(w, h) <= limit {
+		return src
+	}
+
+	dw, dh := limit, limit
+	if w >= h {
+		dh = max(h*limit/w, 1)
+	} else {
+		dw = max(w*limit/h, 1)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(bounds.Min.X, bounds.Min.Y+sy):]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += int(p[0])
+					sum[1] += int(p[1])
+					sum[2] += int(p[2])
+					sum[3] += int(p[3])
+				}
+			}
+
+			n := (y1 - y0) * (x1 - x0)
+			d := dst.Pix[y*dst.Stride+x*4:]
+			for i := range sum {
+				d[i] = uint8(sum[i] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// ReloadImages reads the data of every image in messages from its path,
+// e.g. after a session stored by reference was loaded. Images that cannot
+// be read are left without data; providers then tell the model the image
+// is unavailable. It returns the first error, if any.
+func ReloadImages(messages []Message) error {
+	var firstErr error
+	for i := range messages {
+		for j := range messages[i].Images {
+			img := &messages[i].Images[j]
+			if len(img.Data) > 0 {
+				continue
+			}
+			if err := img.Reload(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
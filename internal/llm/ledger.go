@@ -0,0 +1,261 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LedgerEntry records the tokens one LLM call used
+type LedgerEntry struct {
+	Time             time.Time    `json:"time"`
+	Session          string       `json:"session,omitempty"`
+	Project          string       `json:"project,omitempty"` // Working directory the call was made from
+	Provider         ProviderType `json:"provider"`
+	Model            string       `json:"model"`
+	InputTokens      int          `json:"input_tokens"` // Including cached tokens
+	OutputTokens     int          `json:"output_tokens"`
+	CacheReadTokens  int          `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int          `json:"cache_write_tokens,omitempty"`
+}
+
+// Usage returns the entry's token counts
+func (e LedgerEntry) Usage() Usage {
+	return Usage{
+		PromptTokens:     e.InputTokens,
+		CompletionTokens: e.OutputTokens,
+		TotalTokens:      e.InputTokens + e.OutputTokens,
+		CacheReadTokens:  e.CacheReadTokens,
+		CacheWriteTokens: e.CacheWriteTokens,
+	}
+}
+
+// Ledger is a JSON Lines file with an entry for every LLM call, kept
+// across sessions. Every process appends to the same file; each entry is
+// written with a single append so concurrent writers do not interleave.
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewLedger opens the ledger at path, which is created on first use
+func NewLedger(path string) *Ledger {
+	if len(path) >= 2 && path[:2] == "~/" {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	return &Ledger{path: path}
+}
+
+// Path returns the ledger's file path
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Append adds an entry to the ledger
+func (l *Ledger) Append(entry LedgerEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create usage ledger directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write usage ledger: %w", err)
+	}
+	return nil
+}
+
+// Entries returns the entries recorded at or after since, oldest first.
+// Lines that cannot be parsed, such as one cut short by a crash, are
+// skipped.
+func (l *Ledger) Entries(since time.Time) ([]LedgerEntry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open usage ledger: %w", err)
+	}
+	defer f.Close()
+
+	var entries []LedgerEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry LedgerEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		if !entry.Time.Before(since) {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	return entries, nil
+}
+
+// UsageTotal sums the usage and cost of a group of ledger entries
+type UsageTotal struct {
+	Key              string // What the entries have in common, e.g. a day or project
+	Requests         int
+	InputTokens      int
+	OutputTokens     int
+	CacheReadTokens  int
+	CacheWriteTokens int
+	Cost             float64 // USD, for the entries whose model has a price
+	Unpriced         int     // Entries whose model has no price
+}
+
+// SumUsage groups entries by key and totals each group, pricing them with
+// the catalog. The totals are sorted by key.
+func SumUsage(entries []LedgerEntry, catalog Catalog, key func(LedgerEntry) string) []UsageTotal {
+	byKey := make(map[string]*UsageTotal)
+	for _, entry := range entries {
+		k := key(entry)
+		total, ok := byKey[k]
+		if !ok {
+			total = &UsageTotal{Key: k}
+			byKey[k] = total
+		}
+
+		total.Requests++
+		total.InputTokens += entry.InputTokens
+		total.OutputTokens += entry.OutputTokens
+		total.CacheReadTokens += entry.CacheReadTokens
+		total.CacheWriteTokens += entry.CacheWriteTokens
+		if cost, ok := catalog.Cost(entry.Provider, entry.Model, entry.Usage()); ok {
+			total.Cost += cost
+		} else {
+			total.Unpriced++
+		}
+	}
+
+	totals := make([]UsageTotal, 0, len(byKey))
+	for _, total := range byKey {
+		totals = append(totals, *total)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		return totals[i].Key < totals[j].Key
+	})
+	return totals
+}
+
+// LedgerClient wraps a client and records the usage of every call it
+// completes in a ledger. Recording never fails a call; write errors go
+// to the error callback.
+type LedgerClient struct {
+	client  Client
+	ledger  *Ledger
+	session string
+	project string
+	onError func(error)
+}
+
+// NewLedgerClient creates a client that records calls made by session
+// from the project directory
+func NewLedgerClient(client Client, ledger *Ledger, session, project string) *LedgerClient {
+	return &LedgerClient{
+		client:  client,
+		ledger:  ledger,
+		session: session,
+		project: project,
+	}
+}
+
+// SetErrorCallback sets the function called when an entry cannot be
+// written
+func (c *LedgerClient) SetErrorCallback(cb func(error)) {
+	c.onError = cb
+}
+
+// Complete sends the request and records its usage
+func (c *LedgerClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	resp, err := c.client.Complete(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	model := resp.Model
+	if model == "" {
+		model = c.client.Model()
+	}
+	c.record(model, resp.Usage)
+	return resp, nil
+}
+
+// Stream streams the request and records the usage reported at its end
+func (c *LedgerClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	return c.client.Stream(ctx, req, func(event StreamEvent) {
+		if event.Usage != nil {
+			c.record(c.client.Model(), *event.Usage)
+		}
+		callback(event)
+	})
+}
+
+// record appends a call's usage to the ledger, skipping calls the
+// provider reported no usage for
+func (c *LedgerClient) record(model string, usage Usage) {
+	if usage.PromptTokens == 0 && usage.CompletionTokens == 0 {
+		return
+	}
+
+	err := c.ledger.Append(LedgerEntry{
+		Time:             time.Now(),
+		Session:          c.session,
+		Project:          c.project,
+		Provider:         c.client.Provider(),
+		Model:            model,
+		InputTokens:      usage.PromptTokens,
+		OutputTokens:     usage.CompletionTokens,
+		CacheReadTokens:  usage.CacheReadTokens,
+		CacheWriteTokens: usage.CacheWriteTokens,
+	})
+	if err != nil && c.onError != nil {
+		c.onError(err)
+	}
+}
+
+// Provider returns the wrapped client's provider
+func (c *LedgerClient) Provider() ProviderType {
+	return c.client.Provider()
+}
+
+// Model returns the wrapped client's model
+func (c *LedgerClient) Model() string {
+	return c.client.Model()
+}
+
+// CountTokens counts tokens with the wrapped client
+func (c *LedgerClient) CountTokens(text string) int {
+	return c.client.CountTokens(text)
+}
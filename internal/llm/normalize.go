@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"slices"
+	"strings"
+)
+
+// omittedTurnText opens a conversation that would otherwise start with
+// the assistant, for providers that require the user to speak first
+const omittedTurnText = "(earlier messages omitted)"
+
+// messageRules are the constraints a provider puts on a conversation
+type messageRules struct {
+	alternate bool // The conversation must start with the user and alternate
+}
+
+// providerMessageRules returns the constraints of provider. Anthropic and
+// Gemini require strict user/assistant alternation starting with the
+// user; the OpenAI-style APIs only need tool results right after the
+// calls they answer, which merging keeps.
+func providerMessageRules(provider ProviderType) messageRules {
+	switch provider {
+	case ProviderAnthropic, ProviderGemini:
+		return messageRules{alternate: true}
+	default:
+		return messageRules{}
+	}
+}
+
+// normalizeMessages puts a conversation in the shape provider accepts.
+// System messages are joined to the system prompt, which is returned
+// separately; messages without content are dropped; consecutive turns of
+// the same role, such as tool results followed by the user's reply, are
+// merged into one; and for providers that require it, a conversation
+// that opens with the assistant gets a user turn first.
+func normalizeMessages(provider ProviderType, systemPrompt string, messages []Message) (string, []Message) {
+	rules := providerMessageRules(provider)
+
+	var system []string
+	if strings.TrimSpace(systemPrompt) != "" {
+		system = append(system, systemPrompt)
+	}
+
+	normalized := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Role == RoleSystem {
+			if strings.TrimSpace(msg.Content) != "" {
+				system = append(system, msg.Content)
+			}
+			continue
+		}
+
+		if strings.TrimSpace(msg.Content) == "" {
+			msg.Content = ""
+		}
+		if msg.Content == "" && len(msg.ToolCalls) == 0 && len(msg.ToolResults) == 0 && len(msg.Images) == 0 {
+			continue
+		}
+
+		if n := len(normalized); n > 0 && normalized[n-1].Role == msg.Role {
+			normalized[n-1] = mergeMessages(normalized[n-1], msg)
+			continue
+		}
+		normalized = append(normalized, msg)
+	}
+
+	if rules.alternate && len(normalized) > 0 && normalized[0].Role == RoleAssistant {
+		normalized = append([]Message{{Role: RoleUser, Content: omittedTurnText}}, normalized...)
+	}
+
+	return strings.Join(system, "\n\n"), normalized
+}
+
+// mergeMessages joins two consecutive messages of the same role, leaving
+// both unchanged
+func mergeMessages(first, second Message) Message {
+	merged := first
+	switch {
+	case first.Content == "":
+		merged.Content = second.Content
+	case second.Content != "":
+		merged.Content = first.Content + "\n\n" + second.Content
+	}
+	merged.ToolCalls = slices.Concat(first.ToolCalls, second.ToolCalls)
+	merged.ToolResults = slices.Concat(first.ToolResults, second.ToolResults)
+	merged.Reasoning = slices.Concat(first.Reasoning, second.Reasoning)
+	merged.Images = slices.Concat(first.Images, second.Images)
+	return merged
+}
@@ -0,0 +1,810 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tokenizer"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+const (
+	// ollamaAPIURL is the default address of a local Ollama server
+	ollamaAPIURL = "http://localhost:11434"
+
+	// ollamaTimeout is longer than for hosted APIs because the first
+	// request may have to load the model into memory
+	ollamaTimeout = 5 * time.Minute
+)
+
+// OllamaClient implements the Client interface for Ollama's native API
+type OllamaClient struct {
+	config     ClientConfig
+	httpClient *http.Client
+}
+
+// NewOllamaClient creates a new Ollama client. No API key is needed; if
+// one is set it is sent as a bearer token for servers behind a proxy.
+func NewOllamaClient(config ClientConfig) (*OllamaClient, error) {
+	if config.BaseURL == "" {
+		config.BaseURL = ollamaAPIURL
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+
+	if config.Timeout == 0 {
+		config.Timeout = ollamaTimeout
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	}
+
+	return &OllamaClient{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}, nil
+}
+
+// ollamaRequest represents the /api/chat request format
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []openaiTool    `json:"tools,omitempty"` // Same shape as OpenAI function tools
+	Stream   bool            `json:"stream"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Think    bool            `json:"think,omitempty"`  // Separate the reasoning of thinking models
+	Format   map[string]any  `json:"format,omitempty"` // JSON schema the answer must match
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"` // The function a tool message answers
+	Images    []string         `json:"images,omitempty"`    // Base64 images, for vision models
+}
+
+type ollamaToolCall struct {
+	Function ollamaFunctionCall `json:"function"`
+}
+
+type ollamaFunctionCall struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+type ollamaOptions struct {
+	Temperature float64 `json:"temperature,omitempty"`
+	TopP        float64 `json:"top_p,omitempty"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+// ollamaResponse represents an /api/chat response, and each line of a
+// streamed one
+type ollamaResponse struct {
+	Model           string        `json:"model"`
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+	EvalCount       int           `json:"eval_count,omitempty"`
+	Error           string        `json:"error,omitempty"`
+}
+
+// OllamaModel is a model installed on the Ollama server
+type OllamaModel struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// OllamaModelInfo describes an installed model
+type OllamaModelInfo struct {
+	Name          string
+	Family        string   // Architecture, e.g. "llama"
+	ContextLength int      // Maximum context window in tokens, 0 if unknown
+	Capabilities  []string // e.g. "completion", "tools", "vision"
+}
+
+// Capabilities Ollama reports for models that accept images or think
+// before answering
+const (
+	OllamaCapabilityVision   = "vision"
+	OllamaCapabilityThinking = "thinking"
+)
+
+// PullProgress reports the progress of downloading a model
+type PullProgress struct {
+	Status    string // e.g. "pulling manifest", "verifying sha256 digest"
+	Completed int64  // Bytes downloaded of the current layer
+	Total     int64  // Size of the current layer, 0 when not downloading
+}
+
+// ModelNotFoundError is returned when the configured model is not
+// installed on the Ollama server
+type ModelNotFoundError struct {
+	Model       string
+	Installed   []string // Models that are installed
+	Suggestions []string // Installed models with similar names
+}
+
+func (e *ModelNotFoundError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "model %q is not installed in Ollama", e.Model)
+	if len(e.Suggestions) > 0 {
+		fmt.Fprintf(&b, "; did you mean %s?", strings.Join(quoteAll(e.Suggestions), " or "))
+	} else if len(e.Installed) > 0 {
+		fmt.Fprintf(&b, "; installed models: %s", strings.Join(e.Installed, ", "))
+	} else {
+		b.WriteString("; no models are installed")
+	}
+	return b.String()
+}
+
+// Complete sends a non-streaming request
+func (c *OllamaClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	return completeStructured(ctx, req, c.complete)
+}
+
+// complete sends one non-streaming request
+func (c *OllamaClient) complete(ctx context.Context, req Request) (*Response, error) {
+	apiReq := c.buildRequest(req)
+	apiReq.Stream = false
+
+	resp, err := c.post(ctx, c.httpClient, "/api/chat", apiReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var apiResp ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeServer,
+			Message: "failed to decode response: " + err.Error(),
+		}
+	}
+
+	if apiResp.Error != "" {
+		return nil, &LLMError{
+			Type:    ErrorTypeServer,
+			Message: apiResp.Error,
+		}
+	}
+
+	return c.convertResponse(&apiResp), nil
+}
+
+// Stream sends a streaming request
+func (c *OllamaClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	if req.ResponseSchema != nil {
+		return streamStructured(ctx, req, c.Complete, callback)
+	}
+
+	apiReq := c.buildRequest(req)
+	apiReq.Stream = true
+
+	resp, err := c.post(ctx, c.httpClient, "/api/chat", apiReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return c.processStream(resp.Body, callback)
+}
+
+// processStream processes the NDJSON stream: one response object per line,
+// the last of which has done set and carries the token counts
+func (c *OllamaClient) processStream(body io.Reader, callback StreamCallback) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	var thinking strings.Builder
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			continue // Skip malformed lines
+		}
+
+		if chunk.Error != "" {
+			return &LLMError{
+				Type:    ErrorTypeServer,
+				Message: chunk.Error,
+			}
+		}
+
+		if chunk.Message.Thinking != "" {
+			thinking.WriteString(chunk.Message.Thinking)
+			callback(StreamEvent{
+				Reasoning: chunk.Message.Thinking,
+				Timestamp: time.Now(),
+			})
+		}
+
+		if chunk.Message.Content != "" {
+			callback(StreamEvent{
+				Delta:     chunk.Message.Content,
+				Done:      false,
+				Timestamp: time.Now(),
+			})
+		}
+
+		for _, call := range chunk.Message.ToolCalls {
+			toolCall := ollamaToolCallToSchema(call)
+			callback(StreamEvent{
+				ToolCall:  &toolCall,
+				Timestamp: time.Now(),
+			})
+		}
+
+		if chunk.Done {
+			if thinking.Len() > 0 {
+				callback(StreamEvent{
+					ReasoningBlock: &ReasoningBlock{Provider: ProviderOllama, Text: thinking.String()},
+					Timestamp:      time.Now(),
+				})
+			}
+			callback(StreamEvent{
+				Done:         false,
+				FinishReason: chunk.DoneReason,
+				Timestamp:    time.Now(),
+			})
+			callback(StreamEvent{
+				Done:      true,
+				Usage:     ollamaUsage(&chunk),
+				Timestamp: time.Now(),
+			})
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: "stream reading error: " + err.Error(),
+		}
+	}
+
+	callback(StreamEvent{
+		Done:      true,
+		Timestamp: time.Now(),
+	})
+
+	return nil
+}
+
+// ListModels returns the models installed on the server
+func (c *OllamaClient) ListModels(ctx context.Context) ([]OllamaModel, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, "GET", c.config.BaseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: "failed to create request: " + err.Error(),
+		}
+	}
+	c.setHeaders(httpReq)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, c.requestError(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleError(resp)
+	}
+
+	var tags struct {
+		Models []OllamaModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeServer,
+			Message: "failed to decode model list: " + err.Error(),
+		}
+	}
+
+	return tags.Models, nil
+}
+
+// ShowModel returns details of an installed model, including its
+// context length
+func (c *OllamaClient) ShowModel(ctx context.Context, model string) (*OllamaModelInfo, error) {
+	resp, err := c.post(ctx, c.httpClient, "/api/show", map[string]string{"model": model})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var show struct {
+		ModelInfo    map[string]any `json:"model_info"`
+		Capabilities []string       `json:"capabilities"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeServer,
+			Message: "failed to decode model details: " + err.Error(),
+		}
+	}
+
+	info := &OllamaModelInfo{
+		Name:         model,
+		Capabilities: show.Capabilities,
+	}
+
+	// Model info keys are prefixed with the architecture, e.g.
+	// "llama.context_length"
+	if family, ok := show.ModelInfo["general.architecture"].(string); ok {
+		info.Family = family
+		if length, ok := show.ModelInfo[family+".context_length"].(float64); ok {
+			info.ContextLength = int(length)
+		}
+	}
+
+	return info, nil
+}
+
+// ValidateModel checks that the configured model is installed, returning
+// a *ModelNotFoundError naming similar installed models if it is not
+func (c *OllamaClient) ValidateModel(ctx context.Context) error {
+	models, err := c.ListModels(ctx)
+	if err != nil {
+		return err
+	}
+
+	want := normalizeOllamaModel(c.config.Model)
+	installed := make([]string, 0, len(models))
+	for _, model := range models {
+		if normalizeOllamaModel(model.Name) == want {
+			return nil
+		}
+		installed = append(installed, model.Name)
+	}
+	sort.Strings(installed)
+
+	return &ModelNotFoundError{
+		Model:       c.config.Model,
+		Installed:   installed,
+		Suggestions: similarModels(c.config.Model, installed),
+	}
+}
+
+// Pull downloads the configured model, reporting progress as it goes.
+// Downloads can take far longer than a chat request, so only ctx bounds
+// them; cancelling it stops the pull and returns ctx's error.
+func (c *OllamaClient) Pull(ctx context.Context, progress func(PullProgress)) error {
+	resp, err := c.post(ctx, &http.Client{}, "/api/pull", map[string]any{
+		"model":  c.config.Model,
+		"stream": true,
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var update struct {
+			Status    string `json:"status"`
+			Total     int64  `json:"total"`
+			Completed int64  `json:"completed"`
+			Error     string `json:"error"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			continue
+		}
+
+		if update.Error != "" {
+			return &LLMError{
+				Type:    ErrorTypeInvalidRequest,
+				Message: fmt.Sprintf("failed to pull %s: %s", c.config.Model, update.Error),
+			}
+		}
+		if update.Status == "success" {
+			return nil
+		}
+		if progress != nil {
+			progress(PullProgress{
+				Status:    update.Status,
+				Completed: update.Completed,
+				Total:     update.Total,
+			})
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return c.requestError(err)
+	}
+	return &LLMError{
+		Type:    ErrorTypeServer,
+		Message: fmt.Sprintf("pull of %s ended before it completed", c.config.Model),
+	}
+}
+
+// Load loads the configured model into memory so the first request does
+// not pay for it
+func (c *OllamaClient) Load(ctx context.Context) error {
+	resp, err := c.post(ctx, c.httpClient, "/api/generate", map[string]string{"model": c.config.Model})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+// post sends a JSON request to an API path and returns the response if
+// it succeeded
+func (c *OllamaClient) post(ctx context.Context, client *http.Client, path string, payload any) (*http.Response, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: "failed to marshal request: " + err.Error(),
+		}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: "failed to create request: " + err.Error(),
+		}
+	}
+
+	c.setHeaders(httpReq)
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, c.requestError(err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, c.handleError(resp)
+	}
+
+	return resp, nil
+}
+
+// requestError wraps a transport error, pointing out when no server is
+// listening at the configured address
+func (c *OllamaClient) requestError(err error) error {
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return &LLMError{
+			Type:    ErrorTypeNetwork,
+			Message: fmt.Sprintf("cannot reach Ollama at %s; is it running (ollama serve)?", c.config.BaseURL),
+			Details: err.Error(),
+		}
+	}
+	return &LLMError{
+		Type:    ErrorTypeNetwork,
+		Message: "request failed: " + err.Error(),
+	}
+}
+
+// buildRequest converts a generic Request to Ollama format
+func (c *OllamaClient) buildRequest(req Request) ollamaRequest {
+	apiReq := ollamaRequest{
+		Model:    req.Model,
+		Stream:   req.Stream,
+		Messages: make([]ollamaMessage, 0, len(req.Messages)+1),
+	}
+
+	options := ollamaOptions{
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		NumPredict:  req.MaxTokens,
+	}
+
+	// Use config defaults if not specified
+	if apiReq.Model == "" {
+		apiReq.Model = c.config.Model
+	}
+	if options.NumPredict == 0 {
+		options.NumPredict = c.config.MaxTokens
+	}
+	if options.Temperature == 0 {
+		options.Temperature = c.config.Temperature
+	}
+	if options != (ollamaOptions{}) {
+		apiReq.Options = &options
+	}
+
+	// Ollama has no budget or effort level; thinking is on or off
+	apiReq.Think = req.thinkingBudget() > 0
+
+	// System messages are joined into one leading system message
+	system, messages := normalizeMessages(ProviderOllama, req.SystemPrompt, req.Messages)
+	if system != "" {
+		apiReq.Messages = append(apiReq.Messages, ollamaMessage{
+			Role:    "system",
+			Content: system,
+		})
+	}
+
+	// Convert tool definitions
+	for _, tool := range req.Tools {
+		apiReq.Tools = append(apiReq.Tools, openaiTool{
+			Type: "function",
+			Function: openaiToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
+		})
+	}
+
+	// Tool messages name the function they answer rather than a call ID
+	callNames := make(map[string]string)
+	for _, msg := range messages {
+		for _, call := range msg.ToolCalls {
+			callNames[call.ID] = call.Name
+		}
+	}
+
+	// Convert messages
+	for _, msg := range messages {
+		apiReq.Messages = append(apiReq.Messages, ollamaMessages(msg, callNames)...)
+	}
+
+	if req.ResponseSchema != nil {
+		apiReq.Format = req.ResponseSchema.Schema
+	}
+
+	return apiReq
+}
+
+// ollamaMessages converts a message into Ollama format. Each tool result
+// becomes its own "tool" role message; any accompanying text follows them.
+func ollamaMessages(msg Message, callNames map[string]string) []ollamaMessage {
+	var messages []ollamaMessage
+
+	for _, result := range msg.ToolResults {
+		content := result.Content
+		if result.IsError {
+			content = "Error: " + content
+		}
+		messages = append(messages, ollamaMessage{
+			Role:     "tool",
+			Content:  content,
+			ToolName: callNames[result.ToolCallID],
+		})
+	}
+
+	if msg.Content == "" && len(msg.ToolCalls) == 0 && len(msg.ToolResults) > 0 {
+		return messages
+	}
+
+	apiMsg := ollamaMessage{
+		Role:    string(msg.Role),
+		Content: msg.Content,
+	}
+	for _, img := range msg.Images {
+		if len(img.Data) == 0 {
+			apiMsg.Content = img.unavailableText() + "\n" + apiMsg.Content
+			continue
+		}
+		apiMsg.Images = append(apiMsg.Images, img.Base64())
+	}
+	for _, call := range msg.ToolCalls {
+		args := call.Arguments
+		if args == nil {
+			args = map[string]any{}
+		}
+		apiMsg.ToolCalls = append(apiMsg.ToolCalls, ollamaToolCall{
+			Function: ollamaFunctionCall{Name: call.Name, Arguments: args},
+		})
+	}
+
+	return append(messages, apiMsg)
+}
+
+// ollamaToolCallToSchema converts a tool call. Ollama does not identify
+// calls, so each one is given an ID its result can refer to.
+func ollamaToolCallToSchema(call ollamaToolCall) schema.ToolCall {
+	args := call.Function.Arguments
+	if args == nil {
+		args = make(map[string]any)
+	}
+
+	return schema.ToolCall{
+		ID:        fmt.Sprintf("call_%d", toolCallCounter.Add(1)),
+		Name:      call.Function.Name,
+		Arguments: args,
+	}
+}
+
+// convertResponse converts an Ollama response to generic Response
+func (c *OllamaClient) convertResponse(resp *ollamaResponse) *Response {
+	var toolCalls []schema.ToolCall
+	for _, call := range resp.Message.ToolCalls {
+		toolCalls = append(toolCalls, ollamaToolCallToSchema(call))
+	}
+
+	var reasoning []ReasoningBlock
+	if resp.Message.Thinking != "" {
+		reasoning = []ReasoningBlock{{Provider: ProviderOllama, Text: resp.Message.Thinking}}
+	}
+
+	return &Response{
+		Content:      resp.Message.Content,
+		ToolCalls:    toolCalls,
+		Reasoning:    reasoning,
+		Role:         RoleAssistant,
+		FinishReason: resp.DoneReason,
+		Model:        resp.Model,
+		Usage:        *ollamaUsage(resp),
+	}
+}
+
+// ollamaUsage converts the token counts of a final response
+func ollamaUsage(resp *ollamaResponse) *Usage {
+	return &Usage{
+		PromptTokens:     resp.PromptEvalCount,
+		CompletionTokens: resp.EvalCount,
+		TotalTokens:      resp.PromptEvalCount + resp.EvalCount,
+	}
+}
+
+// setHeaders sets the headers for Ollama API requests
+func (c *OllamaClient) setHeaders(req *http.Request) {
+	req.Header.Set("Content-Type", "application/json")
+	if c.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
+	}
+}
+
+// handleError processes error responses
+func (c *OllamaClient) handleError(resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var apiErr struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &apiErr); err == nil && apiErr.Error != "" {
+		return withResponseHeaders(&LLMError{
+			Type:    c.mapErrorType(resp.StatusCode),
+			Message: apiErr.Error,
+			Code:    resp.StatusCode,
+		}, resp.Header)
+	}
+
+	return withResponseHeaders(&LLMError{
+		Type:    c.mapErrorType(resp.StatusCode),
+		Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
+		Code:    resp.StatusCode,
+	}, resp.Header)
+}
+
+// mapErrorType maps HTTP status codes to error types
+func (c *OllamaClient) mapErrorType(statusCode int) ErrorType {
+	switch statusCode {
+	case 401, 403:
+		return ErrorTypeAuth
+	case 429:
+		return ErrorTypeRateLimit
+	case 400, 404, 422:
+		return ErrorTypeInvalidRequest
+	case 500, 502, 503, 504:
+		return ErrorTypeServer
+	default:
+		return ErrorTypeUnknown
+	}
+}
+
+// Provider returns the provider type
+func (c *OllamaClient) Provider() ProviderType {
+	return ProviderOllama
+}
+
+// Model returns the configured model
+func (c *OllamaClient) Model() string {
+	return c.config.Model
+}
+
+// CountTokens counts tokens offline with the model's tokenizer, or a
+// calibrated estimate when no tokenizer is available for the model
+func (c *OllamaClient) CountTokens(text string) int {
+	return tokenizer.ForModel(c.config.Model).Count(text)
+}
+
+// normalizeOllamaModel adds the implicit ":latest" tag to a model name
+func normalizeOllamaModel(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !strings.Contains(name, ":") {
+		name += ":latest"
+	}
+	return name
+}
+
+// similarModels returns the installed models whose names are within a
+// few edits of model, closest first
+func similarModels(model string, installed []string) []string {
+	want := normalizeOllamaModel(model)
+	maxDistance := len(want)/4 + 1
+
+	type candidate struct {
+		name     string
+		distance int
+	}
+	var candidates []candidate
+	for _, name := range installed {
+		distance := editDistance(want, normalizeOllamaModel(name))
+		// A bare name that only differs by tag is always a candidate
+		base, _, _ := strings.Cut(normalizeOllamaModel(name), ":")
+		wantBase, _, _ := strings.Cut(want, ":")
+		if distance <= maxDistance || base == wantBase {
+			candidates = append(candidates, candidate{name, distance})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var names []string
+	for i, c := range candidates {
+		if i == 3 {
+			break
+		}
+		names = append(names, c.name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// quoteAll quotes each string
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
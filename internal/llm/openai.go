@@ -10,6 +10,9 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tokenizer"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
 const (
@@ -59,11 +62,95 @@ type openaiRequest struct {
 	Temperature float64         `json:"temperature,omitempty"`
 	TopP        float64         `json:"top_p,omitempty"`
 	Stream      bool            `json:"stream"`
+	Tools       []openaiTool    `json:"tools,omitempty"`
+
+	// Streams only report usage, in a final chunk, when asked to
+	StreamOptions *openaiStreamOptions `json:"stream_options,omitempty"`
+
+	// Reasoning models take an effort level, and a token limit that
+	// includes their reasoning instead of max_tokens
+	ReasoningEffort     ReasoningEffort `json:"reasoning_effort,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+
+	ResponseFormat *openaiResponseFormat `json:"response_format,omitempty"`
+}
+
+type openaiStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// openaiResponseFormat constrains the answer to a JSON schema
+type openaiResponseFormat struct {
+	Type       string            `json:"type"` // "json_schema"
+	JSONSchema *openaiJSONSchema `json:"json_schema"`
+}
+
+type openaiJSONSchema struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Schema      map[string]any `json:"schema"`
 }
 
 type openaiMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openaiToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+
+	// Reasoning text, which some OpenAI-compatible servers return
+	// (OpenAI itself does not)
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+	Reasoning        string `json:"reasoning,omitempty"`
+
+	// Parts replaces Content in requests when a message has images, which
+	// must be sent as an array of content parts
+	Parts []openaiContentPart `json:"-"`
+}
+
+// openaiContentPart is a text or image part of a message's content
+type openaiContentPart struct {
+	Type     string          `json:"type"` // "text" or "image_url"
+	Text     string          `json:"text,omitempty"`
+	ImageURL *openaiImageURL `json:"image_url,omitempty"`
+}
+
+type openaiImageURL struct {
+	URL string `json:"url"` // A data URI
+}
+
+// MarshalJSON sends Parts as the content when a message has them
+func (m openaiMessage) MarshalJSON() ([]byte, error) {
+	type plain openaiMessage
+	if len(m.Parts) == 0 {
+		return json.Marshal(plain(m))
+	}
+	return json.Marshal(struct {
+		plain
+		Content []openaiContentPart `json:"content"`
+	}{plain(m), m.Parts})
+}
+
+type openaiTool struct {
+	Type     string             `json:"type"`
+	Function openaiToolFunction `json:"function"`
+}
+
+type openaiToolFunction struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type openaiToolCall struct {
+	Index    int                `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openaiFunctionCall `json:"function"`
+}
+
+type openaiFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
 }
 
 // openaiResponse represents the OpenAI API response format
@@ -85,14 +172,31 @@ type openaiChoice struct {
 }
 
 type openaiDelta struct {
-	Role    string `json:"role,omitempty"`
-	Content string `json:"content,omitempty"`
+	Role             string           `json:"role,omitempty"`
+	Content          string           `json:"content,omitempty"`
+	ToolCalls        []openaiToolCall `json:"tool_calls,omitempty"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	Reasoning        string           `json:"reasoning,omitempty"`
 }
 
 type openaiUsage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int `json:"prompt_tokens"`
+	CompletionTokens    int `json:"completion_tokens"`
+	TotalTokens         int `json:"total_tokens"`
+	PromptTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"` // Included in PromptTokens
+	} `json:"prompt_tokens_details"`
+}
+
+// toUsage converts to Usage. OpenAI caches prompt prefixes automatically
+// and only reports the tokens read from the cache.
+func (u openaiUsage) toUsage() Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+		CacheReadTokens:  u.PromptTokensDetails.CachedTokens,
+	}
 }
 
 type openaiError struct {
@@ -103,6 +207,11 @@ type openaiError struct {
 
 // Complete sends a non-streaming request
 func (c *OpenAIClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	return completeStructured(ctx, req, c.complete)
+}
+
+// complete sends one non-streaming request
+func (c *OpenAIClient) complete(ctx context.Context, req Request) (*Response, error) {
 	apiReq := c.buildRequest(req)
 	apiReq.Stream = false
 
@@ -158,8 +267,13 @@ func (c *OpenAIClient) Complete(ctx context.Context, req Request) (*Response, er
 
 // Stream sends a streaming request
 func (c *OpenAIClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	if req.ResponseSchema != nil {
+		return streamStructured(ctx, req, c.Complete, callback)
+	}
+
 	apiReq := c.buildRequest(req)
 	apiReq.Stream = true
+	apiReq.StreamOptions = &openaiStreamOptions{IncludeUsage: true}
 
 	body, err := json.Marshal(apiReq)
 	if err != nil {
@@ -198,8 +312,40 @@ func (c *OpenAIClient) Stream(ctx context.Context, req Request, callback StreamC
 // processStream processes the SSE stream
 func (c *OpenAIClient) processStream(body io.Reader, callback StreamCallback) error {
 	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 	var usage *Usage
 
+	// Reasoning arrives before the answer and ends up as one block
+	var reasoning strings.Builder
+	flushReasoning := func() {
+		if reasoning.Len() == 0 {
+			return
+		}
+		callback(StreamEvent{
+			ReasoningBlock: &ReasoningBlock{Provider: c.config.Provider, Text: reasoning.String()},
+			Timestamp:      time.Now(),
+		})
+		reasoning.Reset()
+	}
+
+	// Tool call arguments arrive in fragments, keyed by tool call index
+	var toolCalls []*openaiToolCall
+	flushToolCalls := func() {
+		flushReasoning()
+		for _, call := range toolCalls {
+			toolCall := schema.ToolCall{
+				ID:        call.ID,
+				Name:      call.Function.Name,
+				Arguments: decodeToolArguments(call.Function.Arguments),
+			}
+			callback(StreamEvent{
+				ToolCall:  &toolCall,
+				Timestamp: time.Now(),
+			})
+		}
+		toolCalls = nil
+	}
+
 	for scanner.Scan() {
 		line := scanner.Text()
 
@@ -209,6 +355,7 @@ func (c *OpenAIClient) processStream(body io.Reader, callback StreamCallback) er
 
 		data := strings.TrimPrefix(line, "data: ")
 		if data == "[DONE]" {
+			flushToolCalls()
 			callback(StreamEvent{
 				Done:      true,
 				Usage:     usage,
@@ -225,6 +372,16 @@ func (c *OpenAIClient) processStream(body io.Reader, callback StreamCallback) er
 		if len(chunk.Choices) > 0 {
 			choice := chunk.Choices[0]
 
+			if choice.Delta != nil {
+				if text := firstNonEmpty(choice.Delta.ReasoningContent, choice.Delta.Reasoning); text != "" {
+					reasoning.WriteString(text)
+					callback(StreamEvent{
+						Reasoning: text,
+						Timestamp: time.Now(),
+					})
+				}
+			}
+
 			if choice.Delta != nil && choice.Delta.Content != "" {
 				callback(StreamEvent{
 					Delta:     choice.Delta.Content,
@@ -233,7 +390,24 @@ func (c *OpenAIClient) processStream(body io.Reader, callback StreamCallback) er
 				})
 			}
 
+			if choice.Delta != nil {
+				for _, fragment := range choice.Delta.ToolCalls {
+					for len(toolCalls) <= fragment.Index {
+						toolCalls = append(toolCalls, &openaiToolCall{})
+					}
+					call := toolCalls[fragment.Index]
+					if fragment.ID != "" {
+						call.ID = fragment.ID
+					}
+					if fragment.Function.Name != "" {
+						call.Function.Name = fragment.Function.Name
+					}
+					call.Function.Arguments += fragment.Function.Arguments
+				}
+			}
+
 			if choice.FinishReason != "" {
+				flushToolCalls()
 				callback(StreamEvent{
 					Done:         false,
 					FinishReason: choice.FinishReason,
@@ -242,12 +416,10 @@ func (c *OpenAIClient) processStream(body io.Reader, callback StreamCallback) er
 			}
 		}
 
+		// The usage chunk comes last, with no choices
 		if chunk.Usage.TotalTokens > 0 {
-			usage = &Usage{
-				PromptTokens:     chunk.Usage.PromptTokens,
-				CompletionTokens: chunk.Usage.CompletionTokens,
-				TotalTokens:      chunk.Usage.TotalTokens,
-			}
+			chunkUsage := chunk.Usage.toUsage()
+			usage = &chunkUsage
 		}
 	}
 
@@ -258,6 +430,7 @@ func (c *OpenAIClient) processStream(body io.Reader, callback StreamCallback) er
 		}
 	}
 
+	flushToolCalls()
 	callback(StreamEvent{
 		Done:      true,
 		Usage:     usage,
@@ -289,25 +462,123 @@ func (c *OpenAIClient) buildRequest(req Request) openaiRequest {
 		apiReq.Temperature = c.config.Temperature
 	}
 
-	// Add system prompt if present
-	if req.SystemPrompt != "" {
+	// Reasoning models reject max_tokens and sampling settings. The
+	// reasoning budget is added to the limit to leave the answer its full
+	// length. Compatible servers differ too much to be sent these.
+	if effort := req.reasoningEffort(); effort != "" && c.config.Provider == ProviderOpenAI {
+		apiReq.ReasoningEffort = effort
+		if apiReq.MaxTokens > 0 {
+			apiReq.MaxCompletionTokens = apiReq.MaxTokens + req.thinkingBudget()
+		}
+		apiReq.MaxTokens = 0
+		apiReq.Temperature = 0
+		apiReq.TopP = 0
+	}
+
+	// System messages are joined into one leading system message
+	system, messages := normalizeMessages(c.config.Provider, req.SystemPrompt, req.Messages)
+	if system != "" {
 		apiReq.Messages = append(apiReq.Messages, openaiMessage{
 			Role:    "system",
-			Content: req.SystemPrompt,
+			Content: system,
 		})
 	}
 
-	// Convert messages
-	for _, msg := range req.Messages {
-		apiReq.Messages = append(apiReq.Messages, openaiMessage{
-			Role:    string(msg.Role),
-			Content: msg.Content,
+	// Convert tool definitions
+	for _, tool := range req.Tools {
+		apiReq.Tools = append(apiReq.Tools, openaiTool{
+			Type: "function",
+			Function: openaiToolFunction{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.InputSchema,
+			},
 		})
 	}
 
+	// Convert messages
+	for _, msg := range messages {
+		apiReq.Messages = append(apiReq.Messages, openaiMessages(msg)...)
+	}
+
+	if schema := req.ResponseSchema; schema != nil {
+		apiReq.ResponseFormat = &openaiResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &openaiJSONSchema{
+				Name:        schema.name(),
+				Description: schema.Description,
+				Schema:      schema.Schema,
+			},
+		}
+	}
+
 	return apiReq
 }
 
+// openaiMessages converts a message into OpenAI format. Each tool result
+// becomes its own "tool" role message; any accompanying text follows them.
+func openaiMessages(msg Message) []openaiMessage {
+	var messages []openaiMessage
+
+	for _, result := range msg.ToolResults {
+		content := result.Content
+		if result.IsError {
+			content = "Error: " + content
+		}
+		messages = append(messages, openaiMessage{
+			Role:       "tool",
+			Content:    content,
+			ToolCallID: result.ToolCallID,
+		})
+	}
+
+	if msg.Content == "" && len(msg.ToolCalls) == 0 && len(msg.ToolResults) > 0 {
+		return messages
+	}
+
+	apiMsg := openaiMessage{
+		Role:    string(msg.Role),
+		Content: msg.Content,
+		Parts:   openaiImageParts(msg),
+	}
+	for _, call := range msg.ToolCalls {
+		apiMsg.ToolCalls = append(apiMsg.ToolCalls, openaiToolCall{
+			ID:   call.ID,
+			Type: "function",
+			Function: openaiFunctionCall{
+				Name:      call.Name,
+				Arguments: string(encodeToolArguments(call.Arguments)),
+			},
+		})
+	}
+
+	return append(messages, apiMsg)
+}
+
+// openaiImageParts returns a message's images followed by its text as
+// content parts, or nil if it has no images
+func openaiImageParts(msg Message) []openaiContentPart {
+	if len(msg.Images) == 0 {
+		return nil
+	}
+
+	var parts []openaiContentPart
+	for _, img := range msg.Images {
+		if len(img.Data) == 0 {
+			parts = append(parts, openaiContentPart{Type: "text", Text: img.unavailableText()})
+			continue
+		}
+		parts = append(parts, openaiContentPart{
+			Type:     "image_url",
+			ImageURL: &openaiImageURL{URL: img.DataURI()},
+		})
+	}
+	if msg.Content != "" {
+		parts = append(parts, openaiContentPart{Type: "text", Text: msg.Content})
+	}
+	return parts
+}
+
 // convertResponse converts OpenAI response to generic Response
 func (c *OpenAIClient) convertResponse(resp *openaiResponse) *Response {
 	if len(resp.Choices) == 0 {
@@ -318,19 +589,41 @@ func (c *OpenAIClient) convertResponse(resp *openaiResponse) *Response {
 
 	choice := resp.Choices[0]
 
+	var toolCalls []schema.ToolCall
+	for _, call := range choice.Message.ToolCalls {
+		toolCalls = append(toolCalls, schema.ToolCall{
+			ID:        call.ID,
+			Name:      call.Function.Name,
+			Arguments: decodeToolArguments(call.Function.Arguments),
+		})
+	}
+
+	var reasoning []ReasoningBlock
+	if text := firstNonEmpty(choice.Message.ReasoningContent, choice.Message.Reasoning); text != "" {
+		reasoning = []ReasoningBlock{{Provider: c.config.Provider, Text: text}}
+	}
+
 	return &Response{
 		Content:      choice.Message.Content,
+		ToolCalls:    toolCalls,
+		Reasoning:    reasoning,
 		Role:         Role(choice.Message.Role),
 		FinishReason: choice.FinishReason,
 		Model:        resp.Model,
-		Usage: Usage{
-			PromptTokens:     resp.Usage.PromptTokens,
-			CompletionTokens: resp.Usage.CompletionTokens,
-			TotalTokens:      resp.Usage.TotalTokens,
-		},
+		Usage:        resp.Usage.toUsage(),
 	}
 }
 
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
 // setHeaders sets the required headers for OpenAI API
 func (c *OpenAIClient) setHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
@@ -345,19 +638,19 @@ func (c *OpenAIClient) handleError(resp *http.Response) error {
 
 	var apiResp openaiResponse
 	if err := json.Unmarshal(body, &apiResp); err == nil && apiResp.Error != nil {
-		return &LLMError{
+		return withResponseHeaders(&LLMError{
 			Type:    c.mapErrorType(resp.StatusCode),
 			Message: apiResp.Error.Message,
 			Code:    resp.StatusCode,
 			Details: apiResp.Error.Type,
-		}
+		}, resp.Header)
 	}
 
-	return &LLMError{
+	return withResponseHeaders(&LLMError{
 		Type:    c.mapErrorType(resp.StatusCode),
 		Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
 		Code:    resp.StatusCode,
-	}
+	}, resp.Header)
 }
 
 // mapErrorType maps HTTP status codes to error types
@@ -386,8 +679,8 @@ func (c *OpenAIClient) Model() string {
 	return c.config.Model
 }
 
-// CountTokens estimates token count (rough approximation)
+// CountTokens counts tokens offline with the model's tokenizer, or a
+// calibrated estimate when no tokenizer is available for the model
 func (c *OpenAIClient) CountTokens(text string) int {
-	// Rough approximation: ~4 characters per token
-	return len(text) / 4
+	return tokenizer.ForModel(c.config.Model).Count(text)
 }
@@ -0,0 +1,41 @@
+package llm
+
+// ModelPrice is a model's price in USD per million tokens
+type ModelPrice struct {
+	Input      float64
+	Output     float64
+	CacheRead  float64 // Prompt tokens read from the cache
+	CacheWrite float64 // Prompt tokens written to the cache
+}
+
+// Cost returns the price of the tokens in usage. Cached prompt tokens are
+// charged at the cache prices and the rest at the input price.
+func (p ModelPrice) Cost(usage Usage) float64 {
+	uncached := max(usage.PromptTokens-usage.CacheReadTokens-usage.CacheWriteTokens, 0)
+	return (float64(uncached)*p.Input +
+		float64(usage.CacheReadTokens)*p.CacheRead +
+		float64(usage.CacheWriteTokens)*p.CacheWrite +
+		float64(usage.CompletionTokens)*p.Output) / 1_000_000
+}
+
+// Cache prices relative to the input price. Anthropic charges extra to
+// write its 5-minute cache; OpenAI and Gemini cache implicitly and only
+// discount reads.
+const (
+	anthropicCacheReadRate  = 0.10
+	anthropicCacheWriteRate = 1.25
+	openaiCacheReadRate     = 0.50
+	geminiCacheReadRate     = 0.25
+)
+
+func anthropicPrice(input, output float64) ModelPrice {
+	return ModelPrice{input, output, input * anthropicCacheReadRate, input * anthropicCacheWriteRate}
+}
+
+func openaiPrice(input, output float64) ModelPrice {
+	return ModelPrice{input, output, input * openaiCacheReadRate, input}
+}
+
+func geminiPrice(input, output float64) ModelPrice {
+	return ModelPrice{input, output, input * geminiCacheReadRate, input}
+}
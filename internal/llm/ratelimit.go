@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimitInfo is the rate limit headroom a provider reported with a
+// response. Limits of 0 mean the provider did not report them.
+type RateLimitInfo struct {
+	RequestsLimit     int
+	RequestsRemaining int
+	RequestsReset     time.Time // When the request allowance is replenished
+	TokensLimit       int
+	TokensRemaining   int
+	TokensReset       time.Time // When the token allowance is replenished
+}
+
+// ResetWait returns how long after now an exhausted allowance is
+// replenished, or 0 if no reported allowance is exhausted
+func (r *RateLimitInfo) ResetWait(now time.Time) time.Duration {
+	if r == nil {
+		return 0
+	}
+
+	var wait time.Duration
+	if r.RequestsLimit > 0 && r.RequestsRemaining == 0 && r.RequestsReset.After(now) {
+		wait = r.RequestsReset.Sub(now)
+	}
+	if r.TokensLimit > 0 && r.TokensRemaining == 0 && r.TokensReset.After(now) {
+		wait = max(wait, r.TokensReset.Sub(now))
+	}
+	return wait
+}
+
+// withResponseHeaders adds the retry delay and rate limit headroom from
+// an error response's headers to err
+func withResponseHeaders(err *LLMError, header http.Header) *LLMError {
+	now := time.Now()
+	if delay := parseRetryAfter(header, now); delay > 0 {
+		err.RetryAfter = delay
+	}
+	if info := parseRateLimit(header, now); info != nil {
+		err.RateLimit = info
+	}
+	return err
+}
+
+// parseRetryAfter reads the delay a server asked for, from retry-after-ms
+// or from retry-after in seconds or as an HTTP date
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("retry-after-ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+
+	value := header.Get("retry-after")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// parseRateLimit reads rate limit headroom from Anthropic
+// (anthropic-ratelimit-*) or OpenAI (x-ratelimit-*) headers, returning
+// nil if there are none
+func parseRateLimit(header http.Header, now time.Time) *RateLimitInfo {
+	info := &RateLimitInfo{}
+
+	if header.Get("anthropic-ratelimit-requests-limit") != "" ||
+		header.Get("anthropic-ratelimit-tokens-limit") != "" ||
+		header.Get("anthropic-ratelimit-input-tokens-limit") != "" {
+		info.RequestsLimit = headerInt(header, "anthropic-ratelimit-requests-limit")
+		info.RequestsRemaining = headerInt(header, "anthropic-ratelimit-requests-remaining")
+		info.RequestsReset = headerTime(header, "anthropic-ratelimit-requests-reset")
+
+		// Older keys report combined tokens, newer ones input tokens separately
+		tokens := "anthropic-ratelimit-tokens"
+		if header.Get(tokens+"-limit") == "" {
+			tokens = "anthropic-ratelimit-input-tokens"
+		}
+		info.TokensLimit = headerInt(header, tokens+"-limit")
+		info.TokensRemaining = headerInt(header, tokens+"-remaining")
+		info.TokensReset = headerTime(header, tokens+"-reset")
+		return info
+	}
+
+	if header.Get("x-ratelimit-limit-requests") != "" || header.Get("x-ratelimit-limit-tokens") != "" {
+		info.RequestsLimit = headerInt(header, "x-ratelimit-limit-requests")
+		info.RequestsRemaining = headerInt(header, "x-ratelimit-remaining-requests")
+		info.RequestsReset = headerReset(header, "x-ratelimit-reset-requests", now)
+		info.TokensLimit = headerInt(header, "x-ratelimit-limit-tokens")
+		info.TokensRemaining = headerInt(header, "x-ratelimit-remaining-tokens")
+		info.TokensReset = headerReset(header, "x-ratelimit-reset-tokens", now)
+		return info
+	}
+
+	return nil
+}
+
+// headerInt reads an integer header, 0 if it is missing or malformed
+func headerInt(header http.Header, key string) int {
+	n, _ := strconv.Atoi(header.Get(key))
+	return n
+}
+
+// headerTime reads an RFC 3339 timestamp header
+func headerTime(header http.Header, key string) time.Time {
+	t, _ := time.Parse(time.RFC3339, header.Get(key))
+	return t
+}
+
+// headerReset reads a reset header given as a duration such as "6m0s"
+// or "20ms" and returns the time it refers to
+func headerReset(header http.Header, key string, now time.Time) time.Time {
+	d, err := time.ParseDuration(header.Get(key))
+	if err != nil {
+		return time.Time{}
+	}
+	return now.Add(d)
+}
@@ -0,0 +1,76 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Thinking budgets used for each effort level by providers that take a
+// budget, and the budget boundaries between levels for those that take
+// an effort level
+var effortBudgets = map[ReasoningEffort]int{
+	ReasoningEffortLow:    2048,
+	ReasoningEffortMedium: 8192,
+	ReasoningEffortHigh:   24576,
+}
+
+// ParseReasoningEffort converts an effort name such as "high"; an empty
+// name means no extended thinking
+func ParseReasoningEffort(name string) (ReasoningEffort, error) {
+	switch effort := ReasoningEffort(strings.ToLower(strings.TrimSpace(name))); effort {
+	case "", ReasoningEffortLow, ReasoningEffortMedium, ReasoningEffortHigh:
+		return effort, nil
+	default:
+		return "", fmt.Errorf("unknown reasoning effort %q (use low, medium or high)", name)
+	}
+}
+
+// thinkingBudget returns the request's thinking budget, derived from its
+// reasoning effort when no budget is set
+func (r Request) thinkingBudget() int {
+	if r.ThinkingBudget > 0 {
+		return r.ThinkingBudget
+	}
+	return effortBudgets[r.ReasoningEffort]
+}
+
+// reasoningEffort returns the request's reasoning effort, derived from
+// its thinking budget when no effort is set
+func (r Request) reasoningEffort() ReasoningEffort {
+	switch {
+	case r.ReasoningEffort != "":
+		return r.ReasoningEffort
+	case r.ThinkingBudget <= 0:
+		return ""
+	case r.ThinkingBudget <= effortBudgets[ReasoningEffortLow]:
+		return ReasoningEffortLow
+	case r.ThinkingBudget <= effortBudgets[ReasoningEffortMedium]:
+		return ReasoningEffortMedium
+	default:
+		return ReasoningEffortHigh
+	}
+}
+
+// ReasoningText joins the readable text of reasoning blocks
+func ReasoningText(blocks []ReasoningBlock) string {
+	var texts []string
+	for _, block := range blocks {
+		if block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// providerReasoning returns the blocks of a message produced by provider.
+// Signatures are only valid for the provider that issued them, so
+// reasoning from another provider, e.g. before a failover, is not sent.
+func providerReasoning(msg Message, provider ProviderType) []ReasoningBlock {
+	var blocks []ReasoningBlock
+	for _, block := range msg.Reasoning {
+		if block.Provider == provider {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
@@ -2,8 +2,12 @@ package llm
 
 import (
 	"context"
+	"errors"
 	"math"
+	"math/rand/v2"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // RetryConfig holds retry configuration
@@ -12,29 +16,50 @@ type RetryConfig struct {
 	InitialBackoff time.Duration
 	MaxBackoff     time.Duration
 	Multiplier     float64
+
+	// MaxRetryAfter is the longest server-requested delay that is waited
+	// out; a request asking for longer fails immediately (0 = no limit)
+	MaxRetryAfter time.Duration
+
+	// BreakerThreshold is the number of consecutive server errors that
+	// opens the circuit breaker (0 = no breaker)
+	BreakerThreshold int
+
+	// BreakerCooldown is how long an open breaker fails requests fast
+	// before letting one through to probe the provider
+	BreakerCooldown time.Duration
 }
 
 // DefaultRetryConfig returns sensible default retry settings
 func DefaultRetryConfig() RetryConfig {
 	return RetryConfig{
-		MaxRetries:     3,
-		InitialBackoff: 1 * time.Second,
-		MaxBackoff:     30 * time.Second,
-		Multiplier:     2.0,
+		MaxRetries:       3,
+		InitialBackoff:   1 * time.Second,
+		MaxBackoff:       30 * time.Second,
+		Multiplier:       2.0,
+		MaxRetryAfter:    2 * time.Minute,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
 	}
 }
 
 // RetryableClient wraps a Client with retry logic
 type RetryableClient struct {
-	client Client
-	config RetryConfig
+	client  Client
+	config  RetryConfig
+	breaker *circuitBreaker
+
+	// jitter picks the backoff in [0, ceiling]; replaced in tests
+	jitter func(ceiling time.Duration) time.Duration
 }
 
 // NewRetryableClient creates a client with retry logic
 func NewRetryableClient(client Client, config RetryConfig) *RetryableClient {
 	return &RetryableClient{
-		client: client,
-		config: config,
+		client:  client,
+		config:  config,
+		breaker: newCircuitBreaker(config.BreakerThreshold, config.BreakerCooldown),
+		jitter:  fullJitter,
 	}
 }
 
@@ -43,7 +68,12 @@ func (r *RetryableClient) Complete(ctx context.Context, req Request) (*Response,
 	var lastErr error
 
 	for attempt := 0; attempt <= r.config.MaxRetries; attempt++ {
+		if err := r.breaker.allow(); err != nil {
+			return nil, err
+		}
+
 		resp, err := r.client.Complete(ctx, req)
+		r.breaker.record(err)
 		if err == nil {
 			return resp, nil
 		}
@@ -57,12 +87,8 @@ func (r *RetryableClient) Complete(ctx context.Context, req Request) (*Response,
 
 		// Don't sleep after the last attempt
 		if attempt < r.config.MaxRetries {
-			backoff := r.calculateBackoff(attempt)
-			select {
-			case <-time.After(backoff):
-				// Continue to next attempt
-			case <-ctx.Done():
-				return nil, ctx.Err()
+			if err := r.wait(ctx, attempt, err); err != nil {
+				return nil, err
 			}
 		}
 	}
@@ -70,12 +96,47 @@ func (r *RetryableClient) Complete(ctx context.Context, req Request) (*Response,
 	return nil, lastErr
 }
 
-// Stream sends a streaming request with retry logic
+// Stream sends a streaming request with retry logic. Deltas delivered to
+// the callback are never delivered again: a retry after partial text asks
+// the model to continue from that text, and a stream that already
+// delivered a tool call is not retried at all. Neither is a thinking
+// stream that delivered anything: a continuation cannot be prefilled
+// while thinking, and a restart would repeat the reasoning shown. Only
+// Anthropic continues a prefilled reply; other providers would answer
+// from the start, so their streams are not retried after partial text.
 func (r *RetryableClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
 	var lastErr error
+	var delivered strings.Builder
+	toolCallDelivered := false
+	thinkingDelivered := false
+	thinking := req.thinkingBudget() > 0
 
 	for attempt := 0; attempt <= r.config.MaxRetries; attempt++ {
-		err := r.client.Stream(ctx, req, callback)
+		if err := r.breaker.allow(); err != nil {
+			return err
+		}
+
+		attemptReq := req
+		skipSpace := false // The seam already has the whitespace trimmed from the prefill
+		if delivered.Len() > 0 {
+			attemptReq, skipSpace = continuationRequest(req, delivered.String())
+		}
+
+		err := r.client.Stream(ctx, attemptReq, func(event StreamEvent) {
+			if skipSpace && event.Delta != "" {
+				event.Delta = strings.TrimLeftFunc(event.Delta, unicode.IsSpace)
+				skipSpace = event.Delta == ""
+			}
+			delivered.WriteString(event.Delta)
+			if event.ToolCall != nil {
+				toolCallDelivered = true
+			}
+			if thinking && (event.Delta != "" || event.Reasoning != "" || event.ReasoningBlock != nil) {
+				thinkingDelivered = true
+			}
+			callback(event)
+		})
+		r.breaker.record(err)
 		if err == nil {
 			return nil
 		}
@@ -83,18 +144,17 @@ func (r *RetryableClient) Stream(ctx context.Context, req Request, callback Stre
 		lastErr = err
 
 		// Check if error is retryable
-		if !r.isRetryable(err) {
+		if !r.isRetryable(err) || toolCallDelivered || thinkingDelivered {
+			return err
+		}
+		if delivered.Len() > 0 && r.client.Provider() != ProviderAnthropic {
 			return err
 		}
 
 		// Don't sleep after the last attempt
 		if attempt < r.config.MaxRetries {
-			backoff := r.calculateBackoff(attempt)
-			select {
-			case <-time.After(backoff):
-				// Continue to next attempt
-			case <-ctx.Done():
-				return ctx.Err()
+			if err := r.wait(ctx, attempt, err); err != nil {
+				return err
 			}
 		}
 	}
@@ -102,6 +162,58 @@ func (r *RetryableClient) Stream(ctx context.Context, req Request, callback Stre
 	return lastErr
 }
 
+// continuationRequest asks the model to carry on from partial, the text
+// already delivered before a stream failed, by ending the conversation
+// with it as the start of the assistant's reply. The prefill cannot end
+// in whitespace, so when partial does, trimmed is true: the model will
+// start its continuation with whitespace the caller has already seen.
+func continuationRequest(req Request, partial string) (continued Request, trimmed bool) {
+	prefill := strings.TrimRightFunc(partial, unicode.IsSpace)
+	if prefill == "" {
+		return req, false
+	}
+
+	messages := make([]Message, len(req.Messages), len(req.Messages)+1)
+	copy(messages, req.Messages)
+	req.Messages = append(messages, Message{Role: RoleAssistant, Content: prefill})
+	return req, len(prefill) < len(partial)
+}
+
+// wait sleeps before the next attempt after err. It returns err itself if
+// the server asked for a longer delay than MaxRetryAfter.
+func (r *RetryableClient) wait(ctx context.Context, attempt int, err error) error {
+	delay, ok := r.retryDelay(attempt, err)
+	if !ok {
+		return err
+	}
+
+	select {
+	case <-time.After(delay):
+		// Continue to next attempt
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryDelay returns how long to wait before retrying after err: the
+// delay the server asked for, the time until an exhausted rate limit
+// resets, or otherwise a jittered backoff. ok is false if the server's
+// delay exceeds MaxRetryAfter.
+func (r *RetryableClient) retryDelay(attempt int, err error) (delay time.Duration, ok bool) {
+	var llmErr *LLMError
+	if errors.As(err, &llmErr) {
+		delay = llmErr.RetryAfter
+		if delay <= 0 {
+			delay = llmErr.RateLimit.ResetWait(time.Now())
+		}
+		if delay > 0 {
+			return delay, r.config.MaxRetryAfter <= 0 || delay <= r.config.MaxRetryAfter
+		}
+	}
+	return r.calculateBackoff(attempt), true
+}
+
 // isRetryable determines if an error should trigger a retry
 func (r *RetryableClient) isRetryable(err error) bool {
 	if err == nil {
@@ -127,7 +239,19 @@ func (r *RetryableClient) isRetryable(err error) bool {
 }
 
 // calculateBackoff calculates the backoff duration for a given attempt
+// with full jitter: a random duration up to the exponential ceiling, so
+// clients that failed together do not retry together
 func (r *RetryableClient) calculateBackoff(attempt int) time.Duration {
+	ceiling := r.backoffCeiling(attempt)
+	if r.jitter == nil {
+		return fullJitter(ceiling)
+	}
+	return r.jitter(ceiling)
+}
+
+// backoffCeiling returns the exponential backoff for attempt, capped at
+// MaxBackoff
+func (r *RetryableClient) backoffCeiling(attempt int) time.Duration {
 	backoff := float64(r.config.InitialBackoff) * math.Pow(r.config.Multiplier, float64(attempt))
 	if backoff > float64(r.config.MaxBackoff) {
 		backoff = float64(r.config.MaxBackoff)
@@ -135,6 +259,14 @@ func (r *RetryableClient) calculateBackoff(attempt int) time.Duration {
 	return time.Duration(backoff)
 }
 
+// fullJitter returns a random duration in [0, ceiling]
+func fullJitter(ceiling time.Duration) time.Duration {
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
 // Provider returns the provider type
 func (r *RetryableClient) Provider() ProviderType {
 	return r.client.Provider()
@@ -0,0 +1,384 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/tokenizer"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+	"go.yaml.in/yaml/v3"
+)
+
+// scriptedModel is the model name reported when a script does not set one
+const scriptedModel = "scripted"
+
+// Script is the sequence of turns a ScriptedClient plays. It is read from
+// YAML or JSON:
+//
+//	model: demo
+//	turns:
+//	  - match: "(?i)readme"
+//	    reasoning: The user wants a summary, so I need the file first.
+//	    content: Let me read it.
+//	    tool_calls:
+//	      - name: read_file
+//	        arguments: {path: README.md}
+//	  - content: The README describes the project.
+//	  - match: "(?i)fail"
+//	    error: {type: rate_limit, message: simulated rate limit}
+//	fallback:
+//	  content: Try asking about the README.
+type Script struct {
+	Model string `yaml:"model"`
+
+	// ChunkDelayMs paces streamed words so demos look live
+	ChunkDelayMs int `yaml:"chunk_delay_ms"`
+
+	Turns []ScriptTurn `yaml:"turns"`
+
+	// Fallback answers requests no turn applies to; without it they fail
+	Fallback *ScriptTurn `yaml:"fallback"`
+}
+
+// ScriptTurn is one scripted assistant reply or simulated error
+type ScriptTurn struct {
+	// Match is a regular expression on the user's message. A turn with a
+	// match is only played when a new user message matches it; turns
+	// without one follow the previous turn, e.g. after its tool calls.
+	Match string `yaml:"match"`
+
+	Content   string           `yaml:"content"`
+	Reasoning string           `yaml:"reasoning"` // Shown as the model's thinking
+	ToolCalls []ScriptToolCall `yaml:"tool_calls"`
+	Error     *ScriptError     `yaml:"error"`
+
+	match *regexp.Regexp
+}
+
+// ScriptToolCall is a tool call requested by a scripted turn
+type ScriptToolCall struct {
+	Name      string         `yaml:"name"`
+	Arguments map[string]any `yaml:"arguments"`
+}
+
+// ScriptError is a simulated failure, returned as an LLMError
+type ScriptError struct {
+	Type    ErrorType `yaml:"type"`
+	Message string    `yaml:"message"`
+	Code    int       `yaml:"code"`
+}
+
+// LoadScript reads a script from a YAML or JSON file
+func LoadScript(path string) (*Script, error) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: fmt.Sprintf("failed to read script: %v", err),
+		}
+	}
+
+	// YAML is a superset of JSON, so one decoder reads both
+	var script Script
+	if err := yaml.Unmarshal(data, &script); err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: fmt.Sprintf("failed to parse script %s: %v", path, err),
+		}
+	}
+
+	if err := script.compile(); err != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: fmt.Sprintf("invalid script %s: %v", path, err),
+		}
+	}
+	return &script, nil
+}
+
+// compile prepares the match expressions and checks the turns
+func (s *Script) compile() error {
+	if len(s.Turns) == 0 && s.Fallback == nil {
+		return fmt.Errorf("script has no turns")
+	}
+
+	turns := make([]*ScriptTurn, 0, len(s.Turns)+1)
+	for i := range s.Turns {
+		turns = append(turns, &s.Turns[i])
+	}
+	if s.Fallback != nil {
+		turns = append(turns, s.Fallback)
+	}
+
+	for i, turn := range turns {
+		if turn.Match != "" {
+			re, err := regexp.Compile(turn.Match)
+			if err != nil {
+				return fmt.Errorf("turn %d: invalid match: %w", i+1, err)
+			}
+			turn.match = re
+		}
+		if turn.Error != nil {
+			if _, err := ParseErrorType(string(turn.Error.Type)); err != nil {
+				return fmt.Errorf("turn %d: %w", i+1, err)
+			}
+		}
+		for j, call := range turn.ToolCalls {
+			if call.Name == "" {
+				return fmt.Errorf("turn %d: tool call %d has no name", i+1, j+1)
+			}
+		}
+	}
+	return nil
+}
+
+// ScriptedClient implements the Client interface by playing a Script. It
+// needs no API key or network, for demos and end-to-end tests.
+type ScriptedClient struct {
+	config ClientConfig
+	script *Script
+
+	mu   sync.Mutex
+	next int // Index of the turn that follows the last one played
+}
+
+// NewScriptedClient creates a client that plays the script at config.Script
+func NewScriptedClient(config ClientConfig) (*ScriptedClient, error) {
+	if config.Script == "" {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidRequest,
+			Message: "scripted provider needs a script file (set script in the config)",
+		}
+	}
+
+	script, err := LoadScript(config.Script)
+	if err != nil {
+		return nil, err
+	}
+	return NewScriptedClientFromScript(config, script), nil
+}
+
+// NewScriptedClientFromScript creates a client that plays script
+func NewScriptedClientFromScript(config ClientConfig, script *Script) *ScriptedClient {
+	if script.Model != "" {
+		config.Model = script.Model
+	}
+	if config.Model == "" {
+		config.Model = scriptedModel
+	}
+	return &ScriptedClient{config: config, script: script}
+}
+
+// Complete plays the next turn
+func (c *ScriptedClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	return completeStructured(ctx, req, c.complete)
+}
+
+// complete plays one turn
+func (c *ScriptedClient) complete(ctx context.Context, req Request) (*Response, error) {
+	turn, err := c.turnFor(req)
+	if err != nil {
+		return nil, err
+	}
+	if turn.Error != nil {
+		return nil, turn.Error.llmError()
+	}
+	return c.response(req, turn), nil
+}
+
+// Stream plays the next turn word by word
+func (c *ScriptedClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	if req.ResponseSchema != nil {
+		return streamStructured(ctx, req, c.Complete, callback)
+	}
+
+	turn, err := c.turnFor(req)
+	if err != nil {
+		return err
+	}
+	if turn.Error != nil {
+		return turn.Error.llmError()
+	}
+
+	resp := c.response(req, turn)
+	delay := time.Duration(c.script.ChunkDelayMs) * time.Millisecond
+
+	// words streams text word by word through event
+	words := func(text string, event func(word string) StreamEvent) error {
+		for _, word := range strings.SplitAfter(text, " ") {
+			if word == "" {
+				continue
+			}
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			callback(event(word))
+		}
+		return nil
+	}
+
+	if err := words(turn.Reasoning, func(word string) StreamEvent {
+		return StreamEvent{Reasoning: word, Timestamp: time.Now()}
+	}); err != nil {
+		return err
+	}
+	for i := range resp.Reasoning {
+		callback(StreamEvent{ReasoningBlock: &resp.Reasoning[i], Timestamp: time.Now()})
+	}
+	if err := words(resp.Content, func(word string) StreamEvent {
+		return StreamEvent{Delta: word, Timestamp: time.Now()}
+	}); err != nil {
+		return err
+	}
+	for i := range resp.ToolCalls {
+		callback(StreamEvent{ToolCall: &resp.ToolCalls[i], Timestamp: time.Now()})
+	}
+
+	callback(StreamEvent{
+		Done:         true,
+		Usage:        &resp.Usage,
+		FinishReason: resp.FinishReason,
+		Timestamp:    time.Now(),
+	})
+	return nil
+}
+
+// turnFor picks the turn that answers req. A new user message that
+// matches a turn's expression jumps to that turn; otherwise the turn after
+// the last one played follows, unless it starts a new matched section.
+func (c *ScriptedClient) turnFor(req Request) (*ScriptTurn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	turns := c.script.Turns
+	if text, ok := newUserMessage(req); ok {
+		for offset := range turns {
+			i := (c.next + offset) % len(turns)
+			if turns[i].match != nil && turns[i].match.MatchString(text) {
+				c.next = i + 1
+				return &turns[i], nil
+			}
+		}
+	}
+
+	if c.next < len(turns) && turns[c.next].match == nil {
+		c.next++
+		return &turns[c.next-1], nil
+	}
+
+	if c.script.Fallback != nil {
+		return c.script.Fallback, nil
+	}
+	return nil, &LLMError{
+		Type:    ErrorTypeInvalidRequest,
+		Message: "script has no turn for this request",
+	}
+}
+
+// newUserMessage returns the text of the last message if it is a message
+// from the user rather than tool results
+func newUserMessage(req Request) (string, bool) {
+	if len(req.Messages) == 0 {
+		return "", false
+	}
+	last := req.Messages[len(req.Messages)-1]
+	if last.Role != RoleUser || len(last.ToolResults) > 0 {
+		return "", false
+	}
+	return last.Content, true
+}
+
+// response builds the reply for a turn, with usage counted as if a real
+// model had read the request
+func (c *ScriptedClient) response(req Request, turn *ScriptTurn) *Response {
+	resp := &Response{
+		Content:      turn.Content,
+		Role:         RoleAssistant,
+		FinishReason: "end_turn",
+		Model:        c.config.Model,
+	}
+	if turn.Reasoning != "" {
+		resp.Reasoning = []ReasoningBlock{{Provider: ProviderScripted, Text: turn.Reasoning}}
+	}
+
+	for _, call := range turn.ToolCalls {
+		resp.ToolCalls = append(resp.ToolCalls, schema.ToolCall{
+			ID:        fmt.Sprintf("call_%d", toolCallCounter.Add(1)),
+			Name:      call.Name,
+			Arguments: jsonArguments(call.Arguments),
+		})
+	}
+	if len(resp.ToolCalls) > 0 {
+		resp.FinishReason = "tool_use"
+	}
+
+	prompt := req.SystemPrompt
+	for _, msg := range req.Messages {
+		prompt += msg.Content
+		for _, result := range msg.ToolResults {
+			prompt += result.Content
+		}
+	}
+	resp.Usage = Usage{
+		PromptTokens:     c.CountTokens(prompt),
+		CompletionTokens: c.CountTokens(resp.Content),
+	}
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens + resp.Usage.CompletionTokens
+
+	return resp
+}
+
+// jsonArguments converts arguments decoded from YAML to the types a
+// provider's JSON would have, e.g. float64 for numbers
+func jsonArguments(args map[string]any) map[string]any {
+	converted := map[string]any{}
+	if data, err := json.Marshal(args); err == nil {
+		json.Unmarshal(data, &converted)
+	}
+	return converted
+}
+
+func (e *ScriptError) llmError() *LLMError {
+	message := e.Message
+	if message == "" {
+		message = fmt.Sprintf("simulated %s error", e.Type)
+	}
+	return &LLMError{
+		Type:    e.Type,
+		Message: message,
+		Code:    e.Code,
+		Details: "scripted",
+	}
+}
+
+// Provider returns the provider type
+func (c *ScriptedClient) Provider() ProviderType {
+	return ProviderScripted
+}
+
+// Model returns the script's model name
+func (c *ScriptedClient) Model() string {
+	return c.config.Model
+}
+
+// CountTokens estimates the number of tokens in a string
+func (c *ScriptedClient) CountTokens(text string) int {
+	return tokenizer.ForModel(c.config.Model).Count(text)
+}
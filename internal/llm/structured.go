@@ -0,0 +1,398 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ResponseSchema asks for an answer in JSON matching a JSON Schema.
+// OpenAI-compatible APIs and Ollama constrain their output to the schema,
+// Gemini is asked for JSON with it, and Anthropic is made to answer by
+// calling a tool that takes it. The answer is validated locally in every
+// case and parsed into Response.Parsed; an answer that does not match
+// gets one repair attempt.
+type ResponseSchema struct {
+	Name        string         // Names the answer, e.g. "plan"; letters, digits, _ and -
+	Description string         // What the answer is for
+	Schema      map[string]any // The JSON Schema the answer must match
+}
+
+// defaultSchemaName names answers whose schema has no name
+const defaultSchemaName = "response"
+
+// wrappedSchemaProperty holds an answer that is not an object where only
+// objects are accepted, such as Anthropic tool input
+const wrappedSchemaProperty = "value"
+
+// repairPrompt asks the model to correct an answer that did not match
+// the schema
+const repairPrompt = "Your answer did not match the required JSON schema: %v\nReply again with only the corrected JSON."
+
+// name returns the schema's name
+func (s *ResponseSchema) name() string {
+	if s.Name == "" {
+		return defaultSchemaName
+	}
+	return s.Name
+}
+
+// isObject reports whether the schema describes a JSON object
+func (s *ResponseSchema) isObject() bool {
+	t, _ := s.Schema["type"].(string)
+	return t == "object"
+}
+
+// objectSchema returns the schema as one for an object: unchanged if it
+// describes one, otherwise wrapped in a required property
+func (s *ResponseSchema) objectSchema() map[string]any {
+	if s.isObject() {
+		return s.Schema
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": map[string]any{wrappedSchemaProperty: s.Schema},
+		"required":   []string{wrappedSchemaProperty},
+	}
+}
+
+// takeToolCall moves the tool call that carries the answer, if the model
+// answered with one, out of resp's tool calls and into its content
+func (s *ResponseSchema) takeToolCall(resp *Response) {
+	for i, call := range resp.ToolCalls {
+		if call.Name != s.name() {
+			continue
+		}
+
+		var answer any = call.Arguments
+		if !s.isObject() {
+			answer = call.Arguments[wrappedSchemaProperty]
+		}
+		if data, err := json.Marshal(answer); err == nil {
+			resp.Content = string(data)
+		}
+		resp.ToolCalls = slices.Delete(slices.Clone(resp.ToolCalls), i, i+1)
+		if len(resp.ToolCalls) == 0 {
+			resp.ToolCalls = nil
+		}
+		return
+	}
+}
+
+// parse decodes an answer and validates it against the schema. Code
+// fences around the JSON, which some models add, are ignored.
+func (s *ResponseSchema) parse(content string) (any, error) {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "```") {
+		content = strings.TrimPrefix(content, "```json")
+		content = strings.TrimPrefix(content, "```")
+		content = strings.TrimSuffix(content, "```")
+		content = strings.TrimSpace(content)
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		return nil, fmt.Errorf("the answer is not valid JSON: %w", err)
+	}
+	if err := validateJSON(s.Schema, value, "$"); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// completeStructured sends req with complete and, when it has a response
+// schema, validates and parses the answer. An answer that does not match
+// is sent back once with what is wrong with it; the returned response
+// then counts the usage of both calls.
+func completeStructured(ctx context.Context, req Request, complete func(context.Context, Request) (*Response, error)) (*Response, error) {
+	resp, err := complete(ctx, req)
+	if err != nil || req.ResponseSchema == nil {
+		return resp, err
+	}
+
+	schema := req.ResponseSchema
+	schema.takeToolCall(resp)
+	parsed, invalid := schema.parse(resp.Content)
+	if invalid == nil {
+		resp.Parsed = parsed
+		return resp, nil
+	}
+
+	repair := req
+	repair.Messages = append(slices.Clone(req.Messages),
+		Message{Role: RoleAssistant, Content: resp.Content},
+		Message{Role: RoleUser, Content: fmt.Sprintf(repairPrompt, invalid)},
+	)
+	repaired, err := complete(ctx, repair)
+	if err != nil {
+		return nil, err
+	}
+	repaired.Usage = Usage{
+		PromptTokens:     resp.Usage.PromptTokens + repaired.Usage.PromptTokens,
+		CompletionTokens: resp.Usage.CompletionTokens + repaired.Usage.CompletionTokens,
+		TotalTokens:      resp.Usage.TotalTokens + repaired.Usage.TotalTokens,
+		CacheReadTokens:  resp.Usage.CacheReadTokens + repaired.Usage.CacheReadTokens,
+		CacheWriteTokens: resp.Usage.CacheWriteTokens + repaired.Usage.CacheWriteTokens,
+	}
+
+	schema.takeToolCall(repaired)
+	parsed, invalid = schema.parse(repaired.Content)
+	if invalid != nil {
+		return nil, &LLMError{
+			Type:    ErrorTypeInvalidResponse,
+			Message: fmt.Sprintf("the answer does not match the %s schema: %v", schema.name(), invalid),
+			Details: repaired.Content,
+		}
+	}
+	repaired.Parsed = parsed
+	return repaired, nil
+}
+
+// streamStructured answers a request with a response schema through the
+// stream callback. The answer must be complete to be validated, so it is
+// requested with complete and delivered as a single delta.
+func streamStructured(ctx context.Context, req Request, complete func(context.Context, Request) (*Response, error), callback StreamCallback) error {
+	resp, err := complete(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.Content != "" {
+		callback(StreamEvent{Delta: resp.Content, Timestamp: time.Now()})
+	}
+	callback(StreamEvent{
+		Done:         true,
+		Usage:        &resp.Usage,
+		FinishReason: resp.FinishReason,
+		Timestamp:    time.Now(),
+	})
+	return nil
+}
+
+// validateJSON checks a decoded JSON value against a JSON Schema. It
+// supports the keywords structured output schemas use: type, enum,
+// const, anyOf, properties, required, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, minimum and maximum. Other
+// keywords are ignored. The error names the path of the first mismatch,
+// e.g. "$.steps[0].title".
+func validateJSON(schema map[string]any, value any, path string) error {
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		if !slices.ContainsFunc(types, func(t string) bool { return jsonTypeMatches(t, value) }) {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonTypeName(value))
+		}
+	}
+
+	if enum := schemaValues(schema["enum"]); enum != nil {
+		if !slices.ContainsFunc(enum, func(allowed any) bool { return jsonEqual(allowed, value) }) {
+			return fmt.Errorf("%s: %s is not one of the allowed values", path, jsonString(value))
+		}
+	}
+	if constant, ok := schema["const"]; ok && !jsonEqual(constant, value) {
+		return fmt.Errorf("%s: expected %s", path, jsonString(constant))
+	}
+
+	if anyOf := schemaValues(schema["anyOf"]); anyOf != nil {
+		matched := slices.ContainsFunc(anyOf, func(option any) bool {
+			sub, ok := option.(map[string]any)
+			return ok && validateJSON(sub, value, path) == nil
+		})
+		if !matched {
+			return fmt.Errorf("%s: matches none of the allowed schemas", path)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		properties, _ := schema["properties"].(map[string]any)
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			if sub, ok := properties[key].(map[string]any); ok {
+				if err := validateJSON(sub, v[key], path+"."+key); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, ok := properties[key]; ok {
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					return fmt.Errorf("%s: unexpected property %q", path, key)
+				}
+			case map[string]any:
+				if err := validateJSON(additional, v[key], path+"."+key); err != nil {
+					return err
+				}
+			}
+		}
+
+	case []any:
+		if limit, ok := schemaNumber(schema["minItems"]); ok && float64(len(v)) < limit {
+			return fmt.Errorf("%s: expected at least %v items, got %d", path, limit, len(v))
+		}
+		if limit, ok := schemaNumber(schema["maxItems"]); ok && float64(len(v)) > limit {
+			return fmt.Errorf("%s: expected at most %v items, got %d", path, limit, len(v))
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				if err := validateJSON(items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case string:
+		length := float64(utf8.RuneCountInString(v))
+		if limit, ok := schemaNumber(schema["minLength"]); ok && length < limit {
+			return fmt.Errorf("%s: expected at least %v characters", path, limit)
+		}
+		if limit, ok := schemaNumber(schema["maxLength"]); ok && length > limit {
+			return fmt.Errorf("%s: expected at most %v characters", path, limit)
+		}
+
+	case float64:
+		if limit, ok := schemaNumber(schema["minimum"]); ok && v < limit {
+			return fmt.Errorf("%s: %v is less than the minimum %v", path, v, limit)
+		}
+		if limit, ok := schemaNumber(schema["maximum"]); ok && v > limit {
+			return fmt.Errorf("%s: %v is more than the maximum %v", path, v, limit)
+		}
+	}
+
+	return nil
+}
+
+// jsonTypeMatches reports whether a decoded JSON value has a JSON Schema
+// type
+func jsonTypeMatches(t string, value any) bool {
+	switch t {
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true // Unknown types are not checked
+}
+
+// jsonTypeName names the JSON type of a decoded value
+func jsonTypeName(value any) string {
+	switch value.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// jsonEqual compares a value from a schema with a decoded value, after
+// putting both in decoded form so that, e.g., 1 equals 1.0
+func jsonEqual(a, b any) bool {
+	var decoded [2]any
+	for i, v := range []any{a, b} {
+		data, err := json.Marshal(v)
+		if err != nil || json.Unmarshal(data, &decoded[i]) != nil {
+			return false
+		}
+	}
+	return reflect.DeepEqual(decoded[0], decoded[1])
+}
+
+// jsonString formats a value as JSON for an error message
+func jsonString(value any) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// schemaTypes returns the types a schema's type keyword allows
+func schemaTypes(keyword any) []string {
+	if t, ok := keyword.(string); ok {
+		return []string{t}
+	}
+	return schemaStrings(keyword)
+}
+
+// schemaValues returns a keyword's list, whether the schema was decoded
+// from JSON or written in Go; nil if the keyword is not a list
+func schemaValues(keyword any) []any {
+	if list, ok := keyword.([]any); ok {
+		return list
+	}
+	v := reflect.ValueOf(keyword)
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+	list := make([]any, v.Len())
+	for i := range list {
+		list[i] = v.Index(i).Interface()
+	}
+	return list
+}
+
+// schemaStrings returns the strings in a keyword's list
+func schemaStrings(keyword any) []string {
+	var strs []string
+	for _, item := range schemaValues(keyword) {
+		if s, ok := item.(string); ok {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// schemaNumber returns a numeric keyword, whether the schema was decoded
+// from JSON or written in Go
+func schemaNumber(keyword any) (float64, bool) {
+	switch n := keyword.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -12,6 +12,8 @@ type TokenTracker struct {
 	totalPromptTokens     int
 	totalCompletionTokens int
 	totalTokens           int
+	totalCacheReadTokens  int
+	totalCacheWriteTokens int
 	requestCount          int
 	sessionStart          time.Time
 }
@@ -31,6 +33,8 @@ func (t *TokenTracker) AddUsage(usage Usage) {
 	t.totalPromptTokens += usage.PromptTokens
 	t.totalCompletionTokens += usage.CompletionTokens
 	t.totalTokens += usage.TotalTokens
+	t.totalCacheReadTokens += usage.CacheReadTokens
+	t.totalCacheWriteTokens += usage.CacheWriteTokens
 	t.requestCount++
 }
 
@@ -43,6 +47,8 @@ func (t *TokenTracker) GetStats() TokenStats {
 		TotalPromptTokens:     t.totalPromptTokens,
 		TotalCompletionTokens: t.totalCompletionTokens,
 		TotalTokens:           t.totalTokens,
+		TotalCacheReadTokens:  t.totalCacheReadTokens,
+		TotalCacheWriteTokens: t.totalCacheWriteTokens,
 		RequestCount:          t.requestCount,
 		SessionDuration:       time.Since(t.sessionStart),
 	}
@@ -56,51 +62,45 @@ func (t *TokenTracker) Reset() {
 	t.totalPromptTokens = 0
 	t.totalCompletionTokens = 0
 	t.totalTokens = 0
+	t.totalCacheReadTokens = 0
+	t.totalCacheWriteTokens = 0
 	t.requestCount = 0
 	t.sessionStart = time.Now()
 }
 
 // TokenStats holds token usage statistics
 type TokenStats struct {
-	TotalPromptTokens     int
+	TotalPromptTokens     int // Including cached prompt tokens
 	TotalCompletionTokens int
 	TotalTokens           int
+	TotalCacheReadTokens  int
+	TotalCacheWriteTokens int
 	RequestCount          int
 	SessionDuration       time.Duration
 }
 
+// CacheHitRate returns the fraction of prompt tokens read from the
+// provider's prompt cache
+func (s TokenStats) CacheHitRate() float64 {
+	if s.TotalPromptTokens == 0 {
+		return 0
+	}
+	return float64(s.TotalCacheReadTokens) / float64(s.TotalPromptTokens)
+}
+
 // EstimatedCost estimates the cost based on model pricing
-// Returns cost in USD (approximate)
+// Returns cost in USD (approximate), 0 for models without a price
 func (s TokenStats) EstimatedCost(model string) float64 {
-	// Approximate pricing (as of 2026)
-	// These would ideally come from a configuration file
-	var inputCostPer1M, outputCostPer1M float64
-
-	switch model {
-	case "claude-sonnet-4-5", "claude-sonnet-4", "claude-sonnet-3-5":
-		inputCostPer1M = 3.00
-		outputCostPer1M = 15.00
-	case "claude-opus-4-5", "claude-opus-4":
-		inputCostPer1M = 15.00
-		outputCostPer1M = 75.00
-	case "claude-haiku-4", "claude-haiku-3-5":
-		inputCostPer1M = 0.25
-		outputCostPer1M = 1.25
-	case "gpt-4-turbo", "gpt-4":
-		inputCostPer1M = 10.00
-		outputCostPer1M = 30.00
-	case "gpt-3.5-turbo":
-		inputCostPer1M = 0.50
-		outputCostPer1M = 1.50
-	default:
-		// Unknown model, return 0
+	info, ok := DefaultCatalog().Lookup(model)
+	if !ok {
 		return 0
 	}
-
-	inputCost := (float64(s.TotalPromptTokens) / 1_000_000) * inputCostPer1M
-	outputCost := (float64(s.TotalCompletionTokens) / 1_000_000) * outputCostPer1M
-
-	return inputCost + outputCost
+	return info.Price.Cost(Usage{
+		PromptTokens:     s.TotalPromptTokens,
+		CompletionTokens: s.TotalCompletionTokens,
+		CacheReadTokens:  s.TotalCacheReadTokens,
+		CacheWriteTokens: s.TotalCacheWriteTokens,
+	})
 }
 
 // FormatStats returns a human-readable string of the stats
@@ -0,0 +1,42 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+)
+
+// toolCallCounter numbers tool calls for providers that do not always
+// identify them, so results can be matched to their calls
+var toolCallCounter atomic.Uint64
+
+// encodeToolArguments marshals tool call arguments for the wire.
+// Providers require an object, so nil arguments encode as {}.
+func encodeToolArguments(args map[string]any) json.RawMessage {
+	if len(args) == 0 {
+		return json.RawMessage("{}")
+	}
+
+	data, err := json.Marshal(args)
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+	return data
+}
+
+// decodeToolArguments parses tool call arguments returned by a provider.
+// Malformed or empty input yields an empty argument map so the tool
+// reports its own missing-parameter error.
+func decodeToolArguments(raw string) map[string]any {
+	args := make(map[string]any)
+
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return args
+	}
+
+	if err := json.Unmarshal([]byte(raw), &args); err != nil {
+		return make(map[string]any)
+	}
+	return args
+}
@@ -0,0 +1,421 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+// TraceKind is the kind of a trace event
+type TraceKind string
+
+const (
+	TraceRequest    TraceKind = "request"     // A request sent to the LLM
+	TraceResponse   TraceKind = "response"    // A completed call: the reply, or the end of a stream
+	TraceStream     TraceKind = "stream"      // One event of a streamed reply
+	TraceError      TraceKind = "error"       // A call that failed
+	TraceToolCall   TraceKind = "tool_call"   // A tool call about to run
+	TraceToolResult TraceKind = "tool_result" // The outcome of a tool call
+)
+
+// TraceEvent is one line of a trace
+type TraceEvent struct {
+	Time       time.Time    `json:"time"`
+	Kind       TraceKind    `json:"kind"`
+	Call       int          `json:"call,omitempty"` // Numbers an LLM call; its request, stream events and response share it
+	Provider   ProviderType `json:"provider,omitempty"`
+	Model      string       `json:"model,omitempty"`
+	DurationMs int64        `json:"duration_ms,omitempty"` // Since the request, or since a tool call started
+
+	// Requests
+	System  string   `json:"system,omitempty"`
+	Request *Request `json:"request,omitempty"`
+
+	// Replies and stream events
+	Content      string            `json:"content,omitempty"`
+	Reasoning    string            `json:"reasoning,omitempty"`
+	ToolCalls    []schema.ToolCall `json:"tool_calls,omitempty"`
+	Done         bool              `json:"done,omitempty"`
+	FinishReason string            `json:"finish_reason,omitempty"`
+
+	// Token usage, on responses
+	InputTokens      int `json:"input_tokens,omitempty"`
+	OutputTokens     int `json:"output_tokens,omitempty"`
+	CacheReadTokens  int `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
+
+	// Tool calls and results
+	ToolCall *schema.ToolCall `json:"tool_call,omitempty"`
+	Result   string           `json:"result,omitempty"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// Usage returns the event's token counts
+func (e TraceEvent) Usage() Usage {
+	return Usage{
+		PromptTokens:     e.InputTokens,
+		CompletionTokens: e.OutputTokens,
+		TotalTokens:      e.InputTokens + e.OutputTokens,
+		CacheReadTokens:  e.CacheReadTokens,
+		CacheWriteTokens: e.CacheWriteTokens,
+	}
+}
+
+// setUsage copies usage into the event
+func (e *TraceEvent) setUsage(usage Usage) {
+	e.InputTokens = usage.PromptTokens
+	e.OutputTokens = usage.CompletionTokens
+	e.CacheReadTokens = usage.CacheReadTokens
+	e.CacheWriteTokens = usage.CacheWriteTokens
+}
+
+// secretPatterns match credentials that may turn up in prompts, tool
+// output or errors. Each match is replaced with redactedText, keeping the
+// groups named "keep" so the trace still shows what was redacted.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),        // Anthropic and OpenAI keys
+	regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{30,}`),       // Google API keys
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{30,}`),   // GitHub tokens
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{30,}`), // GitHub fine-grained tokens
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`), // Slack tokens
+	regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`),  // AWS access key IDs
+	regexp.MustCompile(`(?i)(?P<keep>bearer\s+)[A-Za-z0-9._~+/-]{8,}=*`),
+	regexp.MustCompile(`(?i)(?P<keep>\b[a-z_]*(?:api[_-]?key|secret|password|passwd|token)\b[\\"']*\s*[:=]\s*[\\"']*)[^\s"'\\,;]{6,}`),
+}
+
+// redactedText replaces a secret in a trace
+const redactedText = "[REDACTED]"
+
+// Tracer writes a session's LLM traffic and tool calls to a JSON Lines
+// file, with API keys and other secrets redacted. Write errors never fail
+// the session; they go to the error callback. The file stays open until
+// Close.
+type Tracer struct {
+	path    string
+	secrets []string
+	onError func(error)
+
+	mu        sync.Mutex
+	file      *os.File // Opened on the first write
+	calls     int
+	toolStart map[string]time.Time // When each running tool call started, by ID
+}
+
+// NewTracer creates a tracer writing to <session>.jsonl in dir, which is
+// created on first use. The secrets, such as the configured API keys, are
+// redacted wherever they appear, along with anything that looks like a
+// credential.
+func NewTracer(dir, session string, secrets ...string) *Tracer {
+	t := &Tracer{
+		path:      TracePath(dir, session),
+		toolStart: make(map[string]time.Time),
+	}
+	for _, secret := range secrets {
+		// Short values would redact ordinary text
+		if len(secret) >= 8 {
+			t.secrets = append(t.secrets, secret)
+		}
+	}
+	return t
+}
+
+// TraceDir returns the trace directory dir with a leading "~/" expanded
+func TraceDir(dir string) string {
+	if len(dir) >= 2 && dir[:2] == "~/" {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	return dir
+}
+
+// TracePath returns the trace file of session in dir
+func TracePath(dir, session string) string {
+	return filepath.Join(TraceDir(dir), session+".jsonl")
+}
+
+// Path returns the trace's file path
+func (t *Tracer) Path() string {
+	return t.path
+}
+
+// SetErrorCallback sets the function called when an event cannot be
+// written
+func (t *Tracer) SetErrorCallback(cb func(error)) {
+	t.onError = cb
+}
+
+// Record appends an event to the trace
+func (t *Tracer) Record(event TraceEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	if err := t.write(event); err != nil && t.onError != nil {
+		t.onError(err)
+	}
+}
+
+// RecordToolCall records a tool call about to run
+func (t *Tracer) RecordToolCall(call schema.ToolCall) {
+	now := time.Now()
+	t.mu.Lock()
+	t.toolStart[call.ID] = now
+	t.mu.Unlock()
+
+	t.Record(TraceEvent{Time: now, Kind: TraceToolCall, ToolCall: &call})
+}
+
+// RecordToolResult records the outcome of a tool call: its result, or the
+// error that kept it from running
+func (t *Tracer) RecordToolResult(call schema.ToolCall, result *schema.ToolResult, err error) {
+	now := time.Now()
+	event := TraceEvent{Time: now, Kind: TraceToolResult, ToolCall: &call}
+
+	t.mu.Lock()
+	if start, ok := t.toolStart[call.ID]; ok {
+		event.DurationMs = now.Sub(start).Milliseconds()
+		delete(t.toolStart, call.ID)
+	}
+	t.mu.Unlock()
+
+	switch {
+	case err != nil:
+		event.Error = err.Error()
+	case result != nil:
+		event.Result = result.Output
+		event.Error = result.Error
+	}
+	t.Record(event)
+}
+
+// nextCall numbers a new LLM call
+func (t *Tracer) nextCall() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.calls++
+	return t.calls
+}
+
+// redact replaces the secrets in a serialized event. The replacement has
+// no characters JSON escapes, so the line stays valid.
+func (t *Tracer) redact(line string) string {
+	for _, secret := range t.secrets {
+		if quoted, err := json.Marshal(secret); err == nil {
+			// Match the secret as it appears inside a JSON string
+			line = strings.ReplaceAll(line, string(quoted[1:len(quoted)-1]), redactedText)
+		}
+	}
+	for _, pattern := range secretPatterns {
+		replacement := redactedText
+		if pattern.SubexpIndex("keep") >= 0 {
+			replacement = "${keep}" + redactedText
+		}
+		line = pattern.ReplaceAllString(line, replacement)
+	}
+	return line
+}
+
+// write appends an event, redacted, to the trace file
+func (t *Tracer) write(event TraceEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace event: %w", err)
+	}
+	line := t.redact(string(data)) + "\n"
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		if err := os.MkdirAll(filepath.Dir(t.path), 0o700); err != nil {
+			return fmt.Errorf("failed to create trace directory: %w", err)
+		}
+		f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open trace: %w", err)
+		}
+		t.file = f
+	}
+
+	if _, err := t.file.WriteString(line); err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
+
+// Close closes the trace file. A later event opens it again.
+func (t *Tracer) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// ReadTrace returns the events of the trace at path in the order they
+// were written. Lines that cannot be parsed, such as one cut short by a
+// crash, are skipped.
+func ReadTrace(path string) ([]TraceEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace: %w", err)
+	}
+	defer f.Close()
+
+	var events []TraceEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			continue
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read trace: %w", err)
+	}
+	return events, nil
+}
+
+// TracingClient wraps a client and records every request it sends, every
+// stream event and every reply or error in a trace
+type TracingClient struct {
+	client Client
+	tracer *Tracer
+}
+
+// NewTracingClient creates a client that traces calls to tracer
+func NewTracingClient(client Client, tracer *Tracer) *TracingClient {
+	return &TracingClient{client: client, tracer: tracer}
+}
+
+// Complete sends the request and traces it and its reply
+func (c *TracingClient) Complete(ctx context.Context, req Request) (*Response, error) {
+	call, start := c.recordRequest(req)
+
+	resp, err := c.client.Complete(ctx, req)
+	if err != nil {
+		c.recordError(call, start, err)
+		return nil, err
+	}
+
+	event := c.event(TraceResponse, call, start)
+	if resp.Model != "" {
+		event.Model = resp.Model
+	}
+	event.Content = resp.Content
+	event.Reasoning = reasoningText(resp.Reasoning)
+	event.ToolCalls = resp.ToolCalls
+	event.FinishReason = resp.FinishReason
+	event.setUsage(resp.Usage)
+	c.tracer.Record(event)
+	return resp, nil
+}
+
+// Stream streams the request, tracing it and each event of the reply
+func (c *TracingClient) Stream(ctx context.Context, req Request, callback StreamCallback) error {
+	call, start := c.recordRequest(req)
+
+	finished := false
+	err := c.client.Stream(ctx, req, func(event StreamEvent) {
+		traced := c.event(TraceStream, call, start)
+		traced.Content = event.Delta
+		traced.Reasoning = event.Reasoning
+		if event.ToolCall != nil {
+			traced.ToolCalls = []schema.ToolCall{*event.ToolCall}
+		}
+		if event.Error != nil {
+			traced.Error = event.Error.Error()
+		}
+		if event.Done {
+			traced.Kind = TraceResponse
+			traced.Done = true
+			traced.FinishReason = event.FinishReason
+			if event.Usage != nil {
+				traced.setUsage(*event.Usage)
+			}
+			finished = true
+		}
+		c.tracer.Record(traced)
+		callback(event)
+	})
+	if err != nil && !finished {
+		c.recordError(call, start, err)
+	}
+	return err
+}
+
+// recordRequest traces a request, returning its call number and when it
+// was sent
+func (c *TracingClient) recordRequest(req Request) (int, time.Time) {
+	call := c.tracer.nextCall()
+	start := time.Now()
+
+	event := c.event(TraceRequest, call, start)
+	event.System = req.SystemPrompt
+	event.Request = &req
+	c.tracer.Record(event)
+	return call, start
+}
+
+// recordError traces a failed call
+func (c *TracingClient) recordError(call int, start time.Time, err error) {
+	event := c.event(TraceError, call, start)
+	event.Error = err.Error()
+	c.tracer.Record(event)
+}
+
+// event starts an event of call, timed from start
+func (c *TracingClient) event(kind TraceKind, call int, start time.Time) TraceEvent {
+	now := time.Now()
+	return TraceEvent{
+		Time:       now,
+		Kind:       kind,
+		Call:       call,
+		Provider:   c.client.Provider(),
+		Model:      c.client.Model(),
+		DurationMs: now.Sub(start).Milliseconds(),
+	}
+}
+
+// reasoningText joins the readable text of reasoning blocks
+func reasoningText(blocks []ReasoningBlock) string {
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Text != "" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// Provider returns the wrapped client's provider
+func (c *TracingClient) Provider() ProviderType {
+	return c.client.Provider()
+}
+
+// Model returns the wrapped client's model
+func (c *TracingClient) Model() string {
+	return c.client.Model()
+}
+
+// CountTokens counts tokens with the wrapped client
+func (c *TracingClient) CountTokens(text string) int {
+	return c.client.CountTokens(text)
+}
@@ -1,6 +1,10 @@
 package llm
 
-import "time"
+import (
+	"time"
+
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
 
 // Role represents the role of a message sender
 type Role string
@@ -13,8 +17,39 @@ const (
 
 // Message represents a conversation message
 type Message struct {
-	Role    Role   `json:"role"`
-	Content string `json:"content"`
+	Role        Role              `json:"role"`
+	Content     string            `json:"content"`
+	ToolCalls   []schema.ToolCall `json:"tool_calls,omitempty"`   // Tool uses requested by the assistant
+	ToolResults []ToolResult      `json:"tool_results,omitempty"` // Results of tool uses, sent back as a user turn
+	Reasoning   []ReasoningBlock  `json:"reasoning,omitempty"`    // The assistant's reasoning before it answered
+	Images      []Image           `json:"images,omitempty"`       // Images attached to a user message
+}
+
+// ReasoningBlock is a piece of a model's reasoning. Providers that sign
+// their reasoning need the signed blocks sent back unchanged with the tool
+// results that follow them; unsigned blocks are only shown to the user.
+type ReasoningBlock struct {
+	Provider   ProviderType `json:"provider,omitempty"`     // The provider that produced the block; others ignore it
+	Text       string       `json:"text,omitempty"`         // Readable reasoning, possibly summarized
+	Signature  string       `json:"signature,omitempty"`    // Provider signature over the block
+	Redacted   string       `json:"redacted,omitempty"`     // Encrypted reasoning the provider withheld from view
+	ToolCallID string       `json:"tool_call_id,omitempty"` // For Gemini, the tool call the signature belongs to
+}
+
+// ReasoningEffort is how much a reasoning model thinks before answering
+type ReasoningEffort string
+
+const (
+	ReasoningEffortLow    ReasoningEffort = "low"
+	ReasoningEffortMedium ReasoningEffort = "medium"
+	ReasoningEffortHigh   ReasoningEffort = "high"
+)
+
+// ToolResult is the outcome of a tool call, returned to the model
+type ToolResult struct {
+	ToolCallID string `json:"tool_call_id"`
+	Content    string `json:"content"`
+	IsError    bool   `json:"is_error,omitempty"`
 }
 
 // Tool represents a tool that can be called by the LLM
@@ -26,19 +61,32 @@ type Tool struct {
 
 // Request represents a request to an LLM
 type Request struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	Stream      bool      `json:"stream"`
-	Tools       []Tool    `json:"tools,omitempty"` // Tools available to the LLM
-	SystemPrompt string   `json:"-"` // Handled differently by providers
+	Model        string    `json:"model"`
+	Messages     []Message `json:"messages"`
+	MaxTokens    int       `json:"max_tokens,omitempty"`
+	Temperature  float64   `json:"temperature,omitempty"`
+	TopP         float64   `json:"top_p,omitempty"`
+	Stream       bool      `json:"stream"`
+	Tools        []Tool    `json:"tools,omitempty"` // Tools available to the LLM
+	SystemPrompt string    `json:"-"`               // Handled differently by providers
+	DisableCache bool      `json:"-"`               // Skip prompt caching, e.g. for one-off requests
+
+	// Extended thinking: providers that take a token budget use
+	// ThinkingBudget, those that take an effort level use ReasoningEffort,
+	// and each falls back to the other when only one is set (0 and "" = off)
+	ThinkingBudget  int             `json:"thinking_budget,omitempty"`
+	ReasoningEffort ReasoningEffort `json:"reasoning_effort,omitempty"`
+
+	// Answer in JSON matching a schema; see ResponseSchema
+	ResponseSchema *ResponseSchema `json:"response_schema,omitempty"`
 }
 
 // Response represents a response from an LLM
 type Response struct {
 	Content      string
+	ToolCalls    []schema.ToolCall // Tool uses requested by the model
+	Reasoning    []ReasoningBlock  // The model's reasoning, separate from Content
+	Parsed       any               // The validated answer to a request with a ResponseSchema
 	Role         Role
 	FinishReason string
 	Usage        Usage
@@ -48,19 +96,24 @@ type Response struct {
 
 // Usage represents token usage information
 type Usage struct {
-	PromptTokens     int
+	PromptTokens     int // All prompt tokens, including cached ones
 	CompletionTokens int
 	TotalTokens      int
+	CacheReadTokens  int // Prompt tokens read from the provider's prompt cache
+	CacheWriteTokens int // Prompt tokens written to the provider's prompt cache
 }
 
 // StreamEvent represents a streaming event
 type StreamEvent struct {
-	Delta        string    // The incremental text
-	Done         bool      // Whether the stream is complete
-	Error        error     // Error if any
-	Usage        *Usage    // Final usage (only on last event)
-	FinishReason string    // Reason for finishing (only on last event)
-	Timestamp    time.Time // When this event occurred
+	Delta          string           // The incremental text
+	Reasoning      string           // Incremental reasoning text, separate from Delta
+	ReasoningBlock *ReasoningBlock  // A completed reasoning block, with its signature
+	ToolCall       *schema.ToolCall // A completed tool call (emitted once its arguments are fully received)
+	Done           bool             // Whether the stream is complete
+	Error          error            // Error if any
+	Usage          *Usage           // Final usage (only on last event)
+	FinishReason   string           // Reason for finishing (only on last event)
+	Timestamp      time.Time        // When this event occurred
 }
 
 // StreamCallback is called for each streaming event
@@ -72,40 +125,52 @@ type ProviderType string
 const (
 	ProviderAnthropic ProviderType = "anthropic"
 	ProviderOpenAI    ProviderType = "openai"
-	ProviderLocal     ProviderType = "local" // Ollama, LM Studio, etc.
+	ProviderGemini    ProviderType = "gemini"
+	ProviderOllama    ProviderType = "ollama"   // Ollama's native API
+	ProviderLocal     ProviderType = "local"    // OpenAI-compatible local servers: LM Studio, llama.cpp, etc.
+	ProviderScripted  ProviderType = "scripted" // Replies from a script file, for demos and tests
 )
 
+// RequiresAPIKey reports whether the provider needs an API key
+func (p ProviderType) RequiresAPIKey() bool {
+	return p != ProviderOllama && p != ProviderLocal && p != ProviderScripted
+}
+
 // ClientConfig holds configuration for an LLM client
 type ClientConfig struct {
 	Provider    ProviderType
 	APIKey      string
-	BaseURL     string // For custom endpoints
+	BaseURL     string // For custom endpoints; the API root for Gemini
 	Model       string // Default model
 	MaxRetries  int    // Max retry attempts
 	Timeout     time.Duration
 	Temperature float64
 	MaxTokens   int
+	Script      string // Script file for the scripted provider
 }
 
 // Error types
 type ErrorType string
 
 const (
-	ErrorTypeAuth         ErrorType = "auth"          // Authentication failed
-	ErrorTypeRateLimit    ErrorType = "rate_limit"    // Rate limit exceeded
-	ErrorTypeInvalidRequest ErrorType = "invalid_request" // Invalid request
-	ErrorTypeTimeout      ErrorType = "timeout"       // Request timeout
-	ErrorTypeNetwork      ErrorType = "network"       // Network error
-	ErrorTypeServer       ErrorType = "server"        // Server error
-	ErrorTypeUnknown      ErrorType = "unknown"       // Unknown error
+	ErrorTypeAuth            ErrorType = "auth"             // Authentication failed
+	ErrorTypeRateLimit       ErrorType = "rate_limit"       // Rate limit exceeded
+	ErrorTypeInvalidRequest  ErrorType = "invalid_request"  // Invalid request
+	ErrorTypeTimeout         ErrorType = "timeout"          // Request timeout
+	ErrorTypeNetwork         ErrorType = "network"          // Network error
+	ErrorTypeServer          ErrorType = "server"           // Server error
+	ErrorTypeInvalidResponse ErrorType = "invalid_response" // Answer did not match the requested schema
+	ErrorTypeUnknown         ErrorType = "unknown"          // Unknown error
 )
 
 // LLMError represents an error from the LLM provider
 type LLMError struct {
-	Type    ErrorType
-	Message string
-	Code    int    // HTTP status code
-	Details string // Additional details
+	Type       ErrorType
+	Message    string
+	Code       int            // HTTP status code
+	Details    string         // Additional details
+	RetryAfter time.Duration  // Delay the server asked for before retrying (0 = none)
+	RateLimit  *RateLimitInfo // Rate limit headroom reported with the error, if any
 }
 
 func (e *LLMError) Error() string {
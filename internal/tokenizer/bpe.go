@@ -0,0 +1,148 @@
+package tokenizer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+)
+
+// Encoding is a byte-level BPE encoding in the style of OpenAI's tiktoken
+type Encoding struct {
+	name    string
+	ranks   map[string]int // Token bytes to rank; lower ranks merge first
+	pattern *regexp2.Regexp
+}
+
+// NewEncoding creates an encoding from merge ranks and the pre-tokenizer
+// pattern that splits text into pieces before merging. Every single byte
+// must have a rank so any input can be encoded.
+func NewEncoding(name string, ranks map[string]int, pattern string) (*Encoding, error) {
+	for b := 0; b < 256; b++ {
+		if _, ok := ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("encoding %s: no rank for byte 0x%02x", name, b)
+		}
+	}
+
+	re, err := regexp2.Compile(pattern, regexp2.None)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s: invalid pattern: %w", name, err)
+	}
+
+	return &Encoding{name: name, ranks: ranks, pattern: re}, nil
+}
+
+// ParseRanks reads merge ranks in tiktoken format: one base64-encoded
+// token and its rank per line
+func ParseRanks(r io.Reader) (map[string]int, error) {
+	ranks := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		token, rankText, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected token and rank", lineNum)
+		}
+
+		raw, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid token: %w", lineNum, err)
+		}
+
+		rank, err := strconv.Atoi(rankText)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid rank: %w", lineNum, err)
+		}
+
+		ranks[string(raw)] = rank
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
+
+// Name returns the encoding name, e.g. "cl100k_base"
+func (e *Encoding) Name() string {
+	return e.name
+}
+
+// Encode returns the token IDs for text. Special tokens are not
+// recognised; they are encoded as ordinary text.
+func (e *Encoding) Encode(text string) []int {
+	var tokens []int
+	e.eachPiece(text, func(piece string) {
+		tokens = e.encodePiece(piece, tokens)
+	})
+	return tokens
+}
+
+// Count returns the number of tokens in text
+func (e *Encoding) Count(text string) int {
+	count := 0
+	e.eachPiece(text, func(piece string) {
+		if _, ok := e.ranks[piece]; ok {
+			count++
+			return
+		}
+		count += len(e.merge(piece)) - 1
+	})
+	return count
+}
+
+// eachPiece calls fn for each pre-tokenized piece of text
+func (e *Encoding) eachPiece(text string, fn func(piece string)) {
+	match, err := e.pattern.FindStringMatch(text)
+	for err == nil && match != nil {
+		fn(match.String())
+		match, err = e.pattern.FindNextMatch(match)
+	}
+}
+
+// encodePiece appends the tokens for a single piece
+func (e *Encoding) encodePiece(piece string, tokens []int) []int {
+	if rank, ok := e.ranks[piece]; ok {
+		return append(tokens, rank)
+	}
+
+	bounds := e.merge(piece)
+	for i := 0; i < len(bounds)-1; i++ {
+		tokens = append(tokens, e.ranks[piece[bounds[i]:bounds[i+1]]])
+	}
+	return tokens
+}
+
+// merge applies byte pair merges to piece, always merging the adjacent
+// pair with the lowest rank first, and returns the token boundaries
+func (e *Encoding) merge(piece string) []int {
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(bounds)-2; i++ {
+			if rank, ok := e.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+
+	return bounds
+}
@@ -0,0 +1,118 @@
+package tokenizer
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// Estimator approximates a BPE tokenizer without a vocabulary. It splits
+// text into runs of letters, digits, spaces, line breaks and symbols and
+// charges each run by length, which tracks real tokenizers far better
+// than dividing the byte length by a constant: prose, code and non-Latin
+// scripts tokenize at very different rates.
+type Estimator struct {
+	LettersPerToken float64 // Letters merged into one token within a word
+	DigitsPerToken  float64 // Digits merged into one token
+	SymbolsPerToken float64 // Punctuation merged into one token within a run
+	SpacesPerToken  float64 // Indentation merged into one token
+}
+
+// Claude holds the weights used for Claude models, which have no public
+// tokenizer; they are tuned to come out near 3.5 to 4 characters per
+// token on English prose, with code and numbers costing more
+var Claude = Estimator{
+	LettersPerToken: 5,
+	DigitsPerToken:  3,
+	SymbolsPerToken: 2,
+	SpacesPerToken:  8,
+}
+
+// Generic is used for models with no known tokenizer
+var Generic = Estimator{
+	LettersPerToken: 6,
+	DigitsPerToken:  3,
+	SymbolsPerToken: 2,
+	SpacesPerToken:  8,
+}
+
+// charClass groups runes into runs that tokenize alike
+type charClass int
+
+const (
+	classLetter charClass = iota
+	classDigit
+	classSpace
+	classNewline
+	classSymbol
+	classWide // CJK and similar scripts, roughly one token per character
+)
+
+// classify returns the class of a rune
+func classify(r rune) charClass {
+	switch {
+	case r == '\n' || r == '\r':
+		return classNewline
+	case unicode.IsSpace(r):
+		return classSpace
+	case unicode.IsDigit(r):
+		return classDigit
+	case r >= 0x2E80 && unicode.IsLetter(r):
+		return classWide
+	case unicode.IsLetter(r) || unicode.IsMark(r):
+		return classLetter
+	default:
+		return classSymbol
+	}
+}
+
+// Count estimates the number of tokens in text
+func (e Estimator) Count(text string) int {
+	tokens := 0.0
+
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		class := classify(r)
+
+		// Measure the run of runes in the same class
+		runes := 1
+		for text = text[size:]; len(text) > 0; runes++ {
+			next, nextSize := utf8.DecodeRuneInString(text)
+			if classify(next) != class {
+				break
+			}
+			text = text[nextSize:]
+		}
+
+		switch class {
+		case classLetter:
+			tokens += ceilDiv(runes, e.LettersPerToken)
+		case classDigit:
+			tokens += ceilDiv(runes, e.DigitsPerToken)
+		case classSymbol:
+			tokens += ceilDiv(runes, e.SymbolsPerToken)
+		case classWide:
+			tokens += float64(runes)
+		case classNewline:
+			tokens++
+		case classSpace:
+			// A single space is absorbed by the following word
+			if runes > 1 {
+				tokens += ceilDiv(runes-1, e.SpacesPerToken)
+			}
+		}
+	}
+
+	return int(tokens)
+}
+
+// ceilDiv returns n/per rounded up, treating per <= 0 as one per token
+func ceilDiv(n int, per float64) float64 {
+	if per <= 0 {
+		return float64(n)
+	}
+	whole := float64(int(float64(n) / per))
+	if whole*per < float64(n) {
+		whole++
+	}
+	return whole
+}
@@ -0,0 +1,77 @@
+//go:build ignore
+
+// gen_vocab downloads the tiktoken vocabularies, checks them against
+// their published hashes and stores them gzipped under vocab/ so they
+// are embedded in the binary. Run it with go generate.
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// vocabularies maps each encoding to its download URL and SHA-256 hash
+var vocabularies = map[string]struct{ url, sha256 string }{
+	"cl100k_base": {
+		url:    "https://openaipublic.blob.core.windows.net/encodings/cl100k_base.tiktoken",
+		sha256: "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	},
+	"o200k_base": {
+		url:    "https://openaipublic.blob.core.windows.net/encodings/o200k_base.tiktoken",
+		sha256: "446a9538cb6c348e3516120d7c08b09f57c36495e2acfffe59a5bf8b0cfb1a2d",
+	},
+}
+
+func main() {
+	for name, vocab := range vocabularies {
+		if err := fetch(name, vocab.url, vocab.sha256); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("wrote vocab/%s.tiktoken.gz\n", name)
+	}
+}
+
+// fetch downloads a vocabulary, verifies it and writes it gzipped
+func fetch(name, url, want string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("hash mismatch: got %s, want %s", got, want)
+	}
+
+	file, err := os.Create(filepath.Join("vocab", name+".tiktoken.gz"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer, err := gzip.NewWriterLevel(file, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return err
+	}
+	return writer.Close()
+}
@@ -0,0 +1,139 @@
+// Package tokenizer counts tokens offline. OpenAI models are counted with
+// their BPE encodings once their vocabularies have been generated into
+// vocab/ (see vocab/README.md); until then, and for Claude and unknown
+// models, counts are a calibrated estimate.
+package tokenizer
+
+//go:generate go run gen_vocab.go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// Counter counts the tokens in a piece of text
+type Counter interface {
+	Count(text string) int
+}
+
+// Encoding names
+const (
+	CL100kBase = "cl100k_base"
+	O200kBase  = "o200k_base"
+)
+
+// ErrNoVocabulary is returned when an encoding's vocabulary is not embedded
+var ErrNoVocabulary = errors.New("vocabulary not embedded")
+
+// patterns are the pre-tokenizer patterns of the supported encodings
+var patterns = map[string]string{
+	CL100kBase: `(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+(?!\S)|\s+`,
+	O200kBase: strings.Join([]string{
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?`,
+		`[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?`,
+		`\p{N}{1,3}`,
+		` ?[^\s\p{L}\p{N}]+[\r\n/]*`,
+		`\s*[\r\n]+`,
+		`\s+(?!\S)`,
+		`\s+`,
+	}, "|"),
+}
+
+// vocabFS holds gzipped tiktoken vocabularies named <encoding>.tiktoken.gz,
+// fetched with go generate
+//
+//go:embed vocab
+var vocabFS embed.FS
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = make(map[string]*loadedEncoding)
+)
+
+// loadedEncoding caches the result of loading an encoding once
+type loadedEncoding struct {
+	encoding *Encoding
+	err      error
+}
+
+// Get returns the named encoding, loading its embedded vocabulary on first use
+func Get(name string) (*Encoding, error) {
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+
+	if loaded, ok := encodings[name]; ok {
+		return loaded.encoding, loaded.err
+	}
+
+	encoding, err := loadEncoding(name)
+	encodings[name] = &loadedEncoding{encoding: encoding, err: err}
+	return encoding, err
+}
+
+// loadEncoding reads an embedded vocabulary
+func loadEncoding(name string) (*Encoding, error) {
+	pattern, ok := patterns[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+
+	data, err := vocabFS.ReadFile("vocab/" + name + ".tiktoken.gz")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, ErrNoVocabulary)
+	} else if err != nil {
+		return nil, err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	defer reader.Close()
+
+	ranks, err := ParseRanks(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return NewEncoding(name, ranks, pattern)
+}
+
+// EncodingForModel returns the name of the encoding used by an OpenAI
+// model, or "" if the model is not known to use one
+func EncodingForModel(model string) string {
+	model = strings.ToLower(model)
+
+	switch {
+	case strings.HasPrefix(model, "gpt-4o"), strings.HasPrefix(model, "chatgpt-4o"),
+		strings.HasPrefix(model, "gpt-4.1"), strings.HasPrefix(model, "gpt-4.5"),
+		strings.HasPrefix(model, "gpt-5"), strings.HasPrefix(model, "o1"),
+		strings.HasPrefix(model, "o3"), strings.HasPrefix(model, "o4"):
+		return O200kBase
+	case strings.HasPrefix(model, "gpt-4"), strings.HasPrefix(model, "gpt-3.5"),
+		strings.HasPrefix(model, "text-embedding-"):
+		return CL100kBase
+	default:
+		return ""
+	}
+}
+
+// ForModel returns the most accurate counter available for a model: its
+// BPE encoding when the vocabulary is embedded, otherwise an estimator
+func ForModel(model string) Counter {
+	if name := EncodingForModel(model); name != "" {
+		if encoding, err := Get(name); err == nil {
+			return encoding
+		}
+	}
+
+	if strings.Contains(strings.ToLower(model), "claude") {
+		return Claude
+	}
+	return Generic
+}
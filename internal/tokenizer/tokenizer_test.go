@@ -0,0 +1,197 @@
+package tokenizer
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testRanks builds a vocabulary with every byte plus a few merges
+func testRanks() map[string]int {
+	ranks := make(map[string]int)
+	for b := 0; b < 256; b++ {
+		ranks[string([]byte{byte(b)})] = b
+	}
+	for i, merge := range []string{"he", "ll", "hell", "hello", " w"} {
+		ranks[merge] = 256 + i
+	}
+	return ranks
+}
+
+func TestEncodingEncode(t *testing.T) {
+	enc, err := NewEncoding("test", testRanks(), patterns[CL100kBase])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := enc.Encode("hello world")
+	want := []int{259, 260, 'o', 'r', 'l', 'd'}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Encode = %v, want %v", got, want)
+	}
+
+	// "hel" merges "he" first, leaving "l"
+	if got := enc.Encode("hel"); fmt.Sprint(got) != fmt.Sprint([]int{256, 'l'}) {
+		t.Errorf("Encode(hel) = %v", got)
+	}
+
+	for _, text := range []string{"hello world", "  indented\n\tcode();", "naïve 12345", ""} {
+		if count := enc.Count(text); count != len(enc.Encode(text)) {
+			t.Errorf("Count(%q) = %d, want %d", text, count, len(enc.Encode(text)))
+		}
+	}
+}
+
+func TestEncodingPatternsSplitPieces(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     []string
+	}{
+		{CL100kBase, "Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{CL100kBase, "I'll pay 12345", []string{"I", "'ll", " pay", " ", "123", "45"}},
+		{CL100kBase, "x\n\n  y", []string{"x", "\n\n", " ", " y"}},
+		{O200kBase, "Hello, world!", []string{"Hello", ",", " world", "!"}},
+		{O200kBase, "HTTPServer's", []string{"HTTPServer's"}},
+		{O200kBase, "path/to\nnext", []string{"path", "/to", "\n", "next"}},
+	}
+
+	for _, tt := range tests {
+		enc, err := NewEncoding(tt.encoding, testRanks(), patterns[tt.encoding])
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		var got []string
+		enc.eachPiece(tt.text, func(piece string) { got = append(got, piece) })
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("%s pieces of %q = %q, want %q", tt.encoding, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNewEncodingNeedsEveryByte(t *testing.T) {
+	ranks := testRanks()
+	delete(ranks, "\x00")
+
+	if _, err := NewEncoding("test", ranks, patterns[CL100kBase]); err == nil {
+		t.Error("expected an error for a vocabulary missing a byte")
+	}
+}
+
+func TestParseRanks(t *testing.T) {
+	input := fmt.Sprintf("%s 0\n%s 1\n\n", base64.StdEncoding.EncodeToString([]byte("a")), base64.StdEncoding.EncodeToString([]byte(" the")))
+
+	ranks, err := ParseRanks(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ranks) != 2 || ranks["a"] != 0 || ranks[" the"] != 1 {
+		t.Errorf("unexpected ranks %v", ranks)
+	}
+
+	for _, bad := range []string{"YQ==", "!!! 1", "YQ== x"} {
+		if _, err := ParseRanks(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error for %q", bad)
+		}
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	tests := map[string]string{
+		"gpt-4o-mini":            O200kBase,
+		"o3-mini":                O200kBase,
+		"gpt-4.1":                O200kBase,
+		"gpt-4-turbo":            CL100kBase,
+		"gpt-3.5-turbo":          CL100kBase,
+		"text-embedding-3-small": CL100kBase,
+		"claude-sonnet-4":        "",
+		"llama3":                 "",
+	}
+
+	for model, want := range tests {
+		if got := EncodingForModel(model); got != want {
+			t.Errorf("EncodingForModel(%q) = %q, want %q", model, got, want)
+		}
+	}
+}
+
+func TestForModelFallsBackToEstimators(t *testing.T) {
+	if ForModel("claude-sonnet-4") != Claude {
+		t.Error("Claude models should use the Claude estimator")
+	}
+	if ForModel("llama3") != Generic {
+		t.Error("Unknown models should use the generic estimator")
+	}
+
+	_, err := Get(CL100kBase)
+	switch {
+	case errors.Is(err, ErrNoVocabulary):
+		if ForModel("gpt-4") != Generic {
+			t.Error("Models without an embedded vocabulary should use the generic estimator")
+		}
+	case err == nil:
+		if enc, ok := ForModel("gpt-4").(*Encoding); !ok || enc.Name() != CL100kBase {
+			t.Error("gpt-4 should use cl100k_base")
+		}
+	default:
+		t.Fatalf("unexpected error loading %s: %v", CL100kBase, err)
+	}
+
+	if _, err := Get("nope"); err == nil {
+		t.Error("expected an error for an unknown encoding")
+	}
+}
+
+func TestForModelCountsGPT4o(t *testing.T) {
+	if _, err := Get(O200kBase); errors.Is(err, ErrNoVocabulary) {
+		t.Skipf("%v; run go generate ./internal/tokenizer", err)
+	} else if err != nil {
+		t.Fatalf("unexpected error loading %s: %v", O200kBase, err)
+	}
+
+	counter := ForModel("gpt-4o")
+	enc, ok := counter.(*Encoding)
+	if !ok || enc.Name() != O200kBase {
+		t.Fatalf("gpt-4o should use %s, got %T", O200kBase, counter)
+	}
+
+	want := []int{13225, 11, 2375, 0}
+	if got := enc.Encode("Hello, world!"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Encode(%q) = %v, want %v", "Hello, world!", got, want)
+	}
+	if got := counter.Count("Hello, world!"); got != len(want) {
+		t.Errorf("Count(%q) = %d, want %d", "Hello, world!", got, len(want))
+	}
+}
+
+func TestEstimatorCount(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"the cat sat", 3},
+		{"implementation", 3},
+		{"x := 42", 3},
+		{"\n\n", 1},
+		{"        return", 3},
+		{"日本語", 3},
+	}
+
+	for _, tt := range tests {
+		if got := Claude.Count(tt.text); got != tt.want {
+			t.Errorf("Claude.Count(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+
+	// Code costs more tokens per character than prose
+	prose := "The agent reads the repository and proposes a plan before it changes anything."
+	code := "func (c *Context) Add(m llm.Message) { c.tokens = append(c.tokens, c.count(m)) }"
+	proseRatio := float64(len(prose)) / float64(Claude.Count(prose))
+	codeRatio := float64(len(code)) / float64(Claude.Count(code))
+	if codeRatio >= proseRatio {
+		t.Errorf("expected code to tokenize denser than prose, got %.2f vs %.2f chars/token", codeRatio, proseRatio)
+	}
+}
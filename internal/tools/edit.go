@@ -0,0 +1,537 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
+)
+
+const (
+	// maxEditCandidates is how many near matches a failed edit reports
+	maxEditCandidates = 3
+
+	// minCandidateSimilarity is how alike text must be to old_string to be
+	// reported as a near match
+	minCandidateSimilarity = 0.5
+
+	// maxCandidateLines caps the lines shown of each near match
+	maxCandidateLines = 30
+)
+
+// fileEdit replaces exact text in a file
+type fileEdit struct {
+	OldString  string
+	NewString  string
+	ReplaceAll bool // Replace every occurrence instead of requiring one
+	StartLine  int  // Lines the text is expected in, 1-based and inclusive; 0 if not given
+	EndLine    int
+}
+
+// EditFileTool replaces exact text in a file, leaving the rest unchanged
+type EditFileTool struct {
+	BaseTool
+}
+
+// NewEditFileTool creates a new edit file tool
+func NewEditFileTool() *EditFileTool {
+	return &EditFileTool{
+		BaseTool: NewBaseTool(
+			"edit_file",
+			"Edit a file by replacing exact text. Each edit's old_string must match the file exactly, "+
+				"including whitespace and indentation, and occur once unless replace_all is set; "+
+				"include surrounding lines to make it unique. Edits refer to the file as it is now and must not overlap. "+
+				"Prefer this to write_file for changes to existing files.",
+			[]schema.ToolParameter{
+				{
+					Name:        "path",
+					Description: "Path to the file to edit",
+					Type:        "string",
+					Required:    true,
+				},
+				{
+					Name:        "edits",
+					Description: "Replacements to make in the file",
+					Type:        "array",
+					Required:    true,
+					Items: map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"old_string": map[string]any{
+								"type":        "string",
+								"description": "Exact text to replace",
+							},
+							"new_string": map[string]any{
+								"type":        "string",
+								"description": "Text to replace it with",
+							},
+							"replace_all": map[string]any{
+								"type":        "boolean",
+								"description": "Replace every occurrence of old_string",
+							},
+							"start_line": map[string]any{
+								"type":        "number",
+								"description": "First line old_string is expected on, to pick one of several occurrences",
+							},
+							"end_line": map[string]any{
+								"type":        "number",
+								"description": "Last line of the range old_string is expected in (default: start_line)",
+							},
+						},
+						"required": []string{"old_string", "new_string"},
+					},
+				},
+			},
+		),
+	}
+}
+
+// Execute applies the edits and writes the file
+func (t *EditFileTool) Execute(ctx context.Context, args map[string]any) (*schema.ToolResult, error) {
+	path, edits, err := parseEditArgs(args)
+	if err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   err.Error(),
+		}, err
+	}
+
+	before, after, replacements, err := editFile(path, edits)
+	if err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   err.Error(),
+		}, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   fmt.Sprintf("failed to stat file: %v", err),
+		}, err
+	}
+	if err := os.WriteFile(path, []byte(after), info.Mode().Perm()); err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   fmt.Sprintf("failed to write file: %v", err),
+		}, err
+	}
+
+	patch := newFilePatch(diffSide(path, before, info.Mode()), diffSide(path, after, info.Mode()))
+	diff, err := patchSet{patch}.Unified()
+	if err != nil {
+		diff = ""
+	}
+
+	return &schema.ToolResult{
+		Success: true,
+		Output: fmt.Sprintf("Edited %s: %d replacements (+%d -%d)\n\n%s",
+			path, replacements, patch.additions, patch.deletions, diff),
+		Data: map[string]any{
+			"path":         path,
+			"replacements": replacements,
+			"additions":    patch.additions,
+			"deletions":    patch.deletions,
+		},
+	}, nil
+}
+
+// Preview returns the unified diff the edits would make
+func (t *EditFileTool) Preview(args map[string]any) (string, error) {
+	path, edits, err := parseEditArgs(args)
+	if err != nil {
+		return "", err
+	}
+
+	before, after, _, err := editFile(path, edits)
+	if err != nil {
+		return "", err
+	}
+
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+	return patchSet{newFilePatch(diffSide(path, before, mode), diffSide(path, after, mode))}.Unified()
+}
+
+// RequiresApproval returns true for edits, which write to the file
+func (t *EditFileTool) RequiresApproval(args map[string]any) bool {
+	return true
+}
+
+// parseEditArgs reads the path and edits of an edit_file call
+func parseEditArgs(args map[string]any) (string, []fileEdit, error) {
+	pathVal, ok := args["path"]
+	if !ok {
+		return "", nil, fmt.Errorf("missing required parameter: path")
+	}
+	path := fmt.Sprintf("%v", pathVal)
+
+	// Some models send the list as a JSON string
+	editsVal := args["edits"]
+	if text, ok := editsVal.(string); ok {
+		if err := json.Unmarshal([]byte(text), &editsVal); err != nil {
+			return "", nil, fmt.Errorf("edits is not a list of edits: %w", err)
+		}
+	}
+	list, ok := editsVal.([]any)
+	if !ok || len(list) == 0 {
+		return "", nil, fmt.Errorf("missing required parameter: edits (a list of objects with old_string and new_string)")
+	}
+
+	edits := make([]fileEdit, len(list))
+	for i, item := range list {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return "", nil, fmt.Errorf("edit %d is not an object with old_string and new_string", i+1)
+		}
+
+		var edit fileEdit
+		if edit.OldString, ok = fields["old_string"].(string); !ok {
+			return "", nil, fmt.Errorf("edit %d is missing old_string", i+1)
+		}
+		if edit.NewString, ok = fields["new_string"].(string); !ok {
+			return "", nil, fmt.Errorf("edit %d is missing new_string", i+1)
+		}
+		edit.ReplaceAll, _ = fields["replace_all"].(bool)
+		edit.StartLine = intArg(fields["start_line"])
+		edit.EndLine = intArg(fields["end_line"])
+
+		switch {
+		case edit.OldString == "":
+			return "", nil, fmt.Errorf("edit %d has an empty old_string; give the text to replace (use write_file to create files)", i+1)
+		case edit.OldString == edit.NewString:
+			return "", nil, fmt.Errorf("edit %d has the same old_string and new_string", i+1)
+		case edit.StartLine < 0 || edit.EndLine < 0:
+			return "", nil, fmt.Errorf("edit %d has a negative line number", i+1)
+		}
+		edits[i] = edit
+	}
+
+	return path, edits, nil
+}
+
+// intArg converts a number argument, which arrives as float64 from JSON
+func intArg(value any) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	default:
+		return 0
+	}
+}
+
+// editFile reads path and applies the edits, returning its content before
+// and after and the number of replacements made
+func editFile(path string, edits []fileEdit) (string, string, int, error) {
+	if isSensitiveFile(path) {
+		return "", "", 0, fmt.Errorf("cannot edit sensitive file: %s", path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", 0, fmt.Errorf("file not found: %s (use write_file to create files)", path)
+		}
+		return "", "", 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return "", "", 0, fmt.Errorf("%s is a directory", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	if isBinaryContent(data) {
+		return "", "", 0, fmt.Errorf("cannot edit binary file: %s", path)
+	}
+
+	before := string(data)
+	after, replacements, err := applyEdits(before, edits)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("cannot edit %s: %w", path, err)
+	}
+	return before, after, replacements, nil
+}
+
+// replacement is a span of the original content and its new text
+type replacement struct {
+	start, end int
+	text       string
+	edit       int
+}
+
+// applyEdits makes the edits to content. Every edit is matched against
+// the original content, so line hints and old strings refer to the file
+// as it was read, and the edits must not overlap.
+func applyEdits(content string, edits []fileEdit) (string, int, error) {
+	crlf := strings.Contains(content, "\r\n")
+
+	var replacements []replacement
+	for i, edit := range edits {
+		// Models write "\n"; match the file's line endings
+		oldString, newString := edit.OldString, edit.NewString
+		if crlf && !strings.Contains(oldString, "\r\n") {
+			oldString = strings.ReplaceAll(oldString, "\n", "\r\n")
+			newString = strings.ReplaceAll(newString, "\n", "\r\n")
+		}
+
+		starts, err := matchEdit(content, oldString, edit)
+		if err != nil {
+			if len(edits) > 1 {
+				return "", 0, fmt.Errorf("edit %d: %w", i+1, err)
+			}
+			return "", 0, err
+		}
+		for _, start := range starts {
+			replacements = append(replacements, replacement{
+				start: start,
+				end:   start + len(oldString),
+				text:  newString,
+				edit:  i + 1,
+			})
+		}
+	}
+
+	sort.Slice(replacements, func(i, j int) bool {
+		return replacements[i].start < replacements[j].start
+	})
+
+	var sb strings.Builder
+	last := 0
+	for i, r := range replacements {
+		if i > 0 && r.start < replacements[i-1].end {
+			return "", 0, fmt.Errorf("edits %d and %d overlap at line %d; combine them into one edit",
+				replacements[i-1].edit, r.edit, lineAt(content, r.start))
+		}
+		sb.WriteString(content[last:r.start])
+		sb.WriteString(r.text)
+		last = r.end
+	}
+	sb.WriteString(content[last:])
+
+	return sb.String(), len(replacements), nil
+}
+
+// matchEdit returns the offsets in content where an edit applies: the
+// single occurrence of oldString, the one in the edit's line range, or
+// every occurrence (in the range, if one is given) for replace_all
+func matchEdit(content, oldString string, edit fileEdit) ([]int, error) {
+	all := occurrences(content, oldString)
+	if len(all) == 0 {
+		return nil, notFoundError(content, oldString)
+	}
+
+	matches := all
+	if edit.StartLine > 0 {
+		end := max(edit.EndLine, edit.StartLine)
+		span := strings.Count(oldString, "\n")
+		var inRange []int
+		for _, start := range all {
+			first := lineAt(content, start)
+			if first <= end && first+span >= edit.StartLine {
+				inRange = append(inRange, start)
+			}
+		}
+
+		switch {
+		case len(inRange) > 0:
+			matches = inRange
+		case len(all) == 1 && !edit.ReplaceAll:
+			// Lines move as a file is edited; a unique match is trusted
+			// over a stale hint
+		default:
+			return nil, fmt.Errorf("old_string does not occur in lines %d-%d; it occurs at %s",
+				edit.StartLine, end, lineList(content, all))
+		}
+	}
+
+	if len(matches) > 1 && !edit.ReplaceAll {
+		return nil, fmt.Errorf("old_string occurs %d times (at %s); include more surrounding lines to make it unique, "+
+			"set replace_all to change every occurrence, or give start_line to pick one",
+			len(matches), lineList(content, matches))
+	}
+	return matches, nil
+}
+
+// occurrences returns the offsets of the non-overlapping occurrences of
+// s in content
+func occurrences(content, s string) []int {
+	var offsets []int
+	for offset := 0; ; {
+		i := strings.Index(content[offset:], s)
+		if i < 0 {
+			return offsets
+		}
+		offsets = append(offsets, offset+i)
+		offset += i + len(s)
+	}
+}
+
+// lineAt returns the 1-based line of an offset in content
+func lineAt(content string, offset int) int {
+	return strings.Count(content[:offset], "\n") + 1
+}
+
+// lineList formats the lines of offsets, e.g. "lines 4, 19, 33"
+func lineList(content string, offsets []int) string {
+	lines := make([]string, len(offsets))
+	for i, offset := range offsets {
+		lines[i] = fmt.Sprint(lineAt(content, offset))
+	}
+	if len(lines) == 1 {
+		return "line " + lines[0]
+	}
+	return "lines " + strings.Join(lines, ", ")
+}
+
+// editCandidate is text in a file that nearly matches an old_string
+type editCandidate struct {
+	start, end int // Lines, 1-based and inclusive
+	text       string
+	similarity float64
+	whitespace bool // Only whitespace differs
+}
+
+// notFoundError reports an old_string missing from content, with the
+// text that most resembles it
+func notFoundError(content, oldString string) error {
+	candidates := closestMatches(content, oldString, maxEditCandidates)
+	if len(candidates) == 0 {
+		return errors.New("old_string was not found; re-read the file and copy the text to replace exactly")
+	}
+
+	var sb strings.Builder
+	sb.WriteString("old_string was not found. The closest text in the file:\n")
+	for _, c := range candidates {
+		note := fmt.Sprintf("%.0f%% similar", c.similarity*100)
+		if c.whitespace {
+			note = "differs only in whitespace"
+		}
+		fmt.Fprintf(&sb, "\nlines %d-%d (%s):\n%s\n", c.start, c.end, note, c.text)
+	}
+	sb.WriteString("\nCopy the text to replace exactly, including whitespace and indentation.")
+	return errors.New(sb.String())
+}
+
+// closestMatches returns up to limit non-overlapping runs of lines in
+// content, as many as old_string has, that most resemble it
+func closestMatches(content, oldString string, limit int) []editCandidate {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	span := strings.Count(strings.TrimSuffix(strings.ReplaceAll(oldString, "\r\n", "\n"), "\n"), "\n") + 1
+	span = min(span, len(lines))
+	want := collapseSpace(oldString)
+
+	var candidates []editCandidate
+	for i := 0; i+span <= len(lines); i++ {
+		window := strings.Join(lines[i:i+span], "\n")
+		got := collapseSpace(window)
+		if got == "" {
+			continue
+		}
+
+		c := editCandidate{start: i + 1, end: i + span, text: window}
+		if got == want {
+			c.similarity, c.whitespace = 1, true
+		} else {
+			c.similarity = textSimilarity(want, got)
+		}
+		if c.similarity >= minCandidateSimilarity {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].similarity > candidates[j].similarity
+	})
+
+	var best []editCandidate
+	for _, c := range candidates {
+		if len(best) == limit {
+			break
+		}
+		overlaps := false
+		for _, b := range best {
+			if c.start <= b.end && b.start <= c.end {
+				overlaps = true
+				break
+			}
+		}
+		if overlaps {
+			continue
+		}
+		if shown := strings.Split(c.text, "\n"); len(shown) > maxCandidateLines {
+			c.text = strings.Join(shown[:maxCandidateLines], "\n") + "\n..."
+		}
+		best = append(best, c)
+	}
+	return best
+}
+
+// collapseSpace replaces each run of whitespace in s with one space
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// textSimilarity returns the Dice coefficient of the character pairs of
+// a and b, from 0 for nothing shared to 1 for the same pairs
+func textSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < 2 || len(rb) < 2 {
+		if a == b {
+			return 1
+		}
+		return 0
+	}
+
+	pairs := make(map[[2]rune]int, len(ra))
+	for i := 0; i+1 < len(ra); i++ {
+		pairs[[2]rune{ra[i], ra[i+1]}]++
+	}
+	shared := 0
+	for i := 0; i+1 < len(rb); i++ {
+		pair := [2]rune{rb[i], rb[i+1]}
+		if pairs[pair] > 0 {
+			pairs[pair]--
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ra)+len(rb)-2)
+}
+
+// diffSide describes one version of a file for a diff, with its path
+// relative to the working directory when it is inside it
+func diffSide(path, content string, mode os.FileMode) *diffFile {
+	name := path
+	if abs, err := filepath.Abs(path); err == nil {
+		if cwd, err := os.Getwd(); err == nil {
+			if rel, err := filepath.Rel(cwd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				name = rel
+			}
+		}
+	}
+
+	fileMode, err := filemode.NewFromOSFileMode(mode)
+	if err != nil {
+		fileMode = filemode.Regular
+	}
+	return &diffFile{
+		path:    filepath.ToSlash(name),
+		hash:    plumbing.ComputeHash(plumbing.BlobObject, []byte(content)),
+		mode:    fileMode,
+		content: content,
+	}
+}
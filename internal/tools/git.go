@@ -10,6 +10,15 @@ import (
 	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
+// openRepository opens the git repository at path
+func openRepository(path string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(path)
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %w", err)
+	}
+	return repo, nil
+}
+
 // GitStatusTool shows git status
 type GitStatusTool struct {
 	BaseTool
@@ -41,11 +50,11 @@ func (t *GitStatusTool) Execute(ctx context.Context, args map[string]any) (*sche
 		path = fmt.Sprintf("%v", pathVal)
 	}
 
-	repo, err := git.PlainOpen(path)
+	repo, err := openRepository(path)
 	if err != nil {
 		return &schema.ToolResult{
 			Success: false,
-			Error:   fmt.Sprintf("not a git repository: %v", err),
+			Error:   err.Error(),
 		}, err
 	}
 
@@ -99,7 +108,7 @@ func NewGitDiffTool() *GitDiffTool {
 	return &GitDiffTool{
 		BaseTool: NewBaseTool(
 			"git_diff",
-			"Show changes in the working directory",
+			"Show changes as a unified diff: unstaged changes (worktree vs index) by default, staged changes (index vs HEAD) with staged=true, or the changes between two refs/commits with from/to",
 			[]schema.ToolParameter{
 				{
 					Name:        "path",
@@ -110,10 +119,36 @@ func NewGitDiffTool() *GitDiffTool {
 				},
 				{
 					Name:        "file",
-					Description: "Specific file to diff",
+					Description: "Specific file (or directory) to diff, relative to the repository root",
+					Type:        "string",
+					Required:    false,
+				},
+				{
+					Name:        "staged",
+					Description: "Show staged changes (index vs HEAD) instead of unstaged ones",
+					Type:        "boolean",
+					Required:    false,
+					Default:     false,
+				},
+				{
+					Name:        "from",
+					Description: "Ref or commit to diff from (e.g. 'main', 'HEAD~3', a commit hash)",
 					Type:        "string",
 					Required:    false,
 				},
+				{
+					Name:        "to",
+					Description: "Ref or commit to diff to (default: HEAD, used only with from)",
+					Type:        "string",
+					Required:    false,
+				},
+				{
+					Name:        "stat",
+					Description: "Only show the diffstat summary, not the patch",
+					Type:        "boolean",
+					Required:    false,
+					Default:     false,
+				},
 			},
 		),
 	}
@@ -121,13 +156,122 @@ func NewGitDiffTool() *GitDiffTool {
 
 // Execute shows git diff
 func (t *GitDiffTool) Execute(ctx context.Context, args map[string]any) (*schema.ToolResult, error) {
-	_ = ctx // unused for now
+	path := "."
+	if pathVal, ok := args["path"]; ok {
+		path = fmt.Sprintf("%v", pathVal)
+	}
+
+	var file, from, to string
+	if fileVal, ok := args["file"]; ok {
+		file = fmt.Sprintf("%v", fileVal)
+	}
+	if fromVal, ok := args["from"]; ok {
+		from = fmt.Sprintf("%v", fromVal)
+	}
+	if toVal, ok := args["to"]; ok {
+		to = fmt.Sprintf("%v", toVal)
+	}
+	staged, _ := args["staged"].(bool)
+	statOnly, _ := args["stat"].(bool)
+
+	repo, err := openRepository(path)
+	if err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   err.Error(),
+		}, err
+	}
+
+	var fromSnap, toSnap *snapshot
+	var mode, fromLabel, toLabel string
+
+	switch {
+	case from != "":
+		if to == "" {
+			to = "HEAD"
+		}
+		mode, fromLabel, toLabel = "refs", from, to
+		fromSnap, err = commitSnapshot(ctx, repo, from)
+		if err == nil {
+			toSnap, err = commitSnapshot(ctx, repo, to)
+		}
+
+	case staged:
+		mode, fromLabel, toLabel = "staged", "HEAD", "index"
+		fromSnap, err = headSnapshot(ctx, repo)
+		if err == nil {
+			toSnap, err = indexSnapshot(repo)
+		}
+
+	default:
+		mode, fromLabel, toLabel = "worktree", "index", "worktree"
+		fromSnap, err = indexSnapshot(repo)
+		if err == nil {
+			toSnap, err = worktreeSnapshot(repo, fromSnap)
+		}
+	}
+
+	if err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   err.Error(),
+		}, err
+	}
+
+	patches, err := diffSnapshots(ctx, fromSnap, toSnap, file)
+	if err != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Error:   fmt.Sprintf("failed to compute diff: %v", err),
+		}, err
+	}
+
+	ps := patchSet(patches)
+	stats := ps.Stats()
+
+	files := make([]map[string]any, len(ps))
+	for i, p := range ps {
+		files[i] = p.toData()
+	}
+
+	data := map[string]any{
+		"mode":          mode,
+		"from":          fromLabel,
+		"to":            toLabel,
+		"files":         files,
+		"files_changed": stats.FilesChanged,
+		"additions":     stats.Additions,
+		"deletions":     stats.Deletions,
+	}
+
+	if len(ps) == 0 {
+		return &schema.ToolResult{
+			Success: true,
+			Output:  fmt.Sprintf("No changes between %s and %s\n", fromLabel, toLabel),
+			Data:    data,
+		}, nil
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("Diff %s..%s:\n\n", fromLabel, toLabel))
+	output.WriteString(ps.StatSummary())
+
+	if !statOnly {
+		unified, err := ps.Unified()
+		if err != nil {
+			return &schema.ToolResult{
+				Success: false,
+				Error:   fmt.Sprintf("failed to format diff: %v", err),
+			}, err
+		}
+		output.WriteString("\n")
+		output.WriteString(unified)
+	}
 
-	// For now, use simple file comparison
-	// A full git diff implementation would use go-git's diff capabilities
 	return &schema.ToolResult{
 		Success: true,
-		Output:  "Git diff functionality - use external git command for full diff",
+		Output:  output.String(),
+		Data:    data,
 	}, nil
 }
 
@@ -181,11 +325,11 @@ func (t *GitLogTool) Execute(ctx context.Context, args map[string]any) (*schema.
 		}
 	}
 
-	repo, err := git.PlainOpen(path)
+	repo, err := openRepository(path)
 	if err != nil {
 		return &schema.ToolResult{
 			Success: false,
-			Error:   fmt.Sprintf("not a git repository: %v", err),
+			Error:   err.Error(),
 		}, err
 	}
 
@@ -0,0 +1,593 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"github.com/siddharth-bhatnagar/anvil/internal/util"
+)
+
+const (
+	// renameSimilarity is the minimum percentage of shared lines for a
+	// deleted/added pair to be reported as a rename (git's default is 50%)
+	renameSimilarity = 50
+
+	// maxRenameCandidates caps inexact rename detection, which compares
+	// every deleted file against every added file
+	maxRenameCandidates = 100
+
+	// binarySniffLen is how many leading bytes are checked for NUL when
+	// deciding whether content is binary, matching git's heuristic
+	binarySniffLen = 8000
+)
+
+// snapshotEntry is a file as recorded in a tree, the index or the worktree
+type snapshotEntry struct {
+	hash plumbing.Hash
+	mode filemode.FileMode
+}
+
+// snapshot is a set of files plus a way to load their contents
+type snapshot struct {
+	entries map[string]snapshotEntry
+	load    func(path string, entry snapshotEntry) ([]byte, error)
+}
+
+// commitSnapshot returns the files of the tree at the given revision
+func commitSnapshot(ctx context.Context, repo *git.Repository, rev string) (*snapshot, error) {
+	hash, err := repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load commit %s: %w", rev, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tree for %s: %w", rev, err)
+	}
+
+	snap := &snapshot{
+		entries: make(map[string]snapshotEntry),
+		load:    blobLoader(repo),
+	}
+
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		snap.entries[f.Name] = snapshotEntry{hash: f.Hash, mode: f.Mode}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk tree for %s: %w", rev, err)
+	}
+
+	return snap, nil
+}
+
+// headSnapshot returns the files at HEAD, or no files if there are no
+// commits yet
+func headSnapshot(ctx context.Context, repo *git.Repository) (*snapshot, error) {
+	if _, err := repo.Head(); err == plumbing.ErrReferenceNotFound {
+		return &snapshot{
+			entries: make(map[string]snapshotEntry),
+			load:    blobLoader(repo),
+		}, nil
+	}
+	return commitSnapshot(ctx, repo, "HEAD")
+}
+
+// indexSnapshot returns the files staged in the index
+func indexSnapshot(repo *git.Repository) (*snapshot, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	snap := &snapshot{
+		entries: make(map[string]snapshotEntry, len(idx.Entries)),
+		load:    blobLoader(repo),
+	}
+
+	for _, e := range idx.Entries {
+		snap.entries[e.Name] = snapshotEntry{hash: e.Hash, mode: e.Mode}
+	}
+
+	return snap, nil
+}
+
+// worktreeSnapshot returns the tracked files as they currently are on disk.
+// Only files that git status reports as changed are read and hashed; the
+// rest are taken from the index. Untracked files are excluded, as in git diff.
+func worktreeSnapshot(repo *git.Repository, index *snapshot) (*snapshot, error) {
+	w, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get status: %w", err)
+	}
+
+	root := w.Filesystem.Root()
+	indexLoad := index.load
+	onDisk := make(map[string][]byte)
+
+	snap := &snapshot{
+		entries: make(map[string]snapshotEntry, len(index.entries)),
+		load: func(path string, entry snapshotEntry) ([]byte, error) {
+			if content, ok := onDisk[path]; ok {
+				return content, nil
+			}
+			return indexLoad(path, entry)
+		},
+	}
+
+	for path, entry := range index.entries {
+		snap.entries[path] = entry
+	}
+
+	for path, fileStatus := range status {
+		switch fileStatus.Worktree {
+		case git.Deleted:
+			delete(snap.entries, path)
+
+		case git.Modified:
+			fullPath := filepath.Join(root, filepath.FromSlash(path))
+			info, err := os.Lstat(fullPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+			}
+
+			var content []byte
+			if info.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(fullPath)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read link %s: %w", path, err)
+				}
+				content = []byte(target)
+			} else {
+				content, err = os.ReadFile(fullPath)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read %s: %w", path, err)
+				}
+			}
+
+			mode, err := filemode.NewFromOSFileMode(info.Mode())
+			if err != nil {
+				mode = filemode.Regular
+			}
+
+			onDisk[path] = content
+			snap.entries[path] = snapshotEntry{
+				hash: plumbing.ComputeHash(plumbing.BlobObject, content),
+				mode: mode,
+			}
+		}
+	}
+
+	return snap, nil
+}
+
+// blobLoader returns a loader that reads blob contents from the object store
+func blobLoader(repo *git.Repository) func(string, snapshotEntry) ([]byte, error) {
+	return func(path string, entry snapshotEntry) ([]byte, error) {
+		blob, err := repo.BlobObject(entry.hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load blob for %s: %w", path, err)
+		}
+
+		reader, err := blob.Reader()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read blob for %s: %w", path, err)
+		}
+		defer reader.Close()
+
+		return io.ReadAll(reader)
+	}
+}
+
+// diffSnapshots compares two snapshots and returns one patch per changed
+// file, sorted by path. If filter is set, only that file (or the files
+// under that directory) are compared.
+func diffSnapshots(ctx context.Context, from, to *snapshot, filter string) ([]*filePatch, error) {
+	paths := make(map[string]bool)
+	for path := range from.entries {
+		paths[path] = true
+	}
+	for path := range to.entries {
+		paths[path] = true
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		if matchesPathFilter(path, filter) {
+			sorted = append(sorted, path)
+		}
+	}
+	sort.Strings(sorted)
+
+	var patches []*filePatch
+	for _, path := range sorted {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		fromEntry, inFrom := from.entries[path]
+		toEntry, inTo := to.entries[path]
+		if inFrom && inTo && fromEntry == toEntry {
+			continue
+		}
+
+		var fromFile, toFile *diffFile
+		var err error
+		if inFrom {
+			if fromFile, err = loadDiffFile(from, path, fromEntry); err != nil {
+				return nil, err
+			}
+		}
+		if inTo {
+			if toFile, err = loadDiffFile(to, path, toEntry); err != nil {
+				return nil, err
+			}
+		}
+
+		patches = append(patches, newFilePatch(fromFile, toFile))
+	}
+
+	return detectRenames(patches), nil
+}
+
+// matchesPathFilter reports whether path is the filtered file or lies under
+// the filtered directory
+func matchesPathFilter(path, filter string) bool {
+	filter = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(filter)), "./")
+	if filter == "" || filter == "." {
+		return true
+	}
+	return path == filter || strings.HasPrefix(path, strings.TrimSuffix(filter, "/")+"/")
+}
+
+// loadDiffFile loads a snapshot entry's content
+func loadDiffFile(snap *snapshot, path string, entry snapshotEntry) (*diffFile, error) {
+	content, err := snap.load(path, entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &diffFile{
+		path:    path,
+		hash:    entry.hash,
+		mode:    entry.mode,
+		content: string(content),
+		binary:  isBinaryContent(content),
+	}, nil
+}
+
+// isBinaryContent reports whether content looks binary (contains a NUL byte
+// near the start)
+func isBinaryContent(content []byte) bool {
+	if len(content) > binarySniffLen {
+		content = content[:binarySniffLen]
+	}
+	for _, b := range content {
+		if b == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// detectRenames pairs deleted and added files into renames: first those
+// with identical content, then those whose content is similar enough
+func detectRenames(patches []*filePatch) []*filePatch {
+	var added, deleted []*filePatch
+	for _, p := range patches {
+		switch {
+		case p.from == nil:
+			added = append(added, p)
+		case p.to == nil:
+			deleted = append(deleted, p)
+		}
+	}
+
+	if len(added) == 0 || len(deleted) == 0 {
+		return patches
+	}
+
+	merged := make(map[*filePatch]*filePatch) // added patch -> rename patch
+	used := make(map[*filePatch]bool)         // deleted patches already paired
+
+	// Exact renames
+	for _, a := range added {
+		for _, d := range deleted {
+			if !used[d] && d.from.hash == a.to.hash {
+				merged[a] = newFilePatch(d.from, a.to)
+				used[d] = true
+				break
+			}
+		}
+	}
+
+	// Similar renames
+	if len(added) <= maxRenameCandidates && len(deleted) <= maxRenameCandidates {
+		for _, a := range added {
+			if merged[a] != nil || a.to.binary {
+				continue
+			}
+
+			var best *filePatch
+			bestScore := renameSimilarity - 1
+			for _, d := range deleted {
+				if used[d] || d.from.binary {
+					continue
+				}
+				if score := similarity(d.from.content, a.to.content); score > bestScore {
+					best, bestScore = d, score
+				}
+			}
+
+			if best != nil {
+				merged[a] = newFilePatch(best.from, a.to)
+				used[best] = true
+			}
+		}
+	}
+
+	result := make([]*filePatch, 0, len(patches))
+	for _, p := range patches {
+		if used[p] {
+			continue
+		}
+		if rename, ok := merged[p]; ok {
+			result = append(result, rename)
+			continue
+		}
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// similarity returns the percentage of lines shared by two texts
+func similarity(a, b string) int {
+	total := countLines(a) + countLines(b)
+	if total == 0 {
+		return 100
+	}
+
+	common := 0
+	for _, d := range diff.Do(a, b) {
+		if d.Type == diffmatchpatch.DiffEqual {
+			common += countLines(d.Text)
+		}
+	}
+
+	return common * 2 * 100 / total
+}
+
+// countLines counts lines, including a final line without a newline
+func countLines(text string) int {
+	if text == "" {
+		return 0
+	}
+	n := strings.Count(text, "\n")
+	if !strings.HasSuffix(text, "\n") {
+		n++
+	}
+	return n
+}
+
+// diffFile is one side of a file change; it implements fdiff.File
+type diffFile struct {
+	path    string
+	hash    plumbing.Hash
+	mode    filemode.FileMode
+	content string
+	binary  bool
+}
+
+func (f *diffFile) Hash() plumbing.Hash     { return f.hash }
+func (f *diffFile) Mode() filemode.FileMode { return f.mode }
+func (f *diffFile) Path() string            { return f.path }
+
+// diffChunk is a run of equal, added or deleted lines; it implements fdiff.Chunk
+type diffChunk struct {
+	content string
+	op      fdiff.Operation
+}
+
+func (c diffChunk) Content() string       { return c.content }
+func (c diffChunk) Type() fdiff.Operation { return c.op }
+
+// filePatch describes how one file changed; it implements fdiff.FilePatch
+type filePatch struct {
+	from, to  *diffFile
+	chunks    []fdiff.Chunk
+	additions int
+	deletions int
+}
+
+// newFilePatch computes the line diff between two versions of a file.
+// Either side may be nil for added or deleted files.
+func newFilePatch(from, to *diffFile) *filePatch {
+	p := &filePatch{from: from, to: to}
+	if p.IsBinary() {
+		return p
+	}
+
+	var fromContent, toContent string
+	if from != nil {
+		fromContent = from.content
+	}
+	if to != nil {
+		toContent = to.content
+	}
+
+	for _, d := range diff.Do(fromContent, toContent) {
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			p.chunks = append(p.chunks, diffChunk{content: d.Text, op: fdiff.Equal})
+		case diffmatchpatch.DiffInsert:
+			p.chunks = append(p.chunks, diffChunk{content: d.Text, op: fdiff.Add})
+			p.additions += countLines(d.Text)
+		case diffmatchpatch.DiffDelete:
+			p.chunks = append(p.chunks, diffChunk{content: d.Text, op: fdiff.Delete})
+			p.deletions += countLines(d.Text)
+		}
+	}
+
+	return p
+}
+
+// IsBinary returns true if either side of the change is binary
+func (p *filePatch) IsBinary() bool {
+	return (p.from != nil && p.from.binary) || (p.to != nil && p.to.binary)
+}
+
+// Files returns the two sides of the change, nil for a missing side
+func (p *filePatch) Files() (fdiff.File, fdiff.File) {
+	var from, to fdiff.File
+	if p.from != nil {
+		from = p.from
+	}
+	if p.to != nil {
+		to = p.to
+	}
+	return from, to
+}
+
+// Chunks returns the line-level changes
+func (p *filePatch) Chunks() []fdiff.Chunk {
+	return p.chunks
+}
+
+// Path returns the file's current path (or its old path if deleted)
+func (p *filePatch) Path() string {
+	if p.to != nil {
+		return p.to.path
+	}
+	return p.from.path
+}
+
+// Status returns a git-style description of the change
+func (p *filePatch) Status() string {
+	switch {
+	case p.from == nil:
+		return "added"
+	case p.to == nil:
+		return "deleted"
+	case p.from.path != p.to.path:
+		return "renamed"
+	default:
+		return "modified"
+	}
+}
+
+// DisplayName returns the path as shown in a diffstat ("old => new" for renames)
+func (p *filePatch) DisplayName() string {
+	if p.Status() == "renamed" {
+		return p.from.path + " => " + p.to.path
+	}
+	return p.Path()
+}
+
+// toData converts the patch into structured data for a ToolResult
+func (p *filePatch) toData() map[string]any {
+	data := map[string]any{
+		"path":      p.Path(),
+		"status":    p.Status(),
+		"additions": p.additions,
+		"deletions": p.deletions,
+		"binary":    p.IsBinary(),
+	}
+	if p.Status() == "renamed" {
+		data["old_path"] = p.from.path
+	}
+	return data
+}
+
+// patchSet is a collection of file patches; it implements fdiff.Patch
+type patchSet []*filePatch
+
+// FilePatches returns the per-file patches
+func (ps patchSet) FilePatches() []fdiff.FilePatch {
+	patches := make([]fdiff.FilePatch, len(ps))
+	for i, p := range ps {
+		patches[i] = p
+	}
+	return patches
+}
+
+// Message returns an empty message; patches carry no header text
+func (ps patchSet) Message() string {
+	return ""
+}
+
+// Unified renders the patch set as a git-style unified diff
+func (ps patchSet) Unified() (string, error) {
+	var sb strings.Builder
+	if err := fdiff.NewUnifiedEncoder(&sb, fdiff.DefaultContextLines).Encode(ps); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// Stats returns aggregate change statistics
+func (ps patchSet) Stats() util.DiffStats {
+	stats := util.DiffStats{FilesChanged: len(ps)}
+	for _, p := range ps {
+		stats.Additions += p.additions
+		stats.Deletions += p.deletions
+	}
+	return stats
+}
+
+// StatSummary renders a git-style diffstat
+func (ps patchSet) StatSummary() string {
+	const barWidth = 40
+
+	nameWidth := 0
+	maxChanges := 0
+	for _, p := range ps {
+		nameWidth = max(nameWidth, len(p.DisplayName()))
+		maxChanges = max(maxChanges, p.additions+p.deletions)
+	}
+
+	var sb strings.Builder
+	for _, p := range ps {
+		if p.IsBinary() {
+			sb.WriteString(fmt.Sprintf(" %-*s | Bin\n", nameWidth, p.DisplayName()))
+			continue
+		}
+
+		adds, dels := p.additions, p.deletions
+		if maxChanges > barWidth {
+			adds = adds * barWidth / maxChanges
+			dels = dels * barWidth / maxChanges
+		}
+
+		sb.WriteString(fmt.Sprintf(" %-*s | %d %s%s\n", nameWidth, p.DisplayName(),
+			p.additions+p.deletions, strings.Repeat("+", adds), strings.Repeat("-", dels)))
+	}
+	sb.WriteString(" " + ps.Stats().String() + "\n")
+
+	return sb.String()
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
@@ -48,7 +49,8 @@ func (r *Registry) Get(name string) (Tool, error) {
 	return tool, nil
 }
 
-// List returns all registered tools
+// List returns all registered tools, sorted by name so that requests
+// offering them are the same from run to run
 func (r *Registry) List() []Tool {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -57,6 +59,9 @@ func (r *Registry) List() []Tool {
 	for _, tool := range r.tools {
 		tools = append(tools, tool)
 	}
+	sort.Slice(tools, func(i, j int) bool {
+		return tools[i].Name() < tools[j].Name()
+	})
 
 	return tools
 }
@@ -86,6 +91,20 @@ func (r *Registry) Execute(ctx context.Context, toolCall schema.ToolCall) (*sche
 
 	// Check if approval is required
 	if tool.RequiresApproval(toolCall.Arguments) {
+		// Calls that would fail are reported now rather than after the
+		// user approves them
+		var preview string
+		if previewer, ok := tool.(Previewer); ok {
+			preview, err = previewer.Preview(toolCall.Arguments)
+			if err != nil {
+				return &schema.ToolResult{
+					ToolCallID: toolCall.ID,
+					Success:    false,
+					Error:      err.Error(),
+				}, err
+			}
+		}
+
 		// Return result with approval request
 		// The caller will handle the approval flow
 		return &schema.ToolResult{
@@ -96,6 +115,7 @@ func (r *Registry) Execute(ctx context.Context, toolCall schema.ToolCall) (*sche
 				Action:      fmt.Sprintf("Execute %s", tool.Name()),
 				Reason:      "This operation requires user approval",
 				Destructive: true,
+				Preview:     preview,
 			},
 		}, nil
 	}
@@ -127,6 +147,10 @@ func DefaultRegistry() (*Registry, error) {
 		return nil, err
 	}
 
+	if err := registry.Register(NewEditFileTool()); err != nil {
+		return nil, err
+	}
+
 	if err := registry.Register(NewSearchFilesTool()); err != nil {
 		return nil, err
 	}
@@ -74,13 +74,32 @@ func (t *ShellCommandTool) Execute(ctx context.Context, args map[string]any) (*s
 	}
 
 	// Create context with timeout
-	ctx, cancel := context.WithTimeout(ctx, timeout)
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	// Execute command
-	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd := exec.CommandContext(cmdCtx, "sh", "-c", command)
+	configureProcessGroup(cmd)
+	// Don't wait forever on pipes held open by orphaned children
+	cmd.WaitDelay = time.Second
 	output, err := cmd.CombinedOutput()
 
+	// Report cancellation and timeouts rather than the resulting kill signal
+	if ctx.Err() != nil {
+		return &schema.ToolResult{
+			Success: false,
+			Output:  string(output),
+			Error:   "command cancelled",
+		}, ctx.Err()
+	}
+	if cmdCtx.Err() == context.DeadlineExceeded {
+		return &schema.ToolResult{
+			Success: false,
+			Output:  string(output),
+			Error:   fmt.Sprintf("command timed out after %s", timeout),
+		}, fmt.Errorf("command timed out after %s", timeout)
+	}
+
 	if err != nil {
 		return &schema.ToolResult{
 			Success: false,
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package tools
+
+import "os/exec"
+
+// configureProcessGroup is a no-op where process groups are unavailable;
+// cancellation kills only the shell
+func configureProcessGroup(cmd *exec.Cmd) {}
@@ -24,6 +24,14 @@ type Tool interface {
 	RequiresApproval(args map[string]any) bool
 }
 
+// Previewer is implemented by tools that can show what a call would
+// change, such as a diff, before the user approves it
+type Previewer interface {
+	// Preview returns the change the call would make, or an error if
+	// the call would fail
+	Preview(args map[string]any) (string, error)
+}
+
 // BaseTool provides common functionality for tools
 type BaseTool struct {
 	name        string
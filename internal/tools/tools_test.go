@@ -4,8 +4,12 @@ import (
 	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
@@ -94,6 +98,7 @@ func TestDefaultRegistry(t *testing.T) {
 	expectedTools := []string{
 		"read_file",
 		"write_file",
+		"edit_file",
 		"search_files",
 		"grep_files",
 		"list_directory",
@@ -345,6 +350,32 @@ func TestShellCommandTool(t *testing.T) {
 	}
 }
 
+func TestShellCommandToolCancel(t *testing.T) {
+	tool := NewShellCommandTool()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	// The subshell keeps the output pipe open, so only killing the
+	// whole process group lets the command return promptly
+	start := time.Now()
+	result, err := tool.Execute(ctx, map[string]any{
+		"command": "(sleep 10; echo late) && echo done",
+	})
+
+	if err == nil {
+		t.Fatal("Expected an error for a cancelled command")
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Cancelled command took %s to return", elapsed)
+	}
+
+	if result.Success || result.Error != "command cancelled" {
+		t.Errorf("Expected cancelled result, got %+v", result)
+	}
+}
+
 func TestShellCommandToolRequiresApproval(t *testing.T) {
 	tool := NewShellCommandTool()
 
@@ -467,3 +498,341 @@ func TestIsDestructiveCommand(t *testing.T) {
 		}
 	}
 }
+
+// newTestRepo creates a git repository with one commit containing the given files
+func newTestRepo(t *testing.T, files map[string]string) (string, *git.Worktree) {
+	t.Helper()
+	dir := t.TempDir()
+
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatalf("PlainInit failed: %v", err)
+	}
+
+	w, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree failed: %v", err)
+	}
+
+	for name, content := range files {
+		writeTestFile(t, dir, name, content)
+		if _, err := w.Add(name); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+
+	commitTestRepo(t, w, "initial")
+	return dir, w
+}
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+func commitTestRepo(t *testing.T, w *git.Worktree, msg string) {
+	t.Helper()
+	_, err := w.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+}
+
+func TestGitDiffToolWorktree(t *testing.T) {
+	dir, _ := newTestRepo(t, map[string]string{
+		"main.go":  "package main\n\nfunc main() {\n\tprintln(\"old\")\n}\n",
+		"other.go": "package main\n",
+	})
+	writeTestFile(t, dir, "main.go", "package main\n\nfunc main() {\n\tprintln(\"new\")\n}\n")
+	writeTestFile(t, dir, "untracked.go", "package main\n")
+
+	tool := NewGitDiffTool()
+	result, err := tool.Execute(context.Background(), map[string]any{"path": dir})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	for _, want := range []string{"diff --git a/main.go b/main.go", "-\tprintln(\"old\")", "+\tprintln(\"new\")", "1 file(s) changed"} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, result.Output)
+		}
+	}
+
+	if strings.Contains(result.Output, "untracked.go") {
+		t.Error("Untracked files should not appear in the worktree diff")
+	}
+
+	files := result.Data["files"].([]map[string]any)
+	if len(files) != 1 || files[0]["path"] != "main.go" || files[0]["additions"] != 1 || files[0]["deletions"] != 1 {
+		t.Errorf("Unexpected file data: %+v", files)
+	}
+}
+
+func TestGitDiffToolStaged(t *testing.T) {
+	dir, w := newTestRepo(t, map[string]string{"a.txt": "one\n"})
+	writeTestFile(t, dir, "a.txt", "one\ntwo\n")
+	writeTestFile(t, dir, "b.txt", "new file\n")
+	w.Add("a.txt")
+	w.Add("b.txt")
+
+	tool := NewGitDiffTool()
+
+	// Unstaged diff is now empty
+	result, err := tool.Execute(context.Background(), map[string]any{"path": dir})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Data["files_changed"] != 0 {
+		t.Errorf("Expected no unstaged changes, got: %s", result.Output)
+	}
+
+	result, err = tool.Execute(context.Background(), map[string]any{"path": dir, "staged": true})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Data["files_changed"] != 2 || result.Data["additions"] != 2 {
+		t.Errorf("Unexpected staged stats: %+v", result.Data)
+	}
+	if !strings.Contains(result.Output, "new file mode") {
+		t.Errorf("Expected new file header, got:\n%s", result.Output)
+	}
+
+	// File filter
+	result, err = tool.Execute(context.Background(), map[string]any{"path": dir, "staged": true, "file": "b.txt"})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Data["files_changed"] != 1 || strings.Contains(result.Output, "a.txt") {
+		t.Errorf("Expected only b.txt in filtered diff, got:\n%s", result.Output)
+	}
+}
+
+func TestGitDiffToolRefsWithRename(t *testing.T) {
+	content := "line 1\nline 2\nline 3\nline 4\nline 5\n"
+	dir, w := newTestRepo(t, map[string]string{"old/name.txt": content})
+
+	os.Remove(filepath.Join(dir, "old", "name.txt"))
+	w.Remove("old/name.txt")
+	writeTestFile(t, dir, "new/name.txt", content+"line 6\n")
+	w.Add("new/name.txt")
+	commitTestRepo(t, w, "rename")
+
+	tool := NewGitDiffTool()
+	result, err := tool.Execute(context.Background(), map[string]any{"path": dir, "from": "HEAD~1"})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	for _, want := range []string{"rename from old/name.txt", "rename to new/name.txt", "+line 6"} {
+		if !strings.Contains(result.Output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, result.Output)
+		}
+	}
+
+	files := result.Data["files"].([]map[string]any)
+	if len(files) != 1 || files[0]["status"] != "renamed" || files[0]["old_path"] != "old/name.txt" {
+		t.Errorf("Unexpected file data: %+v", files)
+	}
+
+	// Stat only
+	result, err = tool.Execute(context.Background(), map[string]any{"path": dir, "from": "HEAD~1", "to": "HEAD", "stat": true})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if strings.Contains(result.Output, "@@") || !strings.Contains(result.Output, "old/name.txt => new/name.txt") {
+		t.Errorf("Expected stat-only output, got:\n%s", result.Output)
+	}
+}
+
+func TestGitDiffToolNotARepo(t *testing.T) {
+	tool := NewGitDiffTool()
+	result, err := tool.Execute(context.Background(), map[string]any{"path": t.TempDir()})
+	if err == nil {
+		t.Fatal("Expected error for non-repository path")
+	}
+	if result.Success {
+		t.Error("Expected failure result")
+	}
+}
+
+func TestEditFileTool(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "main.go")
+	original := "package main\n\nfunc main() {\n\tprintln(\"old\")\n}\n\nfunc other() {\n\tprintln(\"old\")\n}\n"
+	os.WriteFile(tmpFile, []byte(original), 0o755)
+
+	tool := NewEditFileTool()
+	if !tool.RequiresApproval(map[string]any{"path": tmpFile}) {
+		t.Error("Edits should require approval")
+	}
+
+	edit := func(edits ...map[string]any) map[string]any {
+		list := make([]any, len(edits))
+		for i, e := range edits {
+			list[i] = e
+		}
+		return map[string]any{"path": tmpFile, "edits": list}
+	}
+
+	// The preview is a unified diff and leaves the file alone
+	args := edit(
+		map[string]any{"old_string": "func main() {\n\tprintln(\"old\")", "new_string": "func main() {\n\tprintln(\"new\")"},
+		map[string]any{"old_string": "package main", "new_string": "package app"},
+	)
+	preview, err := tool.Preview(args)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	for _, want := range []string{"--- a/", "+++ b/", "@@ -1,", "-package main", "+package app", "-\tprintln(\"old\")", "+\tprintln(\"new\")"} {
+		if !strings.Contains(preview, want) {
+			t.Errorf("Expected preview to contain %q, got:\n%s", want, preview)
+		}
+	}
+	if content, _ := os.ReadFile(tmpFile); string(content) != original {
+		t.Error("Preview should not change the file")
+	}
+
+	result, err := tool.Execute(context.Background(), args)
+	if err != nil || !result.Success {
+		t.Fatalf("Execute failed: %v %s", err, result.Error)
+	}
+	want := "package app\n\nfunc main() {\n\tprintln(\"new\")\n}\n\nfunc other() {\n\tprintln(\"old\")\n}\n"
+	if content, _ := os.ReadFile(tmpFile); string(content) != want {
+		t.Errorf("Unexpected content:\n%s", content)
+	}
+	if info, _ := os.Stat(tmpFile); info.Mode().Perm() != 0o755 {
+		t.Errorf("Expected the file mode kept, got %v", info.Mode())
+	}
+	if result.Data["replacements"] != 2 || result.Data["additions"] != 2 || result.Data["deletions"] != 2 {
+		t.Errorf("Unexpected data: %+v", result.Data)
+	}
+}
+
+func TestEditFileToolMatching(t *testing.T) {
+	content := "a := 1\nb := 2\nif a {\n    return b\n}\nb := 2\n"
+
+	tests := []struct {
+		name    string
+		edit    fileEdit
+		want    string
+		wantErr string
+	}{
+		{
+			name: "unique",
+			edit: fileEdit{OldString: "a := 1", NewString: "a := 3"},
+			want: "a := 3\nb := 2\nif a {\n    return b\n}\nb := 2\n",
+		},
+		{
+			name:    "ambiguous",
+			edit:    fileEdit{OldString: "b := 2", NewString: "b := 4"},
+			wantErr: "occurs 2 times (at lines 2, 6)",
+		},
+		{
+			name: "replace all",
+			edit: fileEdit{OldString: "b := 2", NewString: "b := 4", ReplaceAll: true},
+			want: "a := 1\nb := 4\nif a {\n    return b\n}\nb := 4\n",
+		},
+		{
+			name: "line hint picks one",
+			edit: fileEdit{OldString: "b := 2", NewString: "b := 4", StartLine: 6},
+			want: "a := 1\nb := 2\nif a {\n    return b\n}\nb := 4\n",
+		},
+		{
+			name: "stale hint with a unique match",
+			edit: fileEdit{OldString: "a := 1", NewString: "a := 3", StartLine: 40, EndLine: 50},
+			want: "a := 3\nb := 2\nif a {\n    return b\n}\nb := 2\n",
+		},
+		{
+			name:    "hint without a match",
+			edit:    fileEdit{OldString: "b := 2", NewString: "b := 4", StartLine: 3, EndLine: 4},
+			wantErr: "does not occur in lines 3-4; it occurs at lines 2, 6",
+		},
+		{
+			name:    "whitespace differs",
+			edit:    fileEdit{OldString: "if a {\n\treturn b\n}", NewString: "if a {\n\treturn a\n}"},
+			wantErr: "lines 3-5 (differs only in whitespace)",
+		},
+		{
+			name:    "closest candidate",
+			edit:    fileEdit{OldString: "if b {\n    return b\n}", NewString: "x"},
+			wantErr: "lines 3-5 (",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := applyEdits(content, []fileEdit{tt.edit})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Got:\n%s\nwant:\n%s", got, tt.want)
+			}
+		})
+	}
+
+	// Edits must not overlap
+	_, _, err := applyEdits(content, []fileEdit{
+		{OldString: "a := 1\nb := 2", NewString: "x"},
+		{OldString: "b := 2\nif", NewString: "y"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "edits 1 and 2 overlap") {
+		t.Errorf("Expected overlapping edits to fail, got %v", err)
+	}
+
+	// Files with CRLF line endings take edits written with \n
+	got, _, err := applyEdits("one\r\ntwo\r\n", []fileEdit{{OldString: "one\ntwo", NewString: "1\n2"}})
+	if err != nil || got != "1\r\n2\r\n" {
+		t.Errorf("Expected CRLF kept, got %q (%v)", got, err)
+	}
+}
+
+func TestRegistryExecuteEditPreview(t *testing.T) {
+	tmpFile := filepath.Join(t.TempDir(), "notes.txt")
+	os.WriteFile(tmpFile, []byte("hello world\n"), 0o644)
+
+	reg := NewRegistry()
+	reg.Register(NewEditFileTool())
+
+	// Approval requests carry the diff
+	result, err := reg.Execute(context.Background(), schema.ToolCall{
+		ID:   "edit-1",
+		Name: "edit_file",
+		Arguments: map[string]any{
+			"path":  tmpFile,
+			"edits": []any{map[string]any{"old_string": "hello", "new_string": "goodbye"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+	if result.Approval == nil || !strings.Contains(result.Approval.Preview, "+goodbye world") {
+		t.Errorf("Expected an approval request with a diff, got %+v", result.Approval)
+	}
+
+	// Edits that cannot apply fail without asking for approval
+	result, err = reg.Execute(context.Background(), schema.ToolCall{
+		ID:   "edit-2",
+		Name: "edit_file",
+		Arguments: map[string]any{
+			"path":  tmpFile,
+			"edits": `[{"old_string": "hello wrld", "new_string": "bye"}]`,
+		},
+	})
+	if err == nil || result.Approval != nil || !strings.Contains(result.Error, "closest text") {
+		t.Errorf("Expected the missing match reported, got %+v (%v)", result, err)
+	}
+}
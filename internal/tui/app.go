@@ -2,8 +2,11 @@ package tui
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"slices"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
@@ -13,6 +16,8 @@ import (
 	"github.com/siddharth-bhatnagar/anvil/internal/llm"
 	"github.com/siddharth-bhatnagar/anvil/internal/tools"
 	"github.com/siddharth-bhatnagar/anvil/internal/tui/panels"
+	"github.com/siddharth-bhatnagar/anvil/internal/util"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
 // AgentResponseMsg represents a response from the agent
@@ -23,6 +28,9 @@ type AgentResponseMsg struct {
 
 const version = "0.1.0"
 
+// turnCancelledMessage is shown after the user cancels an agent turn
+const turnCancelledMessage = "Cancelled. Output so far was kept; send a message to redirect the agent."
+
 // Model represents the application state
 type Model struct {
 	width           int
@@ -36,18 +44,42 @@ type Model struct {
 	configManager   *config.Manager
 	streaming       bool
 	streamBuffer    string
+	streamedTurn    bool // Whether the current agent turn streamed any text
+	cancelTurn      context.CancelFunc
+	cancelling      bool
+	sender          *programSender
+	phase           agent.Phase
+	planSteps       []agent.PlanStep
+	verification    *agent.VerifyReport
 	agent           *agent.Agent
 	approvalManager *agent.ApprovalManager
 	pendingApproval *agent.ApprovalItem
 	awaitingApproval bool
+	reviewingPlan   bool // The plan panel is waiting for the user to approve the plan
+	ollama          *llm.OllamaClient // Set when the provider is Ollama, to manage its models
+	modelStatus     string            // Progress of checking, pulling or loading the model
+	offerPull       bool              // The configured model is missing and can be pulled
+	pulling         bool              // The model is being pulled as the running turn
+	primaryProvider string            // Configured provider and model, e.g. "anthropic/claude-sonnet-4-5"
+	activeProvider  string            // Provider and model serving requests, which differs after a fallback
+	attachments     []llm.Image       // Images attached from the Files panel, sent with the next message
+	sessionID       string            // Identifies this run in the usage ledger and its trace
+	tracer          *llm.Tracer       // Set when tracing is enabled
 }
 
 // Init initializes the model
 func (m Model) Init() tea.Cmd {
-	return tea.Batch(
+	cmds := []tea.Cmd{
 		m.panelManager.Init(),
 		textinput.Blink,
-	)
+	}
+
+	// Catch a missing or misspelled local model before the first message
+	if m.ollama != nil {
+		cmds = append(cmds, m.prepareModel())
+	}
+
+	return tea.Batch(cmds...)
 }
 
 // Update handles messages and updates the model
@@ -65,9 +97,21 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		// If streaming, only allow interrupt
 		if m.streaming {
-			if msg.String() == "ctrl+c" {
+			switch msg.String() {
+			case "esc":
+				// Cancel the turn; its result arrives as usual once it unwinds
+				if m.cancelTurn != nil && !m.cancelling {
+					m.cancelling = true
+					m.cancelTurn()
+				}
+			case "ctrl+c":
+				if m.cancelTurn != nil {
+					m.cancelTurn()
+				}
 				m.streaming = false
 				return m, tea.Quit
+			case "t":
+				m.toggleReasoning()
 			}
 			return m, nil
 		}
@@ -80,7 +124,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				userMsg := m.input.Value()
 				if userMsg != "" {
 					m.input.SetValue("")
-					return m, m.sendMessage(userMsg)
+					cmd := m.sendMessage(userMsg)
+					return m, cmd
 				}
 				return m, nil
 			case "esc":
@@ -93,6 +138,16 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 
+		// While the plan is under review its panel gets every key
+		if m.reviewingPlan {
+			if msg.String() == "ctrl+c" {
+				return m, tea.Quit
+			}
+			planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+			cmd := planPanel.UpdatePanel(msg)
+			return m, cmd
+		}
+
 		// Handle approval responses
 		if m.awaitingApproval && m.pendingApproval != nil {
 			switch msg.String() {
@@ -100,36 +155,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// Approve the tool call
 				if m.approvalManager != nil && m.agent != nil {
 					m.approvalManager.Approve(m.pendingApproval.ID)
-
-					// Execute the approved tool
-					ctx := context.Background()
-					result, err := m.agent.ApproveToolCall(ctx, m.pendingApproval.ToolCall)
-
-					convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
-					if err != nil {
-						convPanel.AddMessage("system", fmt.Sprintf("Tool execution failed: %v", err))
-					} else {
-						convPanel.AddMessage("system", fmt.Sprintf("Tool executed successfully:\n%s", result.Output))
-					}
-
-					// Check for more pending approvals
-					m.approvalManager.ClearResolved()
-					pending := m.approvalManager.GetPending()
-					if len(pending) > 0 {
-						m.pendingApproval = pending[0]
-						approvalText := agent.FormatApprovalRequest(m.pendingApproval)
-						convPanel.AddMessage("system", fmt.Sprintf("Approval Required:\n%s\n\nPress 'y' to approve, 'n' to reject", approvalText))
-					} else {
-						m.awaitingApproval = false
-						m.pendingApproval = nil
-
-						// Continue the agent loop
-						return m, func() tea.Msg {
-							ctx := context.Background()
-							resp, err := m.agent.ContinueAfterApproval(ctx)
-							return AgentResponseMsg{Response: resp, Error: err}
-						}
-					}
+					return m, m.runApprovedTool(m.pendingApproval.ToolCall)
 				}
 				return m, nil
 
@@ -138,28 +164,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.approvalManager != nil && m.agent != nil {
 					m.approvalManager.Reject(m.pendingApproval.ID, "User rejected")
 					m.agent.RejectToolCall(m.pendingApproval.ToolCall, "User rejected")
+					if m.tracer != nil {
+						m.tracer.RecordToolResult(m.pendingApproval.ToolCall, nil, errors.New("rejected by user"))
+					}
 
 					convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
 					convPanel.AddMessage("system", fmt.Sprintf("Tool call rejected: %s", m.pendingApproval.ToolCall.Name))
-
-					// Check for more pending approvals
-					m.approvalManager.ClearResolved()
-					pending := m.approvalManager.GetPending()
-					if len(pending) > 0 {
-						m.pendingApproval = pending[0]
-						approvalText := agent.FormatApprovalRequest(m.pendingApproval)
-						convPanel.AddMessage("system", fmt.Sprintf("Approval Required:\n%s\n\nPress 'y' to approve, 'n' to reject", approvalText))
-					} else {
-						m.awaitingApproval = false
-						m.pendingApproval = nil
-
-						// Continue the agent loop
-						return m, func() tea.Msg {
-							ctx := context.Background()
-							resp, err := m.agent.ContinueAfterApproval(ctx)
-							return AgentResponseMsg{Response: resp, Error: err}
-						}
-					}
+					return m, m.nextApproval()
 				}
 				return m, nil
 			}
@@ -170,6 +181,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case "ctrl+c", "q":
 			return m, tea.Quit
 
+		case "p":
+			if m.offerPull {
+				m.offerPull = false
+				return m, m.pullModel()
+			}
+			return m, nil
+
 		case "i", "/":
 			m.input.Focus()
 			return m, textinput.Blink
@@ -198,6 +216,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.panelManager.SetActivePanelByType(PanelPlan)
 			return m, nil
 
+		case "t":
+			m.toggleReasoning()
+			return m, nil
+
 		case "?":
 			m.showHelp = !m.showHelp
 			return m, nil
@@ -205,23 +227,35 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case StreamChunkMsg:
 		// Handle streaming chunk
+		convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+
 		if msg.Error != nil {
-			m.streaming = false
+			m.endTurn()
+			if errors.Is(msg.Error, context.Canceled) {
+				convPanel.FinishStream()
+				convPanel.AddMessage("system", turnCancelledMessage)
+				return m, nil
+			}
 			// Show error
 			return m, func() tea.Msg {
 				return ErrorMsg{Error: msg.Error}
 			}
 		}
 
+		if msg.Reasoning != "" {
+			convPanel.AppendReasoning(msg.Reasoning)
+		}
+
 		if msg.Delta != "" {
 			m.streamBuffer += msg.Delta
+			m.streamedTurn = true
+			convPanel.AppendStream(msg.Delta)
 		}
 
 		if msg.Done {
-			m.streaming = false
-			// Add complete message to conversation
-			convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
-			convPanel.AddMessage("assistant", m.streamBuffer)
+			m.endTurn()
+			// The streamed message is already in the conversation
+			convPanel.FinishStream()
 
 			// Track usage
 			if msg.Usage != nil {
@@ -235,54 +269,106 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case ErrorMsg:
 		// Handle error - could show in status bar or conversation
-		m.streaming = false
+		m.endTurn()
 		convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
 		convPanel.AddMessage("system", fmt.Sprintf("Error: %v", msg.Error))
 		return m, nil
 
+	case AgentEventMsg:
+		m.handleAgentEvent(msg.Event)
+		return m, nil
+
+	case ModelLoadMsg:
+		m.handleModelLoad(msg)
+		return m, nil
+
+	case ProviderSwitchMsg:
+		m.activeProvider = msg.To
+		convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+		if msg.Cause != nil {
+			convPanel.AddMessage("system", fmt.Sprintf("%s failed (%v); switched to %s", msg.From, msg.Cause, msg.To))
+		} else {
+			convPanel.AddMessage("system", fmt.Sprintf("Switched back to %s", msg.To))
+		}
+		return m, nil
+
+	case panels.PlanApprovedMsg:
+		cmd := m.approvePlan(msg.Steps)
+		return m, cmd
+
+	case panels.PlanRejectedMsg:
+		cmd := m.rejectPlan()
+		return m, cmd
+
+	case panels.AttachFileMsg:
+		m.attachFile(msg.Path)
+		return m, nil
+
+	case ToolApprovalMsg:
+		cancelled := m.cancelling || errors.Is(msg.Error, context.Canceled)
+		m.endTurn()
+		if m.tracer != nil {
+			m.tracer.RecordToolResult(msg.ToolCall, msg.Result, msg.Error)
+		}
+
+		convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+		if msg.Error != nil {
+			convPanel.AddMessage("system", fmt.Sprintf("Tool execution failed: %v", msg.Error))
+		} else {
+			convPanel.AddMessage("system", fmt.Sprintf("Tool executed successfully:\n%s", msg.Result.Output))
+		}
+
+		// A cancelled turn stops here, even if the tool finished first
+		if cancelled {
+			m.cancelApprovals()
+			convPanel.AddMessage("system", turnCancelledMessage)
+			return m, nil
+		}
+		return m, m.nextApproval()
+
 	case AgentResponseMsg:
-		m.streaming = false
+		m.endTurn()
+
+		convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+		convPanel.FinishStream()
 
 		if msg.Error != nil {
-			convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+			if errors.Is(msg.Error, context.Canceled) {
+				// Partial output and finished tool results stay in the agent's context
+				if msg.Response != nil {
+					m.phase = msg.Response.Phase
+					m.planSteps = msg.Response.PlanSteps
+					m.updatePlanPanel()
+				}
+				convPanel.AddMessage("system", turnCancelledMessage)
+				return m, nil
+			}
 			convPanel.AddMessage("system", fmt.Sprintf("Error: %v", msg.Error))
 			return m, nil
 		}
 
 		if msg.Response != nil {
-			// Add assistant message to conversation
-			if msg.Response.Message != "" {
-				convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+			// Add assistant message to conversation unless it was streamed
+			if msg.Response.Message != "" && !m.streamedTurn {
 				convPanel.AddMessage("assistant", msg.Response.Message)
 			}
 
 			// Update plan panel with lifecycle phase
-			planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
-			planPanel.ClearSteps()
-
-			// Add phase indicator
-			phaseNames := []string{"Understand", "Plan", "Act", "Verify"}
-			currentPhase := int(msg.Response.Phase)
-			for i, name := range phaseNames {
-				planPanel.AddStep(name)
-				if i < currentPhase {
-					planPanel.UpdateStep(i+1, panels.StepCompleted, "")
-				} else if i == currentPhase {
-					planPanel.UpdateStep(i+1, panels.StepInProgress, "")
-				}
+			m.phase = msg.Response.Phase
+			m.planSteps = msg.Response.PlanSteps
+			if msg.Response.Verification != nil {
+				m.verification = msg.Response.Verification
 			}
-
-			// Add plan steps if available
-			for _, step := range msg.Response.PlanSteps {
-				planPanel.AddStep(step.Description)
-				switch step.Status {
-				case agent.StepCompleted:
-					planPanel.UpdateStep(planPanel.StepCount(), panels.StepCompleted, step.Result)
-				case agent.StepInProgress:
-					planPanel.UpdateStep(planPanel.StepCount(), panels.StepInProgress, "")
-				case agent.StepFailed:
-					planPanel.UpdateStep(planPanel.StepCount(), panels.StepFailed, "")
-				}
+			m.updatePlanPanel()
+
+			// Let the user review the plan before any step runs
+			if msg.Response.AwaitingPlanApproval {
+				m.reviewingPlan = true
+				planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+				planPanel.StartReview()
+				m.panelManager.SetActivePanelByType(PanelPlan)
+				convPanel.AddMessage("system", "Review the plan in the Plan panel, then press Enter to approve it or Esc to reject it")
+				return m, nil
 			}
 
 			// Handle pending approvals
@@ -318,28 +404,391 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // sendMessage sends a message to the agent
 func (m *Model) sendMessage(content string) tea.Cmd {
+	images := m.takeAttachments(content)
+
 	// Add user message to conversation
 	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
-	convPanel.AddMessage("user", content)
+	convPanel.AddMessageWithImages("user", content, imagePlaceholders(images))
 
 	// If no agent, fall back to direct LLM call
 	if m.agent == nil {
-		return m.sendDirectLLMMessage(content)
+		return m.sendDirectLLMMessage(content, images)
 	}
 
-	m.streaming = true
-	m.streamBuffer = ""
+	ctx := m.startTurn()
 
-	// Use the agent
+	// Use the agent; progress arrives as AgentEventMsg and StreamChunkMsg
+	agt := m.agent
 	return func() tea.Msg {
-		ctx := context.Background()
-		resp, err := m.agent.ProcessRequest(ctx, content)
+		resp, err := agt.ProcessRequest(ctx, content, images...)
+		return AgentResponseMsg{Response: resp, Error: err}
+	}
+}
+
+// approvePlan hands the reviewed plan back to the agent and runs it
+func (m *Model) approvePlan(steps []panels.Step) tea.Cmd {
+	if m.agent == nil {
+		m.reviewingPlan = false
+		return nil
+	}
+
+	planSteps := make([]agent.PlanStep, len(steps))
+	for i, step := range steps {
+		planSteps[i] = agent.PlanStep{
+			Title:     step.Description,
+			Rationale: step.Rationale,
+			Files:     step.Files,
+		}
+		// The panel numbers steps from 1
+		for _, dep := range step.DependsOn {
+			planSteps[i].DependsOn = append(planSteps[i].DependsOn, dep-1)
+		}
+		if step.Status == panels.StepSkipped {
+			planSteps[i].Status = agent.StepSkipped
+		}
+	}
+
+	// Keep reviewing if the agent would refuse the edited plan
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	if err := m.agent.CheckPlan(planSteps); err != nil {
+		planPanel.StartReview()
+		planPanel.SetOutcome(&panels.Outcome{
+			Title:   "The plan cannot be approved as edited",
+			Status:  panels.StepFailed,
+			Details: err.Error(),
+		})
+		m.panelManager.SetActivePanelByType(PanelPlan)
+		return nil
+	}
+
+	m.reviewingPlan = false
+	planPanel.SetOutcome(nil)
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	convPanel.AddMessage("system", "Plan approved")
+
+	ctx := m.startTurn()
+
+	agt := m.agent
+	return func() tea.Msg {
+		resp, err := agt.ApprovePlan(ctx, planSteps)
+		return AgentResponseMsg{Response: resp, Error: err}
+	}
+}
+
+// rejectPlan drops the plan under review and hands focus back to the input
+// for the next request
+func (m *Model) rejectPlan() tea.Cmd {
+	m.reviewingPlan = false
+
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	if m.agent != nil {
+		if err := m.agent.RejectPlan(); err != nil {
+			convPanel.AddMessage("system", fmt.Sprintf("Error: %v", err))
+		}
+	}
+	m.phase = agent.PhaseUnderstand
+	m.planSteps = nil
+	m.updatePlanPanel()
+	convPanel.AddMessage("system", "Plan rejected")
+
+	m.input.Focus()
+	return textinput.Blink
+}
+
+// runApprovedTool executes a tool call the user approved as part of the
+// turn, so Esc cancels it; the outcome arrives as ToolApprovalMsg
+func (m *Model) runApprovedTool(call schema.ToolCall) tea.Cmd {
+	ctx := m.startTurn()
+
+	agt := m.agent
+	return func() tea.Msg {
+		result, err := agt.ApproveToolCall(ctx, call)
+		return ToolApprovalMsg{ToolCall: call, Result: result, Error: err}
+	}
+}
+
+// nextApproval asks about the next pending tool call, or resumes the
+// agent loop once every approval is resolved
+func (m *Model) nextApproval() tea.Cmd {
+	m.approvalManager.ClearResolved()
+	pending := m.approvalManager.GetPending()
+	if len(pending) > 0 {
+		m.pendingApproval = pending[0]
+		convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+		approvalText := agent.FormatApprovalRequest(m.pendingApproval)
+		convPanel.AddMessage("system", fmt.Sprintf("Approval Required:\n%s\n\nPress 'y' to approve, 'n' to reject", approvalText))
+		return nil
+	}
+
+	m.awaitingApproval = false
+	m.pendingApproval = nil
+
+	// Continue the agent loop
+	return m.continueAgent()
+}
+
+// cancelApprovals rejects the tool calls still waiting for approval when
+// the user cancels the turn, leaving the agent to be redirected
+func (m *Model) cancelApprovals() {
+	m.approvalManager.ClearResolved()
+	for _, pending := range m.approvalManager.GetPending() {
+		m.approvalManager.Reject(pending.ID, "Cancelled")
+		m.agent.RejectToolCall(pending.ToolCall, "cancelled")
+		if m.tracer != nil {
+			m.tracer.RecordToolResult(pending.ToolCall, nil, context.Canceled)
+		}
+	}
+	m.approvalManager.ClearResolved()
+	m.awaitingApproval = false
+	m.pendingApproval = nil
+}
+
+// continueAgent resumes the agent loop once all pending approvals are resolved
+func (m *Model) continueAgent() tea.Cmd {
+	ctx := m.startTurn()
+
+	agt := m.agent
+	return func() tea.Msg {
+		resp, err := agt.ContinueAfterApproval(ctx)
 		return AgentResponseMsg{Response: resp, Error: err}
 	}
 }
 
+// prepareModel checks that the configured Ollama model is installed and
+// loads it into memory; progress arrives as ModelLoadMsg
+func (m *Model) prepareModel() tea.Cmd {
+	client, sender := m.ollama, m.sender
+	return func() tea.Msg {
+		ctx := context.Background()
+		if err := client.ValidateModel(ctx); err != nil {
+			return ModelLoadMsg{Error: err}
+		}
+		return loadModel(ctx, client, sender)
+	}
+}
+
+// pullModel downloads the configured Ollama model, then loads it. The
+// pull runs as a turn, so Esc cancels it.
+func (m *Model) pullModel() tea.Cmd {
+	client, sender := m.ollama, m.sender
+	m.modelStatus = "Pulling " + client.Model()
+	m.pulling = true
+	ctx := m.startTurn()
+	return func() tea.Msg {
+		err := client.Pull(ctx, func(progress llm.PullProgress) {
+			sender.Send(ModelLoadMsg{
+				Status:    progress.Status,
+				Completed: progress.Completed,
+				Total:     progress.Total,
+			})
+		})
+		if err != nil {
+			return ModelLoadMsg{Error: err}
+		}
+		return loadModel(ctx, client, sender)
+	}
+}
+
+// loadModel loads an installed model into memory and looks up its
+// context window
+func loadModel(ctx context.Context, client *llm.OllamaClient, sender *programSender) tea.Msg {
+	sender.Send(ModelLoadMsg{Status: "Loading " + client.Model()})
+	if err := client.Load(ctx); err != nil {
+		return ModelLoadMsg{Error: err}
+	}
+
+	msg := ModelLoadMsg{Done: true}
+	if info, err := client.ShowModel(ctx, client.Model()); err == nil {
+		msg.ContextLength = info.ContextLength
+		msg.Capabilities = info.Capabilities
+	}
+	return msg
+}
+
+// handleModelLoad shows the progress of checking, pulling and loading the
+// local model
+func (m *Model) handleModelLoad(msg ModelLoadMsg) {
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+
+	cancelled := false
+	if m.pulling && (msg.Error != nil || msg.Done) {
+		cancelled = m.cancelling || errors.Is(msg.Error, context.Canceled)
+		m.pulling = false
+		m.endTurn()
+	}
+
+	switch {
+	case cancelled:
+		m.modelStatus = ""
+		m.offerPull = true
+		convPanel.AddMessage("system", fmt.Sprintf("Pull of %q cancelled. Press p to pull it again", m.ollama.Model()))
+
+	case msg.Error != nil:
+		m.modelStatus = ""
+		var notFound *llm.ModelNotFoundError
+		if errors.As(msg.Error, &notFound) {
+			m.offerPull = true
+			convPanel.AddMessage("system", fmt.Sprintf("Error: %v\nPress p to pull %q, or set another model in ~/.anvil/config.yaml", msg.Error, notFound.Model))
+			return
+		}
+		convPanel.AddMessage("system", fmt.Sprintf("Error: %v", msg.Error))
+
+	case msg.Done:
+		m.modelStatus = ""
+		ready := fmt.Sprintf("Model %s is ready", m.ollama.Model())
+		if msg.ContextLength > 0 {
+			ready += fmt.Sprintf(" (%d token context window)", msg.ContextLength)
+			if m.agent != nil {
+				m.agent.SetContextWindow(msg.ContextLength)
+			}
+		}
+		// The catalog does not know local models, so use what Ollama reports
+		if m.agent != nil && len(msg.Capabilities) > 0 {
+			m.agent.SetFeatures(
+				slices.Contains(msg.Capabilities, llm.OllamaCapabilityVision),
+				slices.Contains(msg.Capabilities, llm.OllamaCapabilityThinking),
+			)
+		}
+		convPanel.AddMessage("system", ready)
+
+	default:
+		m.modelStatus = msg.Status
+		if msg.Total > 0 {
+			m.modelStatus += fmt.Sprintf(" %d%%", msg.Completed*100/msg.Total)
+		}
+	}
+}
+
+// startTurn marks a turn as running and returns the context it runs under.
+// Pressing Esc cancels the context, which aborts the in-flight request,
+// any running shell command and the remaining loop iterations.
+func (m *Model) startTurn() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelTurn = cancel
+	m.cancelling = false
+	m.streaming = true
+	m.streamBuffer = ""
+	m.streamedTurn = false
+	m.verification = nil
+	return ctx
+}
+
+// endTurn clears the running turn state
+func (m *Model) endTurn() {
+	if m.cancelTurn != nil {
+		m.cancelTurn()
+		m.cancelTurn = nil
+	}
+	m.cancelling = false
+	m.streaming = false
+	m.streamBuffer = ""
+}
+
+// handleAgentEvent applies a progress event from the running agent turn
+func (m *Model) handleAgentEvent(event agent.Event) {
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+
+	switch event.Type {
+	case agent.EventToolCallStart:
+		// Text streamed so far belongs before the tool call
+		m.streamBuffer = ""
+		convPanel.AddMessage("system", fmt.Sprintf("Running %s...", event.ToolCall.Name))
+
+	case agent.EventToolCallFinish:
+		switch {
+		case event.Error != nil:
+			convPanel.AddMessage("system", fmt.Sprintf("%s failed: %v", event.ToolCall.Name, event.Error))
+		case event.ToolResult != nil && !event.ToolResult.Success:
+			convPanel.AddMessage("system", fmt.Sprintf("%s failed: %s", event.ToolCall.Name, event.ToolResult.Error))
+		default:
+			convPanel.AddMessage("system", fmt.Sprintf("%s finished", event.ToolCall.Name))
+		}
+
+	case agent.EventPhaseChange:
+		m.phase = event.Phase
+		m.updatePlanPanel()
+
+	case agent.EventPlanUpdate:
+		m.planSteps = event.PlanSteps
+		m.updatePlanPanel()
+
+	case agent.EventUsage:
+		if event.Usage != nil {
+			m.tokenTracker.AddUsage(*event.Usage)
+		}
+
+	case agent.EventVerification:
+		m.verification = event.Verification
+		m.updatePlanPanel()
+	}
+}
+
+// updatePlanPanel renders the lifecycle phases, the plan steps and the
+// verification outcome
+func (m *Model) updatePlanPanel() {
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	planPanel.ClearSteps()
+
+	// Add phase indicator
+	phaseNames := []string{"Understand", "Plan", "Act", "Verify"}
+	planPanel.SetPhases(phaseNames, int(m.phase))
+
+	// Add plan steps if available
+	for _, step := range m.planSteps {
+		panelStep := panels.Step{
+			Description: step.Title,
+			Rationale:   step.Rationale,
+			Files:       step.Files,
+			Revised:     step.Revision > 0,
+		}
+		for _, dep := range step.DependsOn {
+			panelStep.DependsOn = append(panelStep.DependsOn, dep+1)
+		}
+
+		switch step.Status {
+		case agent.StepCompleted:
+			panelStep.Status = panels.StepCompleted
+		case agent.StepInProgress:
+			panelStep.Status = panels.StepInProgress
+		case agent.StepSkipped:
+			panelStep.Status = panels.StepSkipped
+		case agent.StepFailed:
+			panelStep.Status = panels.StepFailed
+		case agent.StepBlocked:
+			panelStep.Status = panels.StepBlocked
+		case agent.StepReplaced:
+			panelStep.Status = panels.StepReplaced
+		}
+		if step.Error != nil {
+			panelStep.Details = step.Error.Error()
+		}
+
+		planPanel.AddPlanStep(panelStep)
+	}
+
+	// Add the verification outcome if checks have run
+	if m.verification == nil {
+		planPanel.SetOutcome(nil)
+		return
+	}
+
+	outcome := &panels.Outcome{
+		Title:   "Verification passed",
+		Status:  panels.StepCompleted,
+		Details: m.verification.Summary(),
+	}
+	if !m.verification.Passed {
+		outcome.Title = "Verification failed"
+		outcome.Status = panels.StepFailed
+	}
+	if m.verification.Attempt > 0 {
+		outcome.Title += fmt.Sprintf(" (after %d repair attempt(s))", m.verification.Attempt)
+	}
+	planPanel.SetOutcome(outcome)
+}
+
 // sendDirectLLMMessage sends a message directly to the LLM (fallback)
-func (m *Model) sendDirectLLMMessage(content string) tea.Cmd {
+func (m *Model) sendDirectLLMMessage(content string, images []llm.Image) tea.Cmd {
 	if m.llmClient == nil {
 		return func() tea.Msg {
 			return ErrorMsg{Error: fmt.Errorf("no LLM client configured")}
@@ -348,7 +797,7 @@ func (m *Model) sendDirectLLMMessage(content string) tea.Cmd {
 
 	// Prepare request
 	messages := []llm.Message{
-		{Role: llm.RoleUser, Content: content},
+		{Role: llm.RoleUser, Content: content, Images: images},
 	}
 
 	req := llm.Request{
@@ -356,24 +805,34 @@ func (m *Model) sendDirectLLMMessage(content string) tea.Cmd {
 		Stream:   true,
 	}
 
-	m.streaming = true
-	m.streamBuffer = ""
+	ctx := m.startTurn()
 
-	// Start streaming
+	// Start streaming; chunks are forwarded to the program as they arrive
+	// and the final message carries the usage
+	sender := m.sender
+	client := m.llmClient
 	return func() tea.Msg {
-		ctx := context.Background()
-
-		err := m.llmClient.Stream(ctx, req, func(event llm.StreamEvent) {
-			// Send each chunk as a message
-			// Note: In a real implementation, we'd use a channel
-			// For simplicity, we'll just accumulate and send at the end
+		var usage *llm.Usage
+		err := client.Stream(ctx, req, func(event llm.StreamEvent) {
+			if event.Reasoning != "" {
+				sender.Send(StreamChunkMsg{Reasoning: event.Reasoning})
+			}
+			if event.Delta != "" {
+				sender.Send(StreamChunkMsg{Delta: event.Delta})
+			}
+			if event.Usage != nil {
+				usage = event.Usage
+			}
 		})
 
+		if ctx.Err() != nil {
+			return StreamChunkMsg{Error: ctx.Err(), Done: true}
+		}
 		if err != nil {
 			return StreamChunkMsg{Error: err, Done: true}
 		}
 
-		return StreamChunkMsg{Done: true}
+		return StreamChunkMsg{Done: true, Usage: usage}
 	}
 }
 
@@ -489,6 +948,12 @@ func (m Model) renderPanelWithBorder(panel Panel, width, height int) string {
 	)
 }
 
+// toggleReasoning expands or collapses the model's reasoning
+func (m *Model) toggleReasoning() {
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	convPanel.ToggleReasoning()
+}
+
 // renderStatusBar renders the status bar
 func (m Model) renderStatusBar() string {
 	activePanel := m.panelManager.GetActivePanel()
@@ -503,14 +968,36 @@ func (m Model) renderStatusBar() string {
 	if stats.TotalTokens > 0 {
 		tokenInfo = fmt.Sprintf(" | Tokens: %d", stats.TotalTokens)
 	}
+	if stats.TotalCacheReadTokens > 0 || stats.TotalCacheWriteTokens > 0 {
+		tokenInfo += fmt.Sprintf(" | Cache: %.0f%%", stats.CacheHitRate()*100)
+	}
 
 	// Show streaming indicator
 	streamingIndicator := ""
-	if m.streaming {
-		streamingIndicator = " | ⚡ Streaming..."
+	if m.cancelling {
+		streamingIndicator = " | Cancelling..."
+	} else if m.streaming {
+		streamingIndicator = " | ⚡ Streaming... (Esc to cancel)"
+	} else if m.reviewingPlan {
+		streamingIndicator = " | Reviewing plan (Enter to approve, Esc to reject)"
+	}
+
+	if n := len(m.attachments); n > 0 {
+		streamingIndicator += fmt.Sprintf(" | Images: %d attached", n)
+	}
+
+	modelInfo := ""
+	if m.activeProvider != "" {
+		modelInfo = " | " + m.activeProvider
+		if m.activeProvider != m.primaryProvider {
+			modelInfo += " (fallback)"
+		}
+	}
+	if m.modelStatus != "" {
+		modelInfo += " | " + m.modelStatus
 	}
 
-	left := fmt.Sprintf("Panel: %s%s%s", activeName, tokenInfo, streamingIndicator)
+	left := fmt.Sprintf("Panel: %s%s%s%s", activeName, tokenInfo, streamingIndicator, modelInfo)
 	right := fmt.Sprintf("i Input | ? Help | Tab Switch | q Quit | %dx%d", m.width, m.height)
 
 	// Calculate spacing
@@ -544,16 +1031,21 @@ func (m Model) renderHelp() string {
 				"",
 				"Panels:",
 				"  1 - Conversation",
-				"  2 - Files (j/k to navigate, r to refresh)",
+				"  2 - Files (j/k to navigate, r to refresh,",
+				"       a attach the selected image)",
 				"  3 - Diff",
-				"  4 - Plan",
+				"  4 - Plan (review: j/k select, J/K move, e edit,",
+				"       a add, d delete, s skip, Enter approve)",
 				"",
 				"Input:",
 				"  i or / - Focus input field",
-				"  Enter  - Send message",
+				"  Enter  - Send (image paths in it are attached)",
 				"  Esc    - Exit input mode",
+				"  Esc    - Cancel the running turn",
 				"",
 				"General:",
+				"  t      - Show or hide the model's reasoning",
+				"  p      - Pull the local model when it is missing",
 				"  ?      - Toggle this help",
 				"  q      - Quit",
 				"  Ctrl+C - Quit",
@@ -645,6 +1137,8 @@ index 1234567..abcdefg 100644
 		tokenTracker:  tokenTracker,
 		streamBuffer:  "",
 		streaming:     false,
+		sender:        &programSender{},
+		sessionID:     agent.NewSessionID(),
 	}
 }
 
@@ -657,27 +1151,57 @@ func NewModelWithConfig(configMgr *config.Manager) (Model, error) {
 	// Initialize LLM client
 	cfg := configMgr.GetConfig()
 
-	// Try to get API key for the configured provider
-	apiKey, err := configMgr.GetAPIKey(cfg.Provider)
+	// Create LLM client; a missing API key is reported rather than
+	// leaving a model that cannot send messages
+	client, err := newProviderClient(configMgr, config.ProviderConfig{
+		Provider: cfg.Provider,
+		Model:    cfg.Model,
+		BaseURL:  cfg.BaseURL,
+		Script:   cfg.Script,
+	})
 	if err != nil {
-		// No API key configured - app will work but can't send messages
-		// We'll show an error when the user tries to send a message
-		return m, nil
+		return m, err
 	}
+	if ollama, ok := client.(*llm.OllamaClient); ok {
+		m.ollama = ollama
+	}
+	m.primaryProvider = llm.DescribeClient(client)
+	m.activeProvider = m.primaryProvider
 
-	// Create LLM client
-	llmConfig := llm.ClientConfig{
-		Provider:    llm.ProviderType(cfg.Provider),
-		APIKey:      apiKey,
-		Model:       cfg.Model,
-		MaxTokens:   cfg.MaxTokens,
-		Temperature: cfg.Temperature,
-		MaxRetries:  3,
+	// Fall back to the configured alternatives when the provider fails
+	if len(cfg.Fallback.Providers) > 0 {
+		fallback, err := newFallbackClient(configMgr, client)
+		if err != nil {
+			return m, err
+		}
+		sender := m.sender
+		fallback.SetSwitchCallback(func(from, to llm.Client, cause error) {
+			sender.Send(ProviderSwitchMsg{
+				From:  llm.DescribeClient(from),
+				To:    llm.DescribeClient(to),
+				Cause: cause,
+			})
+		})
+		client = fallback
 	}
 
-	client, err := llm.NewClient(llmConfig)
-	if err != nil {
-		return m, err
+	// Record every call's token usage in the ledger
+	if cfg.Usage.Ledger != "" {
+		cwd, _ := os.Getwd()
+		ledgerClient := llm.NewLedgerClient(client, llm.NewLedger(cfg.Usage.Ledger), m.sessionID, cwd)
+		ledgerClient.SetErrorCallback(func(err error) {
+			util.Logger.Warn().Err(err).Msg("Failed to record usage")
+		})
+		client = ledgerClient
+	}
+
+	// Trace every attempt, including retries, when enabled
+	if cfg.Trace.Enabled {
+		m.tracer = llm.NewTracer(cfg.Trace.Dir, m.sessionID, traceSecrets(configMgr)...)
+		m.tracer.SetErrorCallback(func(err error) {
+			util.Logger.Warn().Err(err).Msg("Failed to write trace")
+		})
+		client = llm.NewTracingClient(client, m.tracer)
 	}
 
 	// Wrap with retry logic
@@ -689,18 +1213,130 @@ func NewModelWithConfig(configMgr *config.Manager) (Model, error) {
 		return m, fmt.Errorf("failed to create tool registry: %w", err)
 	}
 
+	// Verification commands default to ones detected from the project
+	verifyCommands := cfg.Verify.Commands
+	if len(verifyCommands) == 0 {
+		cwd, _ := os.Getwd()
+		verifyCommands = agent.DetectVerifyCommands(cwd)
+	}
+
+	reasoningEffort, err := llm.ParseReasoningEffort(cfg.ReasoningEffort)
+	if err != nil {
+		return m, err
+	}
+
 	// Create agent
 	agentConfig := agent.Config{
 		SystemPrompt: getSystemPrompt(),
 		Model:        cfg.Model,
 		Temperature:  cfg.Temperature,
 		MaxTokens:    cfg.MaxTokens,
+		Verify: agent.VerifyConfig{
+			Commands:          verifyCommands,
+			MaxRepairAttempts: cfg.Verify.MaxRepairAttempts,
+			Timeout:           time.Duration(cfg.Verify.TimeoutSeconds) * time.Second,
+		},
+		ThinkingBudget:  cfg.ThinkingBudget,
+		ReasoningEffort: reasoningEffort,
+		Catalog:         cfg.ModelCatalog(),
 	}
 	m.agent = agent.NewAgent(m.llmClient, toolRegistry, agentConfig)
 
+	// Forward turn progress to the program so output renders live
+	sender := m.sender
+	tracer := m.tracer
+	m.agent.SetEventCallback(func(event agent.Event) {
+		if tracer != nil {
+			switch event.Type {
+			case agent.EventToolCallStart:
+				tracer.RecordToolCall(*event.ToolCall)
+			case agent.EventToolCallFinish:
+				tracer.RecordToolResult(*event.ToolCall, event.ToolResult, event.Error)
+			}
+		}
+		sender.Send(agentEventMsg(event))
+	})
+
 	return m, nil
 }
 
+// newProviderClient creates a client for one provider and model
+func newProviderClient(configMgr *config.Manager, provider config.ProviderConfig) (llm.Client, error) {
+	providerType := llm.ProviderType(provider.Provider)
+	apiKey, err := configMgr.GetAPIKey(provider.Provider)
+	if err != nil && providerType.RequiresAPIKey() {
+		return nil, fmt.Errorf("no API key for %s (set %s, or use the ollama or scripted provider): %w",
+			provider.Provider, config.APIKeyEnvVar(provider.Provider), err)
+	}
+
+	// Without a configured limit, replies are sized from the model
+	cfg := configMgr.GetConfig()
+	maxTokens := cfg.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = cfg.ModelCatalog().Info(provider.Model).ReplyTokens()
+	}
+
+	return llm.NewClient(llm.ClientConfig{
+		Provider:    providerType,
+		APIKey:      apiKey,
+		BaseURL:     provider.BaseURL,
+		Model:       provider.Model,
+		Script:      provider.Script,
+		MaxTokens:   maxTokens,
+		Temperature: cfg.Temperature,
+		MaxRetries:  3,
+	})
+}
+
+// traceSecrets returns the API keys of the configured providers, which
+// traces redact
+func traceSecrets(configMgr *config.Manager) []string {
+	cfg := configMgr.GetConfig()
+	providers := []string{cfg.Provider}
+	for _, provider := range cfg.Fallback.Providers {
+		providers = append(providers, provider.Provider)
+	}
+
+	var secrets []string
+	for _, provider := range providers {
+		if apiKey, err := configMgr.GetAPIKey(provider); err == nil && apiKey != "" {
+			secrets = append(secrets, apiKey)
+		}
+	}
+	return secrets
+}
+
+// newFallbackClient chains the primary client with the configured
+// fallback providers
+func newFallbackClient(configMgr *config.Manager, primary llm.Client) (*llm.FallbackClient, error) {
+	fallbackCfg := configMgr.GetConfig().Fallback
+	clients := []llm.Client{primary}
+
+	for _, provider := range fallbackCfg.Providers {
+		client, err := newProviderClient(configMgr, provider)
+		if err != nil {
+			return nil, fmt.Errorf("fallback provider %s: %w", provider.Provider, err)
+		}
+		clients = append(clients, client)
+	}
+
+	fallbackConfig := llm.DefaultFallbackConfig()
+	fallbackConfig.Cooldown = time.Duration(fallbackCfg.CooldownSeconds) * time.Second
+	fallbackConfig.Catalog = configMgr.GetConfig().ModelCatalog()
+	if len(fallbackCfg.Errors) > 0 {
+		fallbackConfig.FallbackOn = nil
+		for _, name := range fallbackCfg.Errors {
+			errType, err := llm.ParseErrorType(name)
+			if err != nil {
+				return nil, fmt.Errorf("fallback.errors: %w", err)
+			}
+			fallbackConfig.FallbackOn = append(fallbackConfig.FallbackOn, errType)
+		}
+	}
+
+	return llm.NewFallbackClient(clients, fallbackConfig)
+}
+
 // getSystemPrompt returns the system prompt for the agent
 func getSystemPrompt() string {
 	return `You are Anvil, an AI coding assistant. You help developers with their code by:
@@ -712,6 +1348,7 @@ func getSystemPrompt() string {
 When you need to perform actions, use the available tools:
 - read_file: Read file contents
 - write_file: Write content to a file (requires approval)
+- edit_file: Replace exact text in an existing file; prefer it to write_file for changes (requires approval)
 - search_files: Search for files by pattern
 - grep_files: Search for content in files
 - list_directory: List directory contents
@@ -720,6 +1357,12 @@ When you need to perform actions, use the available tools:
 - git_log: Show git log
 - shell_command: Execute shell commands (may require approval)
 
+For changes that take more than one step, first call submit_plan with the
+steps, their rationale, the files they touch and their dependencies. Each
+step is then executed once its dependencies are done. If a step cannot be
+completed, call fail_step with the reason and submit a revised plan for the
+remaining work.
+
 Always explain what you're doing and why. Be helpful, accurate, and transparent.`
 }
 
@@ -747,7 +1390,11 @@ func RunWithConfig(configMgr *config.Manager) error {
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
 	)
+	m.sender.program = p
 
 	_, err = p.Run()
+	if m.tracer != nil {
+		m.tracer.Close()
+	}
 	return err
 }
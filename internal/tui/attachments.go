@@ -0,0 +1,167 @@
+package tui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/internal/tui/panels"
+)
+
+// attachFile attaches the image at path to the next message
+func (m *Model) attachFile(path string) {
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+
+	if !llm.IsImagePath(path) {
+		convPanel.AddMessage("system", fmt.Sprintf("Cannot attach %s: only PNG, JPEG and GIF images can be attached", filepath.Base(path)))
+		return
+	}
+	if !m.acceptsImages() {
+		return
+	}
+
+	img, err := llm.LoadImage(path)
+	if err != nil {
+		convPanel.AddMessage("system", fmt.Sprintf("Cannot attach image: %v", err))
+		return
+	}
+
+	m.attachments = append(m.attachments, img)
+	convPanel.AddMessage("system", fmt.Sprintf("Attached %s; it will be sent with your next message", imagePlaceholder(img)))
+}
+
+// takeAttachments returns the images to send with a message: those
+// attached from the Files panel, then any whose paths the message
+// mentions. Images that fail to load are reported and left out.
+func (m *Model) takeAttachments(content string) []llm.Image {
+	images := m.attachments
+	m.attachments = nil
+
+	attached := make(map[string]bool, len(images))
+	for _, img := range images {
+		attached[img.Path] = true
+	}
+
+	for _, path := range imagePathsIn(content) {
+		if abs, err := filepath.Abs(path); err == nil && attached[abs] {
+			continue
+		}
+		img, err := llm.LoadImage(path)
+		if err != nil {
+			convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+			convPanel.AddMessage("system", fmt.Sprintf("Cannot attach image: %v", err))
+			continue
+		}
+		attached[img.Path] = true
+		images = append(images, img)
+	}
+
+	if len(images) > 0 && !m.acceptsImages() {
+		return nil
+	}
+	return images
+}
+
+// acceptsImages reports whether the model can be sent images, telling the
+// user when it cannot
+func (m *Model) acceptsImages() bool {
+	if m.agent == nil || m.agent.ModelInfo().Vision {
+		return true
+	}
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	convPanel.AddMessage("system", fmt.Sprintf("Cannot attach images: %s does not accept them", m.agent.ModelInfo().ID))
+	return false
+}
+
+// imagePlaceholders describes each image for the conversation panel
+func imagePlaceholders(images []llm.Image) []string {
+	var placeholders []string
+	for _, img := range images {
+		placeholders = append(placeholders, imagePlaceholder(img))
+	}
+	return placeholders
+}
+
+// imagePlaceholder describes an image, e.g. "screenshot.png 1280×720"
+func imagePlaceholder(img llm.Image) string {
+	if img.Width == 0 || img.Height == 0 {
+		return img.Name()
+	}
+	return fmt.Sprintf("%s %d×%d", img.Name(), img.Width, img.Height)
+}
+
+// imagePathsIn returns the paths of image files that exist and are
+// mentioned in text, such as a screenshot dragged into the terminal.
+// Paths may be quoted or prefixed with @, and spaces in them escaped
+// with a backslash.
+func imagePathsIn(text string) []string {
+	var paths []string
+	seen := make(map[string]bool)
+
+	for _, word := range splitWords(text) {
+		path := strings.TrimPrefix(word, "@")
+		path = strings.TrimRight(path, ",;:!?)")
+		path = strings.TrimSuffix(path, ".")
+		if strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, path[2:])
+			}
+		}
+
+		if !llm.IsImagePath(path) || seen[path] {
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		seen[path] = true
+		paths = append(paths, path)
+	}
+
+	return paths
+}
+
+// splitWords splits text at whitespace the way a shell would, keeping
+// quoted text and backslash-escaped spaces within a word. Only a quote
+// that starts a word opens a quoted section, so apostrophes are kept.
+func splitWords(text string) []string {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord, escaped := false, false
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case (r == '\'' || r == '"') && !inWord:
+			quote, inWord = r, true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words
+}
@@ -1,15 +1,31 @@
 package tui
 
 import (
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/siddharth-bhatnagar/anvil/internal/agent"
 	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
 // StreamChunkMsg represents a streaming chunk from the LLM
 type StreamChunkMsg struct {
-	Delta string
-	Done  bool
-	Error error
-	Usage *llm.Usage
+	Delta     string
+	Reasoning string // A chunk of the model's reasoning, shown apart from Delta
+	Done      bool
+	Error     error
+	Usage     *llm.Usage
+}
+
+// AgentEventMsg carries a progress event from a running agent turn
+type AgentEventMsg struct {
+	Event agent.Event
+}
+
+// ToolApprovalMsg carries the outcome of a tool call the user approved
+type ToolApprovalMsg struct {
+	ToolCall schema.ToolCall
+	Result   *schema.ToolResult
+	Error    error
 }
 
 // SendMessageMsg is sent when the user submits a message
@@ -21,3 +37,49 @@ type SendMessageMsg struct {
 type ErrorMsg struct {
 	Error error
 }
+
+// ModelLoadMsg reports progress while the local model is checked, pulled
+// and loaded into memory
+type ModelLoadMsg struct {
+	Status        string   // What is happening, e.g. "pulling manifest"
+	Completed     int64    // Bytes downloaded of the current layer
+	Total         int64    // Size of the current layer, 0 when not downloading
+	Done          bool     // The model is loaded and ready
+	ContextLength int      // The model's context window in tokens, 0 if unknown
+	Capabilities  []string // What the model supports, e.g. "vision"; nil if unknown
+	Error         error    // Checking, pulling or loading failed
+}
+
+// ProviderSwitchMsg reports that requests moved to another provider
+type ProviderSwitchMsg struct {
+	From  string // Provider and model that stopped serving requests
+	To    string // Provider and model now serving requests
+	Cause error  // The error that caused the switch, nil when returning to a preferred provider
+}
+
+// programSender forwards messages from background goroutines to the running
+// program. Model holds it by pointer so every copy reaches the same program.
+type programSender struct {
+	program *tea.Program
+}
+
+// Send delivers a message to the program, dropping it if none is attached
+func (s *programSender) Send(msg tea.Msg) {
+	if s == nil || s.program == nil {
+		return
+	}
+	s.program.Send(msg)
+}
+
+// agentEventMsg converts an agent event into the message the TUI handles.
+// Text and reasoning deltas travel as StreamChunkMsg so both the agent and
+// the direct LLM fallback share one streaming path.
+func agentEventMsg(event agent.Event) tea.Msg {
+	switch event.Type {
+	case agent.EventTextDelta:
+		return StreamChunkMsg{Delta: event.Delta}
+	case agent.EventReasoningDelta:
+		return StreamChunkMsg{Reasoning: event.Delta}
+	}
+	return AgentEventMsg{Event: event}
+}
@@ -1,6 +1,7 @@
 package panels
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/charmbracelet/bubbles/viewport"
@@ -11,8 +12,10 @@ import (
 
 // Message represents a conversation message
 type Message struct {
-	Role    string // "user" or "assistant"
-	Content string
+	Role      string // "user" or "assistant"
+	Content   string
+	Reasoning string   // The model's thinking before an assistant message
+	Images    []string // Placeholders for attached images, e.g. "screenshot.png 1280×720"
 }
 
 // ConversationPanel displays the conversation history
@@ -24,6 +27,12 @@ type ConversationPanel struct {
 	messages []Message
 	renderer *glamour.TermRenderer
 	ready    bool
+	// streamIdx is the index of the assistant message currently being
+	// streamed, or -1 when no message is in progress
+	streamIdx int
+	// showReasoning expands the model's reasoning; it is collapsed to a
+	// one-line summary otherwise
+	showReasoning bool
 }
 
 // NewConversationPanel creates a new conversation panel
@@ -41,8 +50,9 @@ func NewConversationPanel() *ConversationPanel {
 
 	return &ConversationPanel{
 		viewport: vp,
-		messages: make([]Message, 0),
-		renderer: renderer,
+		messages:  make([]Message, 0),
+		renderer:  renderer,
+		streamIdx: -1,
 	}
 }
 
@@ -93,6 +103,10 @@ func (p *ConversationPanel) View() string {
 					Foreground(lipgloss.Color("86")). // Cyan
 					Bold(true)
 				prefix = "You: "
+			} else if msg.Role == "system" {
+				style = lipgloss.NewStyle().
+					Foreground(lipgloss.Color("240")) // Gray
+				prefix = "System: "
 			} else {
 				style = lipgloss.NewStyle().
 					Foreground(lipgloss.Color("141")) // Purple
@@ -107,6 +121,16 @@ func (p *ConversationPanel) View() string {
 
 			content.WriteString(style.Render(prefix))
 			content.WriteString("\n")
+			if msg.Reasoning != "" {
+				content.WriteString(p.renderReasoning(msg.Reasoning))
+				content.WriteString("\n")
+			}
+			for _, img := range msg.Images {
+				content.WriteString(lipgloss.NewStyle().
+					Foreground(lipgloss.Color("214")). // Orange
+					Render("[image: " + img + "]"))
+				content.WriteString("\n")
+			}
 			content.WriteString(rendered)
 
 			if i < len(p.messages)-1 {
@@ -119,6 +143,18 @@ func (p *ConversationPanel) View() string {
 	return p.viewport.View()
 }
 
+// renderReasoning renders a message's reasoning, expanded or as a summary
+func (p *ConversationPanel) renderReasoning(reasoning string) string {
+	style := lipgloss.NewStyle().Foreground(lipgloss.Color("240")) // Gray
+	if !p.showReasoning {
+		words := len(strings.Fields(reasoning))
+		return style.Render(fmt.Sprintf("▸ Reasoning (%d words, t to expand)", words))
+	}
+
+	body := style.Italic(true).Width(max(p.viewport.Width-4, 20)).Render(strings.TrimSpace(reasoning))
+	return style.Render("▾ Reasoning (t to collapse)") + "\n" + body + "\n"
+}
+
 // SetSize sets the panel dimensions
 func (p *ConversationPanel) SetSize(width, height int) {
 	p.width = width
@@ -153,20 +189,85 @@ func (p *ConversationPanel) Title() string {
 	return "Conversation"
 }
 
-// AddMessage adds a message to the conversation
+// AddMessage adds a message to the conversation.
+// Any message being streamed is finished first.
 func (p *ConversationPanel) AddMessage(role, content string) {
+	p.AddMessageWithImages(role, content, nil)
+}
+
+// AddMessageWithImages adds a message with a placeholder for each
+// attached image
+func (p *ConversationPanel) AddMessageWithImages(role, content string, images []string) {
+	p.FinishStream()
+
 	p.messages = append(p.messages, Message{
 		Role:    role,
 		Content: content,
+		Images:  images,
 	})
 
 	// Scroll to bottom
 	p.viewport.GotoBottom()
 }
 
+// AppendStream appends a streamed chunk to the in-progress assistant
+// message, starting a new one if none is in progress
+func (p *ConversationPanel) AppendStream(delta string) {
+	if p.streamIdx < 0 {
+		p.messages = append(p.messages, Message{Role: "assistant"})
+		p.streamIdx = len(p.messages) - 1
+	}
+
+	p.messages[p.streamIdx].Content += delta
+
+	// Keep the latest output visible
+	p.viewport.GotoBottom()
+}
+
+// AppendReasoning appends a streamed chunk of reasoning to the
+// in-progress assistant message, starting a new one if none is in progress
+func (p *ConversationPanel) AppendReasoning(delta string) {
+	if p.streamIdx < 0 {
+		p.messages = append(p.messages, Message{Role: "assistant"})
+		p.streamIdx = len(p.messages) - 1
+	}
+
+	p.messages[p.streamIdx].Reasoning += delta
+	p.viewport.GotoBottom()
+}
+
+// ToggleReasoning expands or collapses the reasoning of every message
+func (p *ConversationPanel) ToggleReasoning() {
+	p.showReasoning = !p.showReasoning
+}
+
+// ShowsReasoning returns whether reasoning is expanded
+func (p *ConversationPanel) ShowsReasoning() bool {
+	return p.showReasoning
+}
+
+// FinishStream ends the in-progress assistant message, dropping it if
+// nothing was streamed into it
+func (p *ConversationPanel) FinishStream() {
+	if p.streamIdx < 0 {
+		return
+	}
+
+	if msg := p.messages[p.streamIdx]; strings.TrimSpace(msg.Content) == "" && strings.TrimSpace(msg.Reasoning) == "" {
+		p.messages = append(p.messages[:p.streamIdx], p.messages[p.streamIdx+1:]...)
+	}
+	p.streamIdx = -1
+}
+
+// IsStreaming returns whether an assistant message is being streamed
+func (p *ConversationPanel) IsStreaming() bool {
+	return p.streamIdx >= 0
+}
+
 // ClearMessages clears all messages
 func (p *ConversationPanel) ClearMessages() {
 	p.messages = make([]Message, 0)
+	p.streamIdx = -1
 }
 
 // GetMessages returns all messages
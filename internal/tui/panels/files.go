@@ -21,6 +21,12 @@ type FileEntry struct {
 	GitStatus string // "", "M", "A", "D", "?", etc.
 }
 
+// AttachFileMsg is sent when the user attaches the selected file to the
+// next message
+type AttachFileMsg struct {
+	Path string
+}
+
 // FilesPanel displays a file browser
 type FilesPanel struct {
 	width       int
@@ -89,6 +95,13 @@ func (p *FilesPanel) Update(msg tea.Msg) (*FilesPanel, tea.Cmd) {
 			case "r":
 				p.loadFiles()
 				p.updateViewport()
+			case "a":
+				if file := p.GetSelectedFile(); file != nil && !file.IsDir {
+					path := file.Path
+					cmd = func() tea.Msg {
+						return AttachFileMsg{Path: path}
+					}
+				}
 			}
 		}
 	}
@@ -64,6 +64,69 @@ func TestConversationPanelGetMessages(t *testing.T) {
 	}
 }
 
+func TestConversationPanelStream(t *testing.T) {
+	p := NewConversationPanel()
+	p.AddMessage("user", "Hello")
+
+	p.AppendStream("Hi ")
+	p.AppendStream("there")
+	if !p.IsStreaming() {
+		t.Error("Panel should be streaming")
+	}
+	if len(p.messages) != 2 || p.messages[1].Content != "Hi there" {
+		t.Errorf("Streamed message incorrect: %+v", p.messages)
+	}
+
+	// Adding another message finishes the stream
+	p.AddMessage("system", "Running read_file...")
+	if p.IsStreaming() {
+		t.Error("AddMessage should finish the stream")
+	}
+
+	p.AppendStream("Done")
+	p.FinishStream()
+	if len(p.messages) != 4 || p.messages[3].Content != "Done" {
+		t.Errorf("Expected a new streamed message, got %+v", p.messages)
+	}
+
+	// Empty streams leave no message behind
+	p.AppendStream("")
+	p.FinishStream()
+	if len(p.messages) != 4 {
+		t.Errorf("Empty stream should be dropped, got %d messages", len(p.messages))
+	}
+}
+
+func TestConversationPanelReasoning(t *testing.T) {
+	p := NewConversationPanel()
+	p.SetSize(80, 40)
+
+	p.AppendReasoning("The user wants ")
+	p.AppendReasoning("a greeting.")
+	p.AppendStream("Hello!")
+	p.FinishStream()
+	if len(p.messages) != 1 || p.messages[0].Reasoning != "The user wants a greeting." || p.messages[0].Content != "Hello!" {
+		t.Fatalf("Reasoning and answer should share a message, got %+v", p.messages)
+	}
+
+	view := p.View()
+	if !strings.Contains(view, "Reasoning (5 words, t to expand)") || strings.Contains(view, "a greeting") {
+		t.Errorf("Reasoning should be collapsed by default, got %q", view)
+	}
+
+	p.ToggleReasoning()
+	if view := p.View(); !strings.Contains(view, "a greeting.") {
+		t.Errorf("Expanded reasoning should be shown, got %q", view)
+	}
+
+	// A stream with only reasoning is kept
+	p.AppendReasoning("Nothing to say.")
+	p.FinishStream()
+	if len(p.messages) != 2 {
+		t.Errorf("A message with reasoning should be kept, got %+v", p.messages)
+	}
+}
+
 func TestConversationPanelFocus(t *testing.T) {
 	p := NewConversationPanel()
 
@@ -547,6 +610,168 @@ func TestPlanPanelClearSteps(t *testing.T) {
 	}
 }
 
+func TestPlanPanelStructuredView(t *testing.T) {
+	p := NewPlanPanel()
+	p.SetSize(100, 40)
+
+	p.SetPhases([]string{"Understand", "Plan", "Act"}, 1)
+	p.AddPlanStep(Step{Description: "Add parser", Rationale: "CLI needs it", Files: []string{"parser.go"}})
+	p.AddPlanStep(Step{Description: "Wire up CLI", DependsOn: []int{1}, Status: StepInProgress})
+	p.SetOutcome(&Outcome{Title: "Verification passed", Status: StepCompleted, Details: "go test ./... passed"})
+
+	if p.StepCount() != 2 || p.GetSteps()[1].ID != 2 {
+		t.Errorf("Steps should be numbered by position, got %+v", p.GetSteps())
+	}
+
+	view := p.View()
+	for _, want := range []string{"● Understand", "◐ Plan", "○ Act", "Why: CLI needs it", "Files: parser.go", "After: 1", "Verification passed"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("View should contain %q, got:\n%s", want, view)
+		}
+	}
+}
+
+func TestPlanPanelDependencyGraph(t *testing.T) {
+	p := NewPlanPanel()
+	p.SetSize(100, 40)
+	p.AddPlanStep(Step{Description: "Generate grammar", Status: StepReplaced, Details: "generator missing"})
+	p.AddPlanStep(Step{Description: "Wire up CLI", DependsOn: []int{1}, Status: StepReplaced})
+	p.AddPlanStep(Step{Description: "Hand-write grammar", Revised: true, Status: StepCompleted})
+	p.AddPlanStep(Step{Description: "Wire up CLI", DependsOn: []int{3}, Revised: true, Status: StepBlocked, Details: "blocked by step 3"})
+	p.AddPlanStep(Step{Description: "Document CLI", DependsOn: []int{4}})
+
+	if depths := dependencyDepths(p.GetSteps()); depths[0] != 0 || depths[3] != 1 || depths[4] != 2 {
+		t.Errorf("Unexpected depths %v", depths)
+	}
+
+	view := p.View()
+	for _, want := range []string{"↻ 1. Generate grammar", "└ ⊗ 4. Wire up CLI", "  └ ○ 5. Document CLI", "(revised)", "blocked by step 3"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("View should contain %q, got:\n%s", want, view)
+		}
+	}
+}
+
+func TestPlanPanelReview(t *testing.T) {
+	p := NewPlanPanel()
+	p.SetSize(100, 40)
+	p.AddPlanStep(Step{Description: "Add parser"})
+	p.AddPlanStep(Step{Description: "Write docs"})
+	p.AddPlanStep(Step{Description: "Wire up CLI", DependsOn: []int{1}})
+	p.StartReview()
+
+	keys := func(input string) {
+		for _, r := range input {
+			p.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+	}
+
+	if !strings.Contains(p.View(), "enter approve") {
+		t.Error("Review mode should show its key help")
+	}
+
+	// Move "Add parser" below "Write docs"; the dependency follows it
+	keys("J")
+	steps := p.GetSteps()
+	if steps[1].Description != "Add parser" || steps[2].DependsOn[0] != 2 {
+		t.Fatalf("Unexpected steps after move: %+v", steps)
+	}
+
+	// Skip "Write docs"
+	keys("ks")
+	if p.GetSteps()[0].Status != StepSkipped {
+		t.Error("Step should be skipped")
+	}
+
+	// Retitle the last step
+	keys("jje")
+	p.Update(tea.KeyMsg{Type: tea.KeyCtrlU})
+	keys("Wire up the CLI")
+	p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if p.GetSteps()[2].Description != "Wire up the CLI" {
+		t.Errorf("Expected edited title, got %q", p.GetSteps()[2].Description)
+	}
+
+	// Add a step after it, then delete "Add parser"
+	keys("a")
+	keys("Update changelog")
+	p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	keys("kkd")
+
+	steps = p.GetSteps()
+	if len(steps) != 3 || steps[2].Description != "Update changelog" || steps[2].ID != 3 {
+		t.Fatalf("Unexpected steps after insert and delete: %+v", steps)
+	}
+	if len(steps[1].DependsOn) != 0 {
+		t.Errorf("Dependency on a deleted step should be dropped, got %v", steps[1].DependsOn)
+	}
+
+	// Cancelling an added step removes it again
+	keys("a")
+	p.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	if p.StepCount() != 3 {
+		t.Errorf("Cancelled insert should not add a step, got %d", p.StepCount())
+	}
+
+	_, cmd := p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil || p.IsReviewing() {
+		t.Fatal("Enter should approve the plan")
+	}
+	approved, ok := cmd().(PlanApprovedMsg)
+	if !ok || len(approved.Steps) != 3 || approved.Steps[1].Description != "Wire up the CLI" {
+		t.Errorf("Unexpected approval %+v", approved)
+	}
+}
+
+func TestPlanPanelReviewNeedsRunnableStep(t *testing.T) {
+	p := NewPlanPanel()
+	p.AddPlanStep(Step{Description: "Only step"})
+	p.StartReview()
+
+	p.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}})
+	_, cmd := p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd != nil || !p.IsReviewing() {
+		t.Error("A plan with every step skipped should not be approved")
+	}
+}
+
+func TestPlanPanelReviewRevision(t *testing.T) {
+	p := NewPlanPanel()
+	p.AddPlanStep(Step{Description: "Add parser", Status: StepCompleted})
+	p.AddPlanStep(Step{Description: "Add tests", Status: StepReplaced})
+	p.AddPlanStep(Step{Description: "Install a test runner", Revised: true})
+	p.AddPlanStep(Step{Description: "Add tests", DependsOn: []int{1, 3}, Revised: true})
+	p.StartReview()
+
+	keys := func(input string) {
+		for _, r := range input {
+			p.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+		}
+	}
+
+	// The cursor starts on the revision and cannot reach the steps that ran
+	keys("kkKsj")
+	steps := p.GetSteps()
+	if steps[1].Status != StepReplaced || steps[2].Description != "Install a test runner" || steps[2].Status != StepSkipped {
+		t.Fatalf("Only the revised steps should be editable, got %+v", steps)
+	}
+
+	// Skipping every revised step leaves nothing to approve
+	keys("s")
+	if _, cmd := p.Update(tea.KeyMsg{Type: tea.KeyEnter}); cmd != nil {
+		t.Error("A revision with every step skipped should not be approved")
+	}
+
+	keys("s")
+	_, cmd := p.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil {
+		t.Fatal("Enter should approve the revision")
+	}
+	if approved := cmd().(PlanApprovedMsg); len(approved.Steps) != 4 || approved.Steps[0].Status != StepCompleted {
+		t.Errorf("Unexpected approval %+v", approved)
+	}
+}
+
 func TestPlanPanelFocus(t *testing.T) {
 	p := NewPlanPanel()
 
@@ -603,7 +828,7 @@ func TestPlanPanelView(t *testing.T) {
 }
 
 func TestStepStatusString(t *testing.T) {
-	statuses := []StepStatus{StepPending, StepInProgress, StepCompleted, StepFailed}
+	statuses := []StepStatus{StepPending, StepInProgress, StepCompleted, StepFailed, StepSkipped, StepBlocked, StepReplaced}
 
 	for _, s := range statuses {
 		if s.String() == "" {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -17,6 +18,9 @@ const (
 	StepInProgress
 	StepCompleted
 	StepFailed
+	StepSkipped
+	StepBlocked
+	StepReplaced
 )
 
 // String returns the string representation of the step status
@@ -30,6 +34,12 @@ func (s StepStatus) String() string {
 		return "completed"
 	case StepFailed:
 		return "failed"
+	case StepSkipped:
+		return "skipped"
+	case StepBlocked:
+		return "blocked"
+	case StepReplaced:
+		return "replaced"
 	default:
 		return "unknown"
 	}
@@ -39,18 +49,48 @@ func (s StepStatus) String() string {
 type Step struct {
 	ID          int
 	Description string
+	Rationale   string   // Why the step is needed
+	Files       []string // Files the step touches
+	DependsOn   []int    // IDs of steps this one waits for
+	Revised     bool     // Added by a re-plan after a failure
 	Status      StepStatus
 	Details     string
 }
 
+// Outcome is a status line shown below the steps, such as a verification result
+type Outcome struct {
+	Title   string
+	Status  StepStatus
+	Details string
+}
+
+// PlanApprovedMsg is sent when the user approves the plan under review
+type PlanApprovedMsg struct {
+	Steps []Step
+}
+
+// PlanRejectedMsg is sent when the user rejects the plan under review
+type PlanRejectedMsg struct{}
+
 // PlanPanel displays a plan with steps
 type PlanPanel struct {
-	width    int
-	height   int
-	focused  bool
-	viewport viewport.Model
-	steps    []Step
-	ready    bool
+	width        int
+	height       int
+	focused      bool
+	viewport     viewport.Model
+	steps        []Step
+	phases       []string
+	currentPhase int
+	outcome      *Outcome
+	ready        bool
+
+	// Review mode lets the user edit the plan before approving it
+	reviewing bool
+	locked    int // Steps before this index already ran and cannot be edited
+	cursor    int
+	editing   bool
+	inserted  bool // The step being edited was just added
+	editor    textinput.Model
 }
 
 // NewPlanPanel creates a new plan panel
@@ -60,9 +100,14 @@ func NewPlanPanel() *PlanPanel {
 		BorderStyle(lipgloss.RoundedBorder()).
 		BorderForeground(lipgloss.Color("240"))
 
+	editor := textinput.New()
+	editor.Placeholder = "Describe the step"
+	editor.CharLimit = 200
+
 	return &PlanPanel{
 		viewport: vp,
 		steps:    make([]Step, 0),
+		editor:   editor,
 	}
 }
 
@@ -77,6 +122,9 @@ func (p *PlanPanel) Update(msg tea.Msg) (*PlanPanel, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if p.reviewing {
+			return p, p.handleReviewKey(msg)
+		}
 		if p.IsFocused() {
 			p.viewport, cmd = p.viewport.Update(msg)
 		}
@@ -89,66 +137,356 @@ func (p *PlanPanel) Update(msg tea.Msg) (*PlanPanel, tea.Cmd) {
 	return p, cmd
 }
 
+// handleReviewKey applies a key press while the plan is under review
+func (p *PlanPanel) handleReviewKey(msg tea.KeyMsg) tea.Cmd {
+	if p.editing {
+		switch msg.String() {
+		case "enter":
+			p.finishEdit(true)
+			return nil
+		case "esc":
+			p.finishEdit(false)
+			return nil
+		default:
+			var cmd tea.Cmd
+			p.editor, cmd = p.editor.Update(msg)
+			return cmd
+		}
+	}
+
+	switch msg.String() {
+	case "j", "down":
+		if p.cursor < len(p.steps)-1 {
+			p.cursor++
+		}
+	case "k", "up":
+		if p.cursor > p.locked {
+			p.cursor--
+		}
+	case "J", "shift+down":
+		p.moveStep(p.cursor, p.cursor+1)
+	case "K", "shift+up":
+		p.moveStep(p.cursor, p.cursor-1)
+	case "e":
+		if p.cursor >= p.locked && p.cursor < len(p.steps) {
+			p.startEdit(false)
+		}
+	case "a":
+		p.insertStep(max(p.cursor+1, p.locked))
+		p.startEdit(true)
+	case "d":
+		if p.cursor >= p.locked {
+			p.deleteStep(p.cursor)
+		}
+	case "s", " ":
+		if p.cursor >= p.locked && p.cursor < len(p.steps) {
+			step := &p.steps[p.cursor]
+			if step.Status == StepSkipped {
+				step.Status = StepPending
+			} else {
+				step.Status = StepSkipped
+			}
+		}
+	case "enter":
+		if !p.hasRunnableStep() {
+			return nil
+		}
+		p.reviewing = false
+		steps := append([]Step(nil), p.steps...)
+		return func() tea.Msg {
+			return PlanApprovedMsg{Steps: steps}
+		}
+	case "esc":
+		p.reviewing = false
+		return func() tea.Msg {
+			return PlanRejectedMsg{}
+		}
+	}
+
+	return nil
+}
+
+// startEdit opens the editor on the step under the cursor
+func (p *PlanPanel) startEdit(inserted bool) {
+	p.editing = true
+	p.inserted = inserted
+	p.editor.SetValue(p.steps[p.cursor].Description)
+	p.editor.CursorEnd()
+	p.editor.Focus()
+}
+
+// finishEdit closes the editor, keeping the new title if save is set.
+// A newly added step is dropped again if it ends up without a title.
+func (p *PlanPanel) finishEdit(save bool) {
+	title := strings.TrimSpace(p.editor.Value())
+	if save && title != "" {
+		p.steps[p.cursor].Description = title
+	}
+	if p.inserted && (!save || title == "") {
+		p.deleteStep(p.cursor)
+	}
+
+	p.editing = false
+	p.inserted = false
+	p.editor.Blur()
+	p.editor.SetValue("")
+}
+
+// moveStep swaps the step at from with the one at to, keeping the cursor on it
+func (p *PlanPanel) moveStep(from, to int) {
+	if from < p.locked || to < p.locked || from >= len(p.steps) || to >= len(p.steps) {
+		return
+	}
+
+	p.steps[from], p.steps[to] = p.steps[to], p.steps[from]
+	p.renumber(func(id int) int {
+		switch id {
+		case from + 1:
+			return to + 1
+		case to + 1:
+			return from + 1
+		}
+		return id
+	})
+	p.cursor = to
+}
+
+// insertStep adds an empty step at index
+func (p *PlanPanel) insertStep(index int) {
+	if index > len(p.steps) {
+		index = len(p.steps)
+	}
+
+	p.steps = append(p.steps[:index], append([]Step{{Status: StepPending}}, p.steps[index:]...)...)
+	p.renumber(func(id int) int {
+		if id > index {
+			return id + 1
+		}
+		return id
+	})
+	p.cursor = index
+}
+
+// deleteStep removes the step at index along with dependencies on it
+func (p *PlanPanel) deleteStep(index int) {
+	if index < 0 || index >= len(p.steps) {
+		return
+	}
+
+	p.steps = append(p.steps[:index], p.steps[index+1:]...)
+	p.renumber(func(id int) int {
+		switch {
+		case id == index+1:
+			return 0
+		case id > index+1:
+			return id - 1
+		}
+		return id
+	})
+	if p.cursor >= len(p.steps) && p.cursor > p.locked {
+		p.cursor = len(p.steps) - 1
+	}
+}
+
+// renumber numbers steps by position and rewrites dependencies with
+// mapping, which returns a dependency's new ID or 0 if it was removed
+func (p *PlanPanel) renumber(mapping func(id int) int) {
+	for i := range p.steps {
+		p.steps[i].ID = i + 1
+
+		var deps []int
+		for _, dep := range p.steps[i].DependsOn {
+			if id := mapping(dep); id > 0 && id != i+1 {
+				deps = append(deps, id)
+			}
+		}
+		p.steps[i].DependsOn = deps
+	}
+}
+
+// hasRunnableStep reports whether any step is left to execute
+func (p *PlanPanel) hasRunnableStep() bool {
+	for _, step := range p.steps[min(p.locked, len(p.steps)):] {
+		if step.Status != StepSkipped {
+			return true
+		}
+	}
+	return false
+}
+
 // View renders the panel
 func (p *PlanPanel) View() string {
 	if !p.ready {
 		return "Loading..."
 	}
 
-	if len(p.steps) == 0 {
+	if len(p.steps) == 0 && len(p.phases) == 0 && p.outcome == nil {
 		return lipgloss.NewStyle().
 			Foreground(lipgloss.Color("240")).
 			Render("No plan yet")
 	}
 
-	var content strings.Builder
+	var lines []string
+
+	if len(p.phases) > 0 {
+		lines = append(lines, p.renderPhases(), "")
+	}
+
+	detailStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("244"))
+
+	depths := dependencyDepths(p.steps)
+	revisedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("141"))
 
 	for i, step := range p.steps {
-		// Icon and color based on status
-		var icon string
-		var style lipgloss.Style
-
-		switch step.Status {
-		case StepPending:
-			icon = "○"
-			style = lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-		case StepInProgress:
-			icon = "◐"
-			style = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("214")).
-				Bold(true)
-		case StepCompleted:
-			icon = "●"
-			style = lipgloss.NewStyle().Foreground(lipgloss.Color("82"))
-		case StepFailed:
-			icon = "✗"
-			style = lipgloss.NewStyle().
-				Foreground(lipgloss.Color("196")).
-				Bold(true)
+		icon, style := stepStyle(step.Status)
+
+		// Indent steps under the steps they wait for
+		indent := ""
+		if depths[i] > 0 {
+			indent = strings.Repeat("  ", depths[i]-1) + "└ "
+		}
+		tag := ""
+		if step.Revised {
+			tag = revisedStyle.Render(" (revised)")
+		}
+
+		marker := ""
+		if p.reviewing {
+			marker = "  "
+			if i == p.cursor {
+				marker = "▸ "
+			}
 		}
 
 		// Format the step
-		line := fmt.Sprintf("%s %d. %s", icon, step.ID, step.Description)
-		content.WriteString(style.Render(line))
+		if p.editing && i == p.cursor {
+			lines = append(lines, fmt.Sprintf("%s%s%s %d. %s", marker, indent, icon, step.ID, p.editor.View()))
+		} else {
+			lines = append(lines, marker+indent+style.Render(fmt.Sprintf("%s %d. %s", icon, step.ID, step.Description))+tag)
+		}
+
+		// Detail lines line up with the step's title
+		pad := strings.Repeat(" ", lipgloss.Width(marker+indent))
+
+		if step.Rationale != "" {
+			lines = append(lines, pad+detailStyle.Render("   Why: "+step.Rationale))
+		}
+		if len(step.Files) > 0 {
+			lines = append(lines, pad+detailStyle.Render("   Files: "+strings.Join(step.Files, ", ")))
+		}
+		if len(step.DependsOn) > 0 {
+			deps := make([]string, len(step.DependsOn))
+			for i, dep := range step.DependsOn {
+				deps[i] = fmt.Sprintf("%d", dep)
+			}
+			lines = append(lines, pad+detailStyle.Render("   After: "+strings.Join(deps, ", ")))
+		}
 
 		// Add details if present
 		if step.Details != "" {
-			details := lipgloss.NewStyle().
-				Foreground(lipgloss.Color("244")).
-				Render(fmt.Sprintf("   %s", step.Details))
-			content.WriteString("\n")
-			content.WriteString(details)
+			lines = append(lines, pad+detailStyle.Render(fmt.Sprintf("   %s", step.Details)))
 		}
+	}
+
+	if p.outcome != nil {
+		if len(p.steps) > 0 {
+			lines = append(lines, "")
+		}
+		icon, style := stepStyle(p.outcome.Status)
+		lines = append(lines, style.Render(fmt.Sprintf("%s %s", icon, p.outcome.Title)))
+		if p.outcome.Details != "" {
+			lines = append(lines, detailStyle.Render("   "+p.outcome.Details))
+		}
+	}
 
-		if i < len(p.steps)-1 {
-			content.WriteString("\n")
+	if p.reviewing {
+		help := "j/k select · J/K move · e edit · a add · d delete · s skip · enter approve · esc reject"
+		if p.editing {
+			help = "enter save · esc cancel"
 		}
+		lines = append(lines, "", detailStyle.Render(help))
 	}
 
-	p.viewport.SetContent(content.String())
+	p.viewport.SetContent(strings.Join(lines, "\n"))
 	return p.viewport.View()
 }
 
+// dependencyDepths returns how deep each step sits in the dependency
+// graph: 0 for steps without dependencies, otherwise one more than the
+// deepest step it waits for
+func dependencyDepths(steps []Step) []int {
+	index := make(map[int]int, len(steps))
+	for i, step := range steps {
+		index[step.ID] = i
+	}
+
+	depths := make([]int, len(steps))
+	visiting := make([]bool, len(steps))
+	var depth func(i int) int
+	depth = func(i int) int {
+		if depths[i] > 0 || visiting[i] {
+			return depths[i]
+		}
+		visiting[i] = true
+		for _, dep := range steps[i].DependsOn {
+			if j, ok := index[dep]; ok && j != i {
+				depths[i] = max(depths[i], depth(j)+1)
+			}
+		}
+		visiting[i] = false
+		return depths[i]
+	}
+
+	for i := range steps {
+		depth(i)
+	}
+	return depths
+}
+
+// renderPhases renders the lifecycle phases as a single progress line
+func (p *PlanPanel) renderPhases() string {
+	parts := make([]string, len(p.phases))
+	for i, name := range p.phases {
+		status := StepPending
+		if i < p.currentPhase {
+			status = StepCompleted
+		} else if i == p.currentPhase {
+			status = StepInProgress
+		}
+		icon, style := stepStyle(status)
+		parts[i] = style.Render(icon + " " + name)
+	}
+	return strings.Join(parts, " → ")
+}
+
+// stepStyle returns the icon and color for a status
+func stepStyle(status StepStatus) (string, lipgloss.Style) {
+	switch status {
+	case StepInProgress:
+		return "◐", lipgloss.NewStyle().
+			Foreground(lipgloss.Color("214")).
+			Bold(true)
+	case StepCompleted:
+		return "●", lipgloss.NewStyle().Foreground(lipgloss.Color("82"))
+	case StepFailed:
+		return "✗", lipgloss.NewStyle().
+			Foreground(lipgloss.Color("196")).
+			Bold(true)
+	case StepSkipped:
+		return "⊘", lipgloss.NewStyle().
+			Foreground(lipgloss.Color("240")).
+			Strikethrough(true)
+	case StepBlocked:
+		return "⊗", lipgloss.NewStyle().Foreground(lipgloss.Color("208"))
+	case StepReplaced:
+		return "↻", lipgloss.NewStyle().
+			Foreground(lipgloss.Color("240")).
+			Strikethrough(true)
+	default:
+		return "○", lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	}
+}
+
 // SetSize sets the panel dimensions
 func (p *PlanPanel) SetSize(width, height int) {
 	p.width = width
@@ -192,6 +530,46 @@ func (p *PlanPanel) AddStep(description string) {
 	})
 }
 
+// AddPlanStep adds a structured step to the plan, numbering it by position
+func (p *PlanPanel) AddPlanStep(step Step) {
+	step.ID = len(p.steps) + 1
+	p.steps = append(p.steps, step)
+}
+
+// SetPhases sets the lifecycle phases shown above the steps and the
+// index of the current one
+func (p *PlanPanel) SetPhases(phases []string, current int) {
+	p.phases = phases
+	p.currentPhase = current
+}
+
+// SetOutcome sets the status line shown below the steps
+func (p *PlanPanel) SetOutcome(outcome *Outcome) {
+	p.outcome = outcome
+}
+
+// StartReview puts the panel into review mode so the user can reorder,
+// edit, add, delete and skip steps before approving the plan. Steps before
+// the first pending one already ran, when a revised plan is under review,
+// and stay as they are.
+func (p *PlanPanel) StartReview() {
+	p.reviewing = true
+	p.editing = false
+	p.locked = len(p.steps)
+	for i, step := range p.steps {
+		if step.Status == StepPending {
+			p.locked = i
+			break
+		}
+	}
+	p.cursor = p.locked
+}
+
+// IsReviewing returns whether the plan is waiting for the user's approval
+func (p *PlanPanel) IsReviewing() bool {
+	return p.reviewing
+}
+
 // UpdateStep updates a step's status and details
 func (p *PlanPanel) UpdateStep(id int, status StepStatus, details string) {
 	for i := range p.steps {
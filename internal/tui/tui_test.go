@@ -1,10 +1,25 @@
 package tui
 
 import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/siddharth-bhatnagar/anvil/internal/agent"
+	"github.com/siddharth-bhatnagar/anvil/internal/config"
+	"github.com/siddharth-bhatnagar/anvil/internal/llm"
+	"github.com/siddharth-bhatnagar/anvil/internal/tools"
+	"github.com/siddharth-bhatnagar/anvil/internal/tui/panels"
+	"github.com/siddharth-bhatnagar/anvil/pkg/schema"
 )
 
 func TestNewModel(t *testing.T) {
@@ -112,3 +127,642 @@ func TestModelView_Ready(t *testing.T) {
 		t.Error("View should contain 'Conversation' panel")
 	}
 }
+
+func TestModelUpdate_StreamingAgentTurn(t *testing.T) {
+	m := NewModel()
+	m.streaming = true
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	before := len(convPanel.GetMessages())
+
+	msgs := []tea.Msg{
+		agentEventMsg(agent.Event{Type: agent.EventPhaseChange, Phase: agent.PhaseAct}),
+		agentEventMsg(agent.Event{Type: agent.EventTextDelta, Delta: "Reading "}),
+		agentEventMsg(agent.Event{Type: agent.EventTextDelta, Delta: "the file."}),
+		agentEventMsg(agent.Event{Type: agent.EventToolCallStart, ToolCall: &schema.ToolCall{Name: "read_file"}}),
+		agentEventMsg(agent.Event{
+			Type:       agent.EventToolCallFinish,
+			ToolCall:   &schema.ToolCall{Name: "read_file"},
+			ToolResult: &schema.ToolResult{Success: true},
+		}),
+		agentEventMsg(agent.Event{Type: agent.EventUsage, Usage: &llm.Usage{TotalTokens: 42}}),
+		AgentResponseMsg{Response: &agent.Response{Message: "Reading the file.", Phase: agent.PhaseAct, Done: true}},
+	}
+
+	var model tea.Model = m
+	for _, msg := range msgs {
+		model, _ = model.Update(msg)
+	}
+	m = model.(Model)
+
+	if m.streaming {
+		t.Error("Streaming should stop after the agent response")
+	}
+
+	messages := convPanel.GetMessages()[before:]
+	if len(messages) != 3 {
+		t.Fatalf("Expected streamed text and two tool lines, got %+v", messages)
+	}
+	if messages[0].Role != "assistant" || messages[0].Content != "Reading the file." {
+		t.Errorf("Unexpected streamed message %+v", messages[0])
+	}
+	if !strings.Contains(messages[1].Content, "read_file") || !strings.Contains(messages[2].Content, "finished") {
+		t.Errorf("Unexpected tool messages %+v", messages[1:])
+	}
+
+	if m.tokenTracker.GetStats().TotalTokens != 42 {
+		t.Errorf("Usage events should be tracked, got %d tokens", m.tokenTracker.GetStats().TotalTokens)
+	}
+
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	planPanel.SetSize(120, 40)
+	if view := planPanel.View(); !strings.Contains(view, "◐ Act") {
+		t.Errorf("Plan panel should show Act as the current phase, got:\n%s", view)
+	}
+}
+
+func TestModelUpdate_StructuredPlan(t *testing.T) {
+	m := NewModel()
+
+	updated, _ := m.Update(AgentEventMsg{Event: agent.Event{
+		Type: agent.EventPlanUpdate,
+		PlanSteps: []agent.PlanStep{
+			{ID: 0, Title: "Add parser", Rationale: "CLI needs it", Files: []string{"parser.go"}, Status: agent.StepCompleted},
+			{ID: 1, Title: "Wire up CLI", DependsOn: []int{0}, Status: agent.StepInProgress},
+		},
+	}})
+	m = updated.(Model)
+
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	planPanel.SetSize(120, 40)
+	view := planPanel.View()
+
+	for _, want := range []string{"1. Add parser", "Why: CLI needs it", "Files: parser.go", "2. Wire up CLI", "After: 1"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Plan panel should contain %q, got:\n%s", want, view)
+		}
+	}
+}
+
+func TestModelUpdate_RevisedPlan(t *testing.T) {
+	m := NewModel()
+
+	updated, _ := m.Update(AgentEventMsg{Event: agent.Event{
+		Type: agent.EventPlanUpdate,
+		PlanSteps: []agent.PlanStep{
+			{ID: 0, Title: "Generate grammar", Status: agent.StepReplaced, Error: errors.New("generator missing")},
+			{ID: 1, Title: "Hand-write grammar", Revision: 1, Status: agent.StepFailed, Error: errors.New("syntax error")},
+			{ID: 2, Title: "Wire up CLI", DependsOn: []int{1}, Revision: 1, Status: agent.StepBlocked, Error: errors.New("blocked by step 2 (Failed)")},
+		},
+	}})
+	m = updated.(Model)
+
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	steps := planPanel.GetSteps()
+	if steps[0].Status != panels.StepReplaced || steps[0].Revised || steps[0].Details != "generator missing" {
+		t.Errorf("Unexpected replaced step %+v", steps[0])
+	}
+	if steps[2].Status != panels.StepBlocked || !steps[2].Revised || steps[2].DependsOn[0] != 2 {
+		t.Errorf("Unexpected blocked step %+v", steps[2])
+	}
+}
+
+func TestModelUpdate_PlanReview(t *testing.T) {
+	m := NewModel()
+
+	updated, _ := m.Update(AgentResponseMsg{Response: &agent.Response{
+		Phase: agent.PhasePlan,
+		PlanSteps: []agent.PlanStep{
+			{ID: 0, Title: "Add parser"},
+			{ID: 1, Title: "Wire up CLI", DependsOn: []int{0}},
+		},
+		AwaitingPlanApproval: true,
+	}})
+	m = updated.(Model)
+
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	if !m.reviewingPlan || !planPanel.IsReviewing() {
+		t.Fatal("Plan should be under review")
+	}
+
+	// Keys go to the plan panel rather than the global shortcuts
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'s'}})
+	m = updated.(Model)
+	if cmd != nil || planPanel.GetSteps()[0].Status != panels.StepSkipped {
+		t.Error("'s' should skip the selected step")
+	}
+
+	updated, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatal("Enter should approve the plan")
+	}
+
+	approved, ok := cmd().(panels.PlanApprovedMsg)
+	if !ok || len(approved.Steps) != 2 {
+		t.Fatalf("Expected PlanApprovedMsg, got %+v", approved)
+	}
+
+	updated, _ = m.Update(approved)
+	m = updated.(Model)
+	if m.reviewingPlan {
+		t.Error("Review should end once the plan is approved")
+	}
+}
+
+func TestModelUpdate_PlanReviewInvalidEdit(t *testing.T) {
+	m := NewModel()
+	m.agent = agent.NewAgent(nil, tools.NewRegistry(), agent.Config{})
+	plan := []agent.PlanStep{
+		{ID: 0, Title: "Add parser"},
+		{ID: 1, Title: "Wire up CLI", DependsOn: []int{0}},
+	}
+	m.agent.GetLifecycle().SetPlan(plan)
+	m.agent.GetLifecycle().SetPhase(agent.PhasePlan)
+
+	updated, _ := m.Update(AgentResponseMsg{Response: &agent.Response{
+		Phase:                agent.PhasePlan,
+		PlanSteps:            plan,
+		AwaitingPlanApproval: true,
+	}})
+	m = updated.(Model)
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	before := len(convPanel.GetMessages())
+
+	// An edit that makes the steps wait on each other
+	steps := planPanel.GetSteps()
+	steps[0].DependsOn = []int{2}
+	updated, cmd := m.Update(panels.PlanApprovedMsg{Steps: steps})
+	m = updated.(Model)
+
+	if cmd != nil || m.streaming {
+		t.Fatal("An invalid plan should not be sent to the agent")
+	}
+	if !m.reviewingPlan || !planPanel.IsReviewing() || planPanel.StepCount() != 2 {
+		t.Error("The plan should stay under review with its steps")
+	}
+	if m.agent.GetLifecycle().CurrentPhase() != agent.PhasePlan {
+		t.Errorf("The agent should still await approval, got phase %s", m.agent.GetLifecycle().CurrentPhase())
+	}
+
+	planPanel.SetSize(80, 40)
+	if view := planPanel.View(); !strings.Contains(view, "cannot be approved") || !strings.Contains(view, "cycle") {
+		t.Errorf("The plan panel should show why, got:\n%s", view)
+	}
+	for _, msg := range convPanel.GetMessages()[before:] {
+		if strings.Contains(msg.Content, "Plan approved") {
+			t.Error("The plan should not be reported as approved")
+		}
+	}
+}
+
+func TestModelUpdate_PlanReviewEscRejects(t *testing.T) {
+	m := NewModel()
+
+	updated, _ := m.Update(AgentResponseMsg{Response: &agent.Response{
+		Phase:                agent.PhasePlan,
+		PlanSteps:            []agent.PlanStep{{ID: 0, Title: "Add parser"}},
+		AwaitingPlanApproval: true,
+	}})
+	m = updated.(Model)
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = updated.(Model)
+	if cmd == nil {
+		t.Fatal("Esc should reject the plan")
+	}
+
+	rejected, ok := cmd().(panels.PlanRejectedMsg)
+	if !ok {
+		t.Fatalf("Expected PlanRejectedMsg, got %+v", rejected)
+	}
+
+	updated, _ = m.Update(rejected)
+	m = updated.(Model)
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	if m.reviewingPlan || planPanel.IsReviewing() || planPanel.StepCount() != 0 {
+		t.Error("Rejecting should end the review and clear the plan")
+	}
+	if !m.input.Focused() {
+		t.Error("Rejecting should return focus to the input")
+	}
+}
+
+func TestModelUpdate_EscCancelsTurn(t *testing.T) {
+	m := NewModel()
+	ctx := m.startTurn()
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = updated.(Model)
+
+	if cmd != nil {
+		t.Error("Esc should not quit while a turn is running")
+	}
+	if ctx.Err() == nil {
+		t.Error("Esc should cancel the turn context")
+	}
+	if !m.cancelling || !m.streaming {
+		t.Error("Model should stay streaming until the cancelled turn returns")
+	}
+
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	updated, _ = m.Update(AgentResponseMsg{
+		Response: &agent.Response{Message: "Partial", Phase: agent.PhaseAct},
+		Error:    ctx.Err(),
+	})
+	m = updated.(Model)
+
+	if m.streaming || m.cancelling || m.cancelTurn != nil {
+		t.Error("Turn state should be cleared after the cancelled turn returns")
+	}
+
+	messages := convPanel.GetMessages()
+	last := messages[len(messages)-1]
+	if last.Role != "system" || !strings.Contains(last.Content, "Cancelled") {
+		t.Errorf("Expected cancellation notice, got %+v", last)
+	}
+}
+
+// blockingTool needs approval and runs until its context is cancelled
+type blockingTool struct {
+	tools.BaseTool
+}
+
+func (t blockingTool) Execute(ctx context.Context, args map[string]any) (*schema.ToolResult, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (t blockingTool) RequiresApproval(args map[string]any) bool {
+	return true
+}
+
+func TestModelUpdate_EscCancelsApprovedTool(t *testing.T) {
+	registry := tools.NewRegistry()
+	registry.Register(blockingTool{tools.NewBaseTool("slow", "Runs until cancelled", nil)})
+
+	m := NewModel()
+	m.agent = agent.NewAgent(nil, registry, agent.Config{})
+	m.approvalManager = agent.NewApprovalManager()
+	m.approvalManager.AddPending([]agent.PendingApproval{
+		{ToolCall: schema.ToolCall{ID: "call_1", Name: "slow"}},
+		{ToolCall: schema.ToolCall{ID: "call_2", Name: "slow"}},
+	})
+	m.pendingApproval = m.approvalManager.GetPending()[0]
+	m.awaitingApproval = true
+
+	// Approving returns at once; the tool runs in the command
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'y'}})
+	m = updated.(Model)
+	if cmd == nil || !m.streaming {
+		t.Fatal("Approving should run the tool as part of a cancellable turn")
+	}
+
+	result := make(chan tea.Msg, 1)
+	go func() { result <- cmd() }()
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = updated.(Model)
+
+	var msg tea.Msg
+	select {
+	case msg = <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Esc should cancel the approved tool")
+	}
+	approval, ok := msg.(ToolApprovalMsg)
+	if !ok || approval.Error == nil {
+		t.Fatalf("Expected a failed ToolApprovalMsg, got %+v", msg)
+	}
+
+	updated, cmd = m.Update(approval)
+	m = updated.(Model)
+	if cmd != nil || m.streaming || m.awaitingApproval || len(m.approvalManager.GetPending()) != 0 {
+		t.Error("A cancelled approval should end the turn and drop the other pending approvals")
+	}
+
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	messages := convPanel.GetMessages()
+	if last := messages[len(messages)-1]; !strings.Contains(last.Content, "Cancelled") {
+		t.Errorf("Expected cancellation notice, got %+v", last)
+	}
+}
+
+func TestModelUpdate_VerificationInPlanPanel(t *testing.T) {
+	m := NewModel()
+	report := &agent.VerifyReport{
+		Results: []agent.CommandResult{{Command: "go test ./...", Passed: false}},
+		Attempt: 2,
+	}
+
+	updated, _ := m.Update(AgentEventMsg{Event: agent.Event{Type: agent.EventVerification, Verification: report}})
+	m = updated.(Model)
+
+	planPanel := m.panelManager.GetPanelByType(PanelPlan).(*panels.PlanPanel)
+	planPanel.SetSize(80, 40)
+	view := planPanel.View()
+
+	if !strings.Contains(view, "Verification failed (after 2 repair attempt(s))") {
+		t.Errorf("Plan panel should show the verification outcome, got:\n%s", view)
+	}
+}
+
+func TestModelUpdate_OllamaModelMissing(t *testing.T) {
+	pulled := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			if pulled {
+				w.Write([]byte(`{"models":[{"name":"llama3.2:latest"}]}`))
+			} else {
+				w.Write([]byte(`{"models":[{"name":"qwen2.5-coder:7b"}]}`))
+			}
+		case "/api/pull":
+			pulled = true
+			w.Write([]byte(`{"status":"pulling 6a07","total":200,"completed":50}` + "\n"))
+			w.Write([]byte(`{"status":"success"}` + "\n"))
+		case "/api/generate":
+			w.Write([]byte(`{"model":"llama3.2","done":true,"done_reason":"load"}`))
+		case "/api/show":
+			w.Write([]byte(`{"model_info":{"general.architecture":"llama","llama.context_length":8192},"capabilities":["completion","tools"]}`))
+		}
+	}))
+	defer server.Close()
+
+	client, _ := llm.NewOllamaClient(llm.ClientConfig{BaseURL: server.URL, Model: "llama3.2"})
+	m := NewModel()
+	m.ollama = client
+	m.agent = agent.NewAgent(nil, tools.NewRegistry(), agent.Config{Model: "llama3.2"})
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	before := len(convPanel.GetMessages())
+
+	updated, _ := m.Update(m.prepareModel()())
+	m = updated.(Model)
+	messages := convPanel.GetMessages()[before:]
+	if !m.offerPull || len(messages) != 1 || !strings.Contains(messages[0].Content, "Press p to pull") {
+		t.Fatalf("Expected an offer to pull the missing model, got %+v", messages)
+	}
+
+	updated, _ = m.Update(ModelLoadMsg{Status: "pulling 6a07", Completed: 50, Total: 200})
+	m = updated.(Model)
+	if !strings.Contains(m.renderStatusBar(), "pulling 6a07 25%") {
+		t.Errorf("Status bar should show pull progress, got %q", m.renderStatusBar())
+	}
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	m = updated.(Model)
+	if cmd == nil || m.offerPull {
+		t.Fatal("'p' should start pulling the model")
+	}
+
+	updated, _ = m.Update(cmd())
+	m = updated.(Model)
+	messages = convPanel.GetMessages()[before:]
+	if m.modelStatus != "" || !strings.Contains(messages[len(messages)-1].Content, "llama3.2 is ready (8192 token context window)") {
+		t.Errorf("Expected the model to be ready, got %+v", messages)
+	}
+	if m.streaming || m.pulling {
+		t.Error("The pull's turn should end once the model is ready")
+	}
+	if info := m.agent.ModelInfo(); info.Vision || info.Thinking || info.ContextWindow != 8192 {
+		t.Errorf("The agent should use the features Ollama reports, got %+v", info)
+	}
+}
+
+func TestModelUpdate_EscCancelsPull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/pull" {
+			w.Write([]byte(`{"status":"pulling 6a07","total":200,"completed":50}` + "\n"))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer server.Close()
+
+	client, _ := llm.NewOllamaClient(llm.ClientConfig{BaseURL: server.URL, Model: "llama3.2"})
+	m := NewModel()
+	m.ollama = client
+	m.offerPull = true
+
+	updated, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	m = updated.(Model)
+	if cmd == nil || !m.streaming {
+		t.Fatal("'p' should pull the model as a cancellable turn")
+	}
+
+	result := make(chan tea.Msg, 1)
+	go func() { result <- cmd() }()
+
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = updated.(Model)
+
+	var msg tea.Msg
+	select {
+	case msg = <-result:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Esc should cancel the pull")
+	}
+	if load, ok := msg.(ModelLoadMsg); !ok || !errors.Is(load.Error, context.Canceled) {
+		t.Fatalf("Expected a cancelled ModelLoadMsg, got %+v", msg)
+	}
+
+	updated, _ = m.Update(msg)
+	m = updated.(Model)
+	if m.streaming || m.pulling || !m.offerPull || m.modelStatus != "" {
+		t.Error("A cancelled pull should end the turn and offer the pull again")
+	}
+
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	messages := convPanel.GetMessages()
+	if last := messages[len(messages)-1]; !strings.Contains(last.Content, "cancelled") {
+		t.Errorf("Expected cancellation notice, got %+v", last)
+	}
+}
+
+func TestModelUpdate_ProviderSwitch(t *testing.T) {
+	m := NewModel()
+	m.primaryProvider = "anthropic/claude-sonnet-4-5"
+	m.activeProvider = m.primaryProvider
+
+	updated, _ := m.Update(ProviderSwitchMsg{
+		From:  "anthropic/claude-sonnet-4-5",
+		To:    "openai/gpt-4o",
+		Cause: errors.New("overloaded"),
+	})
+	m = updated.(Model)
+
+	if !strings.Contains(m.renderStatusBar(), "openai/gpt-4o (fallback)") {
+		t.Errorf("Status bar should show the fallback provider, got %q", m.renderStatusBar())
+	}
+
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	messages := convPanel.GetMessages()
+	if last := messages[len(messages)-1].Content; !strings.Contains(last, "failed (overloaded); switched to openai/gpt-4o") {
+		t.Errorf("Unexpected switch message %q", last)
+	}
+
+	updated, _ = m.Update(ProviderSwitchMsg{From: "openai/gpt-4o", To: "anthropic/claude-sonnet-4-5"})
+	m = updated.(Model)
+	if strings.Contains(m.renderStatusBar(), "(fallback)") {
+		t.Error("Status bar should not mark the primary provider as a fallback")
+	}
+}
+
+func TestModelUpdate_Reasoning(t *testing.T) {
+	m := NewModel()
+	m.streaming = true
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+	before := len(convPanel.GetMessages())
+
+	msg := agentEventMsg(agent.Event{Type: agent.EventReasoningDelta, Delta: "Thinking it over."})
+	updated, _ := m.Update(msg)
+	m = updated.(Model)
+	updated, _ = m.Update(StreamChunkMsg{Delta: "Answer."})
+	m = updated.(Model)
+
+	messages := convPanel.GetMessages()[before:]
+	if len(messages) != 1 || messages[0].Reasoning != "Thinking it over." || messages[0].Content != "Answer." {
+		t.Fatalf("Expected reasoning apart from the answer, got %+v", messages)
+	}
+
+	// The reasoning can be expanded while the turn is still streaming
+	updated, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'t'}})
+	m = updated.(Model)
+	if !convPanel.ShowsReasoning() {
+		t.Error("'t' should expand the reasoning")
+	}
+}
+
+// writePNG writes a small PNG to path
+func writePNG(t *testing.T, path string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestModelUpdate_ImageAttachments(t *testing.T) {
+	dir := t.TempDir()
+	shot := filepath.Join(dir, "shot.png")
+	spaced := filepath.Join(dir, "other shot.png")
+	writePNG(t, shot)
+	writePNG(t, spaced)
+
+	m := NewModel()
+	convPanel := m.panelManager.GetPanelByType(PanelConversation).(*panels.ConversationPanel)
+
+	updated, _ := m.Update(panels.AttachFileMsg{Path: shot})
+	m = updated.(Model)
+	if len(m.attachments) != 1 || !strings.Contains(m.renderStatusBar(), "Images: 1 attached") {
+		t.Fatalf("Expected one pending attachment, got %d", len(m.attachments))
+	}
+
+	updated, _ = m.Update(panels.AttachFileMsg{Path: filepath.Join(dir, "main.go")})
+	m = updated.(Model)
+	messages := convPanel.GetMessages()
+	if last := messages[len(messages)-1]; !strings.Contains(last.Content, "only PNG, JPEG and GIF") {
+		t.Errorf("Expected non-images to be refused, got %q", last.Content)
+	}
+
+	// Paths in the message are attached too; the attached one only once
+	m.sendMessage(`Compare "` + spaced + `" with @` + shot + `, it's odd`)
+	if len(m.attachments) != 0 {
+		t.Error("Attachments should be cleared once sent")
+	}
+	var sent panels.Message
+	for _, msg := range convPanel.GetMessages() {
+		if msg.Role == "user" {
+			sent = msg
+		}
+	}
+	want := []string{"shot.png 4×3", "other shot.png 4×3"}
+	if strings.Join(sent.Images, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected placeholders %q, got %q", want, sent.Images)
+	}
+
+	words := splitWords(`it's "a b.png" c\ d.png`)
+	if strings.Join(words, "|") != "it's|a b.png|c d.png" {
+		t.Errorf("Unexpected words %q", words)
+	}
+
+	// Models without vision are not sent images
+	m.agent = agent.NewAgent(nil, nil, agent.Config{Model: "gpt-4"})
+	updated, _ = m.Update(panels.AttachFileMsg{Path: shot})
+	m = updated.(Model)
+	messages = convPanel.GetMessages()
+	if last := messages[len(messages)-1]; len(m.attachments) != 0 || !strings.Contains(last.Content, "gpt-4 does not accept") {
+		t.Errorf("Expected the image to be refused, got %q", last.Content)
+	}
+}
+
+func TestModelStatusBar_CacheHitRate(t *testing.T) {
+	m := NewModel()
+	m.tokenTracker.AddUsage(llm.Usage{PromptTokens: 1000, CompletionTokens: 10, TotalTokens: 1010})
+	if strings.Contains(m.renderStatusBar(), "Cache:") {
+		t.Error("Status bar should not show a cache hit rate without cached tokens")
+	}
+
+	m.tokenTracker.AddUsage(llm.Usage{PromptTokens: 1000, CompletionTokens: 10, TotalTokens: 1010, CacheReadTokens: 900})
+	if !strings.Contains(m.renderStatusBar(), "Cache: 45%") {
+		t.Errorf("Status bar should show the cache hit rate, got %q", m.renderStatusBar())
+	}
+}
+
+func TestNewModelWithConfig_Scripted(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "demo.yaml")
+	os.WriteFile(script, []byte("turns:\n  - match: (?i)hello\n    content: Hello from the script.\n"), 0644)
+
+	configMgr := config.NewManager()
+	cfg := config.NewDefaultConfig()
+	cfg.Provider = "scripted"
+	cfg.Script = script
+	cfg.Verify.Commands = []string{"true"}
+	cfg.Usage.Ledger = filepath.Join(t.TempDir(), "usage.jsonl")
+	configMgr.SetConfig(cfg)
+
+	m, err := NewModelWithConfig(configMgr)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if m.agent == nil {
+		t.Fatal("Scripted provider should get an agent without an API key")
+	}
+
+	msg, ok := m.sendMessage("hello there")().(AgentResponseMsg)
+	if !ok || msg.Error != nil {
+		t.Fatalf("Expected an agent response, got %+v", msg)
+	}
+	if msg.Response.Message != "Hello from the script." {
+		t.Errorf("Expected the scripted reply, got %q", msg.Response.Message)
+	}
+
+	entries, err := llm.NewLedger(cfg.Usage.Ledger).Entries(time.Time{})
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("Expected the call in the usage ledger, got %v (%v)", entries, err)
+	}
+	if entries[0].Session != m.sessionID || entries[0].Provider != llm.ProviderScripted || entries[0].InputTokens == 0 {
+		t.Errorf("Unexpected ledger entry %+v", entries[0])
+	}
+}
+
+func TestNewModelWithConfig_MissingAPIKey(t *testing.T) {
+	configMgr := config.NewManager()
+	cfg := config.NewDefaultConfig()
+	cfg.Provider = "gemini"
+	configMgr.SetConfig(cfg)
+
+	t.Setenv("ANVIL_GEMINI_API_KEY", "")
+	if configMgr.HasAPIKey("gemini") {
+		t.Skip("a Gemini API key is stored in the keychain")
+	}
+
+	_, err := NewModelWithConfig(configMgr)
+	if err == nil || !strings.Contains(err.Error(), "ANVIL_GEMINI_API_KEY") {
+		t.Errorf("Expected an error naming the API key variable, got %v", err)
+	}
+}
@@ -7,6 +7,9 @@ type ToolParameter struct {
 	Type        string `json:"type"` // "string", "number", "boolean", "array", "object"
 	Required    bool   `json:"required"`
 	Default     any    `json:"default,omitempty"`
+
+	// JSON schema of the elements of an array parameter
+	Items map[string]any `json:"items,omitempty"`
 }
 
 // ToolDefinition represents the schema of a tool